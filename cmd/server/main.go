@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,9 +17,19 @@ import (
 	"github.com/veranemoloko/url-downloader/internal/api"
 	"github.com/veranemoloko/url-downloader/internal/config"
 	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/health"
+	"github.com/veranemoloko/url-downloader/internal/metrics"
+	"github.com/veranemoloko/url-downloader/internal/queue"
+	"github.com/veranemoloko/url-downloader/internal/scanner"
+	"github.com/veranemoloko/url-downloader/internal/scheduler"
 	"github.com/veranemoloko/url-downloader/internal/service"
 	"github.com/veranemoloko/url-downloader/internal/storage"
+	"github.com/veranemoloko/url-downloader/internal/tracing"
+	"github.com/veranemoloko/url-downloader/internal/validation"
+	"github.com/veranemoloko/url-downloader/internal/webhook"
 	"github.com/veranemoloko/url-downloader/internal/worker"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 func main() {
@@ -30,25 +43,218 @@ func main() {
 	logger := setupLogger(cfg.LogLevel)
 	slog.SetDefault(logger)
 
-	taskStorage, err := storage.NewTaskStorage(cfg.TaskDir)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Enabled:     cfg.TracingEnabled,
+		Endpoint:    cfg.TracingEndpoint,
+		ServiceName: cfg.TracingServiceName,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	if cfg.TracingEnabled {
+		logger.Info("tracing enabled", "endpoint", cfg.TracingEndpoint, "sample_ratio", cfg.TracingSampleRatio)
+	}
+
+	appMetrics := metrics.New()
+
+	storageOpts := []storage.TaskStorageOption{storage.WithMetrics(appMetrics)}
+	var secrets *storage.SecretBox
+	if len(cfg.CredentialsKey) > 0 {
+		secrets, err = storage.NewSecretBox(cfg.CredentialsKey)
+		if err != nil {
+			logger.Error("invalid credentials key", "error", err)
+			os.Exit(1)
+		}
+		storageOpts = append(storageOpts, storage.WithSecretBox(secrets))
+	}
+
+	taskStorage, err := storage.NewTaskStorage(cfg.TaskDir, storageOpts...)
 	if err != nil {
 		logger.Error("failed to initialize task storage", "error", err, "task_dir", cfg.TaskDir)
 		os.Exit(1)
 	}
 	logger.Info("task storage initialized", "task_dir", cfg.TaskDir)
 
+	scheduleStorage, err := storage.NewScheduleStorage(cfg.ScheduleDir, secrets)
+	if err != nil {
+		logger.Error("failed to initialize schedule storage", "error", err, "schedule_dir", cfg.ScheduleDir)
+		os.Exit(1)
+	}
+	schedules := scheduler.New(scheduleStorage, logger, scheduler.Config{HistoryLimit: cfg.ScheduleHistoryLimit})
+
 	fileStorage := storage.NewFileStorage(cfg.DownloadDir)
-	downloadWorker := worker.NewDownloadWorker(fileStorage, logger)
+	tlsConfig, err := worker.LoadTLSConfig(worker.TLSFiles{
+		CAFile:         cfg.TLSCAFile,
+		ClientCertFile: cfg.TLSClientCertFile,
+		ClientKeyFile:  cfg.TLSClientKeyFile,
+		MinVersion:     cfg.TLSMinVersion,
+	})
+	if err != nil {
+		logger.Error("invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
+
+	workerOpts := []worker.Option{
+		worker.WithMetrics(appMetrics),
+		worker.WithContentPolicy(worker.ContentPolicy{Allowed: cfg.AllowedMIMETypes}),
+		worker.WithTransport(worker.TransportConfig{
+			DialTimeout:           cfg.DialTimeout,
+			TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+			ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+			IdleReadTimeout:       cfg.IdleReadTimeout,
+			TotalTimeout:          cfg.DownloadTimeout,
+			TLSConfig:             tlsConfig,
+			DisableHTTP2:          !cfg.HTTP2Enabled,
+		}),
+		worker.WithExtractLimits(worker.ExtractLimits{
+			MaxRatio:     cfg.ExtractMaxRatio,
+			MaxTotalSize: cfg.ExtractMaxSize,
+			MaxFiles:     cfg.ExtractMaxFiles,
+		}),
+	}
+	if cfg.SFTPKnownHostsFile != "" {
+		hostKeys, err := knownhosts.New(cfg.SFTPKnownHostsFile)
+		if err != nil {
+			logger.Error("failed to load SFTP known hosts", "error", err, "file", cfg.SFTPKnownHostsFile)
+			os.Exit(1)
+		}
+		workerOpts = append(workerOpts, worker.WithSSHHostKeys(hostKeys))
+	}
+	if cfg.ProxyURL != "" {
+		proxyCfg := worker.ProxyConfig{URL: cfg.ProxyURL, NoProxy: cfg.NoProxy}
+		if err := proxyCfg.Validate(); err != nil {
+			logger.Error("invalid proxy configuration", "error", err)
+			os.Exit(1)
+		}
+		workerOpts = append(workerOpts, worker.WithProxy(proxyCfg))
+		logger.Info("outbound proxy enabled", "no_proxy", cfg.NoProxy)
+	}
+	if fileScanner := setupScanner(cfg); fileScanner != nil {
+		workerOpts = append(workerOpts, worker.WithScanner(fileScanner, cfg.QuarantineDir))
+		logger.Info("post-download scanning enabled",
+			"scanner", fileScanner.Name(),
+			"quarantine_dir", cfg.QuarantineDir,
+		)
+	}
+	if cfg.TorrentEnabled {
+		torrents, err := worker.NewTorrentClient(worker.TorrentConfig{
+			DataDir:         cfg.DownloadDir,
+			ListenPort:      cfg.TorrentListenPort,
+			NoDHT:           cfg.TorrentNoDHT,
+			SeedRatio:       cfg.TorrentSeedRatio,
+			MaxSeedTime:     cfg.TorrentMaxSeedTime,
+			MetadataTimeout: cfg.TorrentMetadataTimeout,
+			StallTimeout:    cfg.TorrentStallTimeout,
+		})
+		if err != nil {
+			logger.Error("failed to start torrent client", "error", err)
+			os.Exit(1)
+		}
+		defer torrents.Close()
+		workerOpts = append(workerOpts, worker.WithTorrents(torrents))
+		logger.Info("torrent downloads enabled",
+			"listen_port", cfg.TorrentListenPort,
+			"seed_ratio", cfg.TorrentSeedRatio,
+		)
+	}
+	var blobs *storage.BlobStore
+	if cfg.DedupEnabled {
+		blobs, err = storage.NewBlobStore(filepath.Join(cfg.DownloadDir, storage.BlobDirName))
+		if err != nil {
+			logger.Warn("deduplication disabled", "error", err)
+		} else {
+			workerOpts = append(workerOpts, worker.WithBlobStore(blobs))
+			logger.Info("download deduplication enabled", "gc_interval", cfg.DedupGCInterval)
+		}
+	}
+	downloadWorker := worker.NewDownloadWorker(fileStorage, logger, workerOpts...)
+	validation.UseSchemeRegistry(downloadWorker.Fetchers())
+	validation.UseProxyAllowlist(cfg.ProxyAllowlist)
+	logger.Info("download schemes registered", "schemes", downloadWorker.Fetchers().Schemes())
 
-	taskService := service.NewTaskService(taskStorage, fileStorage, downloadWorker, logger)
+	if cfg.WebhookDefaultURL != "" {
+		if err := validation.ValidateCallbackURL(cfg.WebhookDefaultURL); err != nil {
+			logger.Error("invalid default webhook URL", "error", err)
+			os.Exit(1)
+		}
+	}
+	if len(cfg.WebhookSecret) == 0 {
+		logger.Warn("WEBHOOK_SECRET is not set, webhook payloads will not be signed")
+	}
+	notifier := webhook.New(webhook.Config{
+		DefaultURL:     cfg.WebhookDefaultURL,
+		Secret:         cfg.WebhookSecret,
+		MaxAttempts:    cfg.WebhookMaxAttempts,
+		Timeout:        cfg.WebhookTimeout,
+		InitialBackoff: cfg.WebhookInitialBackoff,
+		MaxBackoff:     cfg.WebhookMaxBackoff,
+	}, func(task *domain.Task) any { return service.NewTaskResponse(task) }, logger)
+
+	serviceOpts := []service.Option{
+		service.WithMetrics(appMetrics),
+		service.WithWebhooks(notifier),
+		service.WithIdempotencyTTL(cfg.IdempotencyKeyTTL),
+		service.WithRetention(retentionPolicy(cfg)),
+		service.WithQueue(service.QueueConfig{
+			Capacity: cfg.TaskQueueCapacity,
+			Workers:  cfg.MaxWorkers,
+			Aging:    cfg.TaskQueueAging,
+		}),
+		service.WithStatusListener(schedules.TaskStatusChanged),
+	}
+	broker, err := setupBroker(cfg, logger)
+	if err != nil {
+		logger.Error("failed to connect to message queue", "error", err)
+		os.Exit(1)
+	}
+	if broker != nil {
+		defer broker.Close()
+		events := queue.NewEventPublisher(broker, cfg.QueueEventsSubject, logger)
+		serviceOpts = append(serviceOpts, service.WithStatusListener(events.TaskStatusChanged))
+	}
+
+	taskService := service.NewTaskService(taskStorage, fileStorage, downloadWorker, logger, serviceOpts...)
 	logger.Info("services initialized")
 
+	intakeCtx, stopIntake := context.WithCancel(context.Background())
+	defer stopIntake()
+	if broker != nil {
+		intake := queue.NewIntake(broker, cfg.QueueIntakeSubject, cfg.QueueIntakeGroup, taskService, logger)
+		if err := intake.Start(intakeCtx); err != nil {
+			logger.Error("failed to start queue intake", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("message queue enabled",
+			"driver", cfg.QueueDriver,
+			"intake_subject", cfg.QueueIntakeSubject,
+			"events_subject", cfg.QueueEventsSubject,
+		)
+	}
+
 	restoredCount, err := restoreInProgressTasks(taskService, taskStorage, logger)
 	if err != nil {
 		logger.Error("failed to restore in-progress tasks", "error", err)
 	} else if restoredCount > 0 {
 		logger.Info("tasks restored after restart", "count", restoredCount)
 	}
+	if resumed := taskService.ResumeWebhooks(); resumed > 0 {
+		logger.Info("webhook deliveries resumed", "count", resumed)
+	}
+	if cfg.RetentionEnabled {
+		logger.Info("retention janitor enabled",
+			"interval", cfg.RetentionInterval,
+			"completed_ttl", cfg.RetentionCompletedTTL,
+			"failed_ttl", cfg.RetentionFailedTTL,
+			"max_disk_usage", cfg.RetentionMaxDiskUsage,
+		)
+	}
+
+	stopBlobGC := startBlobGC(blobs, cfg.DedupGCInterval, logger)
+
+	schedules.Start(taskService)
+	logger.Info("scheduler started", "schedule_dir", cfg.ScheduleDir)
 
 	router := chi.NewRouter()
 
@@ -57,10 +263,21 @@ func main() {
 
 	taskHandler := api.NewTaskHandler(taskService)
 	taskHandler.RegisterRoutes(router)
+	api.NewScheduleHandler(schedules).RegisterRoutes(router)
+	router.Handle("/metrics", appMetrics.Handler())
+
+	checker := setupHealthChecks(cfg, taskService)
+	router.Get("/healthz", checker.LivenessHandler)
+	router.Get("/readyz", checker.ReadinessHandler)
+	if cfg.AdminToken != "" {
+		admin := router.With(api.RequireAdminToken(cfg.AdminToken))
+		admin.Get("/debug/state", api.DebugState(taskService))
+		admin.Post("/admin/retention", api.RunRetention(taskService))
+	}
 
 	server := &http.Server{
 		Addr:         cfg.ServerAddress,
-		Handler:      router,
+		Handler:      otelhttp.NewHandler(router, "http.server", otelhttp.WithFilter(isTraced)),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -99,6 +316,9 @@ func main() {
 		logger.Info("HTTP server stopped gracefully")
 	}
 
+	stopIntake()
+	schedules.Stop()
+
 	logger.Info("shutting down task service")
 	if err := taskService.Shutdown(shutdownCtx); err != nil {
 		logger.Error("task service shutdown failed", "error", err)
@@ -106,6 +326,12 @@ func main() {
 		logger.Info("task service stopped gracefully")
 	}
 
+	stopBlobGC()
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("tracing shutdown failed", "error", err)
+	}
+
 	logger.Info("application shutdown completed")
 }
 
@@ -130,6 +356,119 @@ func setupLogger(logLevel string) *slog.Logger {
 	}
 }
 
+// retentionPolicy builds the janitor policy. Background sweeps only run when retention is
+// enabled; the admin endpoint can trigger a sweep either way.
+func retentionPolicy(cfg *config.Config) service.RetentionPolicy {
+	policy := service.RetentionPolicy{
+		TTL: map[domain.TaskStatus]time.Duration{
+			domain.StatusCompleted: cfg.RetentionCompletedTTL,
+			domain.StatusFailed:    cfg.RetentionFailedTTL,
+		},
+		MaxDiskUsage: cfg.RetentionMaxDiskUsage,
+		OrphanGrace:  cfg.RetentionOrphanGrace,
+	}
+	if cfg.RetentionEnabled {
+		policy.Interval = cfg.RetentionInterval
+	}
+	return policy
+}
+
+// setupHealthChecks registers the probe checks. Only a stuck event processor fails
+// liveness; storage, disk space and queue problems make the instance unready.
+func setupHealthChecks(cfg *config.Config, taskService *service.TaskService) *health.Checker {
+	checker := health.NewChecker()
+	checker.AddLiveness("event_processor", taskService.CheckProcessor(cfg.HealthStallTimeout))
+	checker.AddReadiness("queue", taskService.CheckQueue(cfg.HealthQueueSaturation))
+	checker.AddReadiness("task_dir", health.DirWritable(cfg.TaskDir))
+	checker.AddReadiness("download_dir", health.DirWritable(cfg.DownloadDir))
+	checker.AddReadiness("download_dir_free_space", health.MinFreeSpace(cfg.DownloadDir, cfg.HealthMinFreeDisk))
+	checker.AddReadiness("task_dir_free_space", health.MinFreeSpace(cfg.TaskDir, cfg.HealthMinFreeDisk))
+	return checker
+}
+
+// isTraced keeps probes and metric scrapes out of the traces.
+func isTraced(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/healthz", "/readyz", "/metrics":
+		return false
+	}
+	return true
+}
+
+// setupBroker connects to the configured message queue, or returns nil when none is configured.
+func setupBroker(cfg *config.Config, logger *slog.Logger) (queue.Broker, error) {
+	switch cfg.QueueDriver {
+	case "":
+		return nil, nil
+	case "nats":
+		return queue.NewNATS(cfg.NATSURL, logger)
+	default:
+		return nil, fmt.Errorf("unsupported QUEUE_DRIVER %q", cfg.QueueDriver)
+	}
+}
+
+// startBlobGC removes blobs no task file refers to any more, once at startup and then
+// every interval. The returned function stops the collection.
+func startBlobGC(blobs *storage.BlobStore, interval time.Duration, logger *slog.Logger) func() {
+	if blobs == nil {
+		return func() {}
+	}
+
+	collect := func() {
+		stats, err := blobs.GC()
+		if err != nil {
+			logger.Error("blob garbage collection failed", "error", err)
+			return
+		}
+		if stats.Blobs > 0 {
+			logger.Info("unreferenced blobs removed", "count", stats.Blobs, "bytes", stats.Bytes)
+		}
+	}
+	collect()
+	if interval <= 0 {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				collect()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// setupScanner returns the configured file scanner, or nil when scanning is disabled.
+// CLAMD_ADDRESS accepts "tcp://host:port", "unix:///path/to/clamd.sock" or a bare host:port.
+func setupScanner(cfg *config.Config) scanner.Scanner {
+	switch {
+	case cfg.ClamdAddress != "":
+		network, address := "tcp", cfg.ClamdAddress
+		if rest, ok := strings.CutPrefix(address, "unix://"); ok {
+			network, address = "unix", rest
+		} else {
+			address = strings.TrimPrefix(address, "tcp://")
+		}
+		return scanner.NewClamdScanner(network, address, cfg.ScanTimeout)
+	case len(cfg.ScanCommand) > 0:
+		return scanner.NewCommandScanner(cfg.ScanCommand[0], cfg.ScanCommand[1:]...)
+	default:
+		return nil
+	}
+}
+
 func restoreInProgressTasks(service *service.TaskService, storage *storage.TaskStorage, logger *slog.Logger) (int, error) {
 	tasks := storage.GetAll()
 	restoredCount := 0
@@ -142,22 +481,12 @@ func restoreInProgressTasks(service *service.TaskService, storage *storage.TaskS
 			)
 
 			task.Status = domain.StatusPending
-			if err := storage.Save(task); err != nil {
+			if err := storage.Save(context.Background(), task); err != nil {
 				return restoredCount, err
 			}
 
 			restoredCount++
-
-			go func(t *domain.Task) {
-				if err := service.ProcessTask(context.Background(), t); err != nil {
-					logger.Error("failed to process restored task",
-						"error", err,
-						"task_id", t.ID,
-					)
-				} else {
-					logger.Info("restored task processing completed", "task_id", t.ID)
-				}
-			}(task)
+			service.Requeue(task)
 		}
 	}
 
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/veranemoloko/url-downloader/internal/service"
+)
+
+// RetentionRunner runs a retention sweep, removing nothing when dryRun is set.
+type RetentionRunner interface {
+	RunRetention(dryRun bool) (service.RetentionReport, error)
+}
+
+// RunRetention triggers a retention sweep and responds with its report.
+// The dry_run query parameter previews the sweep without removing anything.
+func RunRetention(runner RetentionRunner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun := false
+		if value := r.URL.Query().Get("dry_run"); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				sendError(w, "dry_run must be a boolean", http.StatusBadRequest)
+				return
+			}
+			dryRun = parsed
+		}
+
+		report, err := runner.RunRetention(dryRun)
+		if err != nil {
+			sendError(w, "retention sweep failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
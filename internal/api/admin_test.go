@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/veranemoloko/url-downloader/internal/service"
+)
+
+type stubRetention struct {
+	dryRun *bool
+	err    error
+}
+
+func (s stubRetention) RunRetention(dryRun bool) (service.RetentionReport, error) {
+	*s.dryRun = dryRun
+	return service.RetentionReport{DryRun: dryRun, FreedBytes: 42}, s.err
+}
+
+func TestRunRetention(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		err        error
+		wantStatus int
+		wantDryRun bool
+	}{
+		{"sweep", "", nil, http.StatusOK, false},
+		{"dry run", "?dry_run=true", nil, http.StatusOK, true},
+		{"invalid dry run", "?dry_run=maybe", nil, http.StatusBadRequest, false},
+		{"failure", "", errors.New("disk gone"), http.StatusInternalServerError, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dryRun bool
+			router := chi.NewRouter()
+			router.With(RequireAdminToken("secret")).Post("/admin/retention", RunRetention(stubRetention{dryRun: &dryRun, err: tt.err}))
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/retention"+tt.query, nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var report service.RetentionReport
+			if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if dryRun != tt.wantDryRun || report.DryRun != tt.wantDryRun || report.FreedBytes != 42 {
+				t.Errorf("expected dry_run=%v report, got %+v (runner saw %v)", tt.wantDryRun, report, dryRun)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/veranemoloko/url-downloader/internal/service"
+)
+
+type stubState struct{ state service.State }
+
+func (s stubState) State() service.State { return s.state }
+
+func TestDebugState_RequiresAdminToken(t *testing.T) {
+	router := chi.NewRouter()
+	router.With(RequireAdminToken("secret")).Get("/debug/state", DebugState(stubState{service.State{Goroutines: 7}}))
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong", "Bearer nope", http.StatusUnauthorized},
+		{"not bearer", "Basic secret", http.StatusUnauthorized},
+		{"valid", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/debug/state", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %d, got %d", tt.want, rec.Code)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var state service.State
+			if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if state.Goroutines != 7 {
+				t.Fatalf("unexpected state: %+v", state)
+			}
+		})
+	}
+}
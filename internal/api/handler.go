@@ -2,7 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -10,8 +15,13 @@ import (
 	"github.com/veranemoloko/url-downloader/internal/domain"
 	"github.com/veranemoloko/url-downloader/internal/service"
 	"github.com/veranemoloko/url-downloader/internal/validation"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+var tracer = otel.Tracer("github.com/veranemoloko/url-downloader/internal/api")
+
 var validate = validator.New()
 
 type TaskHandler struct {
@@ -23,53 +33,84 @@ func NewTaskHandler(s service.TaskServiceInterface) *TaskHandler {
 	return &TaskHandler{service: s}
 }
 
-type CreateTaskRequest struct {
-	URLs []string `json:"urls" validate:"required,min=1,max=100,dive,required,url"`
+// UpdateTaskRequest changes a task that is still queued.
+type UpdateTaskRequest struct {
+	Priority *int `json:"priority" validate:"required,gte=-10,lte=10"`
+}
+
+// RetryTaskRequest selects the URLs of a finished task to download again.
+type RetryTaskRequest struct {
+	URLs []string `json:"urls,omitempty" validate:"omitempty,max=100,dive,required"`
 }
 
-type TaskResponse struct {
-	ID        string                  `json:"id"`
-	URLs      []string                `json:"urls"`
-	Status    domain.TaskStatus       `json:"status"`
-	Results   []domain.DownloadResult `json:"results,omitempty"`
-	CreatedAt string                  `json:"created_at"`
-	UpdatedAt string                  `json:"updated_at"`
+// AddURLsRequest appends URLs to a task that has not finished yet.
+type AddURLsRequest struct {
+	URLs []string `json:"urls" validate:"required,min=1,max=100,dive,required"`
+}
+
+// TaskURLResponse renders a single URL of a task with its state and result.
+type TaskURLResponse struct {
+	TaskID string `json:"task_id"`
+	Index  int    `json:"index"`
+	domain.DownloadResult
 }
 
 // CreateTask handles HTTP POST requests to create a new download task.
 // It validates the input URLs and returns the created task in the response.
 func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
-	var req CreateTaskRequest
+	ctx, span := tracer.Start(r.Context(), "TaskHandler.CreateTask")
+	defer span.End()
+
+	var req service.CreateTaskRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		sendError(w, "invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	if err := validate.Struct(req); err != nil {
+	opts, err := req.TaskOptions()
+	if err != nil {
 		sendError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := validation.ValidateURLs(req.URLs); err != nil {
+	if key := r.Header.Get(service.IdempotencyKeyHeader); key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			sendError(w, fmt.Sprintf("%s must be at most %d characters", service.IdempotencyKeyHeader, maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+		ctx = service.WithIdempotencyKey(ctx, key, req.Fingerprint())
+	}
+
+	task, err := h.service.CreateTask(ctx, req.URLs, opts)
+	if errors.Is(err, service.ErrCredentialsNotSupported) || errors.Is(err, service.ErrDuplicateURL) {
 		sendError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	task, err := h.service.CreateTask(req.URLs)
+	if errors.Is(err, service.ErrIdempotencyKeyReused) {
+		sendError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, service.ErrQueueFull) {
+		sendRetryLater(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, service.ErrShuttingDown) {
+		sendRetryLater(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		sendError(w, "create task failed", http.StatusInternalServerError)
 		return
 	}
+	span.SetAttributes(
+		attribute.String("task.id", task.ID),
+		attribute.Int("task.urls", len(task.URLs)),
+	)
 
-	response := TaskResponse{
-		ID:        task.ID,
-		URLs:      task.URLs,
-		Status:    task.Status,
-		Results:   task.Results,
-		CreatedAt: task.CreatedAt.Format(time.RFC3339),
-		UpdatedAt: task.UpdatedAt.Format(time.RFC3339),
-	}
+	response := service.NewTaskResponse(task)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -78,6 +119,8 @@ func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+const maxIdempotencyKeyLength = 255
+
 // GetTask handles HTTP GET requests to retrieve a task by its ID.
 // Returns the task details including status and download results.
 func (h *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request) {
@@ -93,13 +136,161 @@ func (h *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response := TaskResponse{
-		ID:        task.ID,
-		URLs:      task.URLs,
-		Status:    task.Status,
-		Results:   task.Results,
-		CreatedAt: task.CreatedAt.Format(time.RFC3339),
-		UpdatedAt: task.UpdatedAt.Format(time.RFC3339),
+	response := service.NewTaskResponse(task)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// UpdateTask handles HTTP PATCH requests that change the priority of a queued task.
+// Tasks that have already started cannot be changed and get 409.
+func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
+	var req UpdateTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	task, err := h.service.SetPriority(chi.URLParam(r, "id"), *req.Priority)
+	switch {
+	case errors.Is(err, service.ErrTaskNotFound):
+		sendError(w, "task not found", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrTaskNotQueued):
+		sendError(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrShuttingDown):
+		sendRetryLater(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		sendError(w, "update task failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(service.NewTaskResponse(task)); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// RetryTask handles HTTP POST requests that download failed URLs of a finished task again.
+// The optional body lists the URLs to retry; without it every failed URL is retried.
+func (h *TaskHandler) RetryTask(w http.ResponseWriter, r *http.Request) {
+	var req RetryTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		sendError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	task, err := h.service.RetryTask(chi.URLParam(r, "id"), req.URLs)
+	switch {
+	case errors.Is(err, service.ErrTaskNotFound):
+		sendError(w, "task not found", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrUnknownURL):
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrTaskNotFinished), errors.Is(err, service.ErrNothingToRetry):
+		sendError(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrQueueFull):
+		sendRetryLater(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case errors.Is(err, service.ErrShuttingDown):
+		sendRetryLater(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		sendError(w, "retry task failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(service.NewTaskResponse(task)); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// AddURLs handles HTTP POST requests that append URLs to a task that has not finished.
+// URLs the task already has are ignored. Finished tasks get 409.
+func (h *TaskHandler) AddURLs(w http.ResponseWriter, r *http.Request) {
+	var req AddURLsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validation.ValidateURLs(req.URLs); err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	task, err := h.service.AddURLs(chi.URLParam(r, "id"), req.URLs)
+	switch {
+	case errors.Is(err, service.ErrTaskNotFound):
+		sendError(w, "task not found", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrTaskFinished):
+		sendError(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrShuttingDown):
+		sendRetryLater(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		sendError(w, "add urls failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(service.NewTaskResponse(task)); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// GetTaskURL handles HTTP GET requests for a single URL of a task, addressed by its
+// position in the task's URL list. Results are kept in the order of the URLs; tasks
+// stored before every URL had a result are looked up by URL.
+func (h *TaskHandler) GetTaskURL(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		sendError(w, "index must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	task, err := h.service.GetTask(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if index < 0 || index >= len(task.URLs) {
+		sendError(w, "url not found", http.StatusNotFound)
+		return
+	}
+
+	url := task.URLs[index]
+	response := TaskURLResponse{
+		TaskID:         task.ID,
+		Index:          index,
+		DownloadResult: domain.DownloadResult{URL: url, State: domain.URLQueued},
+	}
+	if index < len(task.Results) && task.Results[index].URL == url {
+		response.DownloadResult = task.Results[index]
+	} else if i := slices.IndexFunc(task.Results, func(r domain.DownloadResult) bool { return r.URL == url }); i >= 0 {
+		response.DownloadResult = task.Results[i]
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -108,14 +299,29 @@ func (h *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// RegisterRoutes registers the HTTP routes for task operations (create and get).
+// RegisterRoutes registers the HTTP routes for task operations (create, get, update and
+// retry) and for the URLs of a task.
 func (h *TaskHandler) RegisterRoutes(router chi.Router) {
 	router.Route("/tasks", func(r chi.Router) {
 		r.Post("/", h.CreateTask)
 		r.Get("/{id}", h.GetTask)
+		r.Patch("/{id}", h.UpdateTask)
+		r.Post("/{id}/retry", h.RetryTask)
+		r.Post("/{id}/urls", h.AddURLs)
+		r.Get("/{id}/urls/{index}", h.GetTaskURL)
 	})
 }
 
+// retryAfter is the delay suggested to clients when the task queue is full or the
+// service is restarting.
+const retryAfter = 5 * time.Second
+
+// sendRetryLater sends a JSON error response asking the client to retry after retryAfter.
+func sendRetryLater(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	sendError(w, message, status)
+}
+
 // sendError is an internal helper function to send a JSON error response.
 func sendError(w http.ResponseWriter, message string, status int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -6,20 +6,23 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/require"
 	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/service"
 )
 
 type mockTaskService struct{}
 
-func (m *mockTaskService) CreateTask(urls []string) (*domain.Task, error) {
+func (m *mockTaskService) CreateTask(ctx context.Context, urls []string, opts domain.TaskOptions) (*domain.Task, error) {
 	return &domain.Task{
 		ID:        "test-id",
 		URLs:      urls,
+		Options:   opts,
 		Status:    domain.StatusPending,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -37,6 +40,62 @@ func (m *mockTaskService) GetTask(id string) (*domain.Task, error) {
 	}, nil
 }
 
+func (m *mockTaskService) SetPriority(id string, priority int) (*domain.Task, error) {
+	switch id {
+	case "missing":
+		return nil, service.ErrTaskNotFound
+	case "running":
+		return nil, service.ErrTaskNotQueued
+	}
+	return &domain.Task{
+		ID:      id,
+		URLs:    []string{"http://example.com"},
+		Status:  domain.StatusPending,
+		Options: domain.TaskOptions{Priority: priority},
+	}, nil
+}
+
+func (m *mockTaskService) RetryTask(id string, urls []string) (*domain.Task, error) {
+	switch id {
+	case "missing":
+		return nil, service.ErrTaskNotFound
+	case "running":
+		return nil, service.ErrTaskNotFinished
+	case "completed":
+		return nil, service.ErrNothingToRetry
+	}
+	for _, url := range urls {
+		if url != "http://example.com" {
+			return nil, service.ErrUnknownURL
+		}
+	}
+	return &domain.Task{
+		ID:      id,
+		URLs:    []string{"http://example.com"},
+		Status:  domain.StatusPending,
+		Retry:   []string{"http://example.com"},
+		Results: []domain.DownloadResult{{URL: "http://example.com", Attempts: []domain.DownloadAttempt{{Error: "timeout"}}}},
+	}, nil
+}
+
+func (m *mockTaskService) AddURLs(id string, urls []string) (*domain.Task, error) {
+	switch id {
+	case "missing":
+		return nil, service.ErrTaskNotFound
+	case "done":
+		return nil, service.ErrTaskFinished
+	}
+	task := &domain.Task{
+		ID:      id,
+		URLs:    []string{"http://example.com"},
+		Status:  domain.StatusInProgress,
+		Results: []domain.DownloadResult{{URL: "http://example.com", State: domain.URLDownloading}},
+	}
+	task.URLs = append(task.URLs, urls...)
+	task.Results = append(task.Results, domain.QueuedResults(urls)...)
+	return task, nil
+}
+
 func TestTaskHandler_CreateTask(t *testing.T) {
 	svc := &mockTaskService{}
 	handler := NewTaskHandler(svc)
@@ -81,3 +140,358 @@ func TestTaskHandler_GetTask(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "test-id", resp["id"])
 }
+
+func TestTaskHandler_CreateTask_InvalidCredentials(t *testing.T) {
+	svc := &mockTaskService{}
+	handler := NewTaskHandler(svc)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{
+			name: "credentials for unknown URL",
+			body: `{"urls":["http://example.com"],"url_credentials":{"http://other.com":{"bearer_token":"t"}}}`,
+		},
+		{
+			name: "header injection",
+			body: `{"urls":["http://example.com"],"credentials":{"headers":{"X-Test":"a\r\nInjected: b"}}}`,
+		},
+		{
+			name: "range override",
+			body: `{"urls":["http://example.com"],"credentials":{"headers":{"Range":"bytes=0-"}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader([]byte(tt.body)))
+			w := httptest.NewRecorder()
+
+			handler.CreateTask(w, req)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestTaskHandler_CreateTask_DuplicateURLs(t *testing.T) {
+	handler := NewTaskHandler(&mockTaskService{})
+
+	body := `{"urls":["http://example.com/a","http://example.com/b","http://example.com/a"]}`
+	req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handler.CreateTask(w, req)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestTaskHandler_CreateTask_InvalidMirrors(t *testing.T) {
+	svc := &mockTaskService{}
+	handler := NewTaskHandler(svc)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{
+			name: "mirrors for unknown URL",
+			body: `{"urls":["http://example.com/a"],"mirrors":{"http://example.com/b":["http://mirror.example.com/b"]}}`,
+		},
+		{
+			name: "private mirror",
+			body: `{"urls":["http://example.com/a"],"mirrors":{"http://example.com/a":["http://127.0.0.1/a"]}}`,
+		},
+		{
+			name: "empty mirror list",
+			body: `{"urls":["http://example.com/a"],"mirrors":{"http://example.com/a":[]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader([]byte(tt.body)))
+			w := httptest.NewRecorder()
+
+			handler.CreateTask(w, req)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestTaskHandler_CreateTask_InvalidCrawl(t *testing.T) {
+	svc := &mockTaskService{}
+	handler := NewTaskHandler(svc)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{
+			name: "bad include pattern",
+			body: `{"urls":["http://example.com/pub/"],"crawl":{"include":["(unclosed"]}}`,
+		},
+		{
+			name: "depth too large",
+			body: `{"urls":["http://example.com/pub/"],"crawl":{"depth":50}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader([]byte(tt.body)))
+			w := httptest.NewRecorder()
+
+			handler.CreateTask(w, req)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestTaskHandler_CreateTask_InvalidCallbackURL(t *testing.T) {
+	svc := &mockTaskService{}
+	handler := NewTaskHandler(svc)
+
+	for _, callback := range []string{"ftp://hooks.example.com/done", "http://127.0.0.1:9000/done", "http://10.1.2.3/done"} {
+		t.Run(callback, func(t *testing.T) {
+			body := `{"urls":["http://example.com/file"],"callback_url":"` + callback + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader([]byte(body)))
+			w := httptest.NewRecorder()
+
+			handler.CreateTask(w, req)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+type conflictTaskService struct{ mockTaskService }
+
+func (m *conflictTaskService) CreateTask(ctx context.Context, urls []string, opts domain.TaskOptions) (*domain.Task, error) {
+	return nil, service.ErrIdempotencyKeyReused
+}
+
+func TestTaskHandler_CreateTask_IdempotencyKey(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  service.TaskServiceInterface
+		key  string
+		want int
+	}{
+		{"accepted", &mockTaskService{}, "retry-1", http.StatusCreated},
+		{"reused with different body", &conflictTaskService{}, "retry-1", http.StatusConflict},
+		{"key too long", &mockTaskService{}, strings.Repeat("k", 256), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"urls":["http://example.com"]}`))
+			req.Header.Set(service.IdempotencyKeyHeader, tt.key)
+			w := httptest.NewRecorder()
+
+			NewTaskHandler(tt.svc).CreateTask(w, req)
+
+			require.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+type erroringTaskService struct {
+	mockTaskService
+	err error
+}
+
+func (m *erroringTaskService) CreateTask(ctx context.Context, urls []string, opts domain.TaskOptions) (*domain.Task, error) {
+	return nil, m.err
+}
+
+func TestTaskHandler_CreateTask_QueueBackpressure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"queue full", service.ErrQueueFull, http.StatusTooManyRequests},
+		{"shutting down", service.ErrShuttingDown, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"urls":["http://example.com"]}`))
+			w := httptest.NewRecorder()
+
+			NewTaskHandler(&erroringTaskService{err: tt.err}).CreateTask(w, req)
+
+			require.Equal(t, tt.want, w.Code)
+			require.Equal(t, "5", w.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestTaskHandler_CreateTask_Priority(t *testing.T) {
+	for body, want := range map[string]int{
+		`{"urls":["http://example.com"],"priority":7}`:   http.StatusCreated,
+		`{"urls":["http://example.com"],"priority":-10}`: http.StatusCreated,
+		`{"urls":["http://example.com"],"priority":11}`:  http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		NewTaskHandler(&mockTaskService{}).CreateTask(w, req)
+
+		require.Equal(t, want, w.Code, body)
+		if want == http.StatusCreated {
+			var resp service.TaskResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			var sent service.CreateTaskRequest
+			require.NoError(t, json.Unmarshal([]byte(body), &sent))
+			require.Equal(t, sent.Priority, resp.Priority)
+		}
+	}
+}
+
+func TestTaskHandler_UpdateTask(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		body string
+		want int
+	}{
+		{"queued", "queued", `{"priority":3}`, http.StatusOK},
+		{"not queued", "running", `{"priority":3}`, http.StatusConflict},
+		{"missing", "missing", `{"priority":3}`, http.StatusNotFound},
+		{"out of range", "queued", `{"priority":42}`, http.StatusBadRequest},
+		{"no priority", "queued", `{}`, http.StatusBadRequest},
+		{"invalid JSON", "queued", `{`, http.StatusBadRequest},
+	}
+
+	router := chi.NewRouter()
+	NewTaskHandler(&mockTaskService{}).RegisterRoutes(router)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/tasks/"+tt.id, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.want, w.Code)
+			if tt.want == http.StatusOK {
+				var resp service.TaskResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				require.Equal(t, 3, resp.Priority)
+			}
+		})
+	}
+}
+
+func TestTaskHandler_RetryTask(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		body string
+		want int
+	}{
+		{"failed urls", "failed", ``, http.StatusAccepted},
+		{"given urls", "failed", `{"urls":["http://example.com"]}`, http.StatusAccepted},
+		{"unknown url", "failed", `{"urls":["http://other.example"]}`, http.StatusBadRequest},
+		{"running", "running", ``, http.StatusConflict},
+		{"nothing failed", "completed", ``, http.StatusConflict},
+		{"missing", "missing", ``, http.StatusNotFound},
+		{"invalid JSON", "failed", `{`, http.StatusBadRequest},
+	}
+
+	router := chi.NewRouter()
+	NewTaskHandler(&mockTaskService{}).RegisterRoutes(router)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tasks/"+tt.id+"/retry", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.want, w.Code)
+			if tt.want == http.StatusAccepted {
+				var resp service.TaskResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				require.Equal(t, domain.StatusPending, resp.Status)
+				require.Len(t, resp.Results[0].Attempts, 1)
+			}
+		})
+	}
+}
+
+func TestTaskHandler_AddURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		body string
+		want int
+	}{
+		{"running", "running", `{"urls":["http://example.com/more"]}`, http.StatusAccepted},
+		{"finished", "done", `{"urls":["http://example.com/more"]}`, http.StatusConflict},
+		{"missing", "missing", `{"urls":["http://example.com/more"]}`, http.StatusNotFound},
+		{"no urls", "running", `{"urls":[]}`, http.StatusBadRequest},
+		{"invalid url", "running", `{"urls":["not a url"]}`, http.StatusBadRequest},
+		{"invalid JSON", "running", `{`, http.StatusBadRequest},
+	}
+
+	router := chi.NewRouter()
+	NewTaskHandler(&mockTaskService{}).RegisterRoutes(router)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tasks/"+tt.id+"/urls", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.want, w.Code)
+			if tt.want == http.StatusAccepted {
+				var resp service.TaskResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				require.Len(t, resp.URLs, 2)
+				require.Equal(t, domain.URLQueued, resp.Results[1].State)
+			}
+		})
+	}
+}
+
+func TestTaskHandler_GetTaskURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		index string
+		want  int
+	}{
+		{"first", "0", http.StatusOK},
+		{"out of range", "1", http.StatusNotFound},
+		{"negative", "-1", http.StatusNotFound},
+		{"not a number", "first", http.StatusBadRequest},
+	}
+
+	router := chi.NewRouter()
+	NewTaskHandler(&mockTaskService{}).RegisterRoutes(router)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/tasks/task/urls/"+tt.index, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.want, w.Code)
+			if tt.want == http.StatusOK {
+				var resp TaskURLResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				require.Equal(t, "task", resp.TaskID)
+				require.Equal(t, 0, resp.Index)
+				require.Equal(t, "http://example.com", resp.URL)
+				require.True(t, resp.Success)
+			}
+		})
+	}
+}
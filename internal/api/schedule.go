@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/scheduler"
+	"github.com/veranemoloko/url-downloader/internal/service"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// ScheduleService manages scheduled downloads. It is implemented by scheduler.Scheduler.
+type ScheduleService interface {
+	Create(urls []string, opts domain.TaskOptions, startAt *time.Time, cron string) (*domain.Schedule, error)
+	Get(id string) (*domain.Schedule, error)
+	List() []*domain.Schedule
+	Delete(id string) error
+}
+
+// ScheduleHandler serves the /schedules endpoints.
+type ScheduleHandler struct {
+	schedules ScheduleService
+}
+
+// NewScheduleHandler creates a ScheduleHandler backed by s.
+func NewScheduleHandler(s ScheduleService) *ScheduleHandler {
+	return &ScheduleHandler{schedules: s}
+}
+
+// CreateScheduleRequest submits the task described by the embedded CreateTaskRequest at
+// StartAt, on every match of Cron, or both. At least one of them is required.
+type CreateScheduleRequest struct {
+	service.CreateTaskRequest
+	StartAt *time.Time `json:"start_at,omitempty"`
+	Cron    string     `json:"cron,omitempty" validate:"max=256"`
+}
+
+// ScheduleResponse renders a schedule. Like service.TaskResponse it leaves out the task options,
+// so that credentials are never returned.
+type ScheduleResponse struct {
+	ID         string                       `json:"id"`
+	URLs       []string                     `json:"urls"`
+	Priority   int                          `json:"priority"`
+	StartAt    *time.Time                   `json:"start_at,omitempty"`
+	Cron       string                       `json:"cron,omitempty"`
+	NextRunAt  *time.Time                   `json:"next_run_at,omitempty"`
+	Validators map[string]domain.Validators `json:"validators,omitempty"`
+	Runs       []domain.ScheduleRun         `json:"runs"`
+	CreatedAt  string                       `json:"created_at"`
+	UpdatedAt  string                       `json:"updated_at"`
+}
+
+// NewScheduleResponse renders a schedule for API responses.
+func NewScheduleResponse(schedule *domain.Schedule) ScheduleResponse {
+	runs := schedule.Runs
+	if runs == nil {
+		runs = []domain.ScheduleRun{}
+	}
+	return ScheduleResponse{
+		ID:         schedule.ID,
+		URLs:       schedule.URLs,
+		Priority:   schedule.Options.Priority,
+		StartAt:    schedule.StartAt,
+		Cron:       schedule.Cron,
+		NextRunAt:  schedule.NextRunAt,
+		Validators: schedule.Validators,
+		Runs:       runs,
+		CreatedAt:  schedule.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:  schedule.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// CreateSchedule handles HTTP POST requests that create a schedule.
+func (h *ScheduleHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var req CreateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := req.TaskOptions()
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := h.schedules.Create(req.URLs, opts, req.StartAt, req.Cron)
+	switch {
+	case errors.Is(err, scheduler.ErrInvalidSchedule), errors.Is(err, storage.ErrNoSecretBox):
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		sendError(w, "create schedule failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(NewScheduleResponse(schedule)); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// ListSchedules handles HTTP GET requests for all schedules, oldest first.
+func (h *ScheduleHandler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules := h.schedules.List()
+	response := make([]ScheduleResponse, 0, len(schedules))
+	for _, schedule := range schedules {
+		response = append(response, NewScheduleResponse(schedule))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// GetSchedule handles HTTP GET requests for a schedule and its run history.
+func (h *ScheduleHandler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, err := h.schedules.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, "schedule not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(NewScheduleResponse(schedule)); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// DeleteSchedule handles HTTP DELETE requests for a schedule. Tasks the schedule
+// already submitted are not affected.
+func (h *ScheduleHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	err := h.schedules.Delete(chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, storage.ErrScheduleNotFound):
+		sendError(w, "schedule not found", http.StatusNotFound)
+		return
+	case err != nil:
+		sendError(w, "delete schedule failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegisterRoutes registers the HTTP routes for schedule operations.
+func (h *ScheduleHandler) RegisterRoutes(router chi.Router) {
+	router.Route("/schedules", func(r chi.Router) {
+		r.Get("/", h.ListSchedules)
+		r.Post("/", h.CreateSchedule)
+		r.Get("/{id}", h.GetSchedule)
+		r.Delete("/{id}", h.DeleteSchedule)
+	})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/veranemoloko/url-downloader/internal/scheduler"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+func newScheduleRouter(t *testing.T) chi.Router {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "schedules_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	st, err := storage.NewScheduleStorage(dir, nil)
+	if err != nil {
+		t.Fatalf("NewScheduleStorage error: %v", err)
+	}
+	router := chi.NewRouter()
+	NewScheduleHandler(scheduler.New(st, slog.New(slog.NewTextHandler(io.Discard, nil)), scheduler.Config{})).RegisterRoutes(router)
+	return router
+}
+
+func serve(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestScheduleHandler_Lifecycle(t *testing.T) {
+	router := newScheduleRouter(t)
+
+	w := serve(router, http.MethodPost, "/schedules", `{"urls":["https://example.com/feed.xml"],"cron":"0 3 * * *","priority":2}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created ScheduleResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if created.ID == "" || created.Cron != "0 3 * * *" || created.NextRunAt == nil || created.Priority != 2 {
+		t.Errorf("unexpected schedule %+v", created)
+	}
+
+	w = serve(router, http.MethodGet, "/schedules", "")
+	var list []ScheduleResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode list: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != created.ID {
+		t.Errorf("expected the created schedule to be listed, got %+v", list)
+	}
+
+	if w = serve(router, http.MethodGet, "/schedules/"+created.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", w.Code)
+	}
+	if w = serve(router, http.MethodDelete, "/schedules/"+created.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", w.Code)
+	}
+	if w = serve(router, http.MethodGet, "/schedules/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %d", w.Code)
+	}
+	if w = serve(router, http.MethodDelete, "/schedules/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 deleting twice, got %d", w.Code)
+	}
+}
+
+func TestScheduleHandler_CreateInvalid(t *testing.T) {
+	router := newScheduleRouter(t)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no timing", `{"urls":["https://example.com/a"]}`},
+		{"bad cron", `{"urls":["https://example.com/a"],"cron":"every day"}`},
+		{"bad url", `{"urls":["ftp//broken"],"cron":"@daily"}`},
+		{"credentials without key", `{"urls":["https://example.com/a"],"cron":"@daily","credentials":{"bearer_token":"x"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, http.MethodPost, "/schedules", tt.body)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+			}
+			if bytes.Contains(w.Body.Bytes(), []byte(`"x"`)) {
+				t.Errorf("expected credentials not to be echoed")
+			}
+		})
+	}
+}
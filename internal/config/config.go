@@ -1,9 +1,11 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -16,11 +18,91 @@ type Config struct {
 	MaxWorkers    int
 	SaveInterval  time.Duration
 	LogLevel      string
+
+	AllowedMIMETypes []string
+
+	QuarantineDir string
+	ClamdAddress  string
+	ScanCommand   []string
+	ScanTimeout   time.Duration
+
+	CredentialsKey []byte
+
+	ProxyURL string
+	NoProxy  string
+	// ProxyAllowlist lists local or private proxy hosts that tasks may select.
+	ProxyAllowlist []string
+
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleReadTimeout       time.Duration
+	DownloadTimeout       time.Duration
+	TLSCAFile             string
+	TLSClientCertFile     string
+	TLSClientKeyFile      string
+	TLSMinVersion         string
+	HTTP2Enabled          bool
+
+	SFTPKnownHostsFile string
+
+	TorrentEnabled         bool
+	TorrentListenPort      int
+	TorrentNoDHT           bool
+	TorrentSeedRatio       float64
+	TorrentMaxSeedTime     time.Duration
+	TorrentMetadataTimeout time.Duration
+	TorrentStallTimeout    time.Duration
+
+	ExtractMaxRatio float64
+	ExtractMaxSize  int64
+	ExtractMaxFiles int
+
+	TracingEnabled     bool
+	TracingEndpoint    string
+	TracingServiceName string
+	TracingSampleRatio float64
+
+	AdminToken            string
+	HealthMinFreeDisk     int64
+	HealthQueueSaturation float64
+	HealthStallTimeout    time.Duration
+
+	WebhookDefaultURL     string
+	WebhookSecret         []byte
+	WebhookMaxAttempts    int
+	WebhookTimeout        time.Duration
+	WebhookInitialBackoff time.Duration
+	WebhookMaxBackoff     time.Duration
+
+	QueueDriver        string
+	NATSURL            string
+	QueueIntakeSubject string
+	QueueIntakeGroup   string
+	QueueEventsSubject string
+
+	IdempotencyKeyTTL time.Duration
+
+	DedupEnabled    bool
+	DedupGCInterval time.Duration
+
+	RetentionEnabled      bool
+	RetentionCompletedTTL time.Duration
+	RetentionFailedTTL    time.Duration
+	RetentionMaxDiskUsage int64
+	RetentionOrphanGrace  time.Duration
+	RetentionInterval     time.Duration
+
+	TaskQueueCapacity int
+	TaskQueueAging    time.Duration
+
+	ScheduleDir          string
+	ScheduleHistoryLimit int
 }
 
 // Load reads environment variables (optionally from a .env file) and
 // returns a Config struct with default values applied if variables are missing.
-// It also ensures that the download and task directories exist.
+// It also ensures that the download, task and schedule directories exist.
 func Load() (*Config, error) {
 
 	if err := godotenv.Load(); err != nil {
@@ -34,6 +116,91 @@ func Load() (*Config, error) {
 		MaxWorkers:    getEnvAsInt("MAX_WORKERS", 5),
 		SaveInterval:  getEnvAsDuration("SAVE_INTERVAL", time.Second*10),
 		LogLevel:      getEnv("LOG_LEVEL", "INFO"),
+
+		AllowedMIMETypes: getEnvAsSlice("ALLOWED_MIME_TYPES", nil),
+
+		QuarantineDir: getEnv("QUARANTINE_DIR", "downloads/quarantine"),
+		ClamdAddress:  getEnv("CLAMD_ADDRESS", ""),
+		ScanCommand:   strings.Fields(getEnv("SCAN_COMMAND", "")),
+		ScanTimeout:   getEnvAsDuration("SCAN_TIMEOUT", 5*time.Minute),
+
+		ProxyURL:       getEnv("PROXY_URL", ""),
+		NoProxy:        getEnv("NO_PROXY", ""),
+		ProxyAllowlist: getEnvAsSlice("PROXY_ALLOWLIST", nil),
+
+		DialTimeout:           getEnvAsDuration("DIAL_TIMEOUT", 30*time.Second),
+		TLSHandshakeTimeout:   getEnvAsDuration("TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+		ResponseHeaderTimeout: getEnvAsDuration("RESPONSE_HEADER_TIMEOUT", time.Minute),
+		IdleReadTimeout:       getEnvAsDuration("IDLE_READ_TIMEOUT", 2*time.Minute),
+		DownloadTimeout:       getEnvAsDuration("DOWNLOAD_TIMEOUT", 0),
+		TLSCAFile:             getEnv("TLS_CA_FILE", ""),
+		TLSClientCertFile:     getEnv("TLS_CLIENT_CERT_FILE", ""),
+		TLSClientKeyFile:      getEnv("TLS_CLIENT_KEY_FILE", ""),
+		TLSMinVersion:         getEnv("TLS_MIN_VERSION", "1.2"),
+		HTTP2Enabled:          getEnvAsBool("HTTP2_ENABLED", true),
+
+		SFTPKnownHostsFile: getEnv("SFTP_KNOWN_HOSTS", ""),
+
+		TorrentEnabled:         getEnvAsBool("TORRENT_ENABLED", false),
+		TorrentListenPort:      getEnvAsInt("TORRENT_LISTEN_PORT", 42069),
+		TorrentNoDHT:           getEnvAsBool("TORRENT_NO_DHT", false),
+		TorrentSeedRatio:       getEnvAsFloat("TORRENT_SEED_RATIO", 0),
+		TorrentMaxSeedTime:     getEnvAsDuration("TORRENT_MAX_SEED_TIME", time.Hour),
+		TorrentMetadataTimeout: getEnvAsDuration("TORRENT_METADATA_TIMEOUT", 5*time.Minute),
+		TorrentStallTimeout:    getEnvAsDuration("TORRENT_STALL_TIMEOUT", 10*time.Minute),
+
+		ExtractMaxRatio: getEnvAsFloat("EXTRACT_MAX_RATIO", 1000),
+		ExtractMaxSize:  int64(getEnvAsInt("EXTRACT_MAX_SIZE", 10<<30)),
+		ExtractMaxFiles: getEnvAsInt("EXTRACT_MAX_FILES", 100000),
+
+		TracingEnabled:     getEnvAsBool("TRACING_ENABLED", false),
+		TracingEndpoint:    getEnv("TRACING_OTLP_ENDPOINT", ""),
+		TracingServiceName: getEnv("TRACING_SERVICE_NAME", "url-downloader"),
+		TracingSampleRatio: getEnvAsFloat("TRACING_SAMPLE_RATIO", 1),
+
+		AdminToken:            getEnv("ADMIN_TOKEN", ""),
+		HealthMinFreeDisk:     int64(getEnvAsInt("HEALTH_MIN_FREE_DISK", 512<<20)),
+		HealthQueueSaturation: getEnvAsFloat("HEALTH_QUEUE_SATURATION", 0.9),
+		HealthStallTimeout:    getEnvAsDuration("HEALTH_STALL_TIMEOUT", 30*time.Second),
+
+		WebhookDefaultURL:     getEnv("WEBHOOK_DEFAULT_URL", ""),
+		WebhookSecret:         []byte(getEnv("WEBHOOK_SECRET", "")),
+		WebhookMaxAttempts:    getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookTimeout:        getEnvAsDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookInitialBackoff: getEnvAsDuration("WEBHOOK_INITIAL_BACKOFF", 2*time.Second),
+		WebhookMaxBackoff:     getEnvAsDuration("WEBHOOK_MAX_BACKOFF", 10*time.Minute),
+
+		QueueDriver:        getEnv("QUEUE_DRIVER", ""),
+		NATSURL:            getEnv("NATS_URL", "nats://127.0.0.1:4222"),
+		QueueIntakeSubject: getEnv("QUEUE_INTAKE_SUBJECT", "downloader.tasks.submit"),
+		QueueIntakeGroup:   getEnv("QUEUE_INTAKE_GROUP", "url-downloader"),
+		QueueEventsSubject: getEnv("QUEUE_EVENTS_SUBJECT", "downloader.tasks.events"),
+
+		IdempotencyKeyTTL: getEnvAsDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+
+		DedupEnabled:    getEnvAsBool("DEDUP_ENABLED", false),
+		DedupGCInterval: getEnvAsDuration("DEDUP_GC_INTERVAL", time.Hour),
+
+		RetentionEnabled:      getEnvAsBool("RETENTION_ENABLED", false),
+		RetentionCompletedTTL: getEnvAsDuration("RETENTION_COMPLETED_TTL", 0),
+		RetentionFailedTTL:    getEnvAsDuration("RETENTION_FAILED_TTL", 0),
+		RetentionMaxDiskUsage: int64(getEnvAsInt("RETENTION_MAX_DISK_USAGE", 0)),
+		RetentionOrphanGrace:  getEnvAsDuration("RETENTION_ORPHAN_GRACE", time.Hour),
+		RetentionInterval:     getEnvAsDuration("RETENTION_INTERVAL", time.Hour),
+
+		TaskQueueCapacity: getEnvAsInt("TASK_QUEUE_CAPACITY", 1000),
+		TaskQueueAging:    getEnvAsDuration("TASK_QUEUE_AGING", time.Minute),
+
+		ScheduleDir:          getEnv("SCHEDULE_DIR", "downloads/schedules"),
+		ScheduleHistoryLimit: getEnvAsInt("SCHEDULE_HISTORY_LIMIT", 50),
+	}
+
+	if key := getEnv("CREDENTIALS_KEY", ""); key != "" {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("decode CREDENTIALS_KEY: %w", err)
+		}
+		cfg.CredentialsKey = decoded
 	}
 
 	if err := os.MkdirAll(cfg.DownloadDir, 0755); err != nil {
@@ -42,6 +209,9 @@ func Load() (*Config, error) {
 	if err := os.MkdirAll(cfg.TaskDir, 0755); err != nil {
 		return nil, fmt.Errorf("create task dir: %w", err)
 	}
+	if err := os.MkdirAll(cfg.ScheduleDir, 0755); err != nil {
+		return nil, fmt.Errorf("create schedule dir: %w", err)
+	}
 
 	return cfg, nil
 }
@@ -70,3 +240,36 @@ func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvAsSlice(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,33 @@
+package domain
+
+import "time"
+
+// Schedule submits a download task at StartAt, on every match of Cron, or both: when
+// both are set, Cron occurrences start at StartAt. NextRunAt is nil once a one-off
+// schedule has run.
+type Schedule struct {
+	ID        string      `json:"id"`
+	URLs      []string    `json:"urls"`
+	Options   TaskOptions `json:"options"`
+	StartAt   *time.Time  `json:"start_at,omitempty"`
+	Cron      string      `json:"cron,omitempty"`
+	NextRunAt *time.Time  `json:"next_run_at,omitempty"`
+	// Validators hold the validators of each URL from its last successful download,
+	// so that later runs skip resources that have not changed.
+	Validators map[string]Validators `json:"validators,omitempty"`
+	// Runs lists the most recent runs, oldest first.
+	Runs      []ScheduleRun `json:"runs,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// ScheduleRun records one submission of a schedule and, once its task has finished,
+// the task's results. Error is set when the task could not be submitted.
+type ScheduleRun struct {
+	ScheduledAt time.Time        `json:"scheduled_at"`
+	TaskID      string           `json:"task_id,omitempty"`
+	Status      TaskStatus       `json:"status,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+	Results     []DownloadResult `json:"results,omitempty"`
+}
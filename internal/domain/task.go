@@ -14,24 +14,326 @@ const (
 	StatusFailed     TaskStatus = "failed"
 )
 
+// IsTerminal reports whether a task in this status will not be processed further.
+func (s TaskStatus) IsTerminal() bool {
+	return s == StatusCompleted || s == StatusFailed
+}
+
 // Task represents a download task containing multiple URLs and their results.
 type Task struct {
 	ID        string           `json:"id"`
 	URLs      []string         `json:"urls"`
 	Status    TaskStatus       `json:"status"`
+	Options   TaskOptions      `json:"options"`
 	Results   []DownloadResult `json:"results,omitempty"`
 	CreatedAt time.Time        `json:"created_at"`
 	UpdatedAt time.Time        `json:"updated_at"`
+	// EnqueuedAt is when the task last joined the queue: on creation, retry or requeue.
+	// Waiting tasks age from this time rather than from CreatedAt. It is zero for tasks
+	// stored before it was recorded.
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	// TraceContext carries the trace of the request that created the task,
+	// so that asynchronous processing joins the same trace.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+	// IdempotencyKey and RequestFingerprint identify the request that created the task,
+	// so that a retried request returns this task instead of creating a new one.
+	IdempotencyKey     string `json:"idempotency_key,omitempty"`
+	RequestFingerprint string `json:"request_fingerprint,omitempty"`
+	// Webhook is set when the task has a callback URL to notify on completion.
+	Webhook *Webhook `json:"webhook,omitempty"`
+	// Retry lists the URLs the next run downloads: URLs retried after the task finished
+	// or added while it was running. Results of the other URLs are kept. It is cleared
+	// once the task finishes.
+	Retry []string `json:"retry,omitempty"`
+}
+
+// Webhook holds the callback URL of a task and the log of deliveries made to it.
+type Webhook struct {
+	URL        string            `json:"url"`
+	Delivered  bool              `json:"delivered"`
+	Deliveries []WebhookDelivery `json:"deliveries,omitempty"`
+}
+
+// WebhookDelivery records one attempt to POST a finished task to its callback URL.
+type WebhookDelivery struct {
+	Attempt    int       `json:"attempt"`
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+}
+
+// TaskOptions holds per-task download settings supplied at creation time.
+// Credentials are never serialized directly; TaskStorage persists them encrypted.
+type TaskOptions struct {
+	AllowedMIMETypes []string                      `json:"allowed_mime_types,omitempty"`
+	Credentials      *RequestCredentials           `json:"-"`
+	URLCredentials   map[string]RequestCredentials `json:"-"`
+	Proxy            *ProxyOptions                 `json:"proxy,omitempty"`
+	// SeedRatio overrides the default upload ratio for torrent downloads; zero disables seeding.
+	SeedRatio *float64 `json:"seed_ratio,omitempty"`
+	// Stream selects the variant downloaded from HLS and DASH manifests.
+	Stream *StreamOptions `json:"stream,omitempty"`
+	// Mirrors lists alternative URLs serving the same file, keyed by the task URL.
+	// SplitMirrors fetches different ranges from different mirrors in parallel.
+	Mirrors      map[string][]string `json:"mirrors,omitempty"`
+	SplitMirrors bool                `json:"split_mirrors,omitempty"`
+	// Extract unpacks zip, tar, tar.gz, tar.zst and gzip downloads into the task directory.
+	Extract bool `json:"extract,omitempty"`
+	// Encoding selects how responses sent with a Content-Encoding are stored.
+	Encoding EncodingMode `json:"encoding,omitempty"`
+	// Crawl treats each URL as an HTML index and downloads the files it links to.
+	Crawl *CrawlOptions `json:"crawl,omitempty"`
+	// CallbackURL receives a POST when the task finishes, overriding the configured default.
+	CallbackURL string `json:"callback_url,omitempty"`
+	// Priority orders queued tasks; higher runs first. See MinPriority and MaxPriority.
+	Priority int `json:"priority,omitempty"`
+	// Validators makes the download of the URLs they are keyed by conditional: a resource
+	// that still matches is reported Unchanged instead of being downloaded again.
+	Validators map[string]Validators `json:"validators,omitempty"`
+}
+
+// Validators are the HTTP validators of a previously downloaded resource.
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// Task priorities range from MinPriority to MaxPriority; the zero value is the default.
+const (
+	MinPriority = -10
+	MaxPriority = 10
+)
+
+// CrawlOptions controls how links are followed from an index page. Links ending in "/"
+// are crawled as subdirectory pages up to Depth levels below the start page; other links
+// are downloaded as files when they match Include (if set) and none of Exclude.
+// Only links on the start host are followed unless AllowExternal is set.
+type CrawlOptions struct {
+	Depth         int      `json:"depth,omitempty"`
+	Include       []string `json:"include,omitempty"`
+	Exclude       []string `json:"exclude,omitempty"`
+	AllowExternal bool     `json:"allow_external,omitempty"`
+	MaxFiles      int      `json:"max_files,omitempty"`
+}
+
+// EncodingMode selects whether a compressed HTTP response is stored as received
+// or decoded. The zero value decodes.
+type EncodingMode string
+
+const (
+	EncodingDecoded EncodingMode = "decoded"
+	EncodingEncoded EncodingMode = "encoded"
+)
+
+// StreamVariant names a variant selection strategy for HLS and DASH manifests.
+type StreamVariant string
+
+const (
+	VariantBest  StreamVariant = "best"
+	VariantWorst StreamVariant = "worst"
+)
+
+// StreamOptions controls which variant of an adaptive stream is downloaded.
+// When MaxBandwidth is set, the best variant not exceeding it is chosen
+// (or the lowest one if all exceed it) and Variant is ignored.
+type StreamOptions struct {
+	Variant      StreamVariant `json:"variant,omitempty"`
+	MaxBandwidth int64         `json:"max_bandwidth,omitempty"`
+}
+
+// ProxyOptions selects an outbound proxy for a single task.
+// Proxy authentication is stored encrypted together with the task credentials.
+type ProxyOptions struct {
+	URL      string   `json:"url"`
+	NoProxy  []string `json:"no_proxy,omitempty"`
+	Username string   `json:"-"`
+	Password string   `json:"-"`
+}
+
+// HasCredentials reports whether the task carries any secrets that need encryption at rest.
+func (o TaskOptions) HasCredentials() bool {
+	if o.Proxy != nil && o.Proxy.Username != "" {
+		return true
+	}
+	return o.Credentials != nil || len(o.URLCredentials) > 0
+}
+
+// CredentialsFor returns the credentials for a URL, merging task-wide values with
+// per-URL values. Per-URL values take precedence.
+func (o TaskOptions) CredentialsFor(url string) RequestCredentials {
+	var merged RequestCredentials
+	if o.Credentials != nil {
+		merged = merged.merge(*o.Credentials)
+	}
+	if perURL, ok := o.URLCredentials[url]; ok {
+		merged = merged.merge(perURL)
+	}
+	return merged
+}
+
+// RequestCredentials holds headers, cookies and authentication applied to outgoing requests.
+type RequestCredentials struct {
+	Headers     map[string]string `json:"headers,omitempty"`
+	Cookies     map[string]string `json:"cookies,omitempty"`
+	BasicAuth   *BasicAuth        `json:"basic_auth,omitempty"`
+	BearerToken string            `json:"bearer_token,omitempty"`
+
+	// SFTP authentication. SSHHostKey is an authorized_keys formatted server key
+	// used instead of the global known_hosts file.
+	SSHPrivateKey string `json:"ssh_private_key,omitempty"`
+	SSHPassphrase string `json:"ssh_passphrase,omitempty"`
+	SSHHostKey    string `json:"ssh_host_key,omitempty"`
+}
+
+// BasicAuth holds HTTP basic authentication credentials.
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (c RequestCredentials) merge(other RequestCredentials) RequestCredentials {
+	c.Headers = mergeMaps(c.Headers, other.Headers)
+	c.Cookies = mergeMaps(c.Cookies, other.Cookies)
+	if other.BasicAuth != nil {
+		c.BasicAuth = other.BasicAuth
+		c.BearerToken = ""
+	}
+	if other.BearerToken != "" {
+		c.BearerToken = other.BearerToken
+		c.BasicAuth = nil
+	}
+	if other.SSHPrivateKey != "" {
+		c.SSHPrivateKey = other.SSHPrivateKey
+		c.SSHPassphrase = other.SSHPassphrase
+	}
+	if other.SSHHostKey != "" {
+		c.SSHHostKey = other.SSHHostKey
+	}
+	return c
+}
+
+func mergeMaps(base, override map[string]string) map[string]string {
+	if len(override) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
 }
 
 // DownloadResult represents the outcome of downloading a single URL.
 type DownloadResult struct {
-	URL       string `json:"url"`
-	FileName  string `json:"file_name,omitempty"`
-	Success   bool   `json:"success"`
-	Error     string `json:"error,omitempty"`
-	BytesRead int64  `json:"bytes_read"`
-	Hash      string `json:"hash,omitempty"`
+	URL       string      `json:"url"`
+	State     URLState    `json:"state,omitempty"`
+	FileName  string      `json:"file_name,omitempty"`
+	Success   bool        `json:"success"`
+	Error     string      `json:"error,omitempty"`
+	BytesRead int64       `json:"bytes_read"`
+	Hash      string      `json:"hash,omitempty"`
+	MIMEType  string      `json:"mime_type,omitempty"`
+	Scan      *ScanResult `json:"scan,omitempty"`
+	Proxy     string      `json:"proxy,omitempty"`
+
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	// TotalBytes is the expected size when known, so BytesRead can be read as progress.
+	TotalBytes int64  `json:"total_bytes,omitempty"`
+	InfoHash   string `json:"info_hash,omitempty"`
+
+	// Segments and Bandwidth describe the stream variant assembled from an HLS or DASH manifest.
+	Segments  int   `json:"segments,omitempty"`
+	Bandwidth int64 `json:"bandwidth,omitempty"`
+
+	// Mirrors lists the mirrors that served data for a mirrored or Metalink download.
+	Mirrors []string `json:"mirrors,omitempty"`
+
+	// ContentEncoding is the coding the server applied, and Encoding whether the
+	// stored file keeps it. Both are empty for responses sent without a coding.
+	ContentEncoding string       `json:"content_encoding,omitempty"`
+	Encoding        EncodingMode `json:"encoding,omitempty"`
+
+	// Blob is the digest of the deduplicated content this result's file links to.
+	// Cached reports that the server confirmed the stored copy was still current.
+	Blob   string `json:"blob,omitempty"`
+	Cached bool   `json:"cached,omitempty"`
+	// Unchanged reports that the server confirmed the task's validators still match,
+	// so nothing was downloaded and FileName is empty.
+	Unchanged bool `json:"unchanged,omitempty"`
+
+	ArchiveType string          `json:"archive_type,omitempty"`
+	Extracted   []ExtractedFile `json:"extracted,omitempty"`
+
+	// Children holds one result per file found by a crawl.
+	Children []DownloadResult `json:"children,omitempty"`
+
+	// Attempts lists earlier downloads of the URL, oldest first, when the task was retried.
+	Attempts []DownloadAttempt `json:"attempts,omitempty"`
+}
+
+// URLState is the state of a single URL of a task. Every URL has a result from the
+// moment it is added to the task, starting out queued.
+type URLState string
+
+const (
+	URLQueued      URLState = "queued"
+	URLDownloading URLState = "downloading"
+	URLDone        URLState = "done"
+	URLFailed      URLState = "failed"
+	// URLSkipped marks a URL that was not downloaded: its resource was unchanged,
+	// or the task was aborted by another failure before the URL started.
+	URLSkipped URLState = "skipped"
+)
+
+// QueuedResults returns a queued result for each of urls.
+func QueuedResults(urls []string) []DownloadResult {
+	results := make([]DownloadResult, len(urls))
+	for i, url := range urls {
+		results[i] = DownloadResult{URL: url, State: URLQueued}
+	}
+	return results
+}
+
+// DownloadAttempt records the outcome of a download that was later retried.
+type DownloadAttempt struct {
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	BytesRead  int64     `json:"bytes_read"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// ExtractedFile is a manifest entry for a file unpacked from a downloaded archive.
+// Path is relative to the download directory. Entries that were not written
+// (unsafe symlinks, device files) carry the reason in Skipped.
+type ExtractedFile struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	Type       string `json:"type"`
+	LinkTarget string `json:"link_target,omitempty"`
+	Skipped    string `json:"skipped,omitempty"`
+}
+
+// ScanVerdict represents the outcome of a post-download malware scan.
+type ScanVerdict string
+
+const (
+	ScanClean    ScanVerdict = "clean"
+	ScanInfected ScanVerdict = "infected"
+	ScanError    ScanVerdict = "error"
+)
+
+// ScanResult records which scanner inspected a downloaded file and what it found.
+type ScanResult struct {
+	Scanner   string      `json:"scanner"`
+	Verdict   ScanVerdict `json:"verdict"`
+	Signature string      `json:"signature,omitempty"`
+	ScannedAt time.Time   `json:"scanned_at"`
 }
 
 // TaskEvent represents an event related to a task, used for notifications or updates.
@@ -48,10 +350,21 @@ type EventType string
 const (
 	EventCreateTask EventType = "create"
 	EventUpdateTask EventType = "update"
+	// EventRetryTask queues a finished task again for the URLs in TaskUpdate.Retry.
+	EventRetryTask EventType = "retry"
+	// EventAddURLs appends the URLs in TaskUpdate.AddURLs to a task.
+	EventAddURLs EventType = "add_urls"
 )
 
 // TaskUpdate represents updates applied to a task, such as status changes or download results.
+// Progress replaces the matching in-flight result (by URL) without touching the others.
+// Delivery is appended to the task's webhook delivery log.
 type TaskUpdate struct {
-	Status  *TaskStatus
-	Results []DownloadResult
+	Status   *TaskStatus
+	Results  []DownloadResult
+	Progress *DownloadResult
+	Delivery *WebhookDelivery
+	Priority *int
+	Retry    []string
+	AddURLs  []string
 }
@@ -0,0 +1,161 @@
+// Package health serves the liveness and readiness probes.
+//
+// Liveness checks only cover failures that a restart fixes, such as a stuck
+// event processor. Readiness additionally covers conditions that should merely
+// take the instance out of rotation, such as a full disk or a saturated queue.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds a single probe so that a hung check reports failure instead of hanging the probe.
+const checkTimeout = 5 * time.Second
+
+// Check returns nil when the checked component is healthy.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker holds the checks run by the liveness and readiness probes.
+type Checker struct {
+	mu        sync.RWMutex
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+// NewChecker returns a Checker without checks; both probes report ok until checks are added.
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// AddLiveness registers a check run by both /healthz and /readyz.
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveness = append(c.liveness, namedCheck{name, check})
+}
+
+// AddReadiness registers a check run by /readyz only.
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness = append(c.readiness, namedCheck{name, check})
+}
+
+// Response is the body returned by both probes.
+type Response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// LivenessHandler serves /healthz.
+func (c *Checker) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	checks := c.liveness
+	c.mu.RUnlock()
+	serve(w, r, checks)
+}
+
+// ReadinessHandler serves /readyz.
+func (c *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	checks := append(append([]namedCheck(nil), c.liveness...), c.readiness...)
+	c.mu.RUnlock()
+	serve(w, r, checks)
+}
+
+// serve runs checks concurrently and answers 200 when all pass and 503 otherwise.
+func serve(w http.ResponseWriter, r *http.Request, checks []namedCheck) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = run(ctx, c.check)
+		}()
+	}
+	wg.Wait()
+
+	response := Response{Status: "ok", Checks: make(map[string]string, len(checks))}
+	status := http.StatusOK
+	for i, c := range checks {
+		if errs[i] != nil {
+			response.Checks[c.name] = errs[i].Error()
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		} else {
+			response.Checks[c.name] = "ok"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// run calls check and gives up when ctx expires first.
+func run(ctx context.Context, check Check) error {
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("check timed out: %w", ctx.Err())
+	}
+}
+
+// DirWritable checks that a file can be created in dir.
+func DirWritable(dir string) Check {
+	return func(context.Context) error {
+		f, err := os.CreateTemp(dir, ".healthcheck-*")
+		if err != nil {
+			return fmt.Errorf("directory not writable: %w", err)
+		}
+		name := f.Name()
+		closeErr := f.Close()
+		if err := os.Remove(name); err != nil {
+			return fmt.Errorf("remove probe file: %w", err)
+		}
+		return closeErr
+	}
+}
+
+// ErrFreeSpaceUnsupported is returned by FreeSpace on platforms without statfs.
+var ErrFreeSpaceUnsupported = errors.New("free space check not supported on this platform")
+
+// MinFreeSpace checks that the file system holding dir has at least minBytes available
+// to unprivileged users.
+func MinFreeSpace(dir string, minBytes int64) Check {
+	return func(context.Context) error {
+		free, err := FreeSpace(dir)
+		if errors.Is(err, ErrFreeSpaceUnsupported) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("stat file system: %w", err)
+		}
+		if free < uint64(minBytes) {
+			return fmt.Errorf("%d bytes free, need at least %d", free, minBytes)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func probe(t *testing.T, handler http.HandlerFunc) (int, Response) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var resp Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return rec.Code, resp
+}
+
+func TestChecker(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	failing := func(context.Context) error { return errors.New("disk full") }
+
+	checker := NewChecker()
+	checker.AddLiveness("processor", ok)
+	checker.AddReadiness("disk", failing)
+
+	code, resp := probe(t, checker.LivenessHandler)
+	if code != http.StatusOK || resp.Status != "ok" {
+		t.Fatalf("expected healthy liveness, got %d %+v", code, resp)
+	}
+	if _, found := resp.Checks["disk"]; found {
+		t.Fatalf("readiness check must not run for liveness: %+v", resp)
+	}
+
+	code, resp = probe(t, checker.ReadinessHandler)
+	if code != http.StatusServiceUnavailable || resp.Status != "unavailable" {
+		t.Fatalf("expected unavailable readiness, got %d %+v", code, resp)
+	}
+	if resp.Checks["processor"] != "ok" || resp.Checks["disk"] != "disk full" {
+		t.Fatalf("unexpected checks: %+v", resp.Checks)
+	}
+}
+
+func TestChecker_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	checker := NewChecker()
+	checker.AddLiveness("stuck", func(context.Context) error {
+		<-release
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx, cancel := context.WithCancel(req.Context())
+	cancel()
+	checker.LivenessHandler(rec, req.WithContext(ctx))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for a hung check, got %d", rec.Code)
+	}
+}
+
+func TestDirWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := DirWritable(dir)(context.Background()); err != nil {
+		t.Fatalf("expected writable dir, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected probe file to be removed, found %d entries", len(entries))
+	}
+
+	if err := DirWritable(filepath.Join(dir, "missing"))(context.Background()); err == nil {
+		t.Fatal("expected error for missing dir")
+	}
+}
+
+func TestMinFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := FreeSpace(dir); errors.Is(err, ErrFreeSpaceUnsupported) {
+		t.Skip(err)
+	}
+
+	if err := MinFreeSpace(dir, 1)(context.Background()); err != nil {
+		t.Fatalf("expected at least one free byte, got %v", err)
+	}
+	if err := MinFreeSpace(dir, 1<<62)(context.Background()); err == nil {
+		t.Fatal("expected error for an unreachable threshold")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package health
+
+// FreeSpace is not implemented on this platform.
+func FreeSpace(dir string) (uint64, error) {
+	return 0, ErrFreeSpaceUnsupported
+}
@@ -0,0 +1,187 @@
+// Package metrics defines the Prometheus metrics exported on /metrics.
+//
+// All methods are safe to call on a nil *Metrics, so components can be
+// instrumented unconditionally and run without metrics in tests.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+const namespace = "downloader"
+
+// Retry kinds reported by Retry.
+const (
+	RetrySegment = "segment"
+	RetryMirror  = "mirror"
+	RetryRestart = "restart"
+)
+
+// Metrics holds the collectors shared by the service, worker and storage.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	activeDownloads  prometheus.Gauge
+	downloadedBytes  prometheus.Counter
+	downloadDuration *prometheus.HistogramVec
+	hostErrors       *prometheus.CounterVec
+	retries          *prometheus.CounterVec
+	storageWrites    prometheus.Histogram
+}
+
+// New creates the metrics on a dedicated registry that also exports Go runtime
+// and process metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		activeDownloads: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_downloads",
+			Help:      "Number of URLs currently being downloaded.",
+		}),
+		downloadedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "downloaded_bytes_total",
+			Help:      "Bytes received from download sources.",
+		}),
+		downloadDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "download_duration_seconds",
+			Help:      "Time taken to download a single URL, by outcome.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10),
+		}, []string{"outcome"}),
+		hostErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "download_errors_total",
+			Help:      "Failed downloads by source host.",
+		}, []string{"host"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Retried fetches: segment retries, mirror failovers and restarted downloads.",
+		}, []string{"kind"}),
+		storageWrites: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_write_duration_seconds",
+			Help:      "Time taken to persist a task to disk.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 8),
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.activeDownloads,
+		m.downloadedBytes,
+		m.downloadDuration,
+		m.hostErrors,
+		m.retries,
+		m.storageWrites,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WatchTasks exports the number of tasks per status and the depth of the task event
+// queue. Both functions are called on every scrape.
+func (m *Metrics) WatchTasks(countByStatus func() map[domain.TaskStatus]int, queueDepth func() int) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(
+		&taskCollector{count: countByStatus},
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queue_depth",
+			Help:      "Task events waiting to be processed.",
+		}, func() float64 { return float64(queueDepth()) }),
+	)
+}
+
+// DownloadStarted marks a download as active and returns a function that records
+// its duration and outcome. A non-nil error counts against host.
+func (m *Metrics) DownloadStarted() func(host string, err error) {
+	if m == nil {
+		return func(string, error) {}
+	}
+
+	start := time.Now()
+	m.activeDownloads.Inc()
+
+	return func(host string, err error) {
+		m.activeDownloads.Dec()
+
+		outcome := "success"
+		if err != nil {
+			outcome = "failure"
+			m.hostErrors.WithLabelValues(host).Inc()
+		}
+		m.downloadDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	}
+}
+
+// AddBytes counts bytes received from a source.
+func (m *Metrics) AddBytes(n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.downloadedBytes.Add(float64(n))
+}
+
+// Retry counts one retry of the given kind.
+func (m *Metrics) Retry(kind string) {
+	if m == nil {
+		return
+	}
+	m.retries.WithLabelValues(kind).Inc()
+}
+
+// ObserveStorageWrite records how long persisting a task took.
+func (m *Metrics) ObserveStorageWrite(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.storageWrites.Observe(d.Seconds())
+}
+
+// taskCollector reports task counts at scrape time so that restored and
+// deleted tasks never leave the gauge out of sync with storage.
+type taskCollector struct {
+	count func() map[domain.TaskStatus]int
+}
+
+var tasksDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "tasks"),
+	"Number of tasks by status.",
+	[]string{"status"}, nil,
+)
+
+func (c *taskCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tasksDesc
+}
+
+func (c *taskCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := c.count()
+	for _, status := range []domain.TaskStatus{
+		domain.StatusPending,
+		domain.StatusInProgress,
+		domain.StatusCompleted,
+		domain.StatusFailed,
+	} {
+		ch <- prometheus.MustNewConstMetric(tasksDesc, prometheus.GaugeValue, float64(counts[status]), string(status))
+	}
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+
+	m.DownloadStarted()("example.com", errors.New("boom"))
+	m.AddBytes(10)
+	m.Retry(RetrySegment)
+	m.ObserveStorageWrite(time.Millisecond)
+	m.WatchTasks(nil, nil)
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 404 {
+		t.Fatalf("expected 404 without metrics, got %d", rec.Code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.WatchTasks(func() map[domain.TaskStatus]int {
+		return map[domain.TaskStatus]int{domain.StatusCompleted: 3, domain.StatusFailed: 1}
+	}, func() int { return 7 })
+
+	finish := m.DownloadStarted()
+	if got := testutil.ToFloat64(m.activeDownloads); got != 1 {
+		t.Fatalf("expected 1 active download, got %v", got)
+	}
+	finish("example.com", errors.New("boom"))
+	m.DownloadStarted()("example.com", nil)
+
+	m.AddBytes(100)
+	m.AddBytes(-5)
+	m.Retry(RetryMirror)
+	m.Retry(RetryMirror)
+	m.ObserveStorageWrite(time.Millisecond)
+
+	if got := testutil.ToFloat64(m.activeDownloads); got != 0 {
+		t.Fatalf("expected no active downloads, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.downloadedBytes); got != 100 {
+		t.Fatalf("expected 100 bytes, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.hostErrors.WithLabelValues("example.com")); got != 1 {
+		t.Fatalf("expected 1 host error, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.retries.WithLabelValues(RetryMirror)); got != 2 {
+		t.Fatalf("expected 2 retries, got %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		`downloader_tasks{status="completed"} 3`,
+		`downloader_tasks{status="pending"} 0`,
+		`downloader_queue_depth 7`,
+		`downloader_download_duration_seconds_count{outcome="failure"} 1`,
+		`downloader_download_duration_seconds_count{outcome="success"} 1`,
+		`downloader_storage_write_duration_seconds_count 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
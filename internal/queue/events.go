@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/service"
+)
+
+// StatusEvent is published whenever a task changes status.
+type StatusEvent struct {
+	Type   domain.EventType     `json:"type"`
+	TaskID string               `json:"task_id"`
+	Status domain.TaskStatus    `json:"status"`
+	Time   time.Time            `json:"time"`
+	Task   service.TaskResponse `json:"task"`
+}
+
+// EventPublisher publishes task status changes on "<subject>.<status>", so that consumers
+// can subscribe to a single status or to "<subject>.>" for all of them.
+type EventPublisher struct {
+	broker  Broker
+	subject string
+	logger  *slog.Logger
+}
+
+// NewEventPublisher creates an EventPublisher on the given subject prefix.
+func NewEventPublisher(broker Broker, subject string, logger *slog.Logger) *EventPublisher {
+	return &EventPublisher{broker: broker, subject: subject, logger: logger}
+}
+
+// TaskStatusChanged publishes the task's current status with the event that changed it, so
+// that a retried task or one queued again for added URLs is not taken for a new task. It is
+// a service.StatusListener; the task's trace context is sent as message headers.
+func (p *EventPublisher) TaskStatusChanged(event domain.EventType, task *domain.Task) {
+	data, err := json.Marshal(StatusEvent{
+		Type:   event,
+		TaskID: task.ID,
+		Status: task.Status,
+		Time:   time.Now(),
+		Task:   service.NewTaskResponse(task),
+	})
+	if err != nil {
+		p.logger.Error("failed to encode task event", "error", err, "task_id", task.ID)
+		return
+	}
+
+	if err := p.broker.Publish(p.subject+"."+string(task.Status), task.TraceContext, data); err != nil {
+		p.logger.Warn("failed to publish task event",
+			"error", err,
+			"task_id", task.ID,
+			"status", task.Status,
+		)
+	}
+}
@@ -0,0 +1,106 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/veranemoloko/url-downloader/internal/service"
+	"github.com/veranemoloko/url-downloader/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/veranemoloko/url-downloader/internal/queue")
+
+// Intake creates tasks from submissions received on a subject. A submission has the
+// same JSON body as POST /tasks and is validated the same way; an Idempotency-Key
+// message header works as it does over HTTP. When the publisher asks for a reply,
+// it receives the created task or {"error": "..."}.
+type Intake struct {
+	broker  Broker
+	subject string
+	group   string
+	tasks   service.TaskServiceInterface
+	logger  *slog.Logger
+}
+
+// NewIntake creates an Intake that hands submissions on subject to tasks.
+func NewIntake(broker Broker, subject, group string, tasks service.TaskServiceInterface, logger *slog.Logger) *Intake {
+	return &Intake{
+		broker:  broker,
+		subject: subject,
+		group:   group,
+		tasks:   tasks,
+		logger:  logger,
+	}
+}
+
+// Start subscribes to the intake subject. Submissions are accepted until ctx is done.
+func (i *Intake) Start(ctx context.Context) error {
+	return i.broker.Subscribe(ctx, i.subject, i.group, i.handle)
+}
+
+func (i *Intake) handle(ctx context.Context, msg Message) {
+	ctx, span := tracer.Start(tracing.Extract(ctx, msg.Header), "Intake.handle",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", msg.Subject)),
+	)
+	defer span.End()
+
+	task, err := i.createTask(ctx, msg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		i.logger.Warn("rejected queued task submission",
+			"subject", msg.Subject,
+			"error", err,
+		)
+		i.reply(msg, map[string]string{"error": err.Error()})
+		return
+	}
+
+	span.SetAttributes(attribute.String("task.id", task.ID))
+	i.reply(msg, task)
+}
+
+func (i *Intake) createTask(ctx context.Context, msg Message) (*service.TaskResponse, error) {
+	var req service.CreateTaskRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		return nil, errors.New("invalid JSON")
+	}
+
+	opts, err := req.TaskOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	if key := msg.Header[service.IdempotencyKeyHeader]; key != "" {
+		ctx = service.WithIdempotencyKey(ctx, key, req.Fingerprint())
+	}
+
+	task, err := i.tasks.CreateTask(ctx, req.URLs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	response := service.NewTaskResponse(task)
+	return &response, nil
+}
+
+func (i *Intake) reply(msg Message, body any) {
+	if msg.Reply == "" {
+		return
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		i.logger.Error("failed to encode intake reply", "error", err)
+		return
+	}
+	if err := i.broker.Publish(msg.Reply, nil, data); err != nil {
+		i.logger.Warn("failed to send intake reply", "error", err)
+	}
+}
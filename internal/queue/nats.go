@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// flushTimeout bounds how long Close waits for published messages to reach the server.
+const flushTimeout = 5 * time.Second
+
+// NATS is a Broker on core NATS. Delivery is at most once: submissions published
+// while no intake is subscribed are dropped, so publishers that need confirmation
+// should use request-reply.
+type NATS struct {
+	conn   *nats.Conn
+	logger *slog.Logger
+}
+
+// NewNATS connects to the NATS server at url. The connection reconnects on its own
+// and buffers publishes while disconnected.
+func NewNATS(url string, logger *slog.Logger) (*NATS, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("url-downloader"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Warn("NATS disconnected", "error", err)
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			logger.Info("NATS reconnected", "url", c.ConnectedUrlRedacted())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("connect to NATS: %w", err)
+	}
+	return &NATS{conn: conn, logger: logger}, nil
+}
+
+func (n *NATS) Subscribe(ctx context.Context, subject, group string, handler Handler) error {
+	sub, err := n.conn.QueueSubscribe(subject, group, func(m *nats.Msg) {
+		msg := Message{Subject: m.Subject, Data: m.Data, Reply: m.Reply}
+		if len(m.Header) > 0 {
+			msg.Header = make(map[string]string, len(m.Header))
+			for key := range m.Header {
+				msg.Header[key] = m.Header.Get(key)
+			}
+		}
+		handler(ctx, msg)
+	})
+	if err != nil {
+		return fmt.Errorf("subscribe to %q: %w", subject, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := sub.Drain(); err != nil && n.conn.IsConnected() {
+			n.logger.Warn("failed to drain NATS subscription", "subject", subject, "error", err)
+		}
+	}()
+	return nil
+}
+
+func (n *NATS) Publish(subject string, header map[string]string, data []byte) error {
+	if n.conn.IsClosed() {
+		return ErrClosed
+	}
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	for key, value := range header {
+		msg.Header.Set(key, value)
+	}
+	return n.conn.PublishMsg(msg)
+}
+
+func (n *NATS) Close() error {
+	if n.conn.IsClosed() {
+		return nil
+	}
+	err := n.conn.FlushTimeout(flushTimeout)
+	n.conn.Close()
+	return err
+}
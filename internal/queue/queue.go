@@ -0,0 +1,38 @@
+// Package queue connects the service to a message queue: it accepts task submissions
+// published by upstream systems and publishes task status changes.
+//
+// Brokers are adapters behind the Broker interface; NATS is the first one.
+package queue
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClosed is returned when a broker is used after Close.
+var ErrClosed = errors.New("broker closed")
+
+// Message is a message received from a broker.
+type Message struct {
+	Subject string
+	Header  map[string]string
+	Data    []byte
+	// Reply is the subject a response should be sent to, empty when the
+	// publisher does not expect one.
+	Reply string
+}
+
+// Handler processes a received message.
+type Handler func(ctx context.Context, msg Message)
+
+// Broker is the message queue used for intake and publishing.
+type Broker interface {
+	// Subscribe calls handler for every message published on subject until ctx is done.
+	// Subscribers sharing a group split the messages between them, so that every
+	// submission is handled by one replica only.
+	Subscribe(ctx context.Context, subject, group string, handler Handler) error
+	// Publish sends data with header on subject. It must not block on the network.
+	Publish(subject string, header map[string]string, data []byte) error
+	// Close flushes pending messages and disconnects.
+	Close() error
+}
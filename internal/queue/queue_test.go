@@ -0,0 +1,227 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// runNATS starts an embedded NATS server on a random port and returns its URL.
+func runNATS(t *testing.T) string {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("start NATS server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(ns.Shutdown)
+	return ns.ClientURL()
+}
+
+func newTestBroker(t *testing.T, url string) *NATS {
+	t.Helper()
+	broker, err := NewNATS(url, newTestLogger())
+	if err != nil {
+		t.Fatalf("NewNATS error: %v", err)
+	}
+	t.Cleanup(func() { broker.Close() })
+	return broker
+}
+
+type mockTaskService struct {
+	mu    sync.Mutex
+	tasks []*domain.Task
+	err   error
+}
+
+func (m *mockTaskService) CreateTask(ctx context.Context, urls []string, opts domain.TaskOptions) (*domain.Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	task := &domain.Task{ID: "task-1", URLs: urls, Status: domain.StatusPending, Options: opts}
+	m.tasks = append(m.tasks, task)
+	return task, nil
+}
+
+func (m *mockTaskService) GetTask(id string) (*domain.Task, error) {
+	return nil, errors.New("not found")
+}
+
+func (m *mockTaskService) SetPriority(id string, priority int) (*domain.Task, error) {
+	return nil, errors.New("not found")
+}
+
+func (m *mockTaskService) RetryTask(id string, urls []string) (*domain.Task, error) {
+	return nil, errors.New("not found")
+}
+
+func (m *mockTaskService) AddURLs(id string, urls []string) (*domain.Task, error) {
+	return nil, errors.New("not found")
+}
+
+func TestIntake(t *testing.T) {
+	url := runNATS(t)
+	broker := newTestBroker(t, url)
+	svc := &mockTaskService{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := NewIntake(broker, "tasks.submit", "downloader", svc, newTestLogger()).Start(ctx); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+
+	client, err := nats.Connect(url)
+	if err != nil {
+		t.Fatalf("connect client: %v", err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		name      string
+		body      string
+		wantError bool
+	}{
+		{"valid", `{"urls":["https://example.com/a.txt"],"extract":true}`, false},
+		{"invalid JSON", `{"urls":`, true},
+		{"no URLs", `{"urls":[]}`, true},
+		{"unsafe URL", `{"urls":["http://127.0.0.1/secret"]}`, true},
+		{"unsafe callback", `{"urls":["https://example.com/a.txt"],"callback_url":"http://10.0.0.1/hook"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := client.Request("tasks.submit", []byte(tt.body), 5*time.Second)
+			if err != nil {
+				t.Fatalf("request error: %v", err)
+			}
+
+			var resp struct {
+				ID    string `json:"id"`
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(reply.Data, &resp); err != nil {
+				t.Fatalf("decode reply %s: %v", reply.Data, err)
+			}
+			if tt.wantError {
+				if resp.Error == "" {
+					t.Fatalf("expected error reply, got %s", reply.Data)
+				}
+				return
+			}
+			if resp.ID != "task-1" {
+				t.Fatalf("expected created task, got %s", reply.Data)
+			}
+		})
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if len(svc.tasks) != 1 || !svc.tasks[0].Options.Extract {
+		t.Fatalf("expected one task with options, got %+v", svc.tasks)
+	}
+}
+
+func TestIntake_FireAndForget(t *testing.T) {
+	url := runNATS(t)
+	broker := newTestBroker(t, url)
+	svc := &mockTaskService{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := NewIntake(broker, "tasks.submit", "downloader", svc, newTestLogger()).Start(ctx); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+
+	publisher := newTestBroker(t, url)
+	if err := publisher.Publish("tasks.submit", nil, []byte(`{"urls":["https://example.com/a.txt"]}`)); err != nil {
+		t.Fatalf("Publish error: %v", err)
+	}
+	publisher.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		svc.mu.Lock()
+		n := len(svc.tasks)
+		svc.mu.Unlock()
+		if n == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timeout waiting for queued task")
+}
+
+func TestEventPublisher(t *testing.T) {
+	url := runNATS(t)
+	broker := newTestBroker(t, url)
+
+	client, err := nats.Connect(url)
+	if err != nil {
+		t.Fatalf("connect client: %v", err)
+	}
+	defer client.Close()
+	sub, err := client.SubscribeSync("tasks.events.>")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	publisher := NewEventPublisher(broker, "tasks.events", newTestLogger())
+	traceparent := "00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01"
+	task := &domain.Task{ID: "task-1", URLs: []string{"https://example.com/a"}, TraceContext: map[string]string{"traceparent": traceparent}}
+	changes := []struct {
+		subject   string
+		eventType domain.EventType
+		status    domain.TaskStatus
+	}{
+		{"tasks.events.pending", domain.EventCreateTask, domain.StatusPending},
+		{"tasks.events.completed", domain.EventUpdateTask, domain.StatusCompleted},
+		{"tasks.events.pending", domain.EventRetryTask, domain.StatusPending},
+		{"tasks.events.pending", domain.EventAddURLs, domain.StatusPending},
+	}
+	for _, change := range changes {
+		task.Status = change.status
+		publisher.TaskStatusChanged(change.eventType, task)
+	}
+
+	for _, want := range changes {
+		msg, err := sub.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", want.subject, err)
+		}
+		if msg.Subject != want.subject {
+			t.Fatalf("expected subject %q, got %q", want.subject, msg.Subject)
+		}
+		if got := msg.Header.Get("traceparent"); got != traceparent {
+			t.Fatalf("expected trace context header, got %q", got)
+		}
+
+		var event StatusEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			t.Fatalf("decode event: %v", err)
+		}
+		if event.Type != want.eventType || event.Status != want.status || event.TaskID != "task-1" || event.Task.ID != "task-1" {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the size of each INSTREAM chunk; it must stay below clamd's StreamMaxLength.
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner streams content to a clamd daemon using the INSTREAM command.
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner creates a ClamdScanner for the given network ("tcp" or "unix") and address.
+// The timeout bounds the whole exchange, including streaming the file.
+func NewClamdScanner(network, address string, timeout time.Duration) *ClamdScanner {
+	return &ClamdScanner{
+		network: network,
+		address: address,
+		timeout: timeout,
+	}
+}
+
+// Name returns the scanner identifier recorded on results.
+func (s *ClamdScanner) Name() string {
+	return "clamd"
+}
+
+// Scan sends the content to clamd and parses its reply.
+func (s *ClamdScanner) Scan(ctx context.Context, r io.Reader) (Verdict, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return Verdict{}, fmt.Errorf("set deadline: %w", err)
+		}
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Verdict{}, fmt.Errorf("send command: %w", err)
+	}
+
+	if err := writeChunks(ctx, conn, r); err != nil {
+		return Verdict{}, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return Verdict{}, fmt.Errorf("read reply: %w", err)
+	}
+
+	return parseClamdReply(reply)
+}
+
+func writeChunks(ctx context.Context, w io.Writer, r io.Reader) error {
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, werr := w.Write(size); werr != nil {
+				return fmt.Errorf("send chunk size: %w", werr)
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return fmt.Errorf("send chunk: %w", werr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read content: %w", err)
+		}
+	}
+
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := w.Write(size); err != nil {
+		return fmt.Errorf("send terminator: %w", err)
+	}
+	return nil
+}
+
+// parseClamdReply interprets replies such as "stream: OK" or "stream: Eicar-Signature FOUND".
+func parseClamdReply(reply string) (Verdict, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	_, status, found := strings.Cut(reply, ": ")
+	if !found {
+		return Verdict{}, fmt.Errorf("unexpected clamd reply: %q", reply)
+	}
+
+	switch {
+	case status == "OK":
+		return Verdict{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return Verdict{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	default:
+		return Verdict{}, fmt.Errorf("clamd error: %s", status)
+	}
+}
@@ -0,0 +1,99 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startFakeClamd runs a minimal clamd that answers INSTREAM requests and flags
+// any stream containing the marker string.
+func startFakeClamd(t *testing.T, marker string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeClamd(conn, marker)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func serveFakeClamd(conn net.Conn, marker string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	command, err := r.ReadString(0)
+	if err != nil || command != "zINSTREAM\x00" {
+		_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+
+	var content bytes.Buffer
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, size); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n == 0 {
+			break
+		}
+		if _, err := io.CopyN(&content, r, int64(n)); err != nil {
+			return
+		}
+	}
+
+	if strings.Contains(content.String(), marker) {
+		_, _ = conn.Write([]byte("stream: Test-Signature FOUND\x00"))
+		return
+	}
+	_, _ = conn.Write([]byte("stream: OK\x00"))
+}
+
+func TestClamdScanner_Scan(t *testing.T) {
+	addr := startFakeClamd(t, "EICAR")
+	s := NewClamdScanner("tcp", addr, 5*time.Second)
+
+	verdict, err := s.Scan(context.Background(), strings.NewReader(strings.Repeat("clean data ", 20000)))
+	if err != nil {
+		t.Fatalf("Scan error: %v", err)
+	}
+	if verdict.Infected {
+		t.Errorf("expected clean verdict, got %+v", verdict)
+	}
+
+	verdict, err = s.Scan(context.Background(), strings.NewReader("X5O!P%@AP EICAR test"))
+	if err != nil {
+		t.Fatalf("Scan error: %v", err)
+	}
+	if !verdict.Infected || verdict.Signature != "Test-Signature" {
+		t.Errorf("expected infected verdict with signature, got %+v", verdict)
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	if _, err := parseClamdReply("stream: INSTREAM size limit exceeded. ERROR\x00"); err == nil {
+		t.Errorf("expected error for clamd ERROR reply")
+	}
+	if _, err := parseClamdReply("garbage"); err == nil {
+		t.Errorf("expected error for malformed reply")
+	}
+}
@@ -0,0 +1,56 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// CommandScanner runs an external program that reads the content on stdin.
+// Exit code 0 means clean and exit code 1 means infected, following the clamscan convention;
+// any other exit code is treated as a scan error.
+type CommandScanner struct {
+	command string
+	args    []string
+}
+
+// NewCommandScanner creates a CommandScanner, e.g. NewCommandScanner("clamscan", "--no-summary", "-").
+func NewCommandScanner(command string, args ...string) *CommandScanner {
+	return &CommandScanner{
+		command: command,
+		args:    args,
+	}
+}
+
+// Name returns the scanner identifier recorded on results.
+func (s *CommandScanner) Name() string {
+	return "command"
+}
+
+// Scan pipes the content into the command and interprets its exit code.
+// The first line of the command output is recorded as the signature on detection.
+func (s *CommandScanner) Scan(ctx context.Context, r io.Reader) (Verdict, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, s.command, s.args...)
+	cmd.Stdin = r
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err == nil {
+		return Verdict{}, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		signature, _, _ := strings.Cut(strings.TrimSpace(stdout.String()), "\n")
+		return Verdict{Infected: true, Signature: signature}, nil
+	}
+
+	return Verdict{}, fmt.Errorf("run scanner: %w: %s", err, strings.TrimSpace(stderr.String()))
+}
@@ -0,0 +1,35 @@
+package scanner
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCommandScanner_Scan(t *testing.T) {
+	s := NewCommandScanner("sh", "-c", `if grep -q EICAR; then echo "stdin: Test-Signature FOUND"; exit 1; fi`)
+
+	verdict, err := s.Scan(context.Background(), strings.NewReader("clean data"))
+	if err != nil {
+		t.Fatalf("Scan error: %v", err)
+	}
+	if verdict.Infected {
+		t.Errorf("expected clean verdict, got %+v", verdict)
+	}
+
+	verdict, err = s.Scan(context.Background(), strings.NewReader("EICAR"))
+	if err != nil {
+		t.Fatalf("Scan error: %v", err)
+	}
+	if !verdict.Infected || verdict.Signature != "stdin: Test-Signature FOUND" {
+		t.Errorf("expected infected verdict, got %+v", verdict)
+	}
+}
+
+func TestCommandScanner_ScanError(t *testing.T) {
+	s := NewCommandScanner("sh", "-c", "cat >/dev/null; exit 2")
+
+	if _, err := s.Scan(context.Background(), strings.NewReader("data")); err == nil {
+		t.Errorf("expected error for exit code 2")
+	}
+}
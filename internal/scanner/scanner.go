@@ -0,0 +1,23 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrInfected is returned by the worker when a scanner reports a file as infected.
+var ErrInfected = errors.New("file is infected")
+
+// Verdict is the outcome of scanning a single file.
+type Verdict struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner inspects file content for malware.
+// Implementations must consume r entirely or until they reach a verdict.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Verdict, error)
+	Name() string
+}
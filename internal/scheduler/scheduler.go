@@ -0,0 +1,370 @@
+// Package scheduler submits download tasks at a given time or on a cron schedule.
+package scheduler
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/service"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// DefaultHistoryLimit is the number of runs kept per schedule when Config leaves it zero.
+const DefaultHistoryLimit = 50
+
+// ErrInvalidSchedule is returned by Create for a schedule that can never run.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// TaskCreator submits download tasks. It is implemented by service.TaskService.
+type TaskCreator interface {
+	CreateTask(ctx context.Context, urls []string, opts domain.TaskOptions) (*domain.Task, error)
+}
+
+// Config configures a Scheduler.
+type Config struct {
+	// HistoryLimit is the number of most recent runs kept for each schedule.
+	HistoryLimit int
+}
+
+// Scheduler keeps schedules and submits a task whenever one is due. Each run passes
+// the validators of the previous successful downloads along, so that unchanged
+// resources are not downloaded again. Runs missed while the service was down are
+// made up for once, after which the schedule continues from the current time.
+type Scheduler struct {
+	storage      *storage.ScheduleStorage
+	logger       *slog.Logger
+	historyLimit int
+	now          func() time.Time
+
+	// mu serialises changes to schedules, so that a finished task cannot overwrite
+	// a run being recorded at the same time.
+	mu     sync.Mutex
+	byTask map[string]string
+
+	tasks TaskCreator
+	wake  chan struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// New creates a Scheduler for the schedules in st. Schedules only run once Start is called.
+func New(st *storage.ScheduleStorage, logger *slog.Logger, cfg Config) *Scheduler {
+	if cfg.HistoryLimit <= 0 {
+		cfg.HistoryLimit = DefaultHistoryLimit
+	}
+	s := &Scheduler{
+		storage:      st,
+		logger:       logger,
+		historyLimit: cfg.HistoryLimit,
+		now:          time.Now,
+		byTask:       make(map[string]string),
+		wake:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+
+	for _, schedule := range st.GetAll() {
+		for _, run := range schedule.Runs {
+			if run.TaskID != "" && !run.Status.IsTerminal() {
+				s.byTask[run.TaskID] = schedule.ID
+			}
+		}
+	}
+	return s
+}
+
+// ParseCron parses a standard five-field cron expression. Descriptors such as
+// "@daily" and a leading "CRON_TZ=<zone>" are accepted as well.
+func ParseCron(expr string) (cron.Schedule, error) {
+	return cron.ParseStandard(expr)
+}
+
+// Start begins submitting due schedules to tasks.
+func (s *Scheduler) Start(tasks TaskCreator) {
+	s.tasks = tasks
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop stops submitting schedules and waits for a run in progress to be recorded.
+func (s *Scheduler) Stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// Create stores a schedule that submits urls with opts at startAt, on every match
+// of cronExpr, or both: when both are given, cron matches start at startAt.
+func (s *Scheduler) Create(urls []string, opts domain.TaskOptions, startAt *time.Time, cronExpr string) (*domain.Schedule, error) {
+	cronExpr = strings.TrimSpace(cronExpr)
+	if startAt == nil && cronExpr == "" {
+		return nil, fmt.Errorf("%w: start_at or cron is required", ErrInvalidSchedule)
+	}
+
+	now := s.now()
+	schedule := &domain.Schedule{
+		ID:        uuid.NewString(),
+		URLs:      urls,
+		Options:   opts,
+		StartAt:   startAt,
+		Cron:      cronExpr,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if cronExpr == "" {
+		next := *startAt
+		schedule.NextRunAt = &next
+	} else {
+		sched, err := ParseCron(cronExpr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: cron: %v", ErrInvalidSchedule, err)
+		}
+		after := now
+		if startAt != nil && startAt.After(now) {
+			// Next returns matches strictly after its argument; step back so that
+			// a match at exactly startAt counts.
+			after = startAt.Add(-time.Nanosecond)
+		}
+		next := sched.Next(after)
+		if next.IsZero() {
+			return nil, fmt.Errorf("%w: cron expression never matches", ErrInvalidSchedule)
+		}
+		schedule.NextRunAt = &next
+	}
+
+	s.mu.Lock()
+	err := s.storage.Save(schedule)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("schedule created",
+		"schedule_id", schedule.ID,
+		"cron", schedule.Cron,
+		"next_run_at", schedule.NextRunAt,
+	)
+	s.signal()
+	return schedule, nil
+}
+
+// Get returns the schedule with the given ID.
+func (s *Scheduler) Get(id string) (*domain.Schedule, error) {
+	return s.storage.Get(id)
+}
+
+// List returns all schedules, oldest first.
+func (s *Scheduler) List() []*domain.Schedule {
+	schedules := s.storage.GetAll()
+	slices.SortFunc(schedules, func(a, b *domain.Schedule) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	return schedules
+}
+
+// Delete removes a schedule. Tasks it already submitted are left to finish.
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.storage.Delete(id); err != nil {
+		return err
+	}
+	for taskID, scheduleID := range s.byTask {
+		if scheduleID == id {
+			delete(s.byTask, taskID)
+		}
+	}
+	s.logger.Info("schedule deleted", "schedule_id", id)
+	return nil
+}
+
+// TaskStatusChanged records the status of tasks submitted by a schedule. Once a task has
+// finished, its results are added to the run and the validators of its successful
+// downloads are kept for the next run. It is meant to be registered with
+// service.WithStatusListener.
+func (s *Scheduler) TaskStatusChanged(_ domain.EventType, task *domain.Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scheduleID, ok := s.byTask[task.ID]
+	if !ok {
+		return
+	}
+	schedule, err := s.storage.Get(scheduleID)
+	if err != nil {
+		delete(s.byTask, task.ID)
+		return
+	}
+
+	i := slices.IndexFunc(schedule.Runs, func(r domain.ScheduleRun) bool { return r.TaskID == task.ID })
+	if i < 0 {
+		delete(s.byTask, task.ID)
+		return
+	}
+	runs := slices.Clone(schedule.Runs)
+	run := &runs[i]
+	run.Status = task.Status
+
+	if task.Status.IsTerminal() {
+		delete(s.byTask, task.ID)
+		finishedAt := task.UpdatedAt
+		run.FinishedAt = &finishedAt
+		run.Results = slices.Clone(task.Results)
+
+		validators := make(map[string]domain.Validators, len(schedule.Validators))
+		for url, v := range schedule.Validators {
+			validators[url] = v
+		}
+		for _, result := range task.Results {
+			if result.Success && (result.ETag != "" || result.LastModified != "") {
+				validators[result.URL] = domain.Validators{ETag: result.ETag, LastModified: result.LastModified}
+			}
+		}
+		schedule.Validators = validators
+	}
+
+	schedule.Runs = runs
+	schedule.UpdatedAt = s.now()
+	if err := s.storage.Save(schedule); err != nil {
+		s.logger.Error("failed to save schedule", "schedule_id", schedule.ID, "error", err)
+	}
+}
+
+func (s *Scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop submits due schedules and then sleeps until the next one is due or a schedule
+// is created.
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		if wait, ok := s.runDue(); ok {
+			timer.Reset(wait)
+		} else {
+			timer.Stop()
+		}
+
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// runDue runs every schedule that is due and returns how long to wait for the next one.
+// It returns false when no schedule is going to run again.
+func (s *Scheduler) runDue() (time.Duration, bool) {
+	var next time.Time
+	for _, schedule := range s.storage.GetAll() {
+		select {
+		case <-s.done:
+			return 0, false
+		default:
+		}
+
+		nextRunAt := schedule.NextRunAt
+		if nextRunAt != nil && !nextRunAt.After(s.now()) {
+			nextRunAt = s.run(schedule.ID)
+		}
+		if nextRunAt != nil && (next.IsZero() || nextRunAt.Before(next)) {
+			next = *nextRunAt
+		}
+	}
+	if next.IsZero() {
+		return 0, false
+	}
+	return max(next.Sub(s.now()), 0), true
+}
+
+// run submits a task for the schedule with the given ID if it is still due, records
+// the run and returns when the schedule runs next.
+func (s *Scheduler) run(id string) *time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, err := s.storage.Get(id)
+	if err != nil || schedule.NextRunAt == nil {
+		return nil
+	}
+	now := s.now()
+	if schedule.NextRunAt.After(now) {
+		return schedule.NextRunAt
+	}
+	scheduledAt := *schedule.NextRunAt
+
+	opts := schedule.Options
+	if len(schedule.Validators) > 0 {
+		opts.Validators = schedule.Validators
+	}
+	// The key makes a run that was submitted but not recorded before a crash
+	// return the same task instead of a second one.
+	key := fmt.Sprintf("schedule:%s:%d", schedule.ID, scheduledAt.Unix())
+	ctx := service.WithIdempotencyKey(context.Background(), key, schedule.ID)
+
+	run := domain.ScheduleRun{ScheduledAt: scheduledAt}
+	task, err := s.tasks.CreateTask(ctx, schedule.URLs, opts)
+	if err != nil {
+		run.Status = domain.StatusFailed
+		run.Error = err.Error()
+		run.FinishedAt = &now
+		s.logger.Warn("scheduled run failed", "schedule_id", schedule.ID, "error", err)
+	} else {
+		run.TaskID = task.ID
+		run.Status = task.Status
+		s.byTask[task.ID] = schedule.ID
+		s.logger.Info("scheduled run submitted", "schedule_id", schedule.ID, "task_id", task.ID)
+	}
+
+	runs := append(slices.Clone(schedule.Runs), run)
+	if excess := len(runs) - s.historyLimit; excess > 0 {
+		for _, old := range runs[:excess] {
+			delete(s.byTask, old.TaskID)
+		}
+		runs = runs[excess:]
+	}
+	schedule.Runs = runs
+	schedule.NextRunAt = s.nextRun(schedule, now)
+	schedule.UpdatedAt = now
+
+	if err := s.storage.Save(schedule); err != nil {
+		s.logger.Error("failed to save schedule", "schedule_id", schedule.ID, "error", err)
+	}
+	return schedule.NextRunAt
+}
+
+// nextRun returns the first cron match after now, or nil for a one-off schedule.
+func (s *Scheduler) nextRun(schedule *domain.Schedule, now time.Time) *time.Time {
+	if schedule.Cron == "" {
+		return nil
+	}
+	sched, err := ParseCron(schedule.Cron)
+	if err != nil {
+		s.logger.Error("invalid cron expression", "schedule_id", schedule.ID, "error", err)
+		return nil
+	}
+	next := sched.Next(now)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
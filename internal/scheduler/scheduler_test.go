@@ -0,0 +1,228 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/service"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+type fakeCreator struct {
+	mu    sync.Mutex
+	calls []domain.TaskOptions
+	err   error
+	made  chan string
+}
+
+func (f *fakeCreator) CreateTask(ctx context.Context, urls []string, opts domain.TaskOptions) (*domain.Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.calls = append(f.calls, opts)
+	task := &domain.Task{ID: "task-" + string(rune('a'+len(f.calls)-1)), URLs: urls, Status: domain.StatusPending, Options: opts}
+	if f.made != nil {
+		f.made <- task.ID
+	}
+	return task, nil
+}
+
+func (f *fakeCreator) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.calls)
+}
+
+// newTestScheduler returns a scheduler whose clock reads *now.
+func newTestScheduler(t *testing.T, now *time.Time) (*Scheduler, *storage.ScheduleStorage) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "scheduler_test_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	st, err := storage.NewScheduleStorage(dir, nil)
+	if err != nil {
+		t.Fatalf("NewScheduleStorage error: %v", err)
+	}
+	s := New(st, slog.New(slog.NewTextHandler(io.Discard, nil)), Config{})
+	s.now = func() time.Time { return *now }
+	return s, st
+}
+
+func TestScheduler_CreateValidation(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+	s, _ := newTestScheduler(t, &now)
+	urls := []string{"https://example.com/a"}
+
+	if _, err := s.Create(urls, domain.TaskOptions{}, nil, ""); !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("expected ErrInvalidSchedule without start_at or cron, got %v", err)
+	}
+	if _, err := s.Create(urls, domain.TaskOptions{}, nil, "not a cron"); !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("expected ErrInvalidSchedule for a bad cron expression, got %v", err)
+	}
+
+	hourly, err := s.Create(urls, domain.TaskOptions{}, nil, "0 * * * *")
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	if want := now.Add(30 * time.Minute); !hourly.NextRunAt.Equal(want) {
+		t.Errorf("expected next run at %v, got %v", want, hourly.NextRunAt)
+	}
+
+	now = now.Add(time.Second)
+	startAt := time.Date(2026, 3, 2, 11, 0, 0, 0, time.UTC)
+	later, err := s.Create(urls, domain.TaskOptions{}, &startAt, "0 * * * *")
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	if !later.NextRunAt.Equal(startAt) {
+		t.Errorf("expected cron matches to start at %v, got %v", startAt, later.NextRunAt)
+	}
+
+	if got := s.List(); len(got) != 2 || got[0].ID != hourly.ID {
+		t.Errorf("expected schedules oldest first, got %v", got)
+	}
+}
+
+func TestScheduler_RunsAndKeepsValidators(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	s, _ := newTestScheduler(t, &now)
+	creator := &fakeCreator{}
+	s.tasks = creator
+
+	url := "https://example.com/feed.xml"
+	schedule, err := s.Create([]string{url}, domain.TaskOptions{}, nil, "*/15 * * * *")
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+
+	// Run an hour late: the missed matches are made up for with a single run.
+	now = now.Add(time.Hour)
+	if wait, ok := s.runDue(); !ok || wait != 15*time.Minute {
+		t.Fatalf("expected to wait 15m for the next run, got %v %v", wait, ok)
+	}
+	if creator.count() != 1 {
+		t.Fatalf("expected one task to be submitted, got %d", creator.count())
+	}
+	if creator.calls[0].Validators != nil {
+		t.Errorf("expected the first run to download unconditionally")
+	}
+
+	s.TaskStatusChanged(domain.EventUpdateTask, &domain.Task{ID: "task-a", Status: domain.StatusInProgress})
+	got, _ := s.Get(schedule.ID)
+	if len(got.Runs) != 1 || got.Runs[0].TaskID != "task-a" || got.Runs[0].Status != domain.StatusInProgress {
+		t.Fatalf("expected the run to follow its task, got %+v", got.Runs)
+	}
+
+	s.TaskStatusChanged(domain.EventUpdateTask, &domain.Task{
+		ID:        "task-a",
+		Status:    domain.StatusCompleted,
+		UpdatedAt: now,
+		Results:   []domain.DownloadResult{{URL: url, Success: true, ETag: `"v1"`}},
+	})
+	got, _ = s.Get(schedule.ID)
+	if got.Runs[0].FinishedAt == nil || len(got.Runs[0].Results) != 1 {
+		t.Errorf("expected the finished run to carry its results, got %+v", got.Runs[0])
+	}
+	if got.Validators[url].ETag != `"v1"` {
+		t.Errorf("expected validators to be kept, got %v", got.Validators)
+	}
+
+	now = now.Add(15 * time.Minute)
+	s.runDue()
+	if creator.count() != 2 {
+		t.Fatalf("expected a second run, got %d", creator.count())
+	}
+	if creator.calls[1].Validators[url].ETag != `"v1"` {
+		t.Errorf("expected the second run to be conditional, got %v", creator.calls[1].Validators)
+	}
+}
+
+func TestScheduler_OneOffAndFailedRuns(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	s, st := newTestScheduler(t, &now)
+	creator := &fakeCreator{err: service.ErrQueueFull}
+	s.tasks = creator
+
+	startAt := now.Add(-time.Minute)
+	schedule, err := s.Create([]string{"https://example.com/a"}, domain.TaskOptions{}, &startAt, "")
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	if _, ok := s.runDue(); ok {
+		t.Errorf("expected nothing left to run")
+	}
+
+	got, err := st.Get(schedule.ID)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if got.NextRunAt != nil {
+		t.Errorf("expected a one-off schedule not to run again, got %v", got.NextRunAt)
+	}
+	if len(got.Runs) != 1 || got.Runs[0].Status != domain.StatusFailed || got.Runs[0].Error == "" {
+		t.Errorf("expected a failed run to be recorded, got %+v", got.Runs)
+	}
+}
+
+func TestScheduler_HistoryLimit(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	s, _ := newTestScheduler(t, &now)
+	s.historyLimit = 2
+	s.tasks = &fakeCreator{}
+
+	schedule, err := s.Create([]string{"https://example.com/a"}, domain.TaskOptions{}, nil, "@every 1m")
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	for range 3 {
+		now = now.Add(time.Minute)
+		s.runDue()
+	}
+
+	got, _ := s.Get(schedule.ID)
+	if len(got.Runs) != 2 || got.Runs[0].TaskID != "task-b" || got.Runs[1].TaskID != "task-c" {
+		t.Errorf("expected the two latest runs to be kept, got %+v", got.Runs)
+	}
+	if _, tracked := s.byTask["task-a"]; tracked {
+		t.Errorf("expected dropped runs to stop being tracked")
+	}
+}
+
+func TestScheduler_StartRunsDueSchedules(t *testing.T) {
+	now := time.Now()
+	s, _ := newTestScheduler(t, &now)
+	s.now = time.Now
+	creator := &fakeCreator{made: make(chan string, 1)}
+	s.Start(creator)
+	defer s.Stop()
+
+	startAt := time.Now().Add(50 * time.Millisecond)
+	schedule, err := s.Create([]string{"https://example.com/a"}, domain.TaskOptions{}, &startAt, "")
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+
+	select {
+	case <-creator.made:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the schedule to run")
+	}
+	if err := s.Delete(schedule.ID); err != nil {
+		t.Errorf("Delete error: %v", err)
+	}
+	if _, err := s.Get(schedule.ID); !errors.Is(err, storage.ErrScheduleNotFound) {
+		t.Errorf("expected ErrScheduleNotFound after delete, got %v", err)
+	}
+}
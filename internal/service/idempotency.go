@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+// DefaultIdempotencyTTL is how long an idempotency key is remembered when
+// WithIdempotencyTTL is not given.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is sent again
+// with a different request.
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+
+type idempotencyKey struct {
+	key         string
+	fingerprint string
+}
+
+type idempotencyKeyCtx struct{}
+
+// WithIdempotencyKey marks the CreateTask call made with the returned context as a retry-safe
+// request. Fingerprint identifies the request content; a repeated key returns the original task
+// when the fingerprints match and ErrIdempotencyKeyReused otherwise.
+func WithIdempotencyKey(ctx context.Context, key, fingerprint string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, idempotencyKey{key: key, fingerprint: fingerprint})
+}
+
+func idempotencyKeyFrom(ctx context.Context) (idempotencyKey, bool) {
+	k, ok := ctx.Value(idempotencyKeyCtx{}).(idempotencyKey)
+	return k, ok && k.key != ""
+}
+
+// WithIdempotencyTTL sets how long idempotency keys are remembered after the task was created.
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(s *TaskService) {
+		if ttl > 0 {
+			s.idempotency.ttl = ttl
+		}
+	}
+}
+
+// idempotencyIndex maps live idempotency keys to the tasks they created. It is rebuilt from
+// storage on startup, so keys survive restarts for as long as their tasks are kept.
+type idempotencyIndex struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	tasks     map[string]*domain.Task
+	lastPrune time.Time
+}
+
+func (idx *idempotencyIndex) load(tasks []*domain.Task) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.tasks = make(map[string]*domain.Task)
+	for _, task := range tasks {
+		if task.IdempotencyKey == "" || idx.expired(task, time.Now()) {
+			continue
+		}
+		idx.tasks[task.IdempotencyKey] = task
+	}
+}
+
+// reserve returns the live task created with key, or records task under key when there is none.
+// The lookup and the reservation happen under one lock so that concurrent retries of the same
+// request cannot both create a task.
+func (idx *idempotencyIndex) reserve(key idempotencyKey, task *domain.Task) (*domain.Task, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	now := time.Now()
+	idx.prune(now)
+
+	if existing, ok := idx.tasks[key.key]; ok && !idx.expired(existing, now) {
+		if existing.RequestFingerprint != key.fingerprint {
+			return nil, ErrIdempotencyKeyReused
+		}
+		return existing, nil
+	}
+
+	task.IdempotencyKey = key.key
+	task.RequestFingerprint = key.fingerprint
+	idx.tasks[key.key] = task
+	return nil, nil
+}
+
+// release forgets a reservation whose task was never queued.
+func (idx *idempotencyIndex) release(key string, task *domain.Task) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.tasks[key] == task {
+		delete(idx.tasks, key)
+	}
+}
+
+func (idx *idempotencyIndex) expired(task *domain.Task, now time.Time) bool {
+	return now.Sub(task.CreatedAt) > idx.ttl
+}
+
+// prune drops expired keys at most once a minute.
+func (idx *idempotencyIndex) prune(now time.Time) {
+	if now.Sub(idx.lastPrune) < time.Minute {
+		return
+	}
+	idx.lastPrune = now
+	for key, task := range idx.tasks {
+		if idx.expired(task, now) {
+			delete(idx.tasks, key)
+		}
+	}
+}
+
+// forget drops the key of a task that has been deleted.
+func (idx *idempotencyIndex) forget(task *domain.Task) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if existing, ok := idx.tasks[task.IdempotencyKey]; ok && existing.ID == task.ID {
+		delete(idx.tasks, task.IdempotencyKey)
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+	"github.com/veranemoloko/url-downloader/internal/worker"
+)
+
+func newIdempotencyTestService(t *testing.T, taskDir string, opts ...Option) (*TaskService, *storage.TaskStorage) {
+	t.Helper()
+	taskStorage, err := storage.NewTaskStorage(taskDir)
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+	logger := newTestLogger()
+	return NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger, opts...), taskStorage
+}
+
+func TestTaskService_IdempotencyKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "AAA")
+	}))
+	defer server.Close()
+	urls := []string{server.URL + "/a"}
+
+	taskDir := makeTempDir(t, "taskservice_tasks_*")
+	svc, taskStorage := newIdempotencyTestService(t, taskDir)
+
+	first, err := svc.CreateTask(WithIdempotencyKey(context.Background(), "key-1", "body-a"), urls, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+
+	again, err := svc.CreateTask(WithIdempotencyKey(context.Background(), "key-1", "body-a"), urls, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("repeated CreateTask error: %v", err)
+	}
+	if again.ID != first.ID {
+		t.Fatalf("expected repeated key to return task %s, got %s", first.ID, again.ID)
+	}
+
+	if _, err := svc.CreateTask(WithIdempotencyKey(context.Background(), "key-1", "body-b"), urls, domain.TaskOptions{}); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Fatalf("expected ErrIdempotencyKeyReused, got %v", err)
+	}
+
+	other, err := svc.CreateTask(WithIdempotencyKey(context.Background(), "key-2", "body-a"), urls, domain.TaskOptions{})
+	if err != nil || other.ID == first.ID {
+		t.Fatalf("expected a new task for a new key, got %v, %v", other, err)
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		got, err := taskStorage.Get(first.ID)
+		return err == nil && got.Status == domain.StatusCompleted
+	})
+	if err := svc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+
+	restarted, _ := newIdempotencyTestService(t, taskDir)
+	defer restarted.Shutdown(context.Background())
+
+	replayed, err := restarted.CreateTask(WithIdempotencyKey(context.Background(), "key-1", "body-a"), urls, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask after restart error: %v", err)
+	}
+	if replayed.ID != first.ID || replayed.Status != domain.StatusCompleted {
+		t.Fatalf("expected completed task %s after restart, got %s (%s)", first.ID, replayed.ID, replayed.Status)
+	}
+	if _, err := restarted.CreateTask(WithIdempotencyKey(context.Background(), "key-1", "body-b"), urls, domain.TaskOptions{}); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Fatalf("expected ErrIdempotencyKeyReused after restart, got %v", err)
+	}
+}
+
+func TestTaskService_IdempotencyKeyExpires(t *testing.T) {
+	taskDir := makeTempDir(t, "taskservice_tasks_*")
+	taskStorage, err := storage.NewTaskStorage(taskDir)
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	old := &domain.Task{
+		ID:                 "old",
+		Status:             domain.StatusCompleted,
+		CreatedAt:          time.Now().Add(-2 * time.Hour),
+		IdempotencyKey:     "key-1",
+		RequestFingerprint: "body-a",
+	}
+	if err := taskStorage.Save(context.Background(), old); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	svc, _ := newIdempotencyTestService(t, taskDir, WithIdempotencyTTL(time.Hour))
+	defer svc.Shutdown(context.Background())
+
+	task, err := svc.CreateTask(WithIdempotencyKey(context.Background(), "key-1", "body-b"), []string{"https://example.invalid/a"}, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("expected expired key to be reusable, got %v", err)
+	}
+	if task.ID == old.ID {
+		t.Fatal("expected a new task for an expired key")
+	}
+}
+
+func TestTaskService_IdempotencyKeyConcurrent(t *testing.T) {
+	svc, _ := newIdempotencyTestService(t, makeTempDir(t, "taskservice_tasks_*"))
+	defer svc.Shutdown(context.Background())
+
+	var wg sync.WaitGroup
+	ids := make([]string, 20)
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task, err := svc.CreateTask(WithIdempotencyKey(context.Background(), "key-1", "body-a"), []string{"https://example.invalid/a"}, domain.TaskOptions{})
+			if err != nil {
+				t.Errorf("CreateTask error: %v", err)
+				return
+			}
+			ids[i] = task.ID
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("expected all concurrent requests to share one task, got %v", ids)
+		}
+	}
+}
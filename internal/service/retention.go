@@ -0,0 +1,350 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+// DefaultOrphanGrace is how old a file without a task has to be before it is removed
+// when the retention policy does not say otherwise.
+const DefaultOrphanGrace = time.Hour
+
+// RetentionPolicy configures the janitor that removes old tasks and their files.
+type RetentionPolicy struct {
+	// TTL is how long finished tasks are kept after their last update, by status.
+	// Statuses without a TTL are only removed to enforce MaxDiskUsage.
+	TTL map[domain.TaskStatus]time.Duration
+	// MaxDiskUsage caps the bytes taken by task files. When it is exceeded, finished
+	// tasks are removed least recently used first. Zero means no limit.
+	MaxDiskUsage int64
+	// OrphanGrace protects files without a task until they are this old.
+	OrphanGrace time.Duration
+	// Interval is the time between background sweeps. Zero disables them, leaving
+	// only sweeps started through RunRetention.
+	Interval time.Duration
+}
+
+// RetentionReport describes what a sweep removed, or would remove in a dry run.
+// Freed bytes of deduplicated files are only returned to the file system once
+// their blob is garbage collected.
+type RetentionReport struct {
+	DryRun       bool          `json:"dry_run"`
+	StartedAt    time.Time     `json:"started_at"`
+	DiskUsage    int64         `json:"disk_usage"`
+	Expired      []RemovedTask `json:"expired"`
+	Evicted      []RemovedTask `json:"evicted"`
+	OrphanFiles  []OrphanFile  `json:"orphan_files"`
+	MissingFiles []string      `json:"tasks_missing_files"`
+	FreedBytes   int64         `json:"freed_bytes"`
+	Errors       []string      `json:"errors,omitempty"`
+}
+
+// RemovedTask is a finished task removed by the janitor together with its files.
+type RemovedTask struct {
+	ID        string            `json:"id"`
+	Status    domain.TaskStatus `json:"status"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Bytes     int64             `json:"bytes"`
+}
+
+// OrphanFile is an entry of the download directory that belongs to no task.
+type OrphanFile struct {
+	Path    string    `json:"path"`
+	Bytes   int64     `json:"bytes"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// WithRetention enables the janitor with policy p.
+func WithRetention(p RetentionPolicy) Option {
+	return func(s *TaskService) {
+		s.retention = p
+	}
+}
+
+// taskEntry is a top-level entry of the download directory. Every file a task writes
+// is named after it: "<task ID>_<url>" for downloads and "<task ID>/" for torrents
+// and extracted archives.
+type taskEntry struct {
+	name    string
+	bytes   int64
+	modTime time.Time
+}
+
+// entryOwner returns the ID of the task an entry of the download directory belongs to.
+// Entries that cannot have been written for a task, such as the blob store or a
+// quarantine directory, return false and are left alone.
+func entryOwner(name string, isDir bool) (string, bool) {
+	if strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	id := name
+	if !isDir {
+		id, _, _ = strings.Cut(name, "_")
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// scanDownloads groups the entries of the download directory by owning task.
+func (s *TaskService) scanDownloads() (map[string][]taskEntry, error) {
+	dirEntries, err := os.ReadDir(s.fileStorage.Path(""))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read download dir: %w", err)
+	}
+
+	owned := make(map[string][]taskEntry)
+	for _, d := range dirEntries {
+		id, ok := entryOwner(d.Name(), d.IsDir())
+		if !ok {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		entry := taskEntry{name: d.Name(), bytes: info.Size(), modTime: info.ModTime()}
+		if d.IsDir() {
+			entry.bytes, entry.modTime = dirUsage(s.fileStorage.Path(d.Name()), info.ModTime())
+		}
+		owned[id] = append(owned[id], entry)
+	}
+	return owned, nil
+}
+
+// dirUsage sums the sizes of the regular files under dir and returns the latest
+// modification time found.
+func dirUsage(dir string, modTime time.Time) (int64, time.Time) {
+	var size int64
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+		return nil
+	})
+	return size, modTime
+}
+
+// RunRetention sweeps tasks and files according to the retention policy. In a dry run
+// nothing is removed and the report lists what would have been. Sweeps never overlap.
+func (s *TaskService) RunRetention(dryRun bool) (RetentionReport, error) {
+	s.sweepMu.Lock()
+	defer s.sweepMu.Unlock()
+
+	now := time.Now()
+	report := RetentionReport{
+		DryRun:       dryRun,
+		StartedAt:    now,
+		Expired:      []RemovedTask{},
+		Evicted:      []RemovedTask{},
+		OrphanFiles:  []OrphanFile{},
+		MissingFiles: []string{},
+	}
+
+	owned, err := s.scanDownloads()
+	if err != nil {
+		return report, err
+	}
+	tasks := s.taskStorage.GetAll()
+	known := make(map[string]bool, len(tasks))
+
+	var candidates []*domain.Task
+	for _, task := range tasks {
+		known[task.ID] = true
+		if task.Status.IsTerminal() && len(owned[task.ID]) == 0 && slices.ContainsFunc(task.Results, func(r domain.DownloadResult) bool {
+			return r.Success && r.FileName != ""
+		}) {
+			report.MissingFiles = append(report.MissingFiles, task.ID)
+		}
+		if s.removable(task) {
+			candidates = append(candidates, task)
+		}
+	}
+
+	slices.Sort(report.MissingFiles)
+
+	grace := s.retention.OrphanGrace
+	if grace <= 0 {
+		grace = DefaultOrphanGrace
+	}
+	var orphans []OrphanFile
+	for id, entries := range owned {
+		for _, e := range entries {
+			report.DiskUsage += e.bytes
+			if !known[id] && now.Sub(e.modTime) > grace {
+				orphans = append(orphans, OrphanFile{Path: e.name, Bytes: e.bytes, ModTime: e.modTime})
+			}
+		}
+	}
+	slices.SortFunc(orphans, func(a, b OrphanFile) int { return strings.Compare(a.Path, b.Path) })
+
+	usage := report.DiskUsage
+	for _, orphan := range orphans {
+		usage -= orphan.Bytes
+	}
+
+	var expired, evicted []*domain.Task
+	var kept []*domain.Task
+	for _, task := range candidates {
+		if ttl := s.retention.TTL[task.Status]; ttl > 0 && now.Sub(task.UpdatedAt) > ttl {
+			expired = append(expired, task)
+			usage -= taskBytes(owned[task.ID])
+		} else {
+			kept = append(kept, task)
+		}
+	}
+	if limit := s.retention.MaxDiskUsage; limit > 0 && usage > limit {
+		slices.SortFunc(kept, func(a, b *domain.Task) int {
+			return cmp.Or(s.lastUsed(a).Compare(s.lastUsed(b)), strings.Compare(a.ID, b.ID))
+		})
+		for _, task := range kept {
+			if usage <= limit {
+				break
+			}
+			if bytes := taskBytes(owned[task.ID]); bytes > 0 {
+				evicted = append(evicted, task)
+				usage -= bytes
+			}
+		}
+	}
+
+	for _, task := range expired {
+		if removed, ok := s.removeTask(task, owned[task.ID], dryRun, &report); ok {
+			report.Expired = append(report.Expired, removed)
+		}
+	}
+	for _, task := range evicted {
+		if removed, ok := s.removeTask(task, owned[task.ID], dryRun, &report); ok {
+			report.Evicted = append(report.Evicted, removed)
+		}
+	}
+	for _, orphan := range orphans {
+		if !dryRun {
+			if err := os.RemoveAll(s.fileStorage.Path(orphan.Path)); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("remove orphan %s: %v", orphan.Path, err))
+				continue
+			}
+		}
+		report.OrphanFiles = append(report.OrphanFiles, orphan)
+		report.FreedBytes += orphan.Bytes
+	}
+
+	s.logger.Info("retention sweep finished",
+		"dry_run", dryRun,
+		"disk_usage", report.DiskUsage,
+		"expired", len(report.Expired),
+		"evicted", len(report.Evicted),
+		"orphan_files", len(report.OrphanFiles),
+		"tasks_missing_files", len(report.MissingFiles),
+		"freed_bytes", report.FreedBytes,
+		"errors", len(report.Errors),
+	)
+	return report, nil
+}
+
+// removable reports whether the janitor may remove task: it has to be finished, out of
+// the worker's hands and done notifying its callback URL.
+func (s *TaskService) removable(task *domain.Task) bool {
+	if !task.Status.IsTerminal() {
+		return false
+	}
+	if _, busy := s.processing.Load(task.ID); busy {
+		return false
+	}
+	return s.webhooks == nil || !s.webhooks.Pending(task)
+}
+
+// removeTask deletes task and then its files, unless the task changed since the sweep
+// looked at it. In a dry run it only reports what would be removed.
+func (s *TaskService) removeTask(task *domain.Task, entries []taskEntry, dryRun bool, report *RetentionReport) (RemovedTask, bool) {
+	removed := RemovedTask{
+		ID:        task.ID,
+		Status:    task.Status,
+		UpdatedAt: task.UpdatedAt,
+		Bytes:     taskBytes(entries),
+	}
+	if dryRun {
+		report.FreedBytes += removed.Bytes
+		return removed, true
+	}
+
+	deleted, err := s.taskStorage.DeleteIf(task.ID, func(current *domain.Task) bool {
+		return current.UpdatedAt.Equal(task.UpdatedAt) && s.removable(current)
+	})
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("delete task %s: %v", task.ID, err))
+	}
+	if !deleted {
+		return removed, false
+	}
+	s.idempotency.forget(task)
+	s.lastRead.Delete(task.ID)
+
+	for _, e := range entries {
+		if err := os.RemoveAll(s.fileStorage.Path(e.name)); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("remove %s: %v", e.name, err))
+			continue
+		}
+		report.FreedBytes += e.bytes
+	}
+	return removed, true
+}
+
+// lastUsed is when task was last updated or read through GetTask, whichever is later.
+// Reads are only tracked since the service started.
+func (s *TaskService) lastUsed(task *domain.Task) time.Time {
+	if read, ok := s.lastRead.Load(task.ID); ok && read.(time.Time).After(task.UpdatedAt) {
+		return read.(time.Time)
+	}
+	return task.UpdatedAt
+}
+
+func taskBytes(entries []taskEntry) int64 {
+	var total int64
+	for _, e := range entries {
+		total += e.bytes
+	}
+	return total
+}
+
+// janitor runs a retention sweep every interval until the service shuts down.
+func (s *TaskService) janitor(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.RunRetention(false); err != nil {
+				s.logger.Error("retention sweep failed", "error", err)
+			}
+		case <-s.shutdownChan:
+			return
+		}
+	}
+}
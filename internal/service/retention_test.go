@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+	"github.com/veranemoloko/url-downloader/internal/worker"
+)
+
+type retentionFixture struct {
+	svc         *TaskService
+	taskStorage *storage.TaskStorage
+	downloadDir string
+}
+
+func newRetentionFixture(t *testing.T, policy RetentionPolicy) *retentionFixture {
+	t.Helper()
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	downloadDir := makeTempDir(t, "taskservice_downloads_*")
+	fileStorage := storage.NewFileStorage(downloadDir)
+	logger := newTestLogger()
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger, WithRetention(policy))
+	t.Cleanup(func() { svc.Shutdown(context.Background()) })
+	return &retentionFixture{svc: svc, taskStorage: taskStorage, downloadDir: downloadDir}
+}
+
+// addTask stores a task last updated age ago and writes a file of size bytes for it.
+func (f *retentionFixture) addTask(t *testing.T, status domain.TaskStatus, age time.Duration, size int) *domain.Task {
+	t.Helper()
+	task := &domain.Task{
+		ID:        uuid.NewString(),
+		Status:    status,
+		CreatedAt: time.Now().Add(-age),
+		UpdatedAt: time.Now().Add(-age),
+	}
+	if size > 0 {
+		name := task.ID + "_file"
+		f.writeFile(t, name, size, age)
+		task.Results = []domain.DownloadResult{{FileName: name, Success: true, BytesRead: int64(size)}}
+	}
+	if err := f.taskStorage.Save(context.Background(), task); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+	return task
+}
+
+func (f *retentionFixture) writeFile(t *testing.T, name string, size int, age time.Duration) {
+	t.Helper()
+	path := filepath.Join(f.downloadDir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to set file times: %v", err)
+	}
+}
+
+func (f *retentionFixture) exists(name string) bool {
+	_, err := os.Stat(filepath.Join(f.downloadDir, name))
+	return err == nil
+}
+
+func removedIDs(tasks []RemovedTask) []string {
+	ids := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func TestTaskService_RetentionTTL(t *testing.T) {
+	f := newRetentionFixture(t, RetentionPolicy{
+		TTL: map[domain.TaskStatus]time.Duration{
+			domain.StatusCompleted: 24 * time.Hour,
+			domain.StatusFailed:    time.Hour,
+		},
+	})
+	oldCompleted := f.addTask(t, domain.StatusCompleted, 48*time.Hour, 10)
+	recentCompleted := f.addTask(t, domain.StatusCompleted, 2*time.Hour, 10)
+	oldFailed := f.addTask(t, domain.StatusFailed, 2*time.Hour, 0)
+	oldPending := f.addTask(t, domain.StatusPending, 72*time.Hour, 10)
+
+	report, err := f.svc.RunRetention(true)
+	if err != nil {
+		t.Fatalf("dry run error: %v", err)
+	}
+	got := removedIDs(report.Expired)
+	if len(got) != 2 || !slices.Contains(got, oldCompleted.ID) || !slices.Contains(got, oldFailed.ID) {
+		t.Fatalf("expected old completed and failed tasks to expire, got %v", got)
+	}
+	if report.FreedBytes != 10 || report.DiskUsage != 30 {
+		t.Errorf("expected 10 of 30 bytes freed, got %d of %d", report.FreedBytes, report.DiskUsage)
+	}
+	if _, err := f.svc.GetTask(oldCompleted.ID); err != nil || !f.exists(oldCompleted.ID+"_file") {
+		t.Fatalf("expected dry run to keep task and file")
+	}
+
+	if _, err := f.svc.RunRetention(false); err != nil {
+		t.Fatalf("RunRetention error: %v", err)
+	}
+	if _, err := f.svc.GetTask(oldCompleted.ID); err == nil {
+		t.Errorf("expected expired task to be deleted")
+	}
+	if f.exists(oldCompleted.ID + "_file") {
+		t.Errorf("expected files of expired task to be deleted")
+	}
+	if _, err := f.svc.GetTask(oldFailed.ID); err == nil {
+		t.Errorf("expected expired failed task to be deleted")
+	}
+	for _, kept := range []*domain.Task{recentCompleted, oldPending} {
+		if _, err := f.svc.GetTask(kept.ID); err != nil || !f.exists(kept.ID+"_file") {
+			t.Errorf("expected task %s in status %s to be kept", kept.ID, kept.Status)
+		}
+	}
+}
+
+func TestTaskService_RetentionOrphans(t *testing.T) {
+	f := newRetentionFixture(t, RetentionPolicy{OrphanGrace: time.Hour})
+	missing := f.addTask(t, domain.StatusCompleted, time.Hour, 0)
+	missing.Results = []domain.DownloadResult{{FileName: missing.ID + "_gone", Success: true}}
+	if err := f.taskStorage.Save(context.Background(), missing); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	oldOrphan := uuid.NewString() + "_file"
+	oldOrphanDir := uuid.NewString()
+	freshOrphan := uuid.NewString() + "_file"
+	f.writeFile(t, oldOrphan, 5, 2*time.Hour)
+	f.writeFile(t, filepath.Join(oldOrphanDir, "extracted.txt"), 7, 2*time.Hour)
+	dirTime := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(f.downloadDir, oldOrphanDir), dirTime, dirTime); err != nil {
+		t.Fatalf("failed to set dir times: %v", err)
+	}
+	f.writeFile(t, freshOrphan, 5, time.Minute)
+	f.writeFile(t, filepath.Join("quarantine", "infected.bin"), 5, 48*time.Hour)
+	f.writeFile(t, filepath.Join(storage.BlobDirName, "ab", "abcdef"), 5, 48*time.Hour)
+
+	report, err := f.svc.RunRetention(false)
+	if err != nil {
+		t.Fatalf("RunRetention error: %v", err)
+	}
+
+	if len(report.OrphanFiles) != 2 || report.FreedBytes != 12 {
+		t.Fatalf("expected the two old orphans (12 bytes) to be removed, got %+v", report)
+	}
+	if f.exists(oldOrphan) || f.exists(oldOrphanDir) {
+		t.Errorf("expected old orphans to be deleted")
+	}
+	if !f.exists(freshOrphan) {
+		t.Errorf("expected orphan within the grace period to be kept")
+	}
+	if !f.exists("quarantine") || !f.exists(storage.BlobDirName) {
+		t.Errorf("expected entries not named after a task to be left alone")
+	}
+	if len(report.MissingFiles) != 1 || report.MissingFiles[0] != missing.ID {
+		t.Errorf("expected task %s to be reported without files, got %v", missing.ID, report.MissingFiles)
+	}
+}
+
+func TestTaskService_RetentionEvictsLeastRecentlyUsed(t *testing.T) {
+	f := newRetentionFixture(t, RetentionPolicy{MaxDiskUsage: 250})
+	oldest := f.addTask(t, domain.StatusCompleted, 3*time.Hour, 100)
+	middle := f.addTask(t, domain.StatusCompleted, 2*time.Hour, 100)
+	newest := f.addTask(t, domain.StatusCompleted, time.Hour, 100)
+	running := f.addTask(t, domain.StatusInProgress, 4*time.Hour, 100)
+
+	// Reading the oldest task makes it the most recently used.
+	if _, err := f.svc.GetTask(oldest.ID); err != nil {
+		t.Fatalf("GetTask error: %v", err)
+	}
+
+	report, err := f.svc.RunRetention(false)
+	if err != nil {
+		t.Fatalf("RunRetention error: %v", err)
+	}
+	got := removedIDs(report.Evicted)
+	if len(got) != 2 || got[0] != middle.ID || got[1] != newest.ID {
+		t.Fatalf("expected middle then newest to be evicted, got %v", got)
+	}
+	if _, err := f.svc.GetTask(running.ID); err != nil || !f.exists(running.ID+"_file") {
+		t.Errorf("expected unfinished task to be kept")
+	}
+	if _, err := f.svc.GetTask(oldest.ID); err != nil {
+		t.Errorf("expected recently read task to be kept")
+	}
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+var (
+	// ErrTaskNotFinished is returned when retrying a task that is still queued or running.
+	ErrTaskNotFinished = errors.New("task has not finished")
+	// ErrNothingToRetry is returned when retrying a task none of whose URLs failed.
+	ErrNothingToRetry = errors.New("task has no failed urls")
+	// ErrUnknownURL is returned when a URL to retry is not one of the task's URLs.
+	ErrUnknownURL = errors.New("url does not belong to the task")
+)
+
+// RetryTask queues a finished task again for urls, or for its failed URLs when urls is
+// empty. Results of the other URLs are kept. Failed downloads resume from their partial
+// files; URLs that had succeeded are downloaded from scratch. The previous outcome of
+// every retried URL is added to its attempt history. The returned task is pending; it
+// moves to in progress when a worker picks it up.
+func (s *TaskService) RetryTask(id string, urls []string) (*domain.Task, error) {
+	task, err := s.taskStorage.Get(id)
+	if err != nil {
+		return nil, ErrTaskNotFound
+	}
+	if _, busy := s.processing.Load(id); busy || !task.Status.IsTerminal() {
+		return nil, ErrTaskNotFinished
+	}
+
+	if len(urls) == 0 {
+		urls = failedURLs(task)
+		if len(urls) == 0 {
+			return nil, ErrNothingToRetry
+		}
+	}
+	for _, url := range urls {
+		if !slices.Contains(task.URLs, url) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownURL, url)
+		}
+	}
+	urls = slices.Compact(slices.Sorted(slices.Values(urls)))
+
+	if _, retrying := s.retrying.LoadOrStore(id, struct{}{}); retrying {
+		return nil, ErrTaskNotFinished
+	}
+	if !s.queue.reserve() {
+		s.retrying.Delete(id)
+		return nil, ErrQueueFull
+	}
+
+	select {
+	case <-s.shutdownChan:
+		s.queue.unreserve()
+		s.retrying.Delete(id)
+		return nil, ErrShuttingDown
+	default:
+	}
+
+	select {
+	case s.eventChan <- domain.TaskEvent{
+		Type:    domain.EventRetryTask,
+		TaskID:  id,
+		Updates: &domain.TaskUpdate{Retry: urls},
+	}:
+	case <-s.shutdownChan:
+		s.queue.unreserve()
+		s.retrying.Delete(id)
+		return nil, ErrShuttingDown
+	}
+
+	s.logger.Info("task retry requested", "task_id", id, "urls_count", len(urls))
+	prepareRetry(task, urls, time.Now())
+	return task, nil
+}
+
+// failedURLs returns the task URLs without a successful result.
+func failedURLs(task *domain.Task) []string {
+	var failed []string
+	for _, url := range task.URLs {
+		if !slices.ContainsFunc(task.Results, func(r domain.DownloadResult) bool {
+			return r.URL == url && r.Success
+		}) {
+			failed = append(failed, url)
+		}
+	}
+	return failed
+}
+
+// prepareRetry turns task back into a pending task that downloads urls again. The
+// results of urls are replaced by ones carrying the attempt history. It returns the
+// files of the retried URLs that had been downloaded successfully; they have to be
+// removed so that they are downloaded afresh rather than resumed.
+func prepareRetry(task *domain.Task, urls []string, now time.Time) []string {
+	var stale []string
+	results := slices.Clone(task.Results)
+	for _, url := range urls {
+		retried := domain.DownloadResult{URL: url, State: domain.URLQueued}
+		i := slices.IndexFunc(results, func(r domain.DownloadResult) bool { return r.URL == url })
+		if i < 0 {
+			results = append(results, retried)
+			continue
+		}
+		previous := results[i]
+		retried.Attempts = append(slices.Clone(previous.Attempts), domain.DownloadAttempt{
+			Success:    previous.Success,
+			Error:      previous.Error,
+			BytesRead:  previous.BytesRead,
+			FinishedAt: task.UpdatedAt,
+		})
+		if previous.Success && previous.FileName != "" {
+			stale = append(stale, previous.FileName)
+		}
+		results[i] = retried
+	}
+
+	task.Results = results
+	task.Retry = urls
+	task.Status = domain.StatusPending
+	task.UpdatedAt = now
+	task.EnqueuedAt = now
+	if task.Webhook != nil {
+		hook := *task.Webhook
+		hook.Delivered = false
+		task.Webhook = &hook
+	}
+	return stale
+}
+
+// applyRetry runs in the event processor. It re-checks that the task is still finished,
+// removes the files that have to be downloaded afresh and queues the task.
+func (s *TaskService) applyRetry(ctx context.Context, event domain.TaskEvent) {
+	defer s.retrying.Delete(event.TaskID)
+
+	task, err := s.taskStorage.Get(event.TaskID)
+	if err != nil || !task.Status.IsTerminal() {
+		s.queue.unreserve()
+		s.logger.Warn("task retry dropped, task changed", "task_id", event.TaskID)
+		return
+	}
+
+	for _, name := range prepareRetry(task, event.Updates.Retry, time.Now()) {
+		// Stored files may be hard links to shared blobs, so they are unlinked
+		// rather than truncated.
+		if err := os.RemoveAll(s.fileStorage.Path(name)); err != nil {
+			s.logger.Warn("failed to remove file before retry",
+				"error", err,
+				"task_id", task.ID,
+				"file", name,
+			)
+		}
+	}
+
+	if err := s.taskStorage.Save(ctx, task); err != nil {
+		s.queue.unreserve()
+		s.logger.Error("failed to save task retry",
+			"error", err,
+			"task_id", task.ID,
+		)
+		return
+	}
+	s.logger.Info("task queued for retry", "task_id", task.ID, "urls", task.Retry)
+	s.statusChanged(domain.EventRetryTask, task)
+	s.queue.push(task, true)
+}
+
+// mergeResults merges the results of a run into the task's earlier results by URL,
+// keeping the attempt history of each URL and the results of URLs the run did not cover.
+func mergeResults(previous, retried []domain.DownloadResult) []domain.DownloadResult {
+	results := slices.Clone(previous)
+	for _, result := range retried {
+		results = mergeProgress(results, result)
+	}
+	return results
+}
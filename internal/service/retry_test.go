@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+	"github.com/veranemoloko/url-downloader/internal/worker"
+)
+
+func TestTaskService_RetryTask(t *testing.T) {
+	body := strings.Repeat("0123456789", 400)
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		if r.URL.Path == "/flaky" {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+		mu.Unlock()
+
+		if r.URL.Path == "/flaky" && n == 1 {
+			// Once the other download is done, send most of the body and drop the connection.
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			io.WriteString(w, body[:3500])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	defer server.Close()
+	hitCount := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[path]
+	}
+
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+	logger := newTestLogger()
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger)
+	defer svc.Shutdown(context.Background())
+
+	okURL, flakyURL := server.URL+"/ok", server.URL+"/flaky"
+	task, err := svc.CreateTask(context.Background(), []string{okURL, flakyURL}, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+	waitForStatus := func(status domain.TaskStatus) *domain.Task {
+		t.Helper()
+		var current *domain.Task
+		waitFor(t, 5*time.Second, func() bool {
+			current, err = svc.GetTask(task.ID)
+			return err == nil && current.Status == status && len(current.Retry) == 0
+		})
+		return current
+	}
+	waitForStatus(domain.StatusFailed)
+
+	if _, err := svc.RetryTask("missing", nil); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+	if _, err := svc.RetryTask(task.ID, []string{server.URL + "/other"}); !errors.Is(err, ErrUnknownURL) {
+		t.Errorf("expected ErrUnknownURL, got %v", err)
+	}
+
+	retried, err := svc.RetryTask(task.ID, nil)
+	if err != nil {
+		t.Fatalf("RetryTask error: %v", err)
+	}
+	if retried.Status != domain.StatusPending || len(retried.Retry) != 1 || retried.Retry[0] != flakyURL {
+		t.Errorf("expected only the failed url to be retried, got %+v", retried)
+	}
+
+	done := waitForStatus(domain.StatusCompleted)
+	if hitCount("/ok") != 1 {
+		t.Errorf("expected the successful url not to be downloaded again, got %d requests", hitCount("/ok"))
+	}
+	mu.Lock()
+	if len(ranges) != 2 || ranges[1] != "bytes=3500-" {
+		t.Errorf("expected the retry to resume the partial file, got ranges %q", ranges)
+	}
+	mu.Unlock()
+
+	for _, result := range done.Results {
+		if !result.Success {
+			t.Errorf("expected %s to succeed, got %q", result.URL, result.Error)
+		}
+		switch result.URL {
+		case okURL:
+			if len(result.Attempts) != 0 {
+				t.Errorf("expected no attempt history for %s", result.URL)
+			}
+		case flakyURL:
+			if len(result.Attempts) != 1 || result.Attempts[0].Success || result.Attempts[0].Error == "" {
+				t.Errorf("expected one failed attempt for %s, got %+v", result.URL, result.Attempts)
+			}
+			data, err := os.ReadFile(fileStorage.Path(result.FileName))
+			if err != nil || string(data) != body {
+				t.Errorf("expected the resumed file to be complete, got %d bytes (%v)", len(data), err)
+			}
+		}
+	}
+
+	if _, err := svc.RetryTask(task.ID, nil); !errors.Is(err, ErrNothingToRetry) {
+		t.Errorf("expected ErrNothingToRetry for a completed task, got %v", err)
+	}
+	if _, err := svc.RetryTask(task.ID, []string{okURL}); err != nil {
+		t.Fatalf("RetryTask error: %v", err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return hitCount("/ok") == 2 })
+	waitForStatus(domain.StatusCompleted)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/worker"
+)
+
+// State is a diagnostic snapshot of the service served on /debug/state.
+type State struct {
+	Goroutines      int                     `json:"goroutines"`
+	Processor       ProcessorState          `json:"event_processor"`
+	Queue           QueueState              `json:"queue"`
+	TaskQueue       QueueState              `json:"task_queue"`
+	Tasks           []TaskState             `json:"tasks"`
+	ActiveDownloads []worker.ActiveDownload `json:"active_downloads"`
+}
+
+// ProcessorState describes the event processor goroutine.
+type ProcessorState struct {
+	Running   bool       `json:"running"`
+	BusySince *time.Time `json:"busy_since,omitempty"`
+}
+
+// QueueState describes the occupancy of the task event queue or of the queue of
+// tasks waiting for a worker.
+type QueueState struct {
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+}
+
+// TaskState describes a task being processed. Downloads counts the goroutines
+// currently downloading one of its URLs.
+type TaskState struct {
+	TaskID    string    `json:"task_id"`
+	StartedAt time.Time `json:"started_at"`
+	Downloads int       `json:"downloads"`
+}
+
+// State returns a snapshot of the event processor, the event queue, the tasks being
+// processed and their active downloads.
+func (s *TaskService) State() State {
+	downloads := s.worker.ActiveDownloads()
+	perTask := make(map[string]int)
+	for _, d := range downloads {
+		perTask[d.TaskID]++
+	}
+
+	var tasks []TaskState
+	s.processing.Range(func(key, value any) bool {
+		id := key.(string)
+		tasks = append(tasks, TaskState{TaskID: id, StartedAt: value.(time.Time), Downloads: perTask[id]})
+		return true
+	})
+	slices.SortFunc(tasks, func(a, b TaskState) int { return a.StartedAt.Compare(b.StartedAt) })
+
+	processor := ProcessorState{Running: s.processorRunning.Load()}
+	if since := s.busySince.Load(); since != 0 {
+		t := time.Unix(0, since)
+		processor.BusySince = &t
+	}
+
+	queued, queueCapacity := s.queue.len()
+	return State{
+		Goroutines:      runtime.NumGoroutine(),
+		Processor:       processor,
+		Queue:           QueueState{Length: len(s.eventChan), Capacity: cap(s.eventChan)},
+		TaskQueue:       QueueState{Length: queued, Capacity: queueCapacity},
+		Tasks:           tasks,
+		ActiveDownloads: downloads,
+	}
+}
+
+// CheckProcessor reports an error when the event processor has exited or has been
+// handling a single event for longer than stallTimeout.
+func (s *TaskService) CheckProcessor(stallTimeout time.Duration) func(context.Context) error {
+	return func(context.Context) error {
+		if !s.processorRunning.Load() {
+			return errors.New("event processor is not running")
+		}
+		if since := s.busySince.Load(); since != 0 {
+			if busy := time.Since(time.Unix(0, since)); busy > stallTimeout {
+				return fmt.Errorf("event processor stuck on one event for %s", busy.Round(time.Second))
+			}
+		}
+		return nil
+	}
+}
+
+// CheckQueue reports an error when the event queue or the task queue is at least
+// maxUsage full, or the service is shutting down.
+func (s *TaskService) CheckQueue(maxUsage float64) func(context.Context) error {
+	return func(context.Context) error {
+		select {
+		case <-s.shutdownChan:
+			return errors.New("service is shutting down")
+		default:
+		}
+		if length, capacity := len(s.eventChan), cap(s.eventChan); float64(length) >= maxUsage*float64(capacity) {
+			return fmt.Errorf("event queue saturated: %d of %d", length, capacity)
+		}
+		if length, capacity := s.queue.len(); float64(length) >= maxUsage*float64(capacity) {
+			return fmt.Errorf("task queue saturated: %d of %d", length, capacity)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+// Defaults used when WithQueue is not given or leaves a field zero.
+const (
+	DefaultQueueCapacity = 1000
+	DefaultQueueWorkers  = 5
+	DefaultQueueAging    = time.Minute
+)
+
+var (
+	// ErrQueueFull is returned by CreateTask when the queue holds as many tasks as it may.
+	ErrQueueFull = errors.New("task queue is full")
+	// ErrShuttingDown is returned when a task cannot be accepted because the service is stopping.
+	ErrShuttingDown = errors.New("service is shutting down")
+	// ErrTaskNotFound is returned for operations on a task that does not exist.
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskNotQueued is returned when reprioritizing a task that is no longer waiting to run.
+	ErrTaskNotQueued = errors.New("task is not queued")
+)
+
+// QueueConfig configures how pending tasks are scheduled.
+type QueueConfig struct {
+	// Capacity is the number of tasks that may wait to run before CreateTask
+	// fails with ErrQueueFull.
+	Capacity int
+	// Workers is the number of tasks processed at the same time.
+	Workers int
+	// Aging raises the priority of a waiting task by one level per interval,
+	// so that low-priority tasks are not starved by a stream of urgent ones.
+	Aging time.Duration
+}
+
+// WithQueue configures the task queue. Zero fields keep their defaults.
+func WithQueue(cfg QueueConfig) Option {
+	return func(s *TaskService) {
+		if cfg.Capacity > 0 {
+			s.queue.capacity = cfg.Capacity
+		}
+		if cfg.Workers > 0 {
+			s.queue.workers = cfg.Workers
+		}
+		if cfg.Aging > 0 {
+			s.queue.aging = cfg.Aging
+		}
+	}
+}
+
+// queuedTask is a pending task waiting for a worker. See taskQueue.rank for rank.
+type queuedTask struct {
+	id       string
+	priority int
+	rank     time.Time
+	index    int
+}
+
+// taskQueue orders pending tasks by priority with aging. Its contents are not stored
+// separately: pending tasks are persisted with their priority, and the queue is rebuilt
+// from storage on startup.
+type taskQueue struct {
+	mu       sync.Mutex
+	items    queueHeap
+	byID     map[string]*queuedTask
+	reserved int
+	ready    chan struct{}
+
+	capacity int
+	workers  int
+	aging    time.Duration
+}
+
+func newTaskQueue() *taskQueue {
+	return &taskQueue{
+		byID:     make(map[string]*queuedTask),
+		ready:    make(chan struct{}, 1),
+		capacity: DefaultQueueCapacity,
+		workers:  DefaultQueueWorkers,
+		aging:    DefaultQueueAging,
+	}
+}
+
+// reserve claims room for a task that is about to be created. Every successful
+// reservation has to be followed by push or unreserve.
+func (q *taskQueue) reserve() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items)+q.reserved >= q.capacity {
+		return false
+	}
+	q.reserved++
+	return true
+}
+
+func (q *taskQueue) unreserve() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reserved--
+}
+
+// push queues task, ranked by its EnqueuedAt (or CreatedAt for tasks stored without it).
+// reserved says whether it takes up a reservation; tasks restored after a restart are
+// queued regardless of capacity.
+func (q *taskQueue) push(task *domain.Task, reserved bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if reserved {
+		q.reserved--
+	}
+	if _, ok := q.byID[task.ID]; ok {
+		return
+	}
+	enqueuedAt := task.EnqueuedAt
+	if enqueuedAt.IsZero() {
+		enqueuedAt = task.CreatedAt
+	}
+	item := &queuedTask{id: task.ID, priority: task.Options.Priority}
+	item.rank = q.rank(enqueuedAt, item.priority)
+	heap.Push(&q.items, item)
+	q.byID[task.ID] = item
+	q.signal()
+}
+
+// pop waits for the most urgent task and returns its ID. It returns false once done is closed.
+func (q *taskQueue) pop(done <-chan struct{}) (string, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			item := heap.Pop(&q.items).(*queuedTask)
+			delete(q.byID, item.id)
+			if len(q.items) > 0 {
+				q.signal()
+			}
+			q.mu.Unlock()
+			return item.id, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-done:
+			return "", false
+		}
+	}
+}
+
+// reprioritize changes the priority of a queued task. It reports false when the task
+// is not in the queue.
+func (q *taskQueue) reprioritize(id string, priority int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item, ok := q.byID[id]
+	if !ok {
+		return false
+	}
+	item.rank = item.rank.Add(time.Duration(item.priority-priority) * q.aging)
+	item.priority = priority
+	heap.Fix(&q.items, item.index)
+	return true
+}
+
+// rank returns the sort key of a task enqueued at enqueuedAt. Tasks are served in order
+// of enqueue time moved earlier by one aging interval per priority level. Because every
+// waiting task ages at the same rate, this equals ordering by priority plus time waited
+// divided by the aging interval, without ever re-sorting the queue.
+func (q *taskQueue) rank(enqueuedAt time.Time, priority int) time.Time {
+	return enqueuedAt.Add(-time.Duration(priority) * q.aging)
+}
+
+func (q *taskQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// len returns the number of tasks waiting and the queue capacity.
+func (q *taskQueue) len() (int, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items) + q.reserved, q.capacity
+}
+
+// queueHeap implements heap.Interface over queued tasks, earliest rank first.
+type queueHeap []*queuedTask
+
+func (h queueHeap) Len() int { return len(h) }
+
+func (h queueHeap) Less(i, j int) bool {
+	if !h[i].rank.Equal(h[j].rank) {
+		return h[i].rank.Before(h[j].rank)
+	}
+	return h[i].id < h[j].id
+}
+
+func (h queueHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *queueHeap) Push(x any) {
+	item := x.(*queuedTask)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *queueHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
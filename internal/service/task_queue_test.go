@@ -0,0 +1,306 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+	"github.com/veranemoloko/url-downloader/internal/worker"
+)
+
+func TestTaskQueue_PriorityAndAging(t *testing.T) {
+	q := newTaskQueue()
+	q.aging = time.Minute
+	now := time.Now()
+
+	push := func(id string, priority int, age time.Duration) {
+		q.push(&domain.Task{ID: id, CreatedAt: now.Add(-age), Options: domain.TaskOptions{Priority: priority}}, false)
+	}
+	// Waiting nine minutes outweighs five priority levels.
+	push("old-low", 0, 9*time.Minute)
+	push("new-high", 5, 0)
+	push("recent-low", 0, time.Minute)
+	push("new-low", 0, 0)
+
+	if !q.reprioritize("new-low", 10) {
+		t.Fatalf("expected queued task to be reprioritized")
+	}
+	if q.reprioritize("unknown", 1) {
+		t.Errorf("expected unknown task not to be reprioritized")
+	}
+
+	done := make(chan struct{})
+	var order []string
+	for range 4 {
+		id, ok := q.pop(done)
+		if !ok {
+			t.Fatalf("expected a queued task")
+		}
+		order = append(order, id)
+	}
+	want := []string{"new-low", "old-low", "new-high", "recent-low"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected order %v, got %v", want, order)
+		}
+	}
+
+	close(done)
+	if _, ok := q.pop(done); ok {
+		t.Errorf("expected pop to stop once done is closed")
+	}
+}
+
+func TestTaskQueue_RequeuedTaskAgesFromEnqueue(t *testing.T) {
+	q := newTaskQueue()
+	q.aging = time.Minute
+	now := time.Now()
+
+	// A task created an hour ago and retried just now has no aging credit left.
+	q.push(&domain.Task{ID: "retried", CreatedAt: now.Add(-time.Hour), EnqueuedAt: now}, false)
+	q.push(&domain.Task{ID: "new-high", CreatedAt: now, EnqueuedAt: now, Options: domain.TaskOptions{Priority: 5}}, false)
+
+	done := make(chan struct{})
+	defer close(done)
+	if id, _ := q.pop(done); id != "new-high" {
+		t.Fatalf("expected the higher priority task first, got %s", id)
+	}
+}
+
+func TestTaskQueue_Capacity(t *testing.T) {
+	q := newTaskQueue()
+	q.capacity = 2
+
+	if !q.reserve() || !q.reserve() {
+		t.Fatalf("expected room for two tasks")
+	}
+	if q.reserve() {
+		t.Fatalf("expected a full queue to refuse a reservation")
+	}
+	q.push(&domain.Task{ID: "a"}, true)
+	q.unreserve()
+	if n, capacity := q.len(); n != 1 || capacity != 2 {
+		t.Errorf("expected 1 of 2 queued, got %d of %d", n, capacity)
+	}
+	// Restored tasks are queued beyond capacity.
+	q.push(&domain.Task{ID: "b"}, false)
+	q.push(&domain.Task{ID: "c"}, false)
+	if n, _ := q.len(); n != 3 {
+		t.Errorf("expected restored tasks to be queued, got %d", n)
+	}
+}
+
+func TestTaskService_QueueOrderAndBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var served []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		served = append(served, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/first" {
+			<-release
+		}
+		io.WriteString(w, "data")
+	}))
+	defer server.Close()
+	servedPaths := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), served...)
+	}
+
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+	logger := newTestLogger()
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger,
+		WithQueue(QueueConfig{Capacity: 2, Workers: 1}))
+	defer svc.Shutdown(context.Background())
+	ctx := context.Background()
+
+	first, err := svc.CreateTask(ctx, []string{server.URL + "/first"}, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return len(servedPaths()) == 1 })
+
+	low, err := svc.CreateTask(ctx, []string{server.URL + "/low"}, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+	later, err := svc.CreateTask(ctx, []string{server.URL + "/later"}, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+	if _, err := svc.CreateTask(ctx, []string{server.URL + "/rejected"}, domain.TaskOptions{}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	waitFor(t, 2*time.Second, func() bool {
+		_, err := taskStorage.Get(later.ID)
+		return err == nil
+	})
+	updated, err := svc.SetPriority(later.ID, 10)
+	if err != nil {
+		t.Fatalf("SetPriority error: %v", err)
+	}
+	if updated.Options.Priority != 10 {
+		t.Errorf("expected returned task to carry the new priority, got %d", updated.Options.Priority)
+	}
+	if _, err := svc.SetPriority(first.ID, 10); !errors.Is(err, ErrTaskNotQueued) {
+		t.Errorf("expected ErrTaskNotQueued for a running task, got %v", err)
+	}
+	if _, err := svc.SetPriority("missing", 10); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		task, err := taskStorage.Get(later.ID)
+		return err == nil && task.Options.Priority == 10
+	})
+
+	close(release)
+	waitFor(t, 5*time.Second, func() bool {
+		task, err := svc.GetTask(low.ID)
+		return err == nil && task.Status == domain.StatusCompleted
+	})
+
+	got := servedPaths()
+	want := []string{"/first", "/later", "/low"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected requests in order %v, got %v", want, got)
+		}
+	}
+}
+
+func TestTaskService_QueueRestoredFromStorage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data")
+	}))
+	defer server.Close()
+
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	pending := &domain.Task{
+		ID:        generateID(),
+		URLs:      []string{server.URL},
+		Status:    domain.StatusPending,
+		Options:   domain.TaskOptions{Priority: 3},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := taskStorage.Save(context.Background(), pending); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+	logger := newTestLogger()
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger)
+	defer svc.Shutdown(context.Background())
+
+	waitFor(t, 2*time.Second, func() bool {
+		task, err := svc.GetTask(pending.ID)
+		return err == nil && task.Status == domain.StatusCompleted
+	})
+}
+
+func TestTaskService_CreateTaskNotRejectedByBusyProcessor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data")
+	}))
+	defer server.Close()
+
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+	logger := newTestLogger()
+	block := make(chan struct{})
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger,
+		WithStatusListener(func(domain.EventType, *domain.Task) { <-block }))
+	defer svc.Shutdown(context.Background())
+	release := sync.OnceFunc(func() { close(block) })
+	defer release()
+	ctx := context.Background()
+
+	// The listener stalls the event processor on the first task; progress and
+	// other events pile up behind it.
+	if _, err := svc.CreateTask(ctx, []string{server.URL + "/first"}, domain.TaskOptions{}); err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return svc.busySince.Load() != 0 })
+	for range 1000 {
+		svc.reportProgress("missing", domain.DownloadResult{URL: server.URL})
+	}
+	for len(svc.eventChan) < cap(svc.eventChan) {
+		svc.eventChan <- domain.TaskEvent{Type: domain.EventUpdateTask, TaskID: "missing", Updates: &domain.TaskUpdate{}}
+	}
+
+	type created struct {
+		task *domain.Task
+		err  error
+	}
+	done := make(chan created, 1)
+	go func() {
+		task, err := svc.CreateTask(ctx, []string{server.URL + "/second"}, domain.TaskOptions{})
+		done <- created{task, err}
+	}()
+	select {
+	case result := <-done:
+		t.Fatalf("expected CreateTask to wait for the event processor, got %v", result.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release()
+	result := <-done
+	if result.err != nil {
+		t.Fatalf("CreateTask error: %v", result.err)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		task, err := svc.GetTask(result.task.ID)
+		return err == nil && task.Status == domain.StatusCompleted
+	})
+}
+
+func TestTaskService_UnsavedTaskNotQueued(t *testing.T) {
+	dir := makeTempDir(t, "taskservice_tasks_*")
+	taskStorage, err := storage.NewTaskStorage(dir)
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+	logger := newTestLogger()
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger,
+		WithQueue(QueueConfig{Capacity: 1, Workers: 1}))
+	defer svc.Shutdown(context.Background())
+	ctx := context.Background()
+
+	for range 2 {
+		task, err := svc.CreateTask(ctx, []string{"http://example.invalid/file"}, domain.TaskOptions{})
+		if err != nil {
+			t.Fatalf("CreateTask error: %v", err)
+		}
+		waitFor(t, 2*time.Second, func() bool {
+			_, err := svc.GetTask(task.ID)
+			queued, _ := svc.queue.len()
+			return err != nil && queued == 0
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/validation"
+)
+
+var validate = validator.New()
+
+// CreateTaskRequest describes a task submission. It is the body of POST /tasks and of
+// messages on the queue intake subject.
+type CreateTaskRequest struct {
+	URLs             []string                             `json:"urls" validate:"required,min=1,max=100,unique,dive,required"`
+	AllowedMIMETypes []string                             `json:"allowed_mime_types,omitempty" validate:"omitempty,max=50,dive,required"`
+	Credentials      *domain.RequestCredentials           `json:"credentials,omitempty"`
+	URLCredentials   map[string]domain.RequestCredentials `json:"url_credentials,omitempty"`
+	Proxy            *ProxyRequest                        `json:"proxy,omitempty"`
+	SeedRatio        *float64                             `json:"seed_ratio,omitempty" validate:"omitempty,gte=0,lte=100"`
+	Stream           *StreamRequest                       `json:"stream,omitempty"`
+	Mirrors          map[string][]string                  `json:"mirrors,omitempty" validate:"omitempty,dive,min=1,max=20"`
+	SplitMirrors     bool                                 `json:"split_mirrors,omitempty"`
+	Extract          bool                                 `json:"extract,omitempty"`
+	Encoding         string                               `json:"encoding,omitempty" validate:"omitempty,oneof=decoded encoded"`
+	Crawl            *CrawlRequest                        `json:"crawl,omitempty"`
+	CallbackURL      string                               `json:"callback_url,omitempty" validate:"omitempty,max=2048"`
+	Priority         int                                  `json:"priority,omitempty" validate:"gte=-10,lte=10"`
+}
+
+// CrawlRequest turns the task URLs into index pages whose linked files are downloaded.
+type CrawlRequest struct {
+	Depth         int      `json:"depth,omitempty" validate:"gte=0,lte=10"`
+	Include       []string `json:"include,omitempty" validate:"omitempty,max=20"`
+	Exclude       []string `json:"exclude,omitempty" validate:"omitempty,max=20"`
+	AllowExternal bool     `json:"allow_external,omitempty"`
+	MaxFiles      int      `json:"max_files,omitempty" validate:"gte=0,lte=10000"`
+}
+
+// StreamRequest selects the variant downloaded from HLS and DASH manifests.
+type StreamRequest struct {
+	Variant      string `json:"variant,omitempty" validate:"omitempty,oneof=best worst"`
+	MaxBandwidth int64  `json:"max_bandwidth,omitempty" validate:"gte=0"`
+}
+
+// ProxyRequest selects an outbound proxy for a task. Credentials may be given
+// separately or embedded in the URL.
+type ProxyRequest struct {
+	URL      string   `json:"url" validate:"required"`
+	NoProxy  []string `json:"no_proxy,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// TaskResponse renders a task without its options, so that credentials are never returned.
+type TaskResponse struct {
+	ID                string                   `json:"id"`
+	URLs              []string                 `json:"urls"`
+	Status            domain.TaskStatus        `json:"status"`
+	Priority          int                      `json:"priority"`
+	Retry             []string                 `json:"retry,omitempty"`
+	Results           []domain.DownloadResult  `json:"results,omitempty"`
+	WebhookDeliveries []domain.WebhookDelivery `json:"webhook_deliveries,omitempty"`
+	CreatedAt         string                   `json:"created_at"`
+	UpdatedAt         string                   `json:"updated_at"`
+}
+
+// NewTaskResponse renders a task for API responses and webhook payloads.
+func NewTaskResponse(task *domain.Task) TaskResponse {
+	response := TaskResponse{
+		ID:        task.ID,
+		URLs:      task.URLs,
+		Status:    task.Status,
+		Priority:  task.Options.Priority,
+		Retry:     task.Retry,
+		Results:   task.Results,
+		CreatedAt: task.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: task.UpdatedAt.Format(time.RFC3339),
+	}
+	if task.Webhook != nil {
+		response.WebhookDeliveries = task.Webhook.Deliveries
+	}
+	return response
+}
+
+// IdempotencyKeyHeader names the header that makes task creation safe to retry.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Fingerprint identifies the content of the request independently of JSON formatting
+// and field order, for comparing retries sent with the same idempotency key.
+func (req *CreateTaskRequest) Fingerprint() string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// TaskOptions validates the request and converts it into task options. It is shared by
+// the HTTP handler and the message queue intake so that both accept the same submissions.
+func (req *CreateTaskRequest) TaskOptions() (domain.TaskOptions, error) {
+	if err := validate.Struct(req); err != nil {
+		return domain.TaskOptions{}, err
+	}
+
+	if err := validation.ValidateURLs(req.URLs); err != nil {
+		return domain.TaskOptions{}, err
+	}
+
+	if err := validateCredentials(*req); err != nil {
+		return domain.TaskOptions{}, err
+	}
+
+	if err := validateMirrors(*req); err != nil {
+		return domain.TaskOptions{}, err
+	}
+
+	if err := validateCrawl(req.Crawl); err != nil {
+		return domain.TaskOptions{}, err
+	}
+
+	if req.CallbackURL != "" {
+		if err := validation.ValidateCallbackURL(req.CallbackURL); err != nil {
+			return domain.TaskOptions{}, err
+		}
+	}
+
+	opts := domain.TaskOptions{
+		AllowedMIMETypes: req.AllowedMIMETypes,
+		Credentials:      req.Credentials,
+		URLCredentials:   req.URLCredentials,
+		SeedRatio:        req.SeedRatio,
+		Mirrors:          req.Mirrors,
+		SplitMirrors:     req.SplitMirrors,
+		Extract:          req.Extract,
+		Encoding:         domain.EncodingMode(req.Encoding),
+		CallbackURL:      req.CallbackURL,
+		Priority:         req.Priority,
+	}
+
+	if req.Stream != nil {
+		opts.Stream = &domain.StreamOptions{
+			Variant:      domain.StreamVariant(req.Stream.Variant),
+			MaxBandwidth: req.Stream.MaxBandwidth,
+		}
+	}
+
+	if req.Crawl != nil {
+		opts.Crawl = &domain.CrawlOptions{
+			Depth:         req.Crawl.Depth,
+			Include:       req.Crawl.Include,
+			Exclude:       req.Crawl.Exclude,
+			AllowExternal: req.Crawl.AllowExternal,
+			MaxFiles:      req.Crawl.MaxFiles,
+		}
+	}
+
+	if req.Proxy != nil {
+		proxy, err := proxyOptions(req.Proxy)
+		if err != nil {
+			return domain.TaskOptions{}, err
+		}
+		opts.Proxy = proxy
+	}
+
+	return opts, nil
+}
+
+// validateCredentials checks that per-URL credentials refer to submitted URLs
+// and that headers and cookies are well-formed.
+func validateCredentials(req CreateTaskRequest) error {
+	if req.Credentials != nil {
+		if err := validateRequestCredentials(*req.Credentials); err != nil {
+			return err
+		}
+	}
+
+	for u, creds := range req.URLCredentials {
+		if !slices.Contains(req.URLs, u) {
+			return fmt.Errorf("credentials given for unknown URL %q", u)
+		}
+		if err := validateRequestCredentials(creds); err != nil {
+			return fmt.Errorf("credentials for %q: %w", u, err)
+		}
+	}
+
+	return nil
+}
+
+// validateMirrors checks that mirrors are given for submitted URLs and are themselves safe URLs.
+func validateMirrors(req CreateTaskRequest) error {
+	for u, mirrors := range req.Mirrors {
+		if !slices.Contains(req.URLs, u) {
+			return fmt.Errorf("mirrors given for unknown URL %q", u)
+		}
+		if err := validation.ValidateURLs(mirrors); err != nil {
+			return fmt.Errorf("mirrors for %q: %w", u, err)
+		}
+	}
+	return nil
+}
+
+// validateCrawl checks that crawl include and exclude patterns are valid regular expressions.
+func validateCrawl(crawl *CrawlRequest) error {
+	if crawl == nil {
+		return nil
+	}
+	for _, pattern := range append(slices.Clone(crawl.Include), crawl.Exclude...) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid crawl pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func validateRequestCredentials(creds domain.RequestCredentials) error {
+	for name, value := range creds.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.EqualFold(name, "Range") || strings.EqualFold(name, "Host") {
+			return fmt.Errorf("header %q cannot be overridden", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid value for header %q", name)
+		}
+	}
+	for name, value := range creds.Cookies {
+		if name == "" || strings.ContainsAny(name, " \t\r\n;=") || strings.ContainsAny(value, "\r\n;") {
+			return fmt.Errorf("invalid cookie %q", name)
+		}
+	}
+	if creds.BasicAuth != nil && creds.BearerToken != "" {
+		return fmt.Errorf("basic_auth and bearer_token are mutually exclusive")
+	}
+	return nil
+}
+
+// proxyOptions validates a proxy request and moves any credentials out of the URL
+// so that they are stored encrypted.
+func proxyOptions(req *ProxyRequest) (*domain.ProxyOptions, error) {
+	if err := validation.ValidateProxyURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	proxy := &domain.ProxyOptions{
+		NoProxy:  req.NoProxy,
+		Username: req.Username,
+		Password: req.Password,
+	}
+
+	if u.User != nil {
+		if proxy.Username == "" {
+			proxy.Username = u.User.Username()
+			proxy.Password, _ = u.User.Password()
+		}
+		u.User = nil
+	}
+	proxy.URL = u.String()
+
+	return proxy, nil
+}
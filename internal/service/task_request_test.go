@@ -0,0 +1,27 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCreateTaskRequest_Fingerprint(t *testing.T) {
+	decode := func(body string) *CreateTaskRequest {
+		var req CreateTaskRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatalf("Unmarshal error: %v", err)
+		}
+		return &req
+	}
+
+	a := decode(`{"urls":["http://example.com/a"],"extract":true,"mirrors":{"http://example.com/a":["http://m1.example.com/a"]}}`)
+	b := decode(`{ "extract": true, "mirrors": {"http://example.com/a": ["http://m1.example.com/a"]}, "urls": ["http://example.com/a"] }`)
+	c := decode(`{"urls":["http://example.com/a"]}`)
+
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Error("expected equal requests to have the same fingerprint")
+	}
+	if a.Fingerprint() == c.Fingerprint() {
+		t.Error("expected different requests to have different fingerprints")
+	}
+}
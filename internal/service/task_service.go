@@ -2,21 +2,40 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/metrics"
 	"github.com/veranemoloko/url-downloader/internal/storage"
+	"github.com/veranemoloko/url-downloader/internal/tracing"
+	"github.com/veranemoloko/url-downloader/internal/webhook"
 	"github.com/veranemoloko/url-downloader/internal/worker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/veranemoloko/url-downloader/internal/service")
+
+// ErrCredentialsNotSupported is returned when a task carries credentials
+// but the storage cannot encrypt them at rest.
+var ErrCredentialsNotSupported = errors.New("task credentials require an encryption key")
+
 // TaskServiceInterface defines the public methods for managing tasks.
 type TaskServiceInterface interface {
-	CreateTask(urls []string) (*domain.Task, error)
+	CreateTask(ctx context.Context, urls []string, opts domain.TaskOptions) (*domain.Task, error)
 	GetTask(id string) (*domain.Task, error)
+	SetPriority(id string, priority int) (*domain.Task, error)
+	RetryTask(id string, urls []string) (*domain.Task, error)
+	AddURLs(id string, urls []string) (*domain.Task, error)
 }
 
 type TaskService struct {
@@ -24,9 +43,56 @@ type TaskService struct {
 	fileStorage  *storage.FileStorage
 	worker       *worker.DownloadWorker
 	eventChan    chan domain.TaskEvent
+	progressChan chan domain.TaskEvent
+	queue        *taskQueue
+	webhooks     *webhook.Notifier
+	listeners    []StatusListener
+	idempotency  idempotencyIndex
+	retention    RetentionPolicy
 	logger       *slog.Logger
 	wg           sync.WaitGroup
 	shutdownChan chan struct{}
+
+	// processorRunning and busySince let the health checks tell a stuck
+	// event processor from an idle one.
+	processorRunning atomic.Bool
+	busySince        atomic.Int64
+	processing       sync.Map // task ID -> time.Time processing started
+	retrying         sync.Map // task ID -> struct{} while a retry event is pending
+
+	// sweepMu serializes retention sweeps; lastRead feeds their LRU eviction.
+	sweepMu  sync.Mutex
+	lastRead sync.Map // task ID -> time.Time of the last GetTask
+}
+
+// Option configures optional TaskService behaviour.
+type Option func(*TaskService)
+
+// WithMetrics exports task counts by status and the event queue depth.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *TaskService) {
+		m.WatchTasks(s.taskStorage.CountByStatus, func() int { return len(s.eventChan) })
+	}
+}
+
+// WithWebhooks notifies the callback URL of a task when it finishes.
+func WithWebhooks(n *webhook.Notifier) Option {
+	return func(s *TaskService) {
+		s.webhooks = n
+	}
+}
+
+// StatusListener is told about every task status change, including creation, along with
+// the event that caused it: EventCreateTask for a new task, EventRetryTask or EventAddURLs
+// for a finished task queued again, EventUpdateTask otherwise. It is called from the event
+// processor after the task has been saved and must not block.
+type StatusListener func(event domain.EventType, task *domain.Task)
+
+// WithStatusListener registers l to be called on task status changes.
+func WithStatusListener(l StatusListener) Option {
+	return func(s *TaskService) {
+		s.listeners = append(s.listeners, l)
+	}
 }
 
 // NewTaskService creates and returns a new TaskService instance with the provided storages, worker, and logger.
@@ -36,30 +102,115 @@ func NewTaskService(
 	fileStorage *storage.FileStorage,
 	worker *worker.DownloadWorker,
 	logger *slog.Logger,
+	opts ...Option,
 ) *TaskService {
 	service := &TaskService{
 		taskStorage:  taskStorage,
 		fileStorage:  fileStorage,
 		worker:       worker,
 		eventChan:    make(chan domain.TaskEvent, 100),
+		progressChan: make(chan domain.TaskEvent, 100),
+		queue:        newTaskQueue(),
 		logger:       logger,
 		shutdownChan: make(chan struct{}),
 	}
+	service.idempotency.ttl = DefaultIdempotencyTTL
+
+	for _, opt := range opts {
+		opt(service)
+	}
+
+	tasks := taskStorage.GetAll()
+	service.idempotency.load(tasks)
+	for _, task := range tasks {
+		if task.Status == domain.StatusPending {
+			service.queue.push(task, false)
+		}
+	}
+
+	worker.SetProgressHandler(service.reportProgress)
 
 	service.wg.Add(1)
+	service.processorRunning.Store(true)
 	go service.eventProcessor()
 
+	for range service.queue.workers {
+		service.wg.Add(1)
+		go service.dispatcher()
+	}
+
+	if service.retention.Interval > 0 {
+		service.wg.Add(1)
+		go service.janitor(service.retention.Interval)
+	}
+
 	return service
 }
 
 // CreateTask creates a new task, triggers a creation event, and returns the created task.
-func (s *TaskService) CreateTask(urls []string) (*domain.Task, error) {
+// The task is queued by priority and started when a worker is free. CreateTask never waits
+// for room in the queue: it fails with ErrQueueFull when the queue is at capacity.
+// It may wait briefly for the event processor, which saves the task before queueing it.
+// The trace context of ctx is stored with the task so that its processing joins the trace.
+// When ctx carries an idempotency key (see WithIdempotencyKey) that is still live, the task
+// created with it is returned instead.
+func (s *TaskService) CreateTask(ctx context.Context, urls []string, opts domain.TaskOptions) (*domain.Task, error) {
+	if opts.HasCredentials() && !s.taskStorage.CanStoreCredentials() {
+		return nil, ErrCredentialsNotSupported
+	}
+	if url, ok := duplicateURL(urls); ok {
+		return nil, fmt.Errorf("%w: %s", ErrDuplicateURL, url)
+	}
+
+	now := time.Now()
 	task := &domain.Task{
-		ID:        generateID(),
-		URLs:      urls,
-		Status:    domain.StatusPending,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:         generateID(),
+		URLs:       urls,
+		Status:     domain.StatusPending,
+		Options:    opts,
+		Results:    domain.QueuedResults(urls),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		EnqueuedAt: now,
+
+		TraceContext: tracing.Inject(ctx),
+	}
+	if s.webhooks != nil {
+		if callbackURL := s.webhooks.URL(opts.CallbackURL); callbackURL != "" {
+			task.Webhook = &domain.Webhook{URL: callbackURL}
+		}
+	}
+
+	key, idempotent := idempotencyKeyFrom(ctx)
+	if idempotent {
+		existing, err := s.idempotency.reserve(key, task)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			s.logger.Info("idempotent task request replayed", "task_id", existing.ID)
+			if current, err := s.taskStorage.Get(existing.ID); err == nil {
+				return current, nil
+			}
+			return existing, nil
+		}
+	}
+
+	if !s.queue.reserve() {
+		if idempotent {
+			s.idempotency.release(key.key, task)
+		}
+		return nil, ErrQueueFull
+	}
+
+	select {
+	case <-s.shutdownChan:
+		s.queue.unreserve()
+		if idempotent {
+			s.idempotency.release(key.key, task)
+		}
+		return nil, ErrShuttingDown
+	default:
 	}
 
 	select {
@@ -71,21 +222,115 @@ func (s *TaskService) CreateTask(urls []string) (*domain.Task, error) {
 		s.logger.Info("task created",
 			"task_id", task.ID,
 			"urls_count", len(urls),
+			"priority", opts.Priority,
 		)
 		return task, nil
 	case <-s.shutdownChan:
-		return nil, fmt.Errorf("service is shutting down")
+		s.queue.unreserve()
+		if idempotent {
+			s.idempotency.release(key.key, task)
+		}
+		return nil, ErrShuttingDown
+	}
+}
+
+// SetPriority changes the priority of a task that is still waiting in the queue.
+func (s *TaskService) SetPriority(id string, priority int) (*domain.Task, error) {
+	task, err := s.taskStorage.Get(id)
+	if err != nil {
+		return nil, ErrTaskNotFound
+	}
+	if !s.queue.reprioritize(id, priority) {
+		return nil, ErrTaskNotQueued
+	}
+
+	select {
+	case s.eventChan <- domain.TaskEvent{
+		Type:    domain.EventUpdateTask,
+		TaskID:  id,
+		Updates: &domain.TaskUpdate{Priority: &priority},
+	}:
+	case <-s.shutdownChan:
+		return nil, ErrShuttingDown
+	}
+
+	s.logger.Info("task reprioritized", "task_id", id, "priority", priority)
+	task.Options.Priority = priority
+	return task, nil
+}
+
+// Requeue queues a pending task again, typically one interrupted by a restart.
+// Requeued tasks are accepted even when the queue is at capacity.
+func (s *TaskService) Requeue(task *domain.Task) {
+	s.queue.push(task, false)
+}
+
+// dispatcher runs queued tasks one at a time until the service shuts down.
+// NewTaskService starts one dispatcher per queue worker.
+func (s *TaskService) dispatcher() {
+	defer s.wg.Done()
+
+	for {
+		id, ok := s.queue.pop(s.shutdownChan)
+		if !ok {
+			return
+		}
+		task, err := s.taskStorage.Get(id)
+		if err != nil || task.Status != domain.StatusPending {
+			continue
+		}
+		if err := s.ProcessTask(context.Background(), task); err != nil {
+			s.logger.Error("failed to process task",
+				"error", err,
+				"task_id", task.ID,
+			)
+		}
+	}
+}
+
+// reportProgress records an intermediate download result. Progress updates are best-effort:
+// they have a queue of their own and are dropped when it is full so that they never slow
+// down downloads nor take the room of task events.
+func (s *TaskService) reportProgress(taskID string, result domain.DownloadResult) {
+	select {
+	case s.progressChan <- domain.TaskEvent{
+		Type:    domain.EventUpdateTask,
+		TaskID:  taskID,
+		Updates: &domain.TaskUpdate{Progress: &result},
+	}:
+	case <-s.shutdownChan:
+	default:
 	}
 }
 
 // GetTask retrieves a task by its ID from the storage.
 func (s *TaskService) GetTask(id string) (*domain.Task, error) {
-	return s.taskStorage.Get(id)
+	task, err := s.taskStorage.Get(id)
+	if err == nil {
+		s.lastRead.Store(id, time.Now())
+	}
+	return task, err
 }
 
 // ProcessTask processes a task: updates its status, downloads URLs using the worker,
 // and updates the task results and status accordingly.
-func (s *TaskService) ProcessTask(ctx context.Context, task *domain.Task) error {
+// A retried task only downloads the URLs in Retry and keeps the other results.
+func (s *TaskService) ProcessTask(ctx context.Context, task *domain.Task) (err error) {
+	ctx, span := tracer.Start(tracing.Extract(ctx, task.TraceContext), "TaskService.ProcessTask", trace.WithAttributes(
+		attribute.String("task.id", task.ID),
+		attribute.Int("task.urls", len(task.URLs)),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	s.processing.Store(task.ID, time.Now())
+	defer s.processing.Delete(task.ID)
+
 	s.logger.Info("start processing task",
 		"task_id", task.ID,
 		"urls_count", len(task.URLs),
@@ -119,7 +364,16 @@ func (s *TaskService) ProcessTask(ctx context.Context, task *domain.Task) error
 		}
 	}()
 
-	results, err := s.worker.DownloadTask(downloadCtx, task)
+	target := task
+	if len(task.Retry) > 0 {
+		retry := *task
+		retry.URLs = task.Retry
+		target = &retry
+	}
+	results, err := s.worker.DownloadTask(downloadCtx, target)
+	if len(task.Retry) > 0 {
+		results = mergeResults(task.Results, results)
+	}
 
 	select {
 	case <-s.shutdownChan:
@@ -187,6 +441,7 @@ func (s *TaskService) ProcessTask(ctx context.Context, task *domain.Task) error
 
 func (s *TaskService) eventProcessor() {
 	defer s.wg.Done()
+	defer s.processorRunning.Store(false)
 
 	for {
 		select {
@@ -195,61 +450,14 @@ func (s *TaskService) eventProcessor() {
 				return
 			}
 
-			switch event.Type {
-			case domain.EventCreateTask:
-				if err := s.taskStorage.Save(event.Task); err != nil {
-					s.logger.Error("failed to save task",
-						"error", err,
-						"task_id", event.TaskID,
-					)
-				} else {
-					s.logger.Debug("task saved to storage",
-						"task_id", event.TaskID,
-					)
-				}
+			s.busySince.Store(time.Now().UnixNano())
+			s.handleEvent(event)
+			s.busySince.Store(0)
 
-				s.wg.Add(1)
-				go func(task *domain.Task) {
-					defer s.wg.Done()
-					if err := s.ProcessTask(context.Background(), task); err != nil {
-						s.logger.Error("failed to process task",
-							"error", err,
-							"task_id", task.ID,
-						)
-					}
-				}(event.Task)
-
-			case domain.EventUpdateTask:
-				task, err := s.taskStorage.Get(event.TaskID)
-				if err != nil {
-					s.logger.Error("failed to get task for update",
-						"error", err,
-						"task_id", event.TaskID,
-					)
-					continue
-				}
-
-				if event.Updates.Status != nil {
-					task.Status = *event.Updates.Status
-				}
-				if event.Updates.Results != nil {
-					task.Results = event.Updates.Results
-				}
-				task.UpdatedAt = time.Now()
-
-				if err := s.taskStorage.Save(task); err != nil {
-					s.logger.Error("failed to save task update",
-						"error", err,
-						"task_id", event.TaskID,
-						"status", task.Status,
-					)
-				} else {
-					s.logger.Debug("task state updated",
-						"task_id", event.TaskID,
-						"status", task.Status,
-					)
-				}
-			}
+		case event := <-s.progressChan:
+			s.busySince.Store(time.Now().UnixNano())
+			s.handleEvent(event)
+			s.busySince.Store(0)
 
 		case <-s.shutdownChan:
 			for {
@@ -261,7 +469,7 @@ func (s *TaskService) eventProcessor() {
 								task.Status = *event.Updates.Status
 							}
 							task.UpdatedAt = time.Now()
-							if err := s.taskStorage.Save(task); err != nil {
+							if err := s.taskStorage.Save(context.Background(), task); err != nil {
 								s.logger.Error("failed to save task update",
 									"error", err,
 									"task_id", event.TaskID,
@@ -278,6 +486,193 @@ func (s *TaskService) eventProcessor() {
 	}
 }
 
+// handleEvent applies a single task event inside a span that belongs to the task's trace.
+func (s *TaskService) handleEvent(event domain.TaskEvent) {
+	var traceContext map[string]string
+	if event.Task != nil {
+		traceContext = event.Task.TraceContext
+	} else if task, err := s.taskStorage.Get(event.TaskID); err == nil {
+		traceContext = task.TraceContext
+	}
+
+	ctx, span := tracer.Start(tracing.Extract(context.Background(), traceContext), "TaskService.handleEvent", trace.WithAttributes(
+		attribute.String("task.id", event.TaskID),
+		attribute.String("event.type", string(event.Type)),
+	))
+	defer span.End()
+
+	switch event.Type {
+	case domain.EventCreateTask:
+		if err := s.taskStorage.Save(ctx, event.Task); err != nil {
+			s.logger.Error("failed to save task",
+				"error", err,
+				"task_id", event.TaskID,
+			)
+			// The task never reached disk; it is dropped rather than left pending
+			// without a place in the queue.
+			if _, err := s.taskStorage.DeleteIf(event.TaskID, func(*domain.Task) bool { return true }); err != nil {
+				s.logger.Warn("failed to drop unsaved task", "error", err, "task_id", event.TaskID)
+			}
+			s.queue.unreserve()
+			s.idempotency.forget(event.Task)
+			return
+		}
+		s.logger.Debug("task saved to storage",
+			"task_id", event.TaskID,
+		)
+		s.statusChanged(domain.EventCreateTask, event.Task)
+		s.queue.push(event.Task, true)
+
+	case domain.EventRetryTask:
+		s.applyRetry(ctx, event)
+
+	case domain.EventAddURLs:
+		s.applyAddURLs(ctx, event)
+
+	case domain.EventUpdateTask:
+		task, err := s.taskStorage.Get(event.TaskID)
+		if err != nil {
+			s.logger.Error("failed to get task for update",
+				"error", err,
+				"task_id", event.TaskID,
+			)
+			return
+		}
+		previous := task.Status
+
+		if event.Updates.Status != nil {
+			task.Status = *event.Updates.Status
+		}
+		if event.Updates.Results != nil {
+			task.Results = mergeResults(task.Results, event.Updates.Results)
+		}
+		if event.Updates.Progress != nil {
+			// Progress travels apart from the other events and may arrive after the
+			// final result of its URL; it only applies while the URL is downloading.
+			if task.Status != domain.StatusInProgress || !downloading(task, event.Updates.Progress.URL) {
+				return
+			}
+			task.Results = mergeProgress(slices.Clone(task.Results), *event.Updates.Progress)
+		}
+		if event.Updates.Priority != nil {
+			task.Options.Priority = *event.Updates.Priority
+		}
+		if event.Updates.Delivery != nil && task.Webhook != nil {
+			hook := *task.Webhook
+			hook.Deliveries = append(slices.Clone(hook.Deliveries), *event.Updates.Delivery)
+			hook.Delivered = hook.Delivered || event.Updates.Delivery.Success
+			task.Webhook = &hook
+		}
+		// URLs added while the task was running are still queued when it finishes;
+		// the task goes back to the queue to download them.
+		requeue := false
+		if task.Status.IsTerminal() {
+			task.Retry = nil
+			if queued := queuedURLs(task); len(queued) > 0 && !previous.IsTerminal() {
+				task.Status = domain.StatusPending
+				task.Retry = queued
+				requeue = true
+			}
+		}
+		task.UpdatedAt = time.Now()
+		if requeue {
+			task.EnqueuedAt = task.UpdatedAt
+		}
+
+		if err := s.taskStorage.Save(ctx, task); err != nil {
+			s.logger.Error("failed to save task update",
+				"error", err,
+				"task_id", event.TaskID,
+				"status", task.Status,
+			)
+		} else {
+			s.logger.Debug("task state updated",
+				"task_id", event.TaskID,
+				"status", task.Status,
+			)
+		}
+
+		if requeue {
+			s.logger.Info("task queued for added urls", "task_id", task.ID, "urls", task.Retry)
+			s.queue.push(task, false)
+		}
+		if task.Status != previous {
+			cause := domain.EventUpdateTask
+			if requeue {
+				cause = domain.EventAddURLs
+			}
+			s.statusChanged(cause, task)
+			if !previous.IsTerminal() && task.Status.IsTerminal() && task.Webhook != nil {
+				s.notify(task)
+			}
+		}
+	}
+}
+
+func (s *TaskService) statusChanged(event domain.EventType, task *domain.Task) {
+	for _, l := range s.listeners {
+		l(event, task)
+	}
+}
+
+// notify delivers a finished task to its callback URL in the background. Each attempt is
+// appended to the task's delivery log through the event queue. Deliveries interrupted by
+// shutdown are picked up again by ResumeWebhooks.
+func (s *TaskService) notify(task *domain.Task) {
+	body, err := s.webhooks.Body(task)
+	if err != nil {
+		s.logger.Error("failed to render webhook payload", "error", err, "task_id", task.ID)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-s.shutdownChan:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		record := func(delivery domain.WebhookDelivery) {
+			select {
+			case s.eventChan <- domain.TaskEvent{
+				Type:    domain.EventUpdateTask,
+				TaskID:  task.ID,
+				Updates: &domain.TaskUpdate{Delivery: &delivery},
+			}:
+			case <-s.shutdownChan:
+			}
+		}
+
+		if err := s.webhooks.Deliver(ctx, task, body, record); err != nil && ctx.Err() == nil {
+			s.logger.Error("webhook delivery abandoned", "error", err, "task_id", task.ID)
+		}
+	}()
+}
+
+// ResumeWebhooks restarts deliveries for finished tasks whose callback has not been
+// reached yet, typically after a restart. It returns the number of deliveries resumed.
+func (s *TaskService) ResumeWebhooks() int {
+	if s.webhooks == nil {
+		return 0
+	}
+
+	resumed := 0
+	for _, task := range s.taskStorage.GetAll() {
+		if s.webhooks.Pending(task) {
+			s.notify(task)
+			resumed++
+		}
+	}
+	return resumed
+}
+
 // Shutdown gracefully shuts down the TaskService, waiting for all in-progress tasks to complete.
 // It closes internal channels and logs shutdown progress.
 func (s *TaskService) Shutdown(ctx context.Context) error {
@@ -305,6 +700,33 @@ func (s *TaskService) Shutdown(ctx context.Context) error {
 	return nil
 }
 
+// downloading reports whether url is one of the task's URLs and its result has not been
+// finished yet. Progress for other URLs, such as files found by a crawl, is not recorded.
+func downloading(task *domain.Task, url string) bool {
+	if !slices.Contains(task.URLs, url) {
+		return false
+	}
+	for _, result := range task.Results {
+		if result.URL == url {
+			return result.State == "" || result.State == domain.URLQueued || result.State == domain.URLDownloading
+		}
+	}
+	return true
+}
+
+// mergeProgress replaces the result for the same URL, or appends it if none exists yet.
+// The attempt history of the replaced result is kept.
+func mergeProgress(results []domain.DownloadResult, progress domain.DownloadResult) []domain.DownloadResult {
+	for i := range results {
+		if results[i].URL == progress.URL {
+			progress.Attempts = results[i].Attempts
+			results[i] = progress
+			return results
+		}
+	}
+	return append(results, progress)
+}
+
 func generateID() string {
 	return uuid.New().String()
 }
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"log/slog"
 	"net/http"
@@ -13,7 +14,13 @@ import (
 
 	"github.com/veranemoloko/url-downloader/internal/domain"
 	"github.com/veranemoloko/url-downloader/internal/storage"
+	"github.com/veranemoloko/url-downloader/internal/webhook"
 	"github.com/veranemoloko/url-downloader/internal/worker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func makeTempDir(t *testing.T, prefix string) string {
@@ -72,7 +79,7 @@ func TestTaskService_CreateTask_ProcessCompletes(t *testing.T) {
 	wrk := worker.NewDownloadWorker(fileStorage, logger)
 	svc := NewTaskService(taskStorage, fileStorage, wrk, logger)
 
-	task, err := svc.CreateTask([]string{server.URL + "/a", server.URL + "/b"})
+	task, err := svc.CreateTask(context.Background(), []string{server.URL + "/a", server.URL + "/b"}, domain.TaskOptions{})
 	if err != nil {
 		t.Fatalf("CreateTask error: %v", err)
 	}
@@ -114,3 +121,327 @@ func TestTaskService_CreateTask_ProcessCompletes(t *testing.T) {
 		t.Fatalf("Shutdown error: %v", err)
 	}
 }
+
+func TestMergeProgress(t *testing.T) {
+	results := []domain.DownloadResult{
+		{URL: "magnet:?xt=a", BytesRead: 10, TotalBytes: 100},
+	}
+
+	results = mergeProgress(results, domain.DownloadResult{URL: "magnet:?xt=a", BytesRead: 50, TotalBytes: 100})
+	results = mergeProgress(results, domain.DownloadResult{URL: "magnet:?xt=b", BytesRead: 5, TotalBytes: 20})
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].BytesRead != 50 {
+		t.Fatalf("expected progress to replace existing result, got %+v", results[0])
+	}
+	if results[1].URL != "magnet:?xt=b" {
+		t.Fatalf("expected new result to be appended, got %+v", results[1])
+	}
+}
+
+func TestTaskService_TracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "AAA")
+	}))
+	defer server.Close()
+
+	logger := newTestLogger()
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger)
+
+	ctx, request := provider.Tracer("test").Start(context.Background(), "request")
+	task, err := svc.CreateTask(ctx, []string{server.URL + "/a"}, domain.TaskOptions{})
+	request.End()
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		got, err := taskStorage.Get(task.ID)
+		return err == nil && got.Status == domain.StatusCompleted
+	})
+	svc.Shutdown(context.Background())
+
+	traceID := request.SpanContext().TraceID()
+	seen := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			seen[span.Name()] = true
+		}
+	}
+	for _, name := range []string{"TaskService.handleEvent", "TaskService.ProcessTask", "DownloadWorker.DownloadURL", "TaskStorage.persist", "HTTP GET"} {
+		if !seen[name] {
+			t.Errorf("expected span %q in the request trace, got %v", name, seen)
+		}
+	}
+}
+
+func TestTaskService_StateAndChecks(t *testing.T) {
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		io.WriteString(w, "AAA")
+	}))
+	defer server.Close()
+
+	logger := newTestLogger()
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger)
+
+	if err := svc.CheckProcessor(time.Minute)(context.Background()); err != nil {
+		t.Fatalf("expected running processor, got %v", err)
+	}
+	if err := svc.CheckQueue(0.9)(context.Background()); err != nil {
+		t.Fatalf("expected queue below saturation, got %v", err)
+	}
+
+	task, err := svc.CreateTask(context.Background(), []string{server.URL + "/a?token=secret"}, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+
+	var state State
+	waitFor(t, 5*time.Second, func() bool {
+		state = svc.State()
+		return len(state.ActiveDownloads) == 1
+	})
+	if len(state.Tasks) != 1 || state.Tasks[0].TaskID != task.ID || state.Tasks[0].Downloads != 1 {
+		t.Fatalf("expected one task with one download, got %+v", state.Tasks)
+	}
+	if got := state.ActiveDownloads[0].URL; got != server.URL+"/a" {
+		t.Fatalf("expected query to be stripped from active download URL, got %q", got)
+	}
+	if !state.Processor.Running || state.Queue.Capacity == 0 || state.Goroutines == 0 {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+
+	close(release)
+	waitFor(t, 5*time.Second, func() bool {
+		got, err := taskStorage.Get(task.ID)
+		return err == nil && got.Status == domain.StatusCompleted
+	})
+	if state := svc.State(); len(state.Tasks) != 0 || len(state.ActiveDownloads) != 0 {
+		t.Fatalf("expected no work after completion, got %+v", state)
+	}
+
+	if err := svc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+	if err := svc.CheckProcessor(time.Minute)(context.Background()); err == nil {
+		t.Fatal("expected processor check to fail after shutdown")
+	}
+	if err := svc.CheckQueue(0.9)(context.Background()); err == nil {
+		t.Fatal("expected queue check to fail after shutdown")
+	}
+}
+
+func TestTaskService_Webhook(t *testing.T) {
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "AAA")
+	}))
+	defer server.Close()
+
+	secret := []byte("s3cret")
+	received := make(chan *http.Request, 4)
+	bodies := make(chan []byte, 4)
+	attempts := 0
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		received <- r
+		bodies <- body
+	}))
+	defer callback.Close()
+
+	logger := newTestLogger()
+	notifier := webhook.New(webhook.Config{
+		DefaultURL:     callback.URL,
+		Secret:         secret,
+		InitialBackoff: time.Millisecond,
+	}, func(task *domain.Task) any { return task }, logger)
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger, WithWebhooks(notifier))
+
+	task, err := svc.CreateTask(context.Background(), []string{server.URL + "/a"}, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+
+	var req *http.Request
+	select {
+	case req = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for webhook")
+	}
+	body := <-bodies
+	if want := webhook.Sign(secret, req.Header.Get(webhook.TimestampHeader), body); req.Header.Get(webhook.SignatureHeader) != want {
+		t.Fatalf("expected signature %q, got %q", want, req.Header.Get(webhook.SignatureHeader))
+	}
+	var payload domain.Task
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.ID != task.ID || payload.Status != domain.StatusCompleted || len(payload.Results) != 1 {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		got, err := taskStorage.Get(task.ID)
+		return err == nil && got.Webhook != nil && got.Webhook.Delivered
+	})
+	final, _ := taskStorage.Get(task.ID)
+	if log := final.Webhook.Deliveries; len(log) != 2 || log[0].StatusCode != http.StatusBadGateway || !log[1].Success {
+		t.Fatalf("unexpected delivery log: %+v", log)
+	}
+	if svc.ResumeWebhooks() != 0 {
+		t.Fatal("expected delivered webhook not to be resumed")
+	}
+
+	if err := svc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+}
+
+func TestTaskService_ResumeWebhooks(t *testing.T) {
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+
+	received := make(chan string, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(webhook.IDHeader)
+	}))
+	defer callback.Close()
+
+	pending := &domain.Task{ID: "pending", Status: domain.StatusFailed, Webhook: &domain.Webhook{
+		URL:        callback.URL,
+		Deliveries: []domain.WebhookDelivery{{Attempt: 1, StatusCode: http.StatusServiceUnavailable}},
+	}}
+	running := &domain.Task{ID: "running", Status: domain.StatusInProgress, Webhook: &domain.Webhook{URL: callback.URL}}
+	for _, task := range []*domain.Task{pending, running} {
+		if err := taskStorage.Save(context.Background(), task); err != nil {
+			t.Fatalf("Save error: %v", err)
+		}
+	}
+
+	logger := newTestLogger()
+	notifier := webhook.New(webhook.Config{}, func(task *domain.Task) any { return task }, logger)
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger, WithWebhooks(notifier))
+
+	if resumed := svc.ResumeWebhooks(); resumed != 1 {
+		t.Fatalf("expected 1 resumed delivery, got %d", resumed)
+	}
+	select {
+	case id := <-received:
+		if id != "pending" {
+			t.Fatalf("expected delivery for pending task, got %q", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for resumed webhook")
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		got, err := taskStorage.Get("pending")
+		return err == nil && got.Webhook.Delivered && len(got.Webhook.Deliveries) == 2 && got.Webhook.Deliveries[1].Attempt == 2
+	})
+
+	if err := svc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+}
+
+func TestTaskService_StatusListener(t *testing.T) {
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "AAA")
+	}))
+	defer server.Close()
+
+	type change struct {
+		event  domain.EventType
+		status domain.TaskStatus
+	}
+	statuses := make(chan change, 10)
+	logger := newTestLogger()
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger,
+		WithStatusListener(func(event domain.EventType, task *domain.Task) { statuses <- change{event, task.Status} }),
+	)
+	expect := func(changes ...change) {
+		t.Helper()
+		for _, want := range changes {
+			select {
+			case got := <-statuses:
+				if got != want {
+					t.Fatalf("expected %s status %s, got %s status %s", want.event, want.status, got.event, got.status)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timeout waiting for status %s", want.status)
+			}
+		}
+	}
+
+	task, err := svc.CreateTask(context.Background(), []string{server.URL + "/a"}, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+	expect(
+		change{domain.EventCreateTask, domain.StatusPending},
+		change{domain.EventUpdateTask, domain.StatusInProgress},
+		change{domain.EventUpdateTask, domain.StatusCompleted},
+	)
+
+	// A retried task is reported as retried rather than as created again.
+	waitFor(t, 2*time.Second, func() bool {
+		_, busy := svc.processing.Load(task.ID)
+		return !busy
+	})
+	if _, err := svc.RetryTask(task.ID, []string{server.URL + "/a"}); err != nil {
+		t.Fatalf("RetryTask error: %v", err)
+	}
+	expect(
+		change{domain.EventRetryTask, domain.StatusPending},
+		change{domain.EventUpdateTask, domain.StatusInProgress},
+		change{domain.EventUpdateTask, domain.StatusCompleted},
+	)
+
+	if err := svc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+	if len(statuses) != 0 {
+		t.Fatalf("expected no further status changes, got %d", len(statuses))
+	}
+}
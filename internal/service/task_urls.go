@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+var (
+	// ErrTaskFinished is returned when adding URLs to a task that has already finished.
+	ErrTaskFinished = errors.New("task has finished")
+	// ErrDuplicateURL is returned when a task is created with the same URL more than once.
+	// Results are tracked per URL, so a second copy would never leave the queued state.
+	ErrDuplicateURL = errors.New("duplicate url")
+)
+
+// AddURLs appends urls to a task that has not finished yet. URLs the task already has are
+// ignored. A queued task downloads them with the rest; a running task goes back to the
+// queue for them once its current downloads are done.
+func (s *TaskService) AddURLs(id string, urls []string) (*domain.Task, error) {
+	task, err := s.taskStorage.Get(id)
+	if err != nil {
+		return nil, ErrTaskNotFound
+	}
+	if task.Status.IsTerminal() {
+		return nil, ErrTaskFinished
+	}
+
+	urls = newURLs(task, urls)
+	if len(urls) == 0 {
+		return task, nil
+	}
+
+	select {
+	case s.eventChan <- domain.TaskEvent{
+		Type:    domain.EventAddURLs,
+		TaskID:  id,
+		Updates: &domain.TaskUpdate{AddURLs: urls},
+	}:
+	case <-s.shutdownChan:
+		return nil, ErrShuttingDown
+	}
+
+	s.logger.Info("urls added to task", "task_id", id, "urls_count", len(urls))
+	addURLs(task, urls)
+	task.UpdatedAt = time.Now()
+	return task, nil
+}
+
+// newURLs returns urls without duplicates and without the URLs task already has.
+func newURLs(task *domain.Task, urls []string) []string {
+	var added []string
+	for _, url := range urls {
+		if !slices.Contains(task.URLs, url) && !slices.Contains(added, url) {
+			added = append(added, url)
+		}
+	}
+	return added
+}
+
+// duplicateURL returns the first URL that occurs more than once in urls.
+func duplicateURL(urls []string) (string, bool) {
+	seen := make(map[string]struct{}, len(urls))
+	for _, url := range urls {
+		if _, ok := seen[url]; ok {
+			return url, true
+		}
+		seen[url] = struct{}{}
+	}
+	return "", false
+}
+
+// addURLs appends urls to task as queued. A task waiting to run only the URLs in Retry
+// runs the new ones as well.
+func addURLs(task *domain.Task, urls []string) {
+	task.URLs = append(slices.Clone(task.URLs), urls...)
+	task.Results = append(slices.Clone(task.Results), domain.QueuedResults(urls)...)
+	if task.Status == domain.StatusPending && len(task.Retry) > 0 {
+		task.Retry = append(slices.Clone(task.Retry), urls...)
+	}
+}
+
+// applyAddURLs runs in the event processor. URLs that arrive after the task has finished
+// are not lost: the task is queued again for them.
+func (s *TaskService) applyAddURLs(ctx context.Context, event domain.TaskEvent) {
+	task, err := s.taskStorage.Get(event.TaskID)
+	if err != nil {
+		s.logger.Error("failed to get task for added urls",
+			"error", err,
+			"task_id", event.TaskID,
+		)
+		return
+	}
+
+	urls := newURLs(task, event.Updates.AddURLs)
+	if len(urls) == 0 {
+		return
+	}
+	finished := task.Status.IsTerminal()
+	if finished {
+		task.URLs = append(slices.Clone(task.URLs), urls...)
+		prepareRetry(task, urls, time.Now())
+	} else {
+		addURLs(task, urls)
+		task.UpdatedAt = time.Now()
+	}
+
+	if err := s.taskStorage.Save(ctx, task); err != nil {
+		s.logger.Error("failed to save added urls",
+			"error", err,
+			"task_id", task.ID,
+		)
+		return
+	}
+	if finished {
+		s.logger.Info("task queued for urls added after it finished", "task_id", task.ID)
+		s.statusChanged(domain.EventAddURLs, task)
+		s.queue.push(task, false)
+	}
+}
+
+// queuedURLs returns the URLs of task that are still waiting to be downloaded.
+func queuedURLs(task *domain.Task) []string {
+	var queued []string
+	for _, result := range task.Results {
+		if result.State == domain.URLQueued {
+			queued = append(queued, result.URL)
+		}
+	}
+	return queued
+}
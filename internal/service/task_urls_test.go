@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+	"github.com/veranemoloko/url-downloader/internal/worker"
+)
+
+func TestTaskService_AddURLs(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		io.WriteString(w, "data")
+	}))
+	defer server.Close()
+
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+	logger := newTestLogger()
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger,
+		WithQueue(QueueConfig{Workers: 1}))
+	defer svc.Shutdown(context.Background())
+	ctx := context.Background()
+
+	slowURL, moreURL, queuedURL := server.URL+"/slow", server.URL+"/more", server.URL+"/queued"
+	running, err := svc.CreateTask(ctx, []string{slowURL}, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+	if len(running.Results) != 1 || running.Results[0].State != domain.URLQueued {
+		t.Fatalf("expected every url to start out queued, got %+v", running.Results)
+	}
+	waiting, err := svc.CreateTask(ctx, []string{server.URL + "/first"}, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		task, err := svc.GetTask(running.ID)
+		return err == nil && task.Status == domain.StatusInProgress && task.Results[0].State == domain.URLDownloading
+	})
+	waitFor(t, 2*time.Second, func() bool {
+		_, err := svc.GetTask(waiting.ID)
+		return err == nil
+	})
+
+	if _, err := svc.AddURLs("missing", []string{moreURL}); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("expected ErrTaskNotFound, got %v", err)
+	}
+	added, err := svc.AddURLs(running.ID, []string{slowURL, moreURL, moreURL})
+	if err != nil {
+		t.Fatalf("AddURLs error: %v", err)
+	}
+	if len(added.URLs) != 2 || added.URLs[1] != moreURL || added.Results[1].State != domain.URLQueued {
+		t.Errorf("expected the new url to be appended once, got %v %+v", added.URLs, added.Results)
+	}
+	if _, err := svc.AddURLs(waiting.ID, []string{queuedURL}); err != nil {
+		t.Fatalf("AddURLs error: %v", err)
+	}
+
+	close(release)
+	for _, id := range []string{running.ID, waiting.ID} {
+		var task *domain.Task
+		waitFor(t, 5*time.Second, func() bool {
+			task, err = svc.GetTask(id)
+			return err == nil && task.Status == domain.StatusCompleted
+		})
+		if len(task.Results) != 2 || len(task.Retry) != 0 {
+			t.Fatalf("expected both urls of task %s to be downloaded, got %+v", id, task)
+		}
+		for _, result := range task.Results {
+			if result.State != domain.URLDone || !result.Success {
+				t.Errorf("expected %s to be done, got %s (%s)", result.URL, result.State, result.Error)
+			}
+		}
+	}
+
+	mu.Lock()
+	if hits["/slow"] != 1 || hits["/more"] != 1 || hits["/queued"] != 1 {
+		t.Errorf("expected every url to be downloaded once, got %v", hits)
+	}
+	mu.Unlock()
+
+	if _, err := svc.AddURLs(running.ID, []string{server.URL + "/late"}); !errors.Is(err, ErrTaskFinished) {
+		t.Errorf("expected ErrTaskFinished, got %v", err)
+	}
+}
+
+func TestTaskService_CreateTask_DuplicateURLs(t *testing.T) {
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+	logger := newTestLogger()
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger)
+	defer svc.Shutdown(context.Background())
+
+	// A second copy of a URL would stay queued forever and requeue the task after every run.
+	urls := []string{"http://example.com/a", "http://example.com/b", "http://example.com/a"}
+	if _, err := svc.CreateTask(context.Background(), urls, domain.TaskOptions{}); !errors.Is(err, ErrDuplicateURL) {
+		t.Fatalf("expected ErrDuplicateURL, got %v", err)
+	}
+	if n, _ := svc.queue.len(); n != 0 {
+		t.Errorf("expected the queue reservation to be released, got %d", n)
+	}
+	if len(taskStorage.GetAll()) != 0 {
+		t.Error("expected no task to be stored")
+	}
+}
+
+func TestTaskService_ProgressForUnknownURLIgnored(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		io.WriteString(w, "data")
+	}))
+	defer server.Close()
+	defer close(release)
+
+	taskStorage, err := storage.NewTaskStorage(makeTempDir(t, "taskservice_tasks_*"))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	fileStorage := storage.NewFileStorage(makeTempDir(t, "taskservice_downloads_*"))
+	logger := newTestLogger()
+	svc := NewTaskService(taskStorage, fileStorage, worker.NewDownloadWorker(fileStorage, logger), logger)
+	defer svc.Shutdown(context.Background())
+
+	task, err := svc.CreateTask(context.Background(), []string{server.URL + "/index"}, domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("CreateTask error: %v", err)
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		current, err := svc.GetTask(task.ID)
+		return err == nil && current.Status == domain.StatusInProgress
+	})
+
+	// Progress is applied in order, so once the second update shows the first has been handled.
+	svc.reportProgress(task.ID, domain.DownloadResult{URL: server.URL + "/found.torrent", State: domain.URLDownloading})
+	svc.reportProgress(task.ID, domain.DownloadResult{URL: server.URL + "/index", State: domain.URLDownloading, BytesRead: 7})
+	waitFor(t, 2*time.Second, func() bool {
+		current, err := svc.GetTask(task.ID)
+		return err == nil && current.Results[0].BytesRead == 7
+	})
+
+	current, err := svc.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("GetTask error: %v", err)
+	}
+	if len(current.Results) != 1 {
+		t.Fatalf("expected progress for an unknown url to be ignored, got %+v", current.Results)
+	}
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+// BlobDirName is the directory inside the download directory that holds the blob store.
+const BlobDirName = ".blobs"
+
+const blobIndexFile = "index.json"
+
+// CacheEntry describes the last download of a URL, so that it can be revalidated
+// with a conditional request instead of being downloaded again.
+type CacheEntry struct {
+	Digest          string              `json:"digest"`
+	Size            int64               `json:"size"`
+	ETag            string              `json:"etag,omitempty"`
+	LastModified    string              `json:"last_modified,omitempty"`
+	ContentType     string              `json:"content_type,omitempty"`
+	ContentEncoding string              `json:"content_encoding,omitempty"`
+	Encoding        domain.EncodingMode `json:"encoding,omitempty"`
+	StoredAt        time.Time           `json:"stored_at"`
+}
+
+// GCStats reports what a garbage collection removed.
+type GCStats struct {
+	Blobs int   `json:"blobs"`
+	Bytes int64 `json:"bytes"`
+}
+
+// BlobStore keeps downloaded content once per SHA-256 digest. Task files are hard links to
+// their blob, so the file system link count says how many task files still reference it and
+// deleting a task file needs no bookkeeping. Blobs are read-only to keep one task from
+// changing the files of another.
+type BlobStore struct {
+	dir string
+
+	mu    sync.Mutex
+	cache map[string]CacheEntry
+}
+
+// NewBlobStore opens the blob store in dir, creating it if needed. It fails when the
+// file system or platform cannot hard-link files or report link counts.
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create blob dir: %w", err)
+	}
+	if err := checkHardLinks(dir); err != nil {
+		return nil, err
+	}
+
+	s := &BlobStore{dir: dir, cache: make(map[string]CacheEntry)}
+	data, err := os.ReadFile(filepath.Join(dir, blobIndexFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read blob index: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.cache); err != nil {
+			return nil, fmt.Errorf("parse blob index: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// checkHardLinks verifies that dir supports hard links with observable link counts.
+func checkHardLinks(dir string) error {
+	probe, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("create probe file: %w", err)
+	}
+	probe.Close()
+	defer os.Remove(probe.Name())
+
+	link := probe.Name() + ".link"
+	if err := os.Link(probe.Name(), link); err != nil {
+		return fmt.Errorf("hard links not supported: %w", err)
+	}
+	defer os.Remove(link)
+
+	info, err := os.Stat(link)
+	if err != nil {
+		return err
+	}
+	if n, ok := linkCount(info); !ok || n != 2 {
+		return errors.New("file link counts not supported")
+	}
+	return nil
+}
+
+func (s *BlobStore) blobPath(digest string) string {
+	return filepath.Join(s.dir, digest[:2], digest)
+}
+
+// Add moves the content of the file at path into the store and leaves path as a hard link to
+// the blob. When identical content is already stored, path is replaced by a link to the
+// existing blob. It returns the hex SHA-256 digest and size of the content.
+func (s *BlobStore) Add(path string) (string, int64, error) {
+	digest, size, err := hashFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob := s.blobPath(digest)
+	if _, err := os.Stat(blob); err == nil {
+		return digest, size, replaceWithLink(blob, path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return "", 0, fmt.Errorf("create blob dir: %w", err)
+	}
+	if err := os.Link(path, blob); err != nil {
+		return "", 0, fmt.Errorf("link blob: %w", err)
+	}
+	if err := os.Chmod(blob, 0444); err != nil {
+		return "", 0, fmt.Errorf("protect blob: %w", err)
+	}
+	return digest, size, nil
+}
+
+// Link makes path a hard link to the blob with the given digest.
+func (s *BlobStore) Link(digest, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return replaceWithLink(s.blobPath(digest), path)
+}
+
+// Open opens the blob with the given digest for reading.
+func (s *BlobStore) Open(digest string) (*os.File, error) {
+	return os.Open(s.blobPath(digest))
+}
+
+// replaceWithLink atomically replaces path with a hard link to blob.
+func replaceWithLink(blob, path string) error {
+	tmp := path + ".link-tmp"
+	os.Remove(tmp)
+	if err := os.Link(blob, tmp); err != nil {
+		return fmt.Errorf("link blob: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace file with blob link: %w", err)
+	}
+	return nil
+}
+
+// Lookup returns the cache entry stored under key when its blob still exists.
+func (s *BlobStore) Lookup(key string) (CacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.cache[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	if _, err := os.Stat(s.blobPath(entry.Digest)); err != nil {
+		delete(s.cache, key)
+		return CacheEntry{}, false
+	}
+	return entry, true
+}
+
+// Remember stores entry under key and persists the cache index.
+func (s *BlobStore) Remember(key string, entry CacheEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cache[key] = entry
+	return s.saveIndex()
+}
+
+// GC deletes blobs that no task file links to any more, along with their cache entries.
+func (s *BlobStore) GC() (GCStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stats GCStats
+	removed := make(map[string]bool)
+
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Dir(path) == s.dir {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if n, ok := linkCount(info); !ok || n > 1 {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("remove blob: %w", err)
+		}
+		removed[d.Name()] = true
+		stats.Blobs++
+		stats.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if len(removed) == 0 {
+		return stats, nil
+	}
+	for key, entry := range s.cache {
+		if removed[entry.Digest] {
+			delete(s.cache, key)
+		}
+	}
+	return stats, s.saveIndex()
+}
+
+// saveIndex writes the cache index atomically. The caller must hold s.mu.
+func (s *BlobStore) saveIndex() error {
+	data, err := json.MarshalIndent(s.cache, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal blob index: %w", err)
+	}
+	tmp := filepath.Join(s.dir, blobIndexFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write blob index: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, blobIndexFile))
+}
+
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("open file for hashing: %w", err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("hash file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
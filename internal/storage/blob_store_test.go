@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestBlobStore(t *testing.T) (*BlobStore, string) {
+	t.Helper()
+	dir := makeTempDir(t)
+	blobs, err := NewBlobStore(filepath.Join(dir, BlobDirName))
+	if err != nil {
+		t.Fatalf("NewBlobStore error: %v", err)
+	}
+	return blobs, dir
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestBlobStore_AddDeduplicates(t *testing.T) {
+	blobs, dir := newTestBlobStore(t)
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	writeTestFile(t, first, "same content")
+	writeTestFile(t, second, "same content")
+
+	digest1, size, err := blobs.Add(first)
+	if err != nil {
+		t.Fatalf("Add first error: %v", err)
+	}
+	digest2, _, err := blobs.Add(second)
+	if err != nil {
+		t.Fatalf("Add second error: %v", err)
+	}
+	if digest1 != digest2 {
+		t.Errorf("expected identical digests, got %s and %s", digest1, digest2)
+	}
+	if size != int64(len("same content")) {
+		t.Errorf("expected size %d, got %d", len("same content"), size)
+	}
+
+	info1, _ := os.Stat(first)
+	info2, _ := os.Stat(second)
+	if !os.SameFile(info1, info2) {
+		t.Errorf("expected both files to share one inode")
+	}
+	if n, _ := linkCount(info1); n != 3 {
+		t.Errorf("expected 3 links (blob and two files), got %d", n)
+	}
+	if info1.Mode().Perm()&0222 != 0 {
+		t.Errorf("expected blob to be read-only, got %v", info1.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(second)
+	if err != nil || string(data) != "same content" {
+		t.Errorf("expected linked file to keep its content, got %q (%v)", data, err)
+	}
+}
+
+func TestBlobStore_GCRemovesUnreferenced(t *testing.T) {
+	blobs, dir := newTestBlobStore(t)
+	kept := filepath.Join(dir, "kept.txt")
+	dropped := filepath.Join(dir, "dropped.txt")
+	writeTestFile(t, kept, "kept")
+	writeTestFile(t, dropped, "dropped")
+
+	keptDigest, _, err := blobs.Add(kept)
+	if err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	droppedDigest, _, err := blobs.Add(dropped)
+	if err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	if err := blobs.Remember("kept", CacheEntry{Digest: keptDigest, ETag: `"k"`}); err != nil {
+		t.Fatalf("Remember error: %v", err)
+	}
+	if err := blobs.Remember("dropped", CacheEntry{Digest: droppedDigest, ETag: `"d"`}); err != nil {
+		t.Fatalf("Remember error: %v", err)
+	}
+
+	if err := os.Remove(dropped); err != nil {
+		t.Fatalf("failed to remove task file: %v", err)
+	}
+
+	stats, err := blobs.GC()
+	if err != nil {
+		t.Fatalf("GC error: %v", err)
+	}
+	if stats.Blobs != 1 || stats.Bytes != int64(len("dropped")) {
+		t.Errorf("expected 1 blob of %d bytes removed, got %+v", len("dropped"), stats)
+	}
+
+	if _, ok := blobs.Lookup("kept"); !ok {
+		t.Errorf("expected referenced blob to stay cached")
+	}
+	if _, ok := blobs.Lookup("dropped"); ok {
+		t.Errorf("expected cache entry of removed blob to be dropped")
+	}
+	if _, err := os.Stat(blobs.blobPath(droppedDigest)); !os.IsNotExist(err) {
+		t.Errorf("expected unreferenced blob to be deleted, got %v", err)
+	}
+}
+
+func TestBlobStore_LookupMissingBlob(t *testing.T) {
+	blobs, dir := newTestBlobStore(t)
+	path := filepath.Join(dir, "file.txt")
+	writeTestFile(t, path, "content")
+
+	digest, _, err := blobs.Add(path)
+	if err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	if err := blobs.Remember("key", CacheEntry{Digest: digest}); err != nil {
+		t.Fatalf("Remember error: %v", err)
+	}
+	if err := os.Remove(blobs.blobPath(digest)); err != nil {
+		t.Fatalf("failed to remove blob: %v", err)
+	}
+
+	if _, ok := blobs.Lookup("key"); ok {
+		t.Errorf("expected lookup to miss when the blob is gone")
+	}
+	if err := blobs.Link(digest, filepath.Join(dir, "other.txt")); err == nil {
+		t.Errorf("expected Link to fail for a missing blob")
+	}
+}
+
+func TestBlobStore_IndexPersists(t *testing.T) {
+	blobs, dir := newTestBlobStore(t)
+	path := filepath.Join(dir, "file.txt")
+	writeTestFile(t, path, "content")
+
+	digest, size, err := blobs.Add(path)
+	if err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	want := CacheEntry{
+		Digest:       digest,
+		Size:         size,
+		ETag:         `"v1"`,
+		LastModified: "Mon, 02 Jan 2006 15:04:05 GMT",
+		StoredAt:     time.Now().UTC().Truncate(time.Second),
+	}
+	if err := blobs.Remember("key", want); err != nil {
+		t.Fatalf("Remember error: %v", err)
+	}
+
+	reopened, err := NewBlobStore(filepath.Join(dir, BlobDirName))
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	got, ok := reopened.Lookup("key")
+	if !ok {
+		t.Fatalf("expected entry to survive reopening the store")
+	}
+	if got.Digest != want.Digest || got.ETag != want.ETag || got.LastModified != want.LastModified || !got.StoredAt.Equal(want.StoredAt) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
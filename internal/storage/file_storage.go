@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 )
@@ -18,8 +20,13 @@ func NewFileStorage(dir string) *FileStorage {
 }
 
 // CreateFile creates a new file with the given filename in the storage directory.
+// An existing file is replaced rather than truncated, so that content it shares
+// with the blob store through a hard link is left intact.
 func (s *FileStorage) CreateFile(filename string) (*os.File, error) {
 	filepath := filepath.Join(s.dir, filename)
+	if err := os.Remove(filepath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
 	return os.Create(filepath)
 }
 
@@ -63,3 +70,17 @@ func (s *FileStorage) CopyFile(src io.Reader, dstFilename string) (int64, error)
 
 	return io.Copy(dst, src)
 }
+
+// MoveFile moves a file out of the storage directory into dstDir, creating dstDir if needed.
+func (s *FileStorage) MoveFile(filename, dstDir string) error {
+	dst := filepath.Join(dstDir, filename)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("create destination dir: %w", err)
+	}
+	return os.Rename(filepath.Join(s.dir, filename), dst)
+}
+
+// Path returns the absolute location of filename inside the storage directory.
+func (s *FileStorage) Path(filename string) string {
+	return filepath.Join(s.dir, filename)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// linkCount is not available on this platform, which disables the blob store.
+func linkCount(os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+// ErrScheduleNotFound is returned for a schedule that does not exist.
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// ScheduleStorage provides thread-safe storage and persistence for schedules.
+type ScheduleStorage struct {
+	mu        sync.RWMutex
+	dir       string
+	schedules map[string]*domain.Schedule
+	secrets   *SecretBox
+}
+
+// persistedSchedule is the on-disk representation of a schedule with its credentials sealed.
+type persistedSchedule struct {
+	*domain.Schedule
+	SealedCredentials string `json:"sealed_credentials,omitempty"`
+}
+
+// NewScheduleStorage creates a ScheduleStorage, loading existing schedules from dir.
+// secrets may be nil, in which case schedules with credentials cannot be stored.
+func NewScheduleStorage(dir string, secrets *SecretBox) (*ScheduleStorage, error) {
+	storage := &ScheduleStorage{
+		dir:       dir,
+		schedules: make(map[string]*domain.Schedule),
+		secrets:   secrets,
+	}
+
+	if err := storage.loadSchedules(); err != nil {
+		return nil, fmt.Errorf("load schedules: %w", err)
+	}
+
+	return storage, nil
+}
+
+func (s *ScheduleStorage) loadSchedules() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("read schedule file: %w", err)
+		}
+
+		var schedule domain.Schedule
+		stored := persistedSchedule{Schedule: &schedule}
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return fmt.Errorf("unmarshal schedule: %w", err)
+		}
+
+		if err := openOptions(s.secrets, &schedule.Options, stored.SealedCredentials); err != nil {
+			return fmt.Errorf("schedule %s: %w", schedule.ID, err)
+		}
+
+		s.schedules[schedule.ID] = &schedule
+	}
+
+	return nil
+}
+
+// CanStoreCredentials reports whether schedules with credentials can be persisted.
+func (s *ScheduleStorage) CanStoreCredentials() bool {
+	return s.secrets != nil
+}
+
+// Save stores or updates a schedule in memory and persists it to disk.
+func (s *ScheduleStorage) Save(schedule *domain.Schedule) error {
+	stored := persistedSchedule{Schedule: schedule}
+
+	sealed, err := sealOptions(s.secrets, schedule.Options)
+	if err != nil {
+		return err
+	}
+	stored.SealedCredentials = sealed
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal schedule: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.schedules[schedule.ID] = schedule
+	if err := os.WriteFile(filepath.Join(s.dir, schedule.ID+".json"), data, 0644); err != nil {
+		return fmt.Errorf("write schedule file: %w", err)
+	}
+	return nil
+}
+
+// Get retrieves a schedule by its ID.
+func (s *ScheduleStorage) Get(id string) (*domain.Schedule, error) {
+	s.mu.RLock()
+	schedule, exists := s.schedules[id]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, ErrScheduleNotFound
+	}
+
+	copySchedule := *schedule
+	return &copySchedule, nil
+}
+
+// GetAll returns all schedules currently stored in memory.
+func (s *ScheduleStorage) GetAll() []*domain.Schedule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	schedules := make([]*domain.Schedule, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		copySchedule := *schedule
+		schedules = append(schedules, &copySchedule)
+	}
+	return schedules
+}
+
+// Delete removes a schedule from memory and disk.
+func (s *ScheduleStorage) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.schedules[id]; !exists {
+		return ErrScheduleNotFound
+	}
+	delete(s.schedules, id)
+
+	if err := os.Remove(filepath.Join(s.dir, id+".json")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove schedule file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+func TestScheduleStorage_SaveLoadDelete(t *testing.T) {
+	dir := makeTempDir(t)
+	box, err := NewSecretBox(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("NewSecretBox error: %v", err)
+	}
+
+	storage, err := NewScheduleStorage(dir, box)
+	if err != nil {
+		t.Fatalf("NewScheduleStorage error: %v", err)
+	}
+	next := time.Now().Add(time.Hour).UTC()
+	schedule := &domain.Schedule{
+		ID:        "nightly",
+		URLs:      []string{"https://example.com/feed.xml"},
+		Cron:      "0 3 * * *",
+		NextRunAt: &next,
+		Options: domain.TaskOptions{
+			Credentials: &domain.RequestCredentials{BearerToken: "top-secret"},
+		},
+		Validators: map[string]domain.Validators{
+			"https://example.com/feed.xml": {ETag: `"v1"`},
+		},
+	}
+	if err := storage.Save(schedule); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "nightly.json"))
+	if err != nil {
+		t.Fatalf("failed to read schedule file: %v", err)
+	}
+	if bytes.Contains(data, []byte("top-secret")) {
+		t.Errorf("expected credentials to be encrypted at rest")
+	}
+
+	reloaded, err := NewScheduleStorage(dir, box)
+	if err != nil {
+		t.Fatalf("NewScheduleStorage error: %v", err)
+	}
+	got, err := reloaded.Get("nightly")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if got.Cron != schedule.Cron || got.NextRunAt == nil || !got.NextRunAt.Equal(next) {
+		t.Errorf("expected schedule to be reloaded, got %+v", got)
+	}
+	if got.Options.Credentials == nil || got.Options.Credentials.BearerToken != "top-secret" {
+		t.Errorf("expected credentials to be restored")
+	}
+	if got.Validators["https://example.com/feed.xml"].ETag != `"v1"` {
+		t.Errorf("expected validators to be restored, got %v", got.Validators)
+	}
+	if all := reloaded.GetAll(); len(all) != 1 {
+		t.Errorf("expected one schedule, got %d", len(all))
+	}
+
+	if err := reloaded.Delete("nightly"); err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+	if _, err := reloaded.Get("nightly"); !errors.Is(err, ErrScheduleNotFound) {
+		t.Errorf("expected ErrScheduleNotFound after delete, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "nightly.json")); !os.IsNotExist(err) {
+		t.Errorf("expected schedule file to be removed")
+	}
+	if err := reloaded.Delete("nightly"); !errors.Is(err, ErrScheduleNotFound) {
+		t.Errorf("expected ErrScheduleNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestScheduleStorage_CredentialsWithoutKey(t *testing.T) {
+	storage, err := NewScheduleStorage(makeTempDir(t), nil)
+	if err != nil {
+		t.Fatalf("NewScheduleStorage error: %v", err)
+	}
+	schedule := &domain.Schedule{
+		ID:      "nokey",
+		Options: domain.TaskOptions{Credentials: &domain.RequestCredentials{BearerToken: "token"}},
+	}
+	if err := storage.Save(schedule); !errors.Is(err, ErrNoSecretBox) {
+		t.Errorf("expected ErrNoSecretBox, got %v", err)
+	}
+	if _, err := storage.Get("nokey"); err == nil {
+		t.Errorf("expected unsaved schedule not to be stored")
+	}
+}
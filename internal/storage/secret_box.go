@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ErrNoSecretBox is returned when a task carries credentials but no encryption key is configured.
+var ErrNoSecretBox = errors.New("credentials encryption key not configured")
+
+// SecretBox encrypts and decrypts small secrets with AES-256-GCM.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox creates a SecretBox from a 32-byte key.
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key length %d, want 32", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts plaintext and returns a base64 string containing the nonce and ciphertext.
+func (b *SecretBox) Seal(plaintext []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal.
+func (b *SecretBox) Open(sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("decode sealed value: %w", err)
+	}
+
+	nonceSize := b.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("sealed value too short")
+	}
+
+	plaintext, err := b.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt sealed value: %w", err)
+	}
+	return plaintext, nil
+}
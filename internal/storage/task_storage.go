@@ -1,29 +1,74 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/veranemoloko/url-downloader/internal/storage")
+
 // TaskStorage provides thread-safe storage and persistence for download tasks.
 type TaskStorage struct {
-	mu    sync.RWMutex
-	dir   string
-	tasks map[string]*domain.Task
+	mu      sync.RWMutex
+	dir     string
+	tasks   map[string]*domain.Task
+	secrets *SecretBox
+	metrics *metrics.Metrics
+}
+
+// TaskStorageOption configures optional TaskStorage behaviour.
+type TaskStorageOption func(*TaskStorage)
+
+// WithSecretBox enables encryption at rest for task credentials.
+func WithSecretBox(box *SecretBox) TaskStorageOption {
+	return func(s *TaskStorage) {
+		s.secrets = box
+	}
+}
+
+// WithMetrics records how long persisting tasks takes.
+func WithMetrics(m *metrics.Metrics) TaskStorageOption {
+	return func(s *TaskStorage) {
+		s.metrics = m
+	}
+}
+
+// persistedTask is the on-disk representation of a task with its credentials sealed.
+type persistedTask struct {
+	*domain.Task
+	SealedCredentials string `json:"sealed_credentials,omitempty"`
+}
+
+type taskCredentials struct {
+	Credentials    *domain.RequestCredentials           `json:"credentials,omitempty"`
+	URLCredentials map[string]domain.RequestCredentials `json:"url_credentials,omitempty"`
+	ProxyUsername  string                               `json:"proxy_username,omitempty"`
+	ProxyPassword  string                               `json:"proxy_password,omitempty"`
 }
 
 // NewTaskStorage creates a new TaskStorage, loading existing tasks from the specified directory.
-func NewTaskStorage(dir string) (*TaskStorage, error) {
+func NewTaskStorage(dir string, opts ...TaskStorageOption) (*TaskStorage, error) {
 	storage := &TaskStorage{
 		dir:   dir,
 		tasks: make(map[string]*domain.Task),
 	}
 
+	for _, opt := range opts {
+		opt(storage)
+	}
+
 	if err := storage.loadTasks(); err != nil {
 		return nil, fmt.Errorf("load tasks: %w", err)
 	}
@@ -48,10 +93,15 @@ func (s *TaskStorage) loadTasks() error {
 			}
 
 			var task domain.Task
-			if err := json.Unmarshal(data, &task); err != nil {
+			stored := persistedTask{Task: &task}
+			if err := json.Unmarshal(data, &stored); err != nil {
 				return fmt.Errorf("unmarshal task: %w", err)
 			}
 
+			if err := s.openCredentials(&task, stored.SealedCredentials); err != nil {
+				return fmt.Errorf("task %s: %w", task.ID, err)
+			}
+
 			s.tasks[task.ID] = &task
 		}
 	}
@@ -59,13 +109,18 @@ func (s *TaskStorage) loadTasks() error {
 	return nil
 }
 
+// CanStoreCredentials reports whether tasks with credentials can be persisted.
+func (s *TaskStorage) CanStoreCredentials() bool {
+	return s.secrets != nil
+}
+
 // Save stores or updates a task in memory and persists it to disk.
-func (s *TaskStorage) Save(task *domain.Task) error {
+func (s *TaskStorage) Save(ctx context.Context, task *domain.Task) error {
 	s.mu.Lock()
 	s.tasks[task.ID] = task
 	s.mu.Unlock()
 
-	return s.persist(task)
+	return s.persist(ctx, task)
 }
 
 // Get retrieves a task by its ID. Returns an error if the task does not exist.
@@ -82,6 +137,18 @@ func (s *TaskStorage) Get(id string) (*domain.Task, error) {
 	return &copyTask, nil
 }
 
+// CountByStatus returns the number of stored tasks in each status.
+func (s *TaskStorage) CountByStatus() map[domain.TaskStatus]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[domain.TaskStatus]int)
+	for _, task := range s.tasks {
+		counts[task.Status]++
+	}
+	return counts
+}
+
 // GetAll returns a slice of all tasks currently stored in memory.
 func (s *TaskStorage) GetAll() []*domain.Task {
 	s.mu.RLock()
@@ -95,8 +162,48 @@ func (s *TaskStorage) GetAll() []*domain.Task {
 	return tasks
 }
 
-func (s *TaskStorage) persist(task *domain.Task) error {
-	data, err := json.MarshalIndent(task, "", "  ")
+// DeleteIf removes a task from memory and disk when cond approves its current state.
+// It reports whether the task was removed; a task that does not exist is not an error.
+func (s *TaskStorage) DeleteIf(id string, cond func(*domain.Task) bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, exists := s.tasks[id]
+	if !exists || !cond(task) {
+		return false, nil
+	}
+	delete(s.tasks, id)
+
+	if err := os.Remove(filepath.Join(s.dir, id+".json")); err != nil && !os.IsNotExist(err) {
+		return true, fmt.Errorf("remove task file: %w", err)
+	}
+	return true, nil
+}
+
+func (s *TaskStorage) persist(ctx context.Context, task *domain.Task) (err error) {
+	_, span := tracer.Start(ctx, "TaskStorage.persist", trace.WithAttributes(
+		attribute.String("task.id", task.ID),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	start := time.Now()
+	defer func() { s.metrics.ObserveStorageWrite(time.Since(start)) }()
+
+	stored := persistedTask{Task: task}
+
+	sealed, err := s.sealCredentials(task)
+	if err != nil {
+		return err
+	}
+	stored.SealedCredentials = sealed
+
+	data, err := json.MarshalIndent(stored, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal task: %w", err)
 	}
@@ -108,3 +215,70 @@ func (s *TaskStorage) persist(task *domain.Task) error {
 
 	return nil
 }
+
+func (s *TaskStorage) sealCredentials(task *domain.Task) (string, error) {
+	return sealOptions(s.secrets, task.Options)
+}
+
+func (s *TaskStorage) openCredentials(task *domain.Task, sealed string) error {
+	return openOptions(s.secrets, &task.Options, sealed)
+}
+
+// sealOptions encrypts the credentials held by opts. It returns an empty string
+// when there are none.
+func sealOptions(secrets *SecretBox, opts domain.TaskOptions) (string, error) {
+	if !opts.HasCredentials() {
+		return "", nil
+	}
+	if secrets == nil {
+		return "", ErrNoSecretBox
+	}
+
+	creds := taskCredentials{
+		Credentials:    opts.Credentials,
+		URLCredentials: opts.URLCredentials,
+	}
+	if opts.Proxy != nil {
+		creds.ProxyUsername = opts.Proxy.Username
+		creds.ProxyPassword = opts.Proxy.Password
+	}
+
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return "", fmt.Errorf("marshal credentials: %w", err)
+	}
+
+	sealed, err := secrets.Seal(plaintext)
+	if err != nil {
+		return "", fmt.Errorf("seal credentials: %w", err)
+	}
+	return sealed, nil
+}
+
+// openOptions decrypts credentials sealed by sealOptions back into opts.
+func openOptions(secrets *SecretBox, opts *domain.TaskOptions, sealed string) error {
+	if sealed == "" {
+		return nil
+	}
+	if secrets == nil {
+		return ErrNoSecretBox
+	}
+
+	plaintext, err := secrets.Open(sealed)
+	if err != nil {
+		return fmt.Errorf("open credentials: %w", err)
+	}
+
+	var creds taskCredentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return fmt.Errorf("unmarshal credentials: %w", err)
+	}
+
+	opts.Credentials = creds.Credentials
+	opts.URLCredentials = creds.URLCredentials
+	if opts.Proxy != nil {
+		opts.Proxy.Username = creds.ProxyUsername
+		opts.Proxy.Password = creds.ProxyPassword
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -22,7 +25,7 @@ func TestTaskStorage_SaveAndGet(t *testing.T) {
 		URLs:   []string{"https://example.com"},
 	}
 
-	if err := storage.Save(task); err != nil {
+	if err := storage.Save(context.Background(), task); err != nil {
 		t.Fatalf("Save error: %v", err)
 	}
 
@@ -77,10 +80,10 @@ func TestTaskStorage_GetAll(t *testing.T) {
 	task1 := &domain.Task{ID: "t1", Status: "pending"}
 	task2 := &domain.Task{ID: "t2", Status: "done"}
 
-	if err := storage.Save(task1); err != nil {
+	if err := storage.Save(context.Background(), task1); err != nil {
 		t.Fatalf("Save error: %v", err)
 	}
-	if err := storage.Save(task2); err != nil {
+	if err := storage.Save(context.Background(), task2); err != nil {
 		t.Fatalf("Save error: %v", err)
 	}
 
@@ -100,6 +103,24 @@ func TestTaskStorage_GetAll(t *testing.T) {
 	}
 }
 
+func TestTaskStorage_CountByStatus(t *testing.T) {
+	storage, err := NewTaskStorage(makeTempDir(t))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+
+	for i, status := range []domain.TaskStatus{domain.StatusPending, domain.StatusCompleted, domain.StatusCompleted} {
+		if err := storage.Save(context.Background(), &domain.Task{ID: string(rune('a' + i)), Status: status}); err != nil {
+			t.Fatalf("Save error: %v", err)
+		}
+	}
+
+	counts := storage.CountByStatus()
+	if counts[domain.StatusPending] != 1 || counts[domain.StatusCompleted] != 2 || counts[domain.StatusFailed] != 0 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+}
+
 func TestTaskStorage_GetNotFound(t *testing.T) {
 	dir := makeTempDir(t)
 	storage, err := NewTaskStorage(dir)
@@ -111,3 +132,104 @@ func TestTaskStorage_GetNotFound(t *testing.T) {
 		t.Errorf("expected error for missing task, got nil")
 	}
 }
+
+func TestTaskStorage_DeleteIf(t *testing.T) {
+	dir := makeTempDir(t)
+	storage, err := NewTaskStorage(dir)
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+	task := &domain.Task{ID: "task1", Status: domain.StatusCompleted}
+	if err := storage.Save(context.Background(), task); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	deleted, err := storage.DeleteIf("task1", func(t *domain.Task) bool { return t.Status == domain.StatusFailed })
+	if err != nil || deleted {
+		t.Fatalf("expected task to be kept when the condition fails, got deleted=%v err=%v", deleted, err)
+	}
+
+	deleted, err = storage.DeleteIf("task1", func(t *domain.Task) bool { return t.Status == domain.StatusCompleted })
+	if err != nil || !deleted {
+		t.Fatalf("expected task to be deleted, got deleted=%v err=%v", deleted, err)
+	}
+	if _, err := storage.Get("task1"); err == nil {
+		t.Errorf("expected deleted task to be gone from memory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "task1.json")); !os.IsNotExist(err) {
+		t.Errorf("expected task file to be removed, got %v", err)
+	}
+
+	deleted, err = storage.DeleteIf("missing", func(*domain.Task) bool { return true })
+	if err != nil || deleted {
+		t.Errorf("expected missing task to be a no-op, got deleted=%v err=%v", deleted, err)
+	}
+}
+
+func TestTaskStorage_CredentialsEncryptedAtRest(t *testing.T) {
+	dir := makeTempDir(t)
+	box, err := NewSecretBox(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("NewSecretBox error: %v", err)
+	}
+
+	storage, err := NewTaskStorage(dir, WithSecretBox(box))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+
+	task := &domain.Task{
+		ID:     "secret",
+		Status: domain.StatusPending,
+		URLs:   []string{"https://example.com/a"},
+		Options: domain.TaskOptions{
+			Credentials: &domain.RequestCredentials{BearerToken: "super-secret-token"},
+			URLCredentials: map[string]domain.RequestCredentials{
+				"https://example.com/a": {BasicAuth: &domain.BasicAuth{Username: "user", Password: "hunter2"}},
+			},
+		},
+	}
+	if err := storage.Save(context.Background(), task); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "secret.json"))
+	if err != nil {
+		t.Fatalf("ReadFile error: %v", err)
+	}
+	if bytes.Contains(raw, []byte("super-secret-token")) || bytes.Contains(raw, []byte("hunter2")) {
+		t.Fatalf("expected credentials to be encrypted on disk, got %s", raw)
+	}
+
+	reloaded, err := NewTaskStorage(dir, WithSecretBox(box))
+	if err != nil {
+		t.Fatalf("NewTaskStorage reload error: %v", err)
+	}
+	got, err := reloaded.Get("secret")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	creds := got.Options.CredentialsFor("https://example.com/a")
+	if creds.BasicAuth == nil || creds.BasicAuth.Password != "hunter2" || creds.BearerToken != "" {
+		t.Errorf("expected per-URL basic auth to override bearer token, got %+v", creds)
+	}
+
+	if _, err := NewTaskStorage(dir); err == nil {
+		t.Errorf("expected error loading sealed credentials without a key")
+	}
+}
+
+func TestTaskStorage_CredentialsWithoutKey(t *testing.T) {
+	storage, err := NewTaskStorage(makeTempDir(t))
+	if err != nil {
+		t.Fatalf("NewTaskStorage error: %v", err)
+	}
+
+	task := &domain.Task{
+		ID:      "nokey",
+		Options: domain.TaskOptions{Credentials: &domain.RequestCredentials{BearerToken: "token"}},
+	}
+	if err := storage.Save(context.Background(), task); !errors.Is(err, ErrNoSecretBox) {
+		t.Errorf("expected ErrNoSecretBox, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSetup_ExportsSpans(t *testing.T) {
+	var exports atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/v1/traces" {
+			exports.Add(1)
+		}
+	}))
+	defer collector.Close()
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	shutdown, err := Setup(context.Background(), Config{
+		Enabled:     true,
+		Endpoint:    collector.URL,
+		ServiceName: "test",
+		SampleRatio: 1,
+	})
+	if err != nil {
+		t.Fatalf("Setup error: %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "work")
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error: %v", err)
+	}
+	if exports.Load() == 0 {
+		t.Fatal("expected spans to be exported to the collector")
+	}
+}
+
+func TestSetup_Disabled(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("Setup error: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error: %v", err)
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	if _, err := Setup(context.Background(), Config{}); err != nil {
+		t.Fatalf("Setup error: %v", err)
+	}
+
+	if carrier := Inject(context.Background()); carrier != nil {
+		t.Fatalf("expected no carrier without a trace, got %v", carrier)
+	}
+
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	carrier := Inject(trace.ContextWithSpanContext(context.Background(), parent))
+	if carrier["traceparent"] == "" {
+		t.Fatalf("expected traceparent in carrier, got %v", carrier)
+	}
+
+	restored := trace.SpanContextFromContext(Extract(context.Background(), carrier))
+	if restored.TraceID() != parent.TraceID() || restored.SpanID() != parent.SpanID() {
+		t.Fatalf("expected %v, got %v", parent, restored)
+	}
+	if !restored.IsRemote() {
+		t.Fatal("expected restored span context to be remote")
+	}
+}
@@ -5,12 +5,45 @@ import (
 	"net"
 	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/go-playground/validator/v10"
 )
 
 var validate *validator.Validate
 
+// SchemeRegistry reports which URL schemes can be downloaded and whether
+// their URLs must name a host. It is implemented by worker.Registry.
+type SchemeRegistry interface {
+	Supports(scheme string) bool
+	RequiresHost(scheme string) bool
+}
+
+type staticSchemes map[string]bool
+
+func (s staticSchemes) Supports(scheme string) bool { return s[scheme] }
+
+func (s staticSchemes) RequiresHost(string) bool { return true }
+
+var (
+	schemesMu sync.RWMutex
+	schemes   SchemeRegistry = staticSchemes{"http": true, "https": true, "ftp": true, "sftp": true}
+)
+
+// UseSchemeRegistry makes URL validation accept exactly the schemes the registry supports.
+// It is intended to be called once at startup.
+func UseSchemeRegistry(r SchemeRegistry) {
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+	schemes = r
+}
+
+func currentSchemes() SchemeRegistry {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+	return schemes
+}
+
 func init() {
 	validate = validator.New()
 	_ = validate.RegisterValidation("safe_url", validateSafeURL)
@@ -28,7 +61,8 @@ func ValidateURLs(urls []string) error {
 }
 
 // validateSafeURL is a custom validator function to ensure URLs are safe.
-// It checks for valid schemes (http/https) and disallows private or loopback addresses.
+// It checks the scheme against the registry (http, https, ftp and sftp by default)
+// and disallows private or loopback addresses.
 func validateSafeURL(fl validator.FieldLevel) bool {
 	urlStr := fl.Field().String()
 
@@ -37,16 +71,24 @@ func validateSafeURL(fl validator.FieldLevel) bool {
 		return false
 	}
 
-	if u.Scheme != "http" && u.Scheme != "https" {
+	registry := currentSchemes()
+	if !registry.Supports(u.Scheme) {
 		return false
 	}
 
+	if !registry.RequiresHost(u.Scheme) {
+		return true
+	}
+
 	if u.Host == "" {
 		return false
 	}
 
-	host := u.Hostname()
+	return isSafeHost(u.Hostname())
+}
 
+// isSafeHost disallows local, loopback and private hosts.
+func isSafeHost(host string) bool {
 	forbiddenHosts := []string{
 		"localhost",
 		"127.0.0.1",
@@ -69,3 +111,73 @@ func validateSafeURL(fl validator.FieldLevel) bool {
 
 	return true
 }
+
+// ValidateCallbackURL checks that a webhook callback URL uses http or https and
+// does not point at a local or private address.
+func ValidateCallbackURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL %q: %w", callbackURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid callback URL %q: unsupported scheme", callbackURL)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid callback URL %q: missing host", callbackURL)
+	}
+	if !isSafeHost(u.Hostname()) {
+		return fmt.Errorf("invalid callback URL %q: local and private addresses are not allowed", callbackURL)
+	}
+	return nil
+}
+
+var (
+	proxyHostsMu sync.RWMutex
+	proxyHosts   []string
+)
+
+// UseProxyAllowlist sets the proxy hosts tasks may select even though they are local or
+// private. It is intended to be called once at startup with the configured list.
+func UseProxyAllowlist(hosts []string) {
+	proxyHostsMu.Lock()
+	defer proxyHostsMu.Unlock()
+	proxyHosts = hosts
+}
+
+func allowedProxyHost(host string) bool {
+	proxyHostsMu.RLock()
+	defer proxyHostsMu.RUnlock()
+	for _, allowed := range proxyHosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateProxyURL checks that a per-task proxy URL uses a supported scheme (http, https,
+// socks5, socks5h) and names a host. Like download URLs, it must not point at a local or
+// private address unless the host is on the allowlist set by UseProxyAllowlist; otherwise
+// a task could reach internal hosts through its proxy.
+func ValidateProxyURL(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL %q: %w", proxyURL, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("invalid proxy URL %q: unsupported scheme", proxyURL)
+	}
+
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid proxy URL %q: missing host", proxyURL)
+	}
+
+	if !allowedProxyHost(u.Hostname()) && !isSafeHost(u.Hostname()) {
+		return fmt.Errorf("invalid proxy URL %q: local and private addresses are not allowed", proxyURL)
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+// Package webhook notifies callback URLs when tasks finish.
+//
+// Each delivery is a POST of the rendered task with a JSON body. When a secret is
+// configured the body is signed: the X-Webhook-Signature header carries
+// "sha256=" followed by the hex HMAC-SHA256 of the X-Webhook-Timestamp value,
+// a dot and the body. Receivers should reject stale timestamps to prevent replays.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+// Headers sent with every delivery. IDHeader carries the task ID and stays the
+// same across retries so that receivers can drop duplicates.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	IDHeader        = "X-Webhook-ID"
+)
+
+// Config controls where and how deliveries are made.
+type Config struct {
+	// DefaultURL is used for tasks created without their own callback URL.
+	DefaultURL string
+	// Secret signs payloads; deliveries are unsigned when it is empty.
+	Secret         []byte
+	MaxAttempts    int
+	Timeout        time.Duration
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultConfig returns the retry and timeout settings used when none are configured.
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts:    8,
+		Timeout:        10 * time.Second,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     10 * time.Minute,
+	}
+}
+
+// PayloadFunc renders the task sent in a delivery body.
+type PayloadFunc func(task *domain.Task) any
+
+// Notifier delivers finished tasks to their callback URLs.
+type Notifier struct {
+	cfg     Config
+	payload PayloadFunc
+	client  *http.Client
+	logger  *slog.Logger
+}
+
+// New creates a Notifier. Callback URLs must have been checked with
+// validation.ValidateCallbackURL before they reach the Notifier; redirects are
+// never followed so that a validated URL cannot bounce to an internal address.
+func New(cfg Config, payload PayloadFunc, logger *slog.Logger) *Notifier {
+	defaults := DefaultConfig()
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaults.MaxAttempts
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaults.InitialBackoff
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = max(defaults.MaxBackoff, cfg.InitialBackoff)
+	}
+
+	return &Notifier{
+		cfg:     cfg,
+		payload: payload,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger: logger,
+	}
+}
+
+// URL returns the callback URL for a task: its own when set, otherwise the default.
+// It is empty when the task should not be notified.
+func (n *Notifier) URL(callbackURL string) string {
+	if callbackURL != "" {
+		return callbackURL
+	}
+	return n.cfg.DefaultURL
+}
+
+// Pending reports whether a finished task still has deliveries to make,
+// for example because the service stopped while retrying.
+func (n *Notifier) Pending(task *domain.Task) bool {
+	return task.Status.IsTerminal() &&
+		task.Webhook != nil &&
+		!task.Webhook.Delivered &&
+		len(task.Webhook.Deliveries) < n.cfg.MaxAttempts
+}
+
+// Body renders the delivery body for task.
+func (n *Notifier) Body(task *domain.Task) ([]byte, error) {
+	body, err := json.Marshal(n.payload(task))
+	if err != nil {
+		return nil, fmt.Errorf("encode webhook payload: %w", err)
+	}
+	return body, nil
+}
+
+// Deliver POSTs body to the task's callback URL until it is accepted, the attempts
+// run out or ctx is done. Attempts are numbered from len(task.Webhook.Deliveries)+1
+// so that a resumed delivery continues the log; record is called after each one.
+func (n *Notifier) Deliver(ctx context.Context, task *domain.Task, body []byte, record func(domain.WebhookDelivery)) error {
+	for attempt := len(task.Webhook.Deliveries) + 1; attempt <= n.cfg.MaxAttempts; attempt++ {
+		delivery := domain.WebhookDelivery{Attempt: attempt, At: time.Now()}
+		retry, err := n.post(ctx, task, body, &delivery)
+		if ctx.Err() != nil {
+			// Interrupted attempts are not logged so that they are retried after a restart.
+			return ctx.Err()
+		}
+		record(delivery)
+
+		if err == nil {
+			n.logger.Info("webhook delivered",
+				"task_id", task.ID,
+				"attempt", attempt,
+				"status_code", delivery.StatusCode,
+			)
+			return nil
+		}
+
+		n.logger.Warn("webhook delivery failed",
+			"task_id", task.ID,
+			"attempt", attempt,
+			"error", err,
+		)
+		if !retry {
+			return err
+		}
+		if attempt == n.cfg.MaxAttempts {
+			return fmt.Errorf("webhook not delivered after %d attempts: %w", attempt, err)
+		}
+
+		select {
+		case <-time.After(n.backoff(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return errors.New("webhook delivery attempts exhausted")
+}
+
+// post makes one delivery attempt and fills in its outcome. It reports whether
+// a failed attempt is worth retrying: network errors, timeouts, throttling and
+// server errors are; other rejections by the receiver are not.
+func (n *Notifier) post(ctx context.Context, task *domain.Task, body []byte, delivery *domain.WebhookDelivery) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, task.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(delivery.At.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "url-downloader-webhook")
+	req.Header.Set(EventHeader, "task."+string(task.Status))
+	req.Header.Set(IDHeader, task.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	if len(n.cfg.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(n.cfg.Secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		delivery.Success = true
+		return false, nil
+	}
+
+	err = fmt.Errorf("callback responded with %s", resp.Status)
+	delivery.Error = err.Error()
+	retry := resp.StatusCode >= 500 ||
+		resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+// backoff returns the delay after the given failed attempt: the initial backoff
+// doubled for every earlier attempt, capped at the maximum.
+func (n *Notifier) backoff(attempt int) time.Duration {
+	delay := n.cfg.InitialBackoff
+	for i := 1; i < attempt && delay < n.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, n.cfg.MaxBackoff)
+}
+
+// Sign returns the signature header value for a payload sent at timestamp.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+func newTestNotifier(cfg Config) *Notifier {
+	cfg.InitialBackoff = time.Millisecond
+	cfg.MaxBackoff = 5 * time.Millisecond
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return New(cfg, func(task *domain.Task) any {
+		return map[string]any{"id": task.ID, "status": task.Status}
+	}, logger)
+}
+
+func finishedTask(url string) *domain.Task {
+	return &domain.Task{ID: "task-1", Status: domain.StatusCompleted, Webhook: &domain.Webhook{URL: url}}
+}
+
+// statusServer answers with the given status codes in turn, repeating the last one.
+func statusServer(t *testing.T, codes ...int) (*httptest.Server, func() []*http.Request) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		requests = append(requests, r)
+		w.WriteHeader(codes[min(len(requests), len(codes))-1])
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []*http.Request {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*http.Request(nil), requests...)
+	}
+}
+
+func TestDeliver_Signed(t *testing.T) {
+	server, requests := statusServer(t, http.StatusNoContent)
+	secret := []byte("s3cret")
+	n := newTestNotifier(Config{Secret: secret})
+
+	task := finishedTask(server.URL)
+	body, err := n.Body(task)
+	if err != nil {
+		t.Fatalf("Body error: %v", err)
+	}
+
+	var log []domain.WebhookDelivery
+	if err := n.Deliver(context.Background(), task, body, func(d domain.WebhookDelivery) { log = append(log, d) }); err != nil {
+		t.Fatalf("Deliver error: %v", err)
+	}
+
+	if len(log) != 1 || !log[0].Success || log[0].StatusCode != http.StatusNoContent || log[0].Attempt != 1 {
+		t.Fatalf("unexpected delivery log: %+v", log)
+	}
+
+	req := requests()[0]
+	got, _ := io.ReadAll(req.Body)
+	var payload map[string]string
+	if err := json.Unmarshal(got, &payload); err != nil || payload["id"] != "task-1" {
+		t.Fatalf("unexpected payload %s: %v", got, err)
+	}
+	if want := Sign(secret, req.Header.Get(TimestampHeader), got); req.Header.Get(SignatureHeader) != want {
+		t.Fatalf("expected signature %q, got %q", want, req.Header.Get(SignatureHeader))
+	}
+	if req.Header.Get(IDHeader) != "task-1" || req.Header.Get(EventHeader) != "task.completed" {
+		t.Fatalf("unexpected headers: %v", req.Header)
+	}
+}
+
+func TestDeliver_Unsigned(t *testing.T) {
+	server, requests := statusServer(t, http.StatusOK)
+	n := newTestNotifier(Config{})
+
+	if err := n.Deliver(context.Background(), finishedTask(server.URL), []byte(`{}`), func(domain.WebhookDelivery) {}); err != nil {
+		t.Fatalf("Deliver error: %v", err)
+	}
+	if sig := requests()[0].Header.Get(SignatureHeader); sig != "" {
+		t.Fatalf("expected no signature without a secret, got %q", sig)
+	}
+}
+
+func TestDeliver_Retries(t *testing.T) {
+	tests := []struct {
+		name        string
+		codes       []int
+		maxAttempts int
+		wantLog     []int
+		wantErr     bool
+	}{
+		{"server errors then success", []int{500, 503, 200}, 5, []int{500, 503, 200}, false},
+		{"throttled then success", []int{429, 200}, 5, []int{429, 200}, false},
+		{"client error is final", []int{400, 200}, 5, []int{400}, true},
+		{"redirect is not followed", []int{302, 200}, 5, []int{302}, true},
+		{"attempts exhausted", []int{500}, 3, []int{500, 500, 500}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := statusServer(t, tt.codes...)
+			n := newTestNotifier(Config{MaxAttempts: tt.maxAttempts})
+
+			var log []domain.WebhookDelivery
+			err := n.Deliver(context.Background(), finishedTask(server.URL), []byte(`{}`), func(d domain.WebhookDelivery) { log = append(log, d) })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if len(log) != len(tt.wantLog) || len(requests()) != len(tt.wantLog) {
+				t.Fatalf("expected %d attempts, got log %+v and %d requests", len(tt.wantLog), log, len(requests()))
+			}
+			for i, code := range tt.wantLog {
+				if log[i].StatusCode != code || log[i].Attempt != i+1 {
+					t.Fatalf("attempt %d: expected status %d, got %+v", i+1, code, log[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDeliver_ResumesAttemptNumbering(t *testing.T) {
+	server, _ := statusServer(t, http.StatusOK)
+	n := newTestNotifier(Config{MaxAttempts: 5})
+
+	task := finishedTask(server.URL)
+	task.Webhook.Deliveries = []domain.WebhookDelivery{{Attempt: 1}, {Attempt: 2}}
+	if !n.Pending(task) {
+		t.Fatal("expected task with failed attempts to be pending")
+	}
+
+	var log []domain.WebhookDelivery
+	if err := n.Deliver(context.Background(), task, []byte(`{}`), func(d domain.WebhookDelivery) { log = append(log, d) }); err != nil {
+		t.Fatalf("Deliver error: %v", err)
+	}
+	if len(log) != 1 || log[0].Attempt != 3 {
+		t.Fatalf("expected attempt 3, got %+v", log)
+	}
+
+	task.Webhook.Deliveries = make([]domain.WebhookDelivery, 5)
+	if n.Pending(task) {
+		t.Fatal("expected task without attempts left not to be pending")
+	}
+}
+
+func TestDeliver_CancelledNotRecorded(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	n := newTestNotifier(Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var log []domain.WebhookDelivery
+	err := n.Deliver(ctx, finishedTask(server.URL), []byte(`{}`), func(d domain.WebhookDelivery) { log = append(log, d) })
+	if err == nil || len(log) != 0 {
+		t.Fatalf("expected interrupted delivery to be unrecorded, got err %v and log %+v", err, log)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	n := New(Config{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}, nil, nil)
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := n.backoff(i + 1); got != w {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, w, got)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// ActiveDownload describes a URL that is currently being downloaded.
+type ActiveDownload struct {
+	TaskID    string    `json:"task_id"`
+	URL       string    `json:"url"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// activeDownloads tracks in-flight downloads for diagnostics.
+type activeDownloads struct {
+	mu    sync.Mutex
+	next  uint64
+	items map[uint64]ActiveDownload
+}
+
+// add records a download and returns the function that removes it again.
+func (a *activeDownloads) add(taskID, rawURL string) func() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.items == nil {
+		a.items = make(map[uint64]ActiveDownload)
+	}
+	id := a.next
+	a.next++
+	a.items[id] = ActiveDownload{TaskID: taskID, URL: spanURL(rawURL), StartedAt: time.Now()}
+
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.items, id)
+	}
+}
+
+// ActiveDownloads returns the downloads in progress, oldest first. URLs are
+// stripped of user info, query and fragment so that no secrets are exposed.
+func (w *DownloadWorker) ActiveDownloads() []ActiveDownload {
+	w.active.mu.Lock()
+	defer w.active.mu.Unlock()
+
+	downloads := make([]ActiveDownload, 0, len(w.active.items))
+	for _, d := range w.active.items {
+		downloads = append(downloads, d)
+	}
+	slices.SortFunc(downloads, func(a, b ActiveDownload) int { return a.StartedAt.Compare(b.StartedAt) })
+	return downloads
+}
@@ -0,0 +1,33 @@
+package worker
+
+import (
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// conditionalDownload returns the task's validators for rawURL as a cache entry without
+// a stored copy, or nil when the task has none or a partial download is being resumed.
+func conditionalDownload(rawURL string, offset int64, opts domain.TaskOptions) *storage.CacheEntry {
+	v, ok := opts.Validators[rawURL]
+	if !ok || offset > 0 || (v.ETag == "" && v.LastModified == "") {
+		return nil
+	}
+	return &storage.CacheEntry{ETag: v.ETag, LastModified: v.LastModified}
+}
+
+// unchanged completes result for a resource the server reported as not modified
+// when there is no stored copy to link to.
+func unchanged(result *domain.DownloadResult, entry *storage.CacheEntry, src *FetchResponse) {
+	result.FileName = ""
+	result.Unchanged = true
+	result.Success = true
+	result.Proxy = src.Proxy
+	result.ETag = entry.ETag
+	result.LastModified = entry.LastModified
+	if src.ETag != "" {
+		result.ETag = src.ETag
+	}
+	if src.LastModified != "" {
+		result.LastModified = src.LastModified
+	}
+}
@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+func TestDownloadWorker_DownloadURL_Validators(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		if r.Header.Get("If-None-Match") == `"v2"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "version two")
+	}))
+	defer server.Close()
+
+	dir := makeTempDir(t)
+	fs := storage.NewFileStorage(dir)
+	worker := NewDownloadWorker(fs, newTestLogger())
+	ctx := context.Background()
+
+	current := domain.TaskOptions{Validators: map[string]domain.Validators{server.URL: {ETag: `"v2"`}}}
+	result, err := worker.DownloadURL(ctx, server.URL, "same", current)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if !result.Success || !result.Unchanged || result.FileName != "" || result.ETag != `"v2"` {
+		t.Errorf("expected an unchanged result without a file, got %+v", result)
+	}
+	if fs.FileExists(worker.generateFilename(server.URL, "same")) {
+		t.Errorf("expected nothing to be written for an unchanged resource")
+	}
+
+	stale := domain.TaskOptions{Validators: map[string]domain.Validators{server.URL: {ETag: `"v1"`}}}
+	result, err = worker.DownloadURL(ctx, server.URL, "changed", stale)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if result.Unchanged || result.BytesRead != int64(len("version two")) || result.ETag != `"v2"` {
+		t.Errorf("expected a changed resource to be downloaded, got %+v", result)
+	}
+}
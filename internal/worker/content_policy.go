@@ -0,0 +1,97 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// sniffLength is the number of leading bytes inspected to detect the real content type.
+const sniffLength = 3072
+
+// ErrContentTypeNotAllowed is returned when a response or its sniffed content
+// does not match the configured MIME type allowlist.
+var ErrContentTypeNotAllowed = errors.New("content type not allowed")
+
+// ContentPolicy restricts which MIME types may be downloaded.
+// An empty allowlist permits every type.
+type ContentPolicy struct {
+	Allowed []string
+}
+
+// Allows reports whether the given MIME type matches the allowlist.
+// Entries may be exact types ("application/pdf") or wildcards ("text/*", "*/*").
+// MIME parameters such as charset are ignored.
+func (p ContentPolicy) Allows(mimeType string) bool {
+	if len(p.Allowed) == 0 {
+		return true
+	}
+
+	mediaType := normalizeMediaType(mimeType)
+	if mediaType == "" {
+		return false
+	}
+
+	for _, allowed := range p.Allowed {
+		if matchMediaType(normalizeMediaType(allowed), mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsDetected reports whether the sniffed MIME type, or one of its aliases, is allowed.
+// Parent types are deliberately not considered, so allowing text/plain does not allow text/html.
+func (p ContentPolicy) allowsDetected(detected *mimetype.MIME) bool {
+	if len(p.Allowed) == 0 {
+		return true
+	}
+	if p.Allows(detected.String()) {
+		return true
+	}
+	for _, allowed := range p.Allowed {
+		if detected.Is(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkContent verifies the declared Content-Type header and the sniffed leading bytes
+// against every policy. It returns the detected MIME type (without parameters).
+func checkContent(policies []ContentPolicy, contentType string, head []byte) (string, error) {
+	detected := mimetype.Detect(head)
+	detectedType := normalizeMediaType(detected.String())
+
+	for _, policy := range policies {
+		if contentType != "" && !policy.Allows(contentType) {
+			return detectedType, fmt.Errorf("%w: declared %s", ErrContentTypeNotAllowed, normalizeMediaType(contentType))
+		}
+		if !policy.allowsDetected(detected) {
+			return detectedType, fmt.Errorf("%w: detected %s", ErrContentTypeNotAllowed, detectedType)
+		}
+	}
+
+	return detectedType, nil
+}
+
+func normalizeMediaType(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(value))
+	}
+	return mediaType
+}
+
+func matchMediaType(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == "*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return pattern == mediaType
+}
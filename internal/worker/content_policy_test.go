@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"testing"
+)
+
+func TestContentPolicy_Allows(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  []string
+		mimeType string
+		want     bool
+	}{
+		{
+			name:     "empty allowlist permits everything",
+			allowed:  nil,
+			mimeType: "application/x-msdownload",
+			want:     true,
+		},
+		{
+			name:     "exact match",
+			allowed:  []string{"application/pdf"},
+			mimeType: "application/pdf",
+			want:     true,
+		},
+		{
+			name:     "parameters are ignored",
+			allowed:  []string{"text/csv"},
+			mimeType: "text/csv; charset=utf-8",
+			want:     true,
+		},
+		{
+			name:     "wildcard subtype",
+			allowed:  []string{"image/*"},
+			mimeType: "image/png",
+			want:     true,
+		},
+		{
+			name:     "not in allowlist",
+			allowed:  []string{"application/pdf", "text/csv"},
+			mimeType: "text/html",
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := ContentPolicy{Allowed: tt.allowed}
+			if got := policy.Allows(tt.mimeType); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.mimeType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckContent_SniffedTypeMustMatch(t *testing.T) {
+	policies := []ContentPolicy{{Allowed: []string{"application/pdf"}}}
+
+	if _, err := checkContent(policies, "application/pdf", []byte("%PDF-1.7\n")); err != nil {
+		t.Errorf("expected PDF to be allowed, got %v", err)
+	}
+
+	detected, err := checkContent(policies, "application/pdf", []byte("<!DOCTYPE html><html><body>404</body></html>"))
+	if err == nil {
+		t.Fatalf("expected HTML masquerading as PDF to be rejected")
+	}
+	if detected != "text/html" {
+		t.Errorf("expected detected type text/html, got %q", detected)
+	}
+}
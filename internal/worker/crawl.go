@@ -0,0 +1,344 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"golang.org/x/net/html"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// maxCrawlPageSize limits how much of an index page is parsed for links.
+	maxCrawlPageSize = 10 << 20
+	// defaultCrawlMaxFiles caps the files downloaded by one crawl when the task sets no limit.
+	defaultCrawlMaxFiles = 1000
+	// crawlConcurrency is the number of files a crawl downloads in parallel.
+	crawlConcurrency = 4
+)
+
+// ErrDisallowedByRobots is returned when robots.txt disallows the start page of a crawl.
+var ErrDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+// crawler walks index pages breadth first and collects the file links to download.
+type crawler struct {
+	w        *DownloadWorker
+	start    *url.URL
+	opts     domain.TaskOptions
+	include  []*regexp.Regexp
+	exclude  []*regexp.Regexp
+	maxFiles int
+	robots   robotsCache
+	visited  map[string]bool
+	files    []string
+}
+
+// crawl downloads every file linked from the index page at rawURL, following
+// subdirectory links as configured by opts.Crawl. Each file is downloaded and
+// validated independently and reported as a child of the returned result.
+func (w *DownloadWorker) crawl(ctx context.Context, rawURL string, taskID string, opts domain.TaskOptions) (domain.DownloadResult, error) {
+	result := domain.DownloadResult{URL: rawURL}
+
+	fail := func(err error) (domain.DownloadResult, error) {
+		result.Error = err.Error()
+		w.logger.Error("crawl failed",
+			"url", rawURL,
+			"error", err,
+		)
+		return result, err
+	}
+
+	c, err := w.newCrawler(rawURL, opts)
+	if err != nil {
+		return fail(err)
+	}
+	if err := c.walk(ctx); err != nil {
+		return fail(err)
+	}
+
+	result.Children = make([]domain.DownloadResult, len(c.files))
+	var (
+		mu     sync.Mutex
+		failed int
+	)
+
+	g := new(errgroup.Group)
+	g.SetLimit(crawlConcurrency)
+	for i, file := range c.files {
+		g.Go(func() error {
+			child, err := c.download(ctx, file, taskID)
+
+			mu.Lock()
+			defer mu.Unlock()
+			result.Children[i] = child
+			result.BytesRead += child.BytesRead
+			if err != nil {
+				failed++
+			}
+			w.reportProgress(taskID, crawlSnapshot(result))
+			return nil
+		})
+	}
+	g.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+	if failed > 0 {
+		return fail(fmt.Errorf("%d of %d crawled files failed", failed, len(c.files)))
+	}
+
+	result.Success = true
+
+	return result, nil
+}
+
+// crawlSnapshot copies a crawl result so that progress reports do not share the
+// children slice that is still being filled in.
+func crawlSnapshot(result domain.DownloadResult) domain.DownloadResult {
+	snapshot := result
+	snapshot.Children = nil
+	for _, child := range result.Children {
+		if child.URL != "" {
+			snapshot.Children = append(snapshot.Children, child)
+		}
+	}
+	return snapshot
+}
+
+func (w *DownloadWorker) newCrawler(rawURL string, opts domain.TaskOptions) (*crawler, error) {
+	start, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse URL: %w", err)
+	}
+	start.Fragment = ""
+
+	c := &crawler{
+		w:        w,
+		start:    start,
+		opts:     opts,
+		maxFiles: opts.Crawl.MaxFiles,
+		visited:  map[string]bool{start.String(): true},
+	}
+	if c.maxFiles <= 0 {
+		c.maxFiles = defaultCrawlMaxFiles
+	}
+
+	for _, pattern := range opts.Crawl.Include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+		c.include = append(c.include, re)
+	}
+	for _, pattern := range opts.Crawl.Exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+		c.exclude = append(c.exclude, re)
+	}
+
+	return c, nil
+}
+
+// walk visits the start page and, breadth first, its subdirectory pages up to the
+// configured depth. Subdirectory pages that fail to load are logged and skipped.
+// The crawl fails when robots.txt disallows the start page itself.
+func (c *crawler) walk(ctx context.Context) error {
+	type page struct {
+		url   *url.URL
+		depth int
+	}
+	if !c.robots.allowed(ctx, c.w, c.start, c.credentials(c.start)) {
+		return fmt.Errorf("%w: %s", ErrDisallowedByRobots, c.start)
+	}
+	queue := []page{{url: c.start}}
+
+	for len(queue) > 0 && len(c.files) < c.maxFiles {
+		p := queue[0]
+		queue = queue[1:]
+
+		links, err := c.links(ctx, p.url)
+		if err != nil {
+			if p.depth == 0 || ctx.Err() != nil {
+				return err
+			}
+			c.w.logger.Warn("skipping crawl page",
+				"url", p.url.String(),
+				"error", err,
+			)
+			continue
+		}
+
+		for _, link := range links {
+			key := link.String()
+			if c.visited[key] || !c.follows(link) {
+				continue
+			}
+			c.visited[key] = true
+
+			if err := c.w.validateURLs([]string{key}); err != nil {
+				c.w.logger.Warn("skipping unsafe crawl link",
+					"url", key,
+					"error", err,
+				)
+				continue
+			}
+			if !c.robots.allowed(ctx, c.w, link, c.credentials(link)) {
+				continue
+			}
+
+			if strings.HasSuffix(link.Path, "/") {
+				if p.depth < c.opts.Crawl.Depth && c.underStart(link) {
+					queue = append(queue, page{url: link, depth: p.depth + 1})
+				}
+				continue
+			}
+
+			if c.matches(key) && len(c.files) < c.maxFiles {
+				c.files = append(c.files, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// links fetches an HTML page and returns the absolute targets of its anchors,
+// without fragments and without links back to the same path (such as the
+// column sorting links of Apache and nginx indexes).
+func (c *crawler) links(ctx context.Context, page *url.URL) ([]*url.URL, error) {
+	resp, err := c.w.open(ctx, page, 0, c.credentials(page))
+	if err != nil {
+		return nil, fmt.Errorf("fetch page: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := normalizeMediaType(resp.ContentType); ct != "" && ct != "text/html" && ct != "application/xhtml+xml" {
+		return nil, fmt.Errorf("not an HTML page: %s", ct)
+	}
+
+	base := page
+	var links []*url.URL
+
+	tokenizer := html.NewTokenizer(io.LimitReader(resp.Body, maxCrawlPageSize))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("read page: %w", err)
+			}
+			return links, nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if !hasAttr || (string(name) != "a" && string(name) != "base") {
+				continue
+			}
+
+			var href string
+			for {
+				key, value, more := tokenizer.TagAttr()
+				if string(key) == "href" {
+					href = strings.TrimSpace(string(value))
+				}
+				if !more {
+					break
+				}
+			}
+			if href == "" {
+				continue
+			}
+
+			u, err := base.Parse(href)
+			if err != nil {
+				continue
+			}
+			if string(name) == "base" {
+				base = u
+				continue
+			}
+
+			u.Fragment = ""
+			u.RawFragment = ""
+			if u.Scheme == page.Scheme && u.Host == page.Host && u.Path == page.Path {
+				continue
+			}
+			links = append(links, u)
+		}
+	}
+}
+
+// follows reports whether a link may be visited at all: it must be on the start
+// host unless external links are allowed.
+func (c *crawler) follows(link *url.URL) bool {
+	if c.opts.Crawl.AllowExternal {
+		return true
+	}
+	return link.Scheme == c.start.Scheme && link.Host == c.start.Host
+}
+
+// underStart reports whether a subdirectory link lies below the start page, so
+// that "Parent Directory" links never widen the crawl.
+func (c *crawler) underStart(link *url.URL) bool {
+	if link.Host != c.start.Host {
+		return c.opts.Crawl.AllowExternal
+	}
+	dir := c.start.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir) + "/"
+	}
+	return strings.HasPrefix(link.Path, dir)
+}
+
+// matches applies the include and exclude patterns to a file URL.
+func (c *crawler) matches(rawURL string) bool {
+	for _, re := range c.exclude {
+		if re.MatchString(rawURL) {
+			return false
+		}
+	}
+	if len(c.include) == 0 {
+		return true
+	}
+	for _, re := range c.include {
+		if re.MatchString(rawURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// credentials returns the start page credentials for links on the start host only,
+// so that task secrets are never sent to external hosts.
+func (c *crawler) credentials(u *url.URL) domain.RequestCredentials {
+	if u.Host != c.start.Host {
+		return domain.RequestCredentials{}
+	}
+	return c.opts.CredentialsFor(c.start.String())
+}
+
+// download downloads one crawled file with the task options, minus the crawl itself
+// and any credentials when the file lives on another host.
+func (c *crawler) download(ctx context.Context, rawURL string, taskID string) (domain.DownloadResult, error) {
+	opts := c.opts
+	opts.Crawl = nil
+	opts.Mirrors = nil
+	opts.Credentials = nil
+	opts.URLCredentials = nil
+
+	if u, err := url.Parse(rawURL); err == nil && u.Host == c.start.Host {
+		opts.URLCredentials = map[string]domain.RequestCredentials{rawURL: c.credentials(u)}
+	}
+
+	return c.w.DownloadURL(ctx, rawURL, taskID, opts)
+}
@@ -0,0 +1,251 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// indexServer serves a small Apache-style directory tree under /pub/.
+func indexServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	pages := map[string]string{
+		"/pub/": `<html><body>
+			<a href="?C=N;O=D">Name</a>
+			<a href="/">Parent Directory</a>
+			<a href="a.txt">a.txt</a>
+			<a href="b.iso">b.iso</a>
+			<a href="a.txt#top">a.txt again</a>
+			<a href="sub/">sub/</a>
+			<a href="private.txt">private.txt</a>
+			<a href="http://external.example/x.txt">external</a>
+			<a href="mailto:admin@example.com">mail</a>
+		</body></html>`,
+		"/pub/sub/": `<html><body>
+			<a href="../">Parent Directory</a>
+			<a href="c.txt">c.txt</a>
+			<a href="deeper/">deeper/</a>
+		</body></html>`,
+		"/pub/sub/deeper/": `<a href="d.txt">d.txt</a>`,
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/robots.txt":
+			rw.Write([]byte("User-agent: *\nDisallow: /pub/private\n"))
+		case r.URL.Path == "/":
+			t.Errorf("crawl left the start directory")
+		case pages[r.URL.Path] != "":
+			rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+			rw.Write([]byte(pages[r.URL.Path]))
+		case strings.HasSuffix(r.URL.Path, ".txt") || strings.HasSuffix(r.URL.Path, ".iso"):
+			rw.Write([]byte("contents of " + r.URL.Path))
+		default:
+			http.NotFound(rw, r)
+		}
+	}))
+}
+
+func newCrawlWorker(t *testing.T) *DownloadWorker {
+	t.Helper()
+	w := NewDownloadWorker(storage.NewFileStorage(makeTempDir(t)), newTestLogger())
+	w.validateURLs = func([]string) error { return nil }
+	return w
+}
+
+func childPaths(t *testing.T, result domain.DownloadResult) []string {
+	t.Helper()
+	var paths []string
+	for _, child := range result.Children {
+		if !child.Success {
+			t.Fatalf("child %s failed: %s", child.URL, child.Error)
+		}
+		u, _ := url.Parse(child.URL)
+		paths = append(paths, u.Path)
+	}
+	return paths
+}
+
+func TestCrawl(t *testing.T) {
+	server := indexServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		crawl domain.CrawlOptions
+		want  []string
+	}{
+		{"start page only", domain.CrawlOptions{}, []string{"/pub/a.txt", "/pub/b.iso"}},
+		{"one level", domain.CrawlOptions{Depth: 1}, []string{"/pub/a.txt", "/pub/b.iso", "/pub/sub/c.txt"}},
+		{"two levels", domain.CrawlOptions{Depth: 2}, []string{"/pub/a.txt", "/pub/b.iso", "/pub/sub/c.txt", "/pub/sub/deeper/d.txt"}},
+		{"exclude", domain.CrawlOptions{Depth: 2, Exclude: []string{`\.iso$`, `/deeper/`}}, []string{"/pub/a.txt", "/pub/sub/c.txt"}},
+		{"include", domain.CrawlOptions{Depth: 2, Include: []string{`\.iso$`}}, []string{"/pub/b.iso"}},
+		{"max files", domain.CrawlOptions{Depth: 2, MaxFiles: 1}, []string{"/pub/a.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newCrawlWorker(t)
+
+			result, err := w.DownloadURL(context.Background(), server.URL+"/pub/", "task1", domain.TaskOptions{Crawl: &tt.crawl})
+			if err != nil {
+				t.Fatalf("DownloadURL error: %v", err)
+			}
+			if !result.Success {
+				t.Fatalf("expected success, got %+v", result)
+			}
+
+			got := childPaths(t, result)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+
+			var total int64
+			for _, child := range result.Children {
+				total += child.BytesRead
+			}
+			if result.BytesRead != total {
+				t.Fatalf("expected %d bytes, got %d", total, result.BytesRead)
+			}
+		})
+	}
+}
+
+func TestCrawl_TaskProxy(t *testing.T) {
+	// The index server answers absolute-form requests as well, so it doubles as a forward proxy
+	// for a host that does not resolve.
+	proxy := indexServer(t)
+	defer proxy.Close()
+
+	w := newCrawlWorker(t)
+	opts := domain.TaskOptions{
+		Crawl: &domain.CrawlOptions{},
+		Proxy: &domain.ProxyOptions{URL: proxy.URL},
+	}
+
+	result, err := w.DownloadURL(context.Background(), "http://files.example.test/pub/", "task1", opts)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if got, want := childPaths(t, result), []string{"/pub/a.txt", "/pub/b.iso"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for _, child := range result.Children {
+		if child.Proxy != proxy.URL {
+			t.Errorf("expected %s to be fetched through the task proxy, got %q", child.URL, child.Proxy)
+		}
+	}
+}
+
+func TestCrawl_UnsafeLinksSkipped(t *testing.T) {
+	server := indexServer(t)
+	defer server.Close()
+
+	// The default validator rejects the loopback test server, so every link is dropped.
+	w := NewDownloadWorker(storage.NewFileStorage(makeTempDir(t)), newTestLogger())
+
+	result, err := w.DownloadURL(context.Background(), server.URL+"/pub/", "task1", domain.TaskOptions{Crawl: &domain.CrawlOptions{Depth: 2}})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if len(result.Children) != 0 {
+		t.Fatalf("expected no children, got %+v", result.Children)
+	}
+}
+
+func TestCrawl_ChildFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			rw.Header().Set("Content-Type", "text/html")
+			rw.Write([]byte(`<a href="ok.txt">ok</a><a href="missing.txt">missing</a>`))
+		case "/ok.txt":
+			rw.Write([]byte("ok"))
+		default:
+			http.NotFound(rw, r)
+		}
+	}))
+	defer server.Close()
+
+	w := newCrawlWorker(t)
+
+	result, err := w.DownloadURL(context.Background(), server.URL+"/", "task1", domain.TaskOptions{Crawl: &domain.CrawlOptions{}})
+	if err == nil || result.Success {
+		t.Fatalf("expected the crawl to fail, got %+v", result)
+	}
+	if len(result.Children) != 2 || !result.Children[0].Success || result.Children[1].Success {
+		t.Fatalf("unexpected children %+v", result.Children)
+	}
+}
+
+func TestCrawl_StartPageDisallowed(t *testing.T) {
+	server := indexServer(t)
+	defer server.Close()
+
+	w := newCrawlWorker(t)
+
+	result, err := w.DownloadURL(context.Background(), server.URL+"/pub/private/", "task1", domain.TaskOptions{Crawl: &domain.CrawlOptions{}})
+	if !errors.Is(err, ErrDisallowedByRobots) {
+		t.Fatalf("expected ErrDisallowedByRobots, got %v", err)
+	}
+	if result.Success || len(result.Children) != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestCrawl_NotHTML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/octet-stream")
+		rw.Write([]byte("binary"))
+	}))
+	defer server.Close()
+
+	w := newCrawlWorker(t)
+
+	_, err := w.DownloadURL(context.Background(), server.URL+"/file.bin", "task1", domain.TaskOptions{Crawl: &domain.CrawlOptions{}})
+	if err == nil || !strings.Contains(err.Error(), "not an HTML page") {
+		t.Fatalf("expected not an HTML page error, got %v", err)
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	rules := parseRobots([]byte(`
+User-agent: googlebot
+Disallow: /
+
+User-agent: other
+User-agent: *
+Disallow: /private/
+Allow: /private/public/
+Disallow: /*.pdf$
+Disallow: /search?q=  # query strings
+`))
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/private/x", false},
+		{"/private/public/x", true},
+		{"/docs/a.pdf", false},
+		{"/docs/a.pdf.txt", true},
+		{"/search?q=go", false},
+		{"/search", true},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse("http://example.com" + tt.path)
+		if got := rules.allowed(u); got != tt.want {
+			t.Errorf("allowed(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package worker
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mpd mirrors the subset of the DASH MPD schema needed to enumerate segments.
+type mpd struct {
+	Type                      string      `xml:"type,attr"`
+	MediaPresentationDuration string      `xml:"mediaPresentationDuration,attr"`
+	BaseURL                   string      `xml:"BaseURL"`
+	Periods                   []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	Duration       string             `xml:"duration,attr"`
+	BaseURL        string             `xml:"BaseURL"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	MimeType        string              `xml:"mimeType,attr"`
+	ContentType     string              `xml:"contentType,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+	Representations []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID              string              `xml:"id,attr"`
+	Bandwidth       int64               `xml:"bandwidth,attr"`
+	MimeType        string              `xml:"mimeType,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+}
+
+type mpdSegmentTemplate struct {
+	Media          string              `xml:"media,attr"`
+	Initialization string              `xml:"initialization,attr"`
+	StartNumber    *int64              `xml:"startNumber,attr"`
+	Timescale      int64               `xml:"timescale,attr"`
+	Duration       int64               `xml:"duration,attr"`
+	Timeline       *mpdSegmentTimeline `xml:"SegmentTimeline"`
+}
+
+type mpdSegmentTimeline struct {
+	S []struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	} `xml:"S"`
+}
+
+type mpdSegmentList struct {
+	Initialization *struct {
+		SourceURL string `xml:"sourceURL,attr"`
+	} `xml:"Initialization"`
+	SegmentURLs []struct {
+		Media string `xml:"media,attr"`
+	} `xml:"SegmentURL"`
+}
+
+// dashPeriod holds the selectable representations of one period, each already
+// expanded into its list of segments.
+type dashPeriod struct {
+	variants []streamVariant
+	segments [][]streamSegment
+}
+
+// parseDASH parses a static MPD. Only one adaptation set per period is downloaded:
+// the first video set, or the first set when the period has no video.
+func parseDASH(base *url.URL, data []byte) ([]dashPeriod, error) {
+	var manifest mpd
+	if err := xml.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parse MPD: %w", err)
+	}
+	if manifest.Type == "dynamic" {
+		return nil, errors.New("live DASH manifests are not supported")
+	}
+	if len(manifest.Periods) == 0 {
+		return nil, errors.New("MPD has no periods")
+	}
+
+	base, err := resolveBaseURL(base, manifest.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// total counts the segments of the longest representation of every period so far;
+	// a download never takes more than that.
+	var periods []dashPeriod
+	total := 0
+	for i, period := range manifest.Periods {
+		durationStr := period.Duration
+		if durationStr == "" && len(manifest.Periods) == 1 {
+			durationStr = manifest.MediaPresentationDuration
+		}
+		var duration time.Duration
+		if durationStr != "" {
+			if duration, err = parseISODuration(durationStr); err != nil {
+				return nil, fmt.Errorf("period %d: %w", i, err)
+			}
+		}
+
+		periodBase, err := resolveBaseURL(base, period.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+
+		set := selectAdaptationSet(period.AdaptationSets)
+		if set == nil {
+			return nil, fmt.Errorf("period %d has no adaptation sets", i)
+		}
+		setBase, err := resolveBaseURL(periodBase, set.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+
+		var parsed dashPeriod
+		longest := 0
+		for _, rep := range set.Representations {
+			repBase, err := resolveBaseURL(setBase, rep.BaseURL)
+			if err != nil {
+				return nil, err
+			}
+
+			segments, err := representationSegments(repBase, rep, set, duration, maxSegments-total)
+			if err != nil {
+				return nil, fmt.Errorf("representation %q: %w", rep.ID, err)
+			}
+			longest = max(longest, len(segments))
+
+			parsed.variants = append(parsed.variants, streamVariant{bandwidth: rep.Bandwidth})
+			parsed.segments = append(parsed.segments, segments)
+		}
+		if len(parsed.variants) == 0 {
+			return nil, fmt.Errorf("period %d has no representations", i)
+		}
+		total += longest
+		periods = append(periods, parsed)
+	}
+
+	return periods, nil
+}
+
+func selectAdaptationSet(sets []mpdAdaptationSet) *mpdAdaptationSet {
+	for i := range sets {
+		if sets[i].ContentType == "video" || strings.HasPrefix(sets[i].MimeType, "video/") {
+			return &sets[i]
+		}
+		for _, rep := range sets[i].Representations {
+			if strings.HasPrefix(rep.MimeType, "video/") {
+				return &sets[i]
+			}
+		}
+	}
+	if len(sets) > 0 {
+		return &sets[0]
+	}
+	return nil
+}
+
+// representationSegments expands a representation into its initialization and media segments.
+// It fails with ErrTooManySegments when there are more than limit of them.
+func representationSegments(base *url.URL, rep mpdRepresentation, set *mpdAdaptationSet, duration time.Duration, limit int) ([]streamSegment, error) {
+	template := rep.SegmentTemplate
+	if template == nil {
+		template = set.SegmentTemplate
+	}
+	list := rep.SegmentList
+	if list == nil {
+		list = set.SegmentList
+	}
+
+	var refs []string
+	switch {
+	case template != nil:
+		expanded, err := expandSegmentTemplate(template, rep, duration, limit)
+		if err != nil {
+			return nil, err
+		}
+		refs = expanded
+
+	case list != nil:
+		if list.Initialization != nil && list.Initialization.SourceURL != "" {
+			refs = append(refs, list.Initialization.SourceURL)
+		}
+		for _, s := range list.SegmentURLs {
+			refs = append(refs, s.Media)
+		}
+		if len(refs) > limit {
+			return nil, ErrTooManySegments
+		}
+
+	default:
+		// A representation with only a BaseURL is a single self-contained file.
+		refs = []string{""}
+	}
+
+	segments := make([]streamSegment, 0, len(refs))
+	for _, ref := range refs {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment URL %q: %w", ref, err)
+		}
+		segments = append(segments, streamSegment{url: u, length: -1})
+	}
+	return segments, nil
+}
+
+// expandSegmentTemplate lists the segment references of a template. The count comes from
+// the manifest, so it is checked against limit before anything is allocated.
+func expandSegmentTemplate(t *mpdSegmentTemplate, rep mpdRepresentation, duration time.Duration, limit int) ([]string, error) {
+	timescale := t.Timescale
+	if timescale <= 0 {
+		timescale = 1
+	}
+	number := int64(1)
+	if t.StartNumber != nil {
+		number = *t.StartNumber
+	}
+
+	var refs []string
+	if t.Initialization != "" {
+		refs = append(refs, fillTemplate(t.Initialization, rep, 0, 0))
+	}
+
+	switch {
+	case t.Timeline != nil:
+		var at int64
+		for _, s := range t.Timeline.S {
+			if s.T != nil {
+				at = *s.T
+			}
+			if s.R < 0 {
+				return nil, errors.New("open-ended segment timelines are not supported")
+			}
+			if s.R >= int64(limit-len(refs)) {
+				return nil, ErrTooManySegments
+			}
+			for i := int64(0); i <= s.R; i++ {
+				refs = append(refs, fillTemplate(t.Media, rep, number, at))
+				number++
+				at += s.D
+			}
+		}
+
+	case t.Duration > 0:
+		if duration <= 0 {
+			return nil, errors.New("segment template needs a period or presentation duration")
+		}
+		segments := math.Ceil(duration.Seconds() * float64(timescale) / float64(t.Duration))
+		if segments > float64(limit-len(refs)) {
+			return nil, ErrTooManySegments
+		}
+		count := int64(segments)
+		for i := int64(0); i < count; i++ {
+			refs = append(refs, fillTemplate(t.Media, rep, number+i, i*t.Duration))
+		}
+
+	default:
+		return nil, errors.New("segment template has neither a timeline nor a duration")
+	}
+
+	return refs, nil
+}
+
+var templateIdentifier = regexp.MustCompile(`\$(RepresentationID|Number|Time|Bandwidth)(?:%0(\d+)d)?\$`)
+
+// fillTemplate substitutes $RepresentationID$, $Number$, $Time$ and $Bandwidth$
+// (with optional %0Nd width) and unescapes $$.
+func fillTemplate(template string, rep mpdRepresentation, number, at int64) string {
+	filled := templateIdentifier.ReplaceAllStringFunc(template, func(match string) string {
+		parts := templateIdentifier.FindStringSubmatch(match)
+
+		var value int64
+		switch parts[1] {
+		case "RepresentationID":
+			return rep.ID
+		case "Number":
+			value = number
+		case "Time":
+			value = at
+		case "Bandwidth":
+			value = rep.Bandwidth
+		}
+
+		if parts[2] != "" {
+			width, _ := strconv.Atoi(parts[2])
+			return fmt.Sprintf("%0*d", width, value)
+		}
+		return strconv.FormatInt(value, 10)
+	})
+	return strings.ReplaceAll(filled, "$$", "$")
+}
+
+func resolveBaseURL(base *url.URL, ref string) (*url.URL, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return base, nil
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BaseURL %q: %w", ref, err)
+	}
+	return u, nil
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseISODuration parses the xs:duration subset used by MPDs, e.g. "PT1H2M3.5S".
+func parseISODuration(value string) (time.Duration, error) {
+	parts := isoDuration.FindStringSubmatch(value)
+	if parts == nil || value == "P" || value == "PT" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var total time.Duration
+	for i, unit := range units {
+		if parts[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(parts[i+1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		total += time.Duration(n * float64(unit))
+	}
+	return total, nil
+}
@@ -0,0 +1,200 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+func segmentPaths(segments []streamSegment) []string {
+	var paths []string
+	for _, s := range segments {
+		paths = append(paths, s.url.String())
+	}
+	return paths
+}
+
+func TestParseDASH_SegmentTemplateWithDuration(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/movie/manifest.mpd")
+	data := []byte(`<?xml version="1.0"?>
+<MPD type="static" mediaPresentationDuration="PT9S">
+  <Period>
+    <AdaptationSet contentType="audio">
+      <Representation id="a1" bandwidth="64000"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/seg-$Number%03d$.m4s" startNumber="1" timescale="1000" duration="4000"/>
+      <Representation id="v1" bandwidth="500000"/>
+      <Representation id="v2" bandwidth="3000000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+
+	periods, err := parseDASH(base, data)
+	if err != nil {
+		t.Fatalf("parseDASH error: %v", err)
+	}
+	if len(periods) != 1 || len(periods[0].variants) != 2 {
+		t.Fatalf("expected one period with two video variants, got %+v", periods)
+	}
+
+	got := segmentPaths(periods[0].segments[1])
+	want := []string{
+		"https://cdn.example.com/movie/v2/init.mp4",
+		"https://cdn.example.com/movie/v2/seg-001.m4s",
+		"https://cdn.example.com/movie/v2/seg-002.m4s",
+		"https://cdn.example.com/movie/v2/seg-003.m4s",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestParseDASH_TimelineAndSegmentList(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/a/manifest.mpd")
+	data := []byte(`<MPD>
+  <BaseURL>https://media.example.com/b/</BaseURL>
+  <Period duration="PT6S">
+    <AdaptationSet mimeType="video/mp4">
+      <Representation id="tl" bandwidth="100">
+        <SegmentTemplate media="t-$Time$.m4s" timescale="1">
+          <SegmentTimeline>
+            <S t="10" d="2" r="2"/>
+          </SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+      <Representation id="list" bandwidth="200">
+        <SegmentList>
+          <Initialization sourceURL="list-init.mp4"/>
+          <SegmentURL media="list-1.m4s"/>
+          <SegmentURL media="list-2.m4s"/>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+
+	periods, err := parseDASH(base, data)
+	if err != nil {
+		t.Fatalf("parseDASH error: %v", err)
+	}
+
+	timeline := segmentPaths(periods[0].segments[0])
+	if len(timeline) != 3 || timeline[0] != "https://media.example.com/b/t-10.m4s" || timeline[2] != "https://media.example.com/b/t-14.m4s" {
+		t.Fatalf("unexpected timeline segments %v", timeline)
+	}
+
+	list := segmentPaths(periods[0].segments[1])
+	if len(list) != 3 || list[0] != "https://media.example.com/b/list-init.mp4" {
+		t.Fatalf("unexpected list segments %v", list)
+	}
+}
+
+func TestParseDASH_RejectsLive(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/live.mpd")
+	if _, err := parseDASH(base, []byte(`<MPD type="dynamic"><Period/></MPD>`)); err == nil {
+		t.Fatalf("expected live manifest to be rejected")
+	}
+}
+
+func TestParseDASH_TooManySegments(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/movie/manifest.mpd")
+
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"timeline repeat", `<SegmentTemplate media="seg-$Number$.m4s"><SegmentTimeline><S t="0" d="1" r="9000000000"/></SegmentTimeline></SegmentTemplate>`},
+		{"tiny duration", `<SegmentTemplate media="seg-$Number$.m4s" timescale="1000000000" duration="1"/>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte(`<MPD type="static" mediaPresentationDuration="PT100000H"><Period><AdaptationSet mimeType="video/mp4">` +
+				tt.template + `<Representation id="v1" bandwidth="1"/></AdaptationSet></Period></MPD>`)
+			if _, err := parseDASH(base, data); !errors.Is(err, ErrTooManySegments) {
+				t.Fatalf("expected ErrTooManySegments, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseISODuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"PT9S", 9 * time.Second, true},
+		{"PT1H2M3.5S", time.Hour + 2*time.Minute + 3500*time.Millisecond, true},
+		{"P1DT1S", 24*time.Hour + time.Second, true},
+		{"PT", 0, false},
+		{"9S", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseISODuration(tt.in)
+		if (err == nil) != tt.ok {
+			t.Fatalf("parseISODuration(%q) error = %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Fatalf("parseISODuration(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadWorker_DASH(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream.mpd", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<MPD mediaPresentationDuration="PT4S">
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate initialization="$RepresentationID$-init" media="$RepresentationID$-$Number$" timescale="1" duration="2"/>
+      <Representation id="hi" bandwidth="2000"/>
+      <Representation id="lo" bandwidth="1000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`))
+	})
+	for _, name := range []string{"lo-init", "lo-1", "lo-2"} {
+		name := name
+		mux.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("[" + name + "]"))
+		})
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := makeTempDir(t)
+	w := newStreamWorker(dir)
+
+	opts := domain.TaskOptions{Stream: &domain.StreamOptions{Variant: domain.VariantWorst}}
+	result, err := w.DownloadURL(context.Background(), server.URL+"/stream.mpd", "task1", opts)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if !result.Success || result.Segments != 3 || result.Bandwidth != 1000 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, result.FileName))
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if string(got) != "[lo-init][lo-1][lo-2]" {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// WithBlobStore deduplicates completed downloads through b and revalidates URLs
+// downloaded before with conditional requests instead of fetching them again.
+func WithBlobStore(b *storage.BlobStore) Option {
+	return func(w *DownloadWorker) {
+		w.blobs = b
+	}
+}
+
+// blobCacheKey identifies a URL fetched with particular credentials and encoding mode,
+// so that content downloaded with one set of credentials is never handed to another.
+func blobCacheKey(rawURL string, opts domain.TaskOptions) string {
+	encoding := opts.Encoding
+	if encoding == "" {
+		encoding = domain.EncodingDecoded
+	}
+	creds, _ := json.Marshal(opts.CredentialsFor(rawURL))
+
+	h := sha256.New()
+	for _, part := range [][]byte{[]byte(rawURL), []byte(encoding), creds} {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedDownload returns the stored copy of rawURL to revalidate, or nil when there is
+// none, it has no validators, or a partial download is being resumed instead.
+func (w *DownloadWorker) cachedDownload(rawURL string, offset int64, opts domain.TaskOptions) *storage.CacheEntry {
+	if w.blobs == nil || offset > 0 {
+		return nil
+	}
+	entry, ok := w.blobs.Lookup(blobCacheKey(rawURL, opts))
+	if !ok || (entry.ETag == "" && entry.LastModified == "") {
+		return nil
+	}
+	return &entry
+}
+
+// linkCached makes filename a link to the cached copy the server just confirmed as current.
+// It fails when the copy has been garbage collected since it was looked up.
+func (w *DownloadWorker) linkCached(entry *storage.CacheEntry, filename string) error {
+	return w.blobs.Link(entry.Digest, w.fileStorage.Path(filename))
+}
+
+// reuseCached checks a file linked by linkCached against the task's content policies and
+// fills in the result as if the content had just been downloaded.
+func (w *DownloadWorker) reuseCached(entry *storage.CacheEntry, filename string, policies []ContentPolicy, result *domain.DownloadResult) error {
+	head, err := w.sniffHead(filename, entry.Size, bufio.NewReader(http.NoBody))
+	if err != nil {
+		return fmt.Errorf("read cached content: %w", err)
+	}
+	if entry.Encoding == domain.EncodingEncoded {
+		head = decodeHead(entry.ContentEncoding, head)
+	}
+
+	result.MIMEType, err = checkContent(policies, entry.ContentType, head)
+	if err != nil {
+		os.Remove(w.fileStorage.Path(filename))
+		return err
+	}
+
+	result.BytesRead = entry.Size
+	result.TotalBytes = entry.Size
+	result.Hash = "sha-256:" + entry.Digest
+	result.Blob = entry.Digest
+	result.Cached = true
+	result.ETag = entry.ETag
+	result.LastModified = entry.LastModified
+	result.ContentEncoding = entry.ContentEncoding
+	if entry.ContentEncoding != "" {
+		result.Encoding = entry.Encoding
+	}
+	return nil
+}
+
+// storeBlob moves a completed download into the blob store and remembers its validators
+// for later revalidation. Failures only cost the deduplication, so they are logged.
+func (w *DownloadWorker) storeBlob(rawURL, filename string, opts domain.TaskOptions, src *FetchResponse, result *domain.DownloadResult) {
+	if w.blobs == nil {
+		return
+	}
+
+	digest, size, err := w.blobs.Add(w.fileStorage.Path(filename))
+	if err != nil {
+		w.logger.Warn("failed to deduplicate download", "url", rawURL, "error", err)
+		return
+	}
+	result.Blob = digest
+	if result.Hash == "" {
+		result.Hash = "sha-256:" + digest
+	}
+
+	if src.ETag == "" && src.LastModified == "" {
+		return
+	}
+	err = w.blobs.Remember(blobCacheKey(rawURL, opts), storage.CacheEntry{
+		Digest:          digest,
+		Size:            size,
+		ETag:            src.ETag,
+		LastModified:    src.LastModified,
+		ContentType:     src.ContentType,
+		ContentEncoding: result.ContentEncoding,
+		Encoding:        result.Encoding,
+		StoredAt:        time.Now(),
+	})
+	if err != nil {
+		w.logger.Warn("failed to remember download validators", "url", rawURL, "error", err)
+	}
+}
@@ -0,0 +1,203 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+func newDedupWorker(t *testing.T, opts ...Option) (*DownloadWorker, *storage.BlobStore, string) {
+	t.Helper()
+	dir := makeTempDir(t)
+	blobs, err := storage.NewBlobStore(filepath.Join(dir, storage.BlobDirName))
+	if err != nil {
+		t.Fatalf("NewBlobStore error: %v", err)
+	}
+	opts = append(opts, WithBlobStore(blobs))
+	return NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger(), opts...), blobs, dir
+}
+
+// etagServer serves body with a strong ETag and answers matching conditional
+// requests with 304. It counts full responses.
+func etagServer(t *testing.T, body string, full *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "text/plain")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	infoA, err := os.Stat(a)
+	if err != nil {
+		t.Fatalf("stat %s: %v", a, err)
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		t.Fatalf("stat %s: %v", b, err)
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+func TestDownloadWorker_Dedup_RevalidatesCachedURL(t *testing.T) {
+	var full atomic.Int32
+	server := etagServer(t, "cached body", &full)
+	worker, _, dir := newDedupWorker(t)
+	ctx := context.Background()
+
+	first, err := worker.DownloadURL(ctx, server.URL+"/file.txt", "task1", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("first DownloadURL error: %v", err)
+	}
+	if first.Cached || first.Blob == "" {
+		t.Errorf("expected a fresh download stored as a blob, got %+v", first)
+	}
+
+	second, err := worker.DownloadURL(ctx, server.URL+"/file.txt", "task2", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("second DownloadURL error: %v", err)
+	}
+	if !second.Success || !second.Cached {
+		t.Fatalf("expected cached success, got %+v", second)
+	}
+	if full.Load() != 1 {
+		t.Errorf("expected the body to be sent once, got %d", full.Load())
+	}
+	if second.Blob != first.Blob || second.Hash != first.Hash {
+		t.Errorf("expected the same blob, got %s and %s", first.Blob, second.Blob)
+	}
+	if second.BytesRead != int64(len("cached body")) || second.MIMEType == "" {
+		t.Errorf("expected size and MIME type to be filled in, got %+v", second)
+	}
+
+	if !sameFile(t, filepath.Join(dir, first.FileName), filepath.Join(dir, second.FileName)) {
+		t.Errorf("expected both task files to link to the same blob")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, second.FileName))
+	if err != nil || string(data) != "cached body" {
+		t.Errorf("expected cached content, got %q (%v)", data, err)
+	}
+}
+
+func TestDownloadWorker_Dedup_CredentialsNotShared(t *testing.T) {
+	var full atomic.Int32
+	server := etagServer(t, "private", &full)
+	worker, _, _ := newDedupWorker(t)
+	ctx := context.Background()
+
+	alice := domain.TaskOptions{Credentials: &domain.RequestCredentials{BearerToken: "alice"}}
+	bob := domain.TaskOptions{Credentials: &domain.RequestCredentials{BearerToken: "bob"}}
+
+	if _, err := worker.DownloadURL(ctx, server.URL, "alice", alice); err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	result, err := worker.DownloadURL(ctx, server.URL, "bob", bob)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if result.Cached {
+		t.Errorf("expected a download with other credentials not to reuse the cache")
+	}
+	if full.Load() != 2 {
+		t.Errorf("expected two full responses, got %d", full.Load())
+	}
+}
+
+func TestDownloadWorker_Dedup_IdenticalContent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "mirrored payload")
+	}))
+	defer server.Close()
+	worker, _, dir := newDedupWorker(t)
+	ctx := context.Background()
+
+	a, err := worker.DownloadURL(ctx, server.URL+"/a.bin", "task", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	b, err := worker.DownloadURL(ctx, server.URL+"/b.bin", "task", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if a.Blob == "" || a.Blob != b.Blob {
+		t.Errorf("expected identical content to share a blob, got %q and %q", a.Blob, b.Blob)
+	}
+	if !sameFile(t, filepath.Join(dir, a.FileName), filepath.Join(dir, b.FileName)) {
+		t.Errorf("expected identical downloads to share one inode")
+	}
+}
+
+func TestDownloadWorker_Dedup_RefetchesCollectedBlob(t *testing.T) {
+	var full atomic.Int32
+	server := etagServer(t, "collected", &full)
+	worker, blobs, dir := newDedupWorker(t)
+	ctx := context.Background()
+
+	first, err := worker.DownloadURL(ctx, server.URL, "task1", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, first.FileName)); err != nil {
+		t.Fatalf("failed to remove task file: %v", err)
+	}
+	// Collect after the lookup succeeded but before the blob is linked.
+	entry := worker.cachedDownload(server.URL, 0, domain.TaskOptions{})
+	if entry == nil {
+		t.Fatalf("expected a cache entry")
+	}
+	if _, err := blobs.GC(); err != nil {
+		t.Fatalf("GC error: %v", err)
+	}
+	if err := worker.linkCached(entry, "orphan"); err == nil {
+		t.Errorf("expected linking a collected blob to fail")
+	}
+
+	second, err := worker.DownloadURL(ctx, server.URL, "task2", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if second.Cached || full.Load() != 2 {
+		t.Errorf("expected a fresh download after GC, cached=%v full=%d", second.Cached, full.Load())
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, second.FileName))
+	if string(data) != "collected" {
+		t.Errorf("expected refetched content, got %q", data)
+	}
+}
+
+func TestDownloadWorker_Dedup_PolicyAppliesToCachedContent(t *testing.T) {
+	var full atomic.Int32
+	server := etagServer(t, "plain text body", &full)
+	worker, _, dir := newDedupWorker(t)
+	ctx := context.Background()
+
+	if _, err := worker.DownloadURL(ctx, server.URL, "task1", domain.TaskOptions{}); err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+
+	result, err := worker.DownloadURL(ctx, server.URL, "task2", domain.TaskOptions{AllowedMIMETypes: []string{"application/pdf"}})
+	if !errors.Is(err, ErrContentTypeNotAllowed) {
+		t.Fatalf("expected ErrContentTypeNotAllowed, got %v", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(dir, result.FileName)); !os.IsNotExist(statErr) {
+		t.Errorf("expected rejected cached content to be unlinked, got %v", statErr)
+	}
+}
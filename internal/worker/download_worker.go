@@ -1,7 +1,9 @@
 package worker
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,32 +12,157 @@ import (
 	"time"
 
 	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/metrics"
+	"github.com/veranemoloko/url-downloader/internal/scanner"
 	"github.com/veranemoloko/url-downloader/internal/storage"
+	"github.com/veranemoloko/url-downloader/internal/validation"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/sync/errgroup"
 )
 
 // DownloadWorker is responsible for downloading files from URLs and storing them in FileStorage.
 type DownloadWorker struct {
-	fileStorage *storage.FileStorage
-	httpClient  *http.Client
-	logger      *slog.Logger
+	fileStorage     *storage.FileStorage
+	httpClient      *http.Client
+	transportConfig TransportConfig
+	contentPolicy   ContentPolicy
+	fetchers        *Registry
+	customFetchers  map[string]Fetcher
+	fileScanner     scanner.Scanner
+	quarantineDir   string
+	sshHostKeys     ssh.HostKeyCallback
+	proxy           proxyFunc
+	torrents        *TorrentClient
+	progress        ProgressHandler
+	extractLimits   ExtractLimits
+	validateURLs    func([]string) error
+	metrics         *metrics.Metrics
+	active          activeDownloads
+	blobs           *storage.BlobStore
+	logger          *slog.Logger
+}
+
+// ProgressHandler receives intermediate results for long-running downloads.
+type ProgressHandler func(taskID string, result domain.DownloadResult)
+
+// Option configures optional DownloadWorker behaviour.
+type Option func(*DownloadWorker)
+
+// WithContentPolicy sets the global MIME type policy applied to every download.
+func WithContentPolicy(policy ContentPolicy) Option {
+	return func(w *DownloadWorker) {
+		w.contentPolicy = policy
+	}
+}
+
+// WithScanner enables post-download scanning. Infected files are moved to quarantineDir.
+func WithScanner(s scanner.Scanner, quarantineDir string) Option {
+	return func(w *DownloadWorker) {
+		w.fileScanner = s
+		w.quarantineDir = quarantineDir
+	}
+}
+
+// SetProgressHandler installs the callback that receives intermediate download results.
+// It must be called before any download starts.
+func (w *DownloadWorker) SetProgressHandler(h ProgressHandler) {
+	w.progress = h
+}
+
+func (w *DownloadWorker) reportProgress(taskID string, result domain.DownloadResult) {
+	if result.State == "" {
+		result.State = domain.URLDownloading
+	}
+	if w.progress != nil {
+		w.progress(taskID, result)
+	}
 }
 
 // NewDownloadWorker creates a new DownloadWorker with the provided FileStorage and logger.
-// It initializes an HTTP client with a 30-minute timeout.
-func NewDownloadWorker(fileStorage *storage.FileStorage, logger *slog.Logger) *DownloadWorker {
-	return &DownloadWorker{
-		fileStorage: fileStorage,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Minute,
-		},
-		logger: logger,
+// The HTTP client uses DefaultTransportConfig unless WithTransport is given.
+func NewDownloadWorker(fileStorage *storage.FileStorage, logger *slog.Logger, opts ...Option) *DownloadWorker {
+	w := &DownloadWorker{
+		fileStorage:     fileStorage,
+		transportConfig: DefaultTransportConfig(),
+		fetchers:        NewRegistry(),
+		customFetchers:  make(map[string]Fetcher),
+		extractLimits:   DefaultExtractLimits(),
+		validateURLs:    validation.ValidateURLs,
+		logger:          logger,
 	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	w.httpClient = w.newHTTPClient()
+
+	w.registerBuiltinFetchers()
+
+	return w
 }
 
 // DownloadURL downloads a single URL and saves it to storage, supporting resume of partial downloads.
+// Magnet links and .torrent URLs are handed to the torrent client when one is configured,
+// HLS (.m3u8) and DASH (.mpd) manifests are assembled from their segments, and Metalink
+// documents or URLs with mirrors are downloaded with failover between the mirrors.
+// The content is checked against the global and per-task MIME policies before anything is written,
+// and the completed file is passed to the scanner when one is configured. When the task asks for
+// extraction, supported archives are then unpacked into the task directory. Crawl tasks treat
+// the URL as an index page and download the files it links to as child results. Validators
+// in the task options turn the request into a conditional one that skips unchanged resources.
 // Returns a DownloadResult with information about the success, bytes read, and errors (if any).
-func (w *DownloadWorker) DownloadURL(ctx context.Context, url string, taskID string) (domain.DownloadResult, error) {
+func (w *DownloadWorker) DownloadURL(ctx context.Context, url string, taskID string, opts domain.TaskOptions) (result domain.DownloadResult, err error) {
+	ctx, span := startDownloadSpan(ctx, url, taskID)
+	defer func() { endDownloadSpan(span, result, err) }()
+
+	// The task proxy is installed first so that crawl pages and robots.txt use it too.
+	ctx, err = withTaskOptionsProxy(ctx, opts)
+	if err != nil {
+		result = domain.DownloadResult{URL: url, Error: fmt.Sprintf("task proxy: %v", err)}
+		w.logger.Error("download failed",
+			"url", url,
+			"error", err,
+		)
+		return result, err
+	}
+
+	if opts.Crawl != nil {
+		return w.crawl(ctx, url, taskID, opts)
+	}
+
+	finished := w.metrics.DownloadStarted()
+	defer w.active.add(taskID, url)()
+	result, err = w.downloadURL(ctx, url, taskID, opts)
+	if err == nil && opts.Extract {
+		err = w.extract(&result, url, taskID)
+	}
+	finished(metricsHost(url), err)
+
+	return result, err
+}
+
+// extract unpacks a downloaded archive and marks the result failed if that goes wrong.
+func (w *DownloadWorker) extract(result *domain.DownloadResult, url string, taskID string) error {
+	if err := w.extractResult(result, url, taskID); err != nil {
+		result.Success = false
+		result.Error = fmt.Sprintf("extract: %v", err)
+		w.logger.Error("extraction failed",
+			"url", url,
+			"file_name", result.FileName,
+			"error", err,
+		)
+		return err
+	}
+
+	return nil
+}
+
+func (w *DownloadWorker) downloadURL(ctx context.Context, url string, taskID string, opts domain.TaskOptions) (domain.DownloadResult, error) {
+	if w.torrents != nil && isTorrentURL(url) {
+		return w.downloadTorrent(ctx, url, taskID, opts)
+	}
+
 	result := domain.DownloadResult{
 		URL:     url,
 		Success: false,
@@ -52,86 +179,273 @@ func (w *DownloadWorker) DownloadURL(ctx context.Context, url string, taskID str
 		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if kind := streamKind(url); kind != "" {
+		return w.downloadStream(ctx, url, taskID, kind, opts)
+	}
+	if isMetalinkURL(url) || len(opts.Mirrors[url]) > 0 {
+		return w.downloadMirrored(ctx, url, taskID, opts)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	cached := w.cachedDownload(url, existingSize, opts)
+	if cached == nil {
+		cached = conditionalDownload(url, existingSize, opts)
+	}
+	src, err := w.fetchStored(ctx, url, filename, existingSize, opts, cached)
+	if err == nil && src.NotModified && cached.Digest == "" {
+		src.Body.Close()
+		unchanged(&result, cached, src)
+		w.logger.Info("resource unchanged, download skipped", "url", url)
+		return result, nil
+	}
+	if err == nil && src.NotModified {
+		if linkErr := w.linkCached(cached, filename); linkErr != nil {
+			w.logger.Warn("cached copy unavailable, downloading again",
+				"url", url,
+				"error", linkErr,
+			)
+			src, err = w.fetchStored(ctx, url, filename, existingSize, opts, nil)
+		}
+	}
 	if err != nil {
-		result.Error = fmt.Sprintf("create request: %v", err)
-		w.logger.Error("download failed",
+		result.Error = err.Error()
+		w.logger.Error("download request failed",
 			"url", url,
 			"error", err,
 		)
 		return result, err
 	}
+	defer src.Body.Close()
 
-	if existingSize > 0 {
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", existingSize))
+	result.Proxy = src.Proxy
+	result.ETag = src.ETag
+	result.LastModified = src.LastModified
+	if src.ContentEncoding != "" {
+		result.ContentEncoding = src.ContentEncoding
+		result.Encoding = domain.EncodingEncoded
+		if src.Decoded {
+			result.Encoding = domain.EncodingDecoded
+		}
 	}
 
-	resp, err := w.httpClient.Do(req)
+	policies := []ContentPolicy{w.contentPolicy, {Allowed: opts.AllowedMIMETypes}}
+	if src.NotModified {
+		err = w.reuseCached(cached, filename, policies, &result)
+	} else {
+		err = w.receive(ctx, cancel, src, filename, policies, &result)
+	}
 	if err != nil {
 		result.Error = err.Error()
-		w.logger.Error("download request failed",
+		w.logger.Error("download failed",
 			"url", url,
+			"content_type", src.ContentType,
+			"mime_type", result.MIMEType,
 			"error", err,
 		)
 		return result, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		result.Error = fmt.Sprintf("bad status: %s", resp.Status)
-		w.logger.Error("download failed",
-			"url", url,
-			"status", resp.Status,
-		)
-		return result, fmt.Errorf("bad status: %s", resp.Status)
+	if w.fileScanner != nil {
+		result.Scan, err = w.scanFile(ctx, filename)
+		if err != nil {
+			result.Error = err.Error()
+			w.logger.Error("download failed scan",
+				"url", url,
+				"file_name", filename,
+				"error", err,
+			)
+			return result, err
+		}
 	}
 
-	if existingSize > 0 && resp.StatusCode != http.StatusPartialContent {
-		existingSize = 0
+	if !result.Cached {
+		w.storeBlob(url, filename, opts, src, &result)
 	}
+	result.Success = true
 
-	var file *os.File
-	var flags int
+	return result, nil
+}
+
+// receive checks the content of an opened source and writes it to filename, appending
+// when the source resumes at a non-zero offset. It sets MIMEType and BytesRead on result.
+// cancel is used by the idle read timeout to abort a stalled transfer.
+func (w *DownloadWorker) receive(ctx context.Context, cancel context.CancelCauseFunc, src *FetchResponse, filename string, policies []ContentPolicy, result *domain.DownloadResult) error {
+	existingSize := src.Offset
 
+	var reader io.Reader = src.Body
+	stopIdleTimer := func() {}
+	if timeout := w.transportConfig.IdleReadTimeout; timeout > 0 {
+		idleReader := newIdleTimeoutReader(src.Body, timeout, cancel)
+		defer idleReader.Stop()
+		reader = idleReader
+		stopIdleTimer = idleReader.Stop
+	}
+
+	body := bufio.NewReaderSize(reader, sniffLength)
+	head, err := w.sniffHead(filename, existingSize, body)
+	if err != nil {
+		return fmt.Errorf("read content: %w", stallCause(ctx, err))
+	}
+	if src.ContentEncoding != "" && !src.Decoded {
+		head = decodeHead(src.ContentEncoding, head)
+	}
+
+	result.MIMEType, err = checkContent(policies, src.ContentType, head)
+	if err != nil {
+		return err
+	}
+
+	var file *os.File
 	if existingSize > 0 {
-		flags = os.O_WRONLY | os.O_APPEND
-		file, err = w.fileStorage.OpenFile(filename, flags)
+		file, err = w.fileStorage.OpenFile(filename, os.O_WRONLY|os.O_APPEND)
 		if err != nil {
-			result.Error = fmt.Sprintf("open file for append: %v", err)
-			w.logger.Error("download failed",
-				"url", url,
-				"error", err,
-			)
-			return result, err
+			return fmt.Errorf("open file for append: %w", err)
 		}
 	} else {
 		file, err = w.fileStorage.CreateFile(filename)
 		if err != nil {
-			result.Error = fmt.Sprintf("create file: %v", err)
-			w.logger.Error("download failed",
-				"url", url,
-				"error", err,
-			)
-			return result, err
+			return fmt.Errorf("create file: %w", err)
 		}
 	}
 	defer file.Close()
 
-	bytesRead, err := w.copyWithContext(ctx, file, resp.Body)
+	bytesRead, err := w.copyWithContext(ctx, file, body)
+	stopIdleTimer()
+	result.BytesRead = existingSize + bytesRead
 	if err != nil {
-		result.Error = fmt.Sprintf("copy data: %v", err)
-		w.logger.Error("download failed",
-			"url", url,
-			"error", err,
-		)
-		return result, err
+		return fmt.Errorf("copy data: %w", stallCause(ctx, err))
 	}
 
-	totalBytes := existingSize + bytesRead
-	result.BytesRead = totalBytes
-	result.Success = true
+	if src.Size >= 0 && result.BytesRead != src.Size {
+		return fmt.Errorf("incomplete download: got %d of %d bytes", result.BytesRead, src.Size)
+	}
 
-	return result, nil
+	return nil
+}
+
+// credentialHeadersKey carries the names of the headers set by applyCredentials in the
+// request context, for stripCredentialsOnRedirect.
+type credentialHeadersKey struct{}
+
+// applyCredentials adds custom headers, cookies and authentication to the request and
+// returns it with the names of those headers recorded, so that they are dropped when
+// the source redirects to another host.
+func applyCredentials(req *http.Request, creds domain.RequestCredentials) *http.Request {
+	names := []string{"Authorization", "Cookie"}
+	for name, value := range creds.Headers {
+		req.Header.Set(name, value)
+		names = append(names, name)
+	}
+	for name, value := range creds.Cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+	if creds.BasicAuth != nil {
+		req.SetBasicAuth(creds.BasicAuth.Username, creds.BasicAuth.Password)
+	}
+	if creds.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+creds.BearerToken)
+	}
+	return req.WithContext(context.WithValue(req.Context(), credentialHeadersKey{}, names))
+}
+
+// stripCredentialsOnRedirect is the CheckRedirect of the HTTP client. http.Client only
+// drops Authorization and Cookie on redirects to other domains; task credentials may be
+// any header, so all of them are removed when a redirect leaves the original host.
+func stripCredentialsOnRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Host == via[0].URL.Host {
+		return nil
+	}
+	names, _ := req.Context().Value(credentialHeadersKey{}).([]string)
+	for _, name := range names {
+		req.Header.Del(name)
+	}
+	return nil
+}
+
+// scanFile streams a downloaded file to the configured scanner and moves it to quarantine
+// when it is reported as infected. Scanner failures are returned as errors so that
+// unscanned files are never reported as successful.
+func (w *DownloadWorker) scanFile(ctx context.Context, filename string) (*domain.ScanResult, error) {
+	scan := &domain.ScanResult{
+		Scanner: w.fileScanner.Name(),
+	}
+
+	file, err := w.fileStorage.OpenFile(filename, os.O_RDONLY)
+	if err != nil {
+		scan.Verdict = domain.ScanError
+		return scan, fmt.Errorf("open file for scan: %w", err)
+	}
+
+	verdict, err := w.fileScanner.Scan(ctx, file)
+	file.Close()
+	scan.ScannedAt = time.Now()
+
+	if err != nil {
+		scan.Verdict = domain.ScanError
+		return scan, fmt.Errorf("scan file: %w", err)
+	}
+
+	if !verdict.Infected {
+		scan.Verdict = domain.ScanClean
+		return scan, nil
+	}
+
+	scan.Verdict = domain.ScanInfected
+	scan.Signature = verdict.Signature
+
+	if err := w.fileStorage.MoveFile(filename, w.quarantineDir); err != nil {
+		return scan, fmt.Errorf("quarantine file: %w", err)
+	}
+
+	w.logger.Warn("infected file quarantined",
+		"file_name", filename,
+		"signature", verdict.Signature,
+		"quarantine_dir", w.quarantineDir,
+	)
+
+	return scan, fmt.Errorf("%w: %s", scanner.ErrInfected, verdict.Signature)
+}
+
+// sniffHead returns the leading bytes of the file being downloaded without consuming the body.
+// When resuming, the head comes from the partial file already on disk.
+func (w *DownloadWorker) sniffHead(filename string, existingSize int64, body *bufio.Reader) ([]byte, error) {
+	var head []byte
+
+	if existingSize > 0 {
+		file, err := w.fileStorage.OpenFile(filename, os.O_RDONLY)
+		if err != nil {
+			return nil, fmt.Errorf("open partial file: %w", err)
+		}
+		defer file.Close()
+
+		head = make([]byte, min(existingSize, sniffLength))
+		if _, err := io.ReadFull(file, head); err != nil {
+			return nil, fmt.Errorf("read partial file: %w", err)
+		}
+	}
+
+	if len(head) < sniffLength {
+		peeked, err := body.Peek(sniffLength - len(head))
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		head = append(head, peeked...)
+	}
+
+	return head, nil
+}
+
+// stallCause replaces a cancellation error with ErrStalled when the idle read timeout fired.
+func stallCause(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(cause, ErrStalled) {
+		return cause
+	}
+	return err
 }
 
 func (w *DownloadWorker) copyWithContext(ctx context.Context, dst *os.File, src io.Reader) (int64, error) {
@@ -168,6 +482,8 @@ func (w *DownloadWorker) copyWithContext(ctx context.Context, dst *os.File, src
 
 // DownloadTask downloads all URLs associated with a task concurrently (limit 5 parallel downloads).
 // Returns a slice of DownloadResult for each URL and an error if any download failed.
+// Each URL is reported as downloading when it starts; URLs that had not started when
+// a download failed are skipped.
 func (w *DownloadWorker) DownloadTask(ctx context.Context, task *domain.Task) ([]domain.DownloadResult, error) {
 	results := make([]domain.DownloadResult, len(task.URLs))
 	g, ctx := errgroup.WithContext(ctx)
@@ -176,7 +492,13 @@ func (w *DownloadWorker) DownloadTask(ctx context.Context, task *domain.Task) ([
 	for i, url := range task.URLs {
 		i, url := i, url
 		g.Go(func() error {
-			result, err := w.DownloadURL(ctx, url, task.ID)
+			if err := ctx.Err(); err != nil {
+				results[i] = domain.DownloadResult{URL: url, State: domain.URLSkipped, Error: fmt.Sprintf("not started: %v", err)}
+				return nil
+			}
+			w.reportProgress(task.ID, domain.DownloadResult{URL: url})
+			result, err := w.DownloadURL(ctx, url, task.ID, task.Options)
+			result.State = finalState(result)
 			results[i] = result
 			return err
 		})
@@ -193,6 +515,18 @@ func (w *DownloadWorker) DownloadTask(ctx context.Context, task *domain.Task) ([
 	return results, nil
 }
 
+// finalState returns the state of a URL whose download has returned result.
+func finalState(result domain.DownloadResult) domain.URLState {
+	switch {
+	case result.Unchanged:
+		return domain.URLSkipped
+	case result.Success:
+		return domain.URLDone
+	default:
+		return domain.URLFailed
+	}
+}
+
 func (w *DownloadWorker) generateFilename(url, taskID string) string {
 	return fmt.Sprintf("%s_%x", taskID, url)
 }
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
@@ -9,10 +10,12 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/scanner"
 	"github.com/veranemoloko/url-downloader/internal/storage"
 )
 
@@ -49,7 +52,7 @@ func TestDownloadWorker_DownloadURL_FullDownload(t *testing.T) {
 	ctx := context.Background()
 	taskID := "task1"
 
-	result, err := worker.DownloadURL(ctx, server.URL, taskID)
+	result, err := worker.DownloadURL(ctx, server.URL, taskID, domain.TaskOptions{})
 	if err != nil {
 		t.Fatalf("DownloadURL error: %v", err)
 	}
@@ -102,7 +105,7 @@ func TestDownloadWorker_DownloadURL_Resume(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	result, err := worker.DownloadURL(ctx, server.URL, taskID)
+	result, err := worker.DownloadURL(ctx, server.URL, taskID, domain.TaskOptions{})
 	if err != nil {
 		t.Fatalf("DownloadURL resume error: %v", err)
 	}
@@ -133,7 +136,7 @@ func TestDownloadWorker_DownloadURL_HTTPError(t *testing.T) {
 	ctx := context.Background()
 	taskID := "task3"
 
-	result, err := worker.DownloadURL(ctx, server.URL, taskID)
+	result, err := worker.DownloadURL(ctx, server.URL, taskID, domain.TaskOptions{})
 	if err == nil {
 		t.Errorf("expected error for 500 response, got nil")
 	}
@@ -187,3 +190,244 @@ func TestDownloadWorker_DownloadTask_Multiple(t *testing.T) {
 		}
 	}
 }
+
+func TestDownloadWorker_DownloadTask_URLStates(t *testing.T) {
+	fs := storage.NewFileStorage(makeTempDir(t))
+	worker := NewDownloadWorker(fs, newTestLogger())
+
+	var mu sync.Mutex
+	started := make(map[string]domain.URLState)
+	worker.SetProgressHandler(func(taskID string, result domain.DownloadResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		started[result.URL] = result.State
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			// Fail once the other download is done, so that it is not cancelled.
+			time.Sleep(100 * time.Millisecond)
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "data")
+	}))
+	defer server.Close()
+
+	okURL, missingURL := server.URL+"/ok", server.URL+"/missing"
+	results, err := worker.DownloadTask(context.Background(), &domain.Task{ID: "states", URLs: []string{okURL, missingURL}})
+	if err == nil {
+		t.Fatalf("expected the missing url to fail the task")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, want := range map[int]domain.URLState{0: domain.URLDone, 1: domain.URLFailed} {
+		if results[i].State != want {
+			t.Errorf("expected %s to be %s, got %s", results[i].URL, want, results[i].State)
+		}
+		if started[results[i].URL] != domain.URLDownloading {
+			t.Errorf("expected %s to be reported as downloading", results[i].URL)
+		}
+	}
+}
+
+func TestDownloadWorker_DownloadURL_ContentPolicy(t *testing.T) {
+	dir := makeTempDir(t)
+	fs := storage.NewFileStorage(dir)
+	logger := newTestLogger()
+	worker := NewDownloadWorker(fs, logger, WithContentPolicy(ContentPolicy{Allowed: []string{"application/pdf", "text/plain"}}))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/report.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			if _, err := io.WriteString(w, "%PDF-1.4\n%fake pdf body"); err != nil {
+				t.Fatalf("failed to write response: %v", err)
+			}
+		case "/error.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			if _, err := io.WriteString(w, "<html><body>Not Found</body></html>"); err != nil {
+				t.Fatalf("failed to write response: %v", err)
+			}
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	result, err := worker.DownloadURL(ctx, server.URL+"/report.pdf", "policy", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if result.MIMEType != "application/pdf" {
+		t.Errorf("expected MIMEType application/pdf, got %q", result.MIMEType)
+	}
+
+	result, err = worker.DownloadURL(ctx, server.URL+"/error.pdf", "policy", domain.TaskOptions{})
+	if !errors.Is(err, ErrContentTypeNotAllowed) {
+		t.Fatalf("expected ErrContentTypeNotAllowed, got %v", err)
+	}
+	if fs.FileExists(result.FileName) {
+		t.Errorf("expected rejected download not to be written to disk")
+	}
+
+	_, err = worker.DownloadURL(ctx, server.URL+"/report.pdf", "policy", domain.TaskOptions{AllowedMIMETypes: []string{"text/csv"}})
+	if !errors.Is(err, ErrContentTypeNotAllowed) {
+		t.Fatalf("expected per-task policy to reject PDF, got %v", err)
+	}
+}
+
+type fakeScanner struct {
+	marker string
+}
+
+func (s *fakeScanner) Name() string { return "fake" }
+
+func (s *fakeScanner) Scan(ctx context.Context, r io.Reader) (scanner.Verdict, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return scanner.Verdict{}, err
+	}
+	if strings.Contains(string(data), s.marker) {
+		return scanner.Verdict{Infected: true, Signature: "Fake-Signature"}, nil
+	}
+	return scanner.Verdict{}, nil
+}
+
+func TestDownloadWorker_DownloadURL_ScanQuarantine(t *testing.T) {
+	dir := makeTempDir(t)
+	quarantineDir := filepath.Join(makeTempDir(t), "quarantine")
+	fs := storage.NewFileStorage(dir)
+	logger := newTestLogger()
+	worker := NewDownloadWorker(fs, logger, WithScanner(&fakeScanner{marker: "EICAR"}, quarantineDir))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/clean":
+			_, _ = io.WriteString(w, "clean content")
+		case "/infected":
+			_, _ = io.WriteString(w, "EICAR test content")
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	result, err := worker.DownloadURL(ctx, server.URL+"/clean", "scan", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if result.Scan == nil || result.Scan.Verdict != domain.ScanClean {
+		t.Errorf("expected clean scan verdict, got %+v", result.Scan)
+	}
+
+	result, err = worker.DownloadURL(ctx, server.URL+"/infected", "scan", domain.TaskOptions{})
+	if !errors.Is(err, scanner.ErrInfected) {
+		t.Fatalf("expected ErrInfected, got %v", err)
+	}
+	if result.Success {
+		t.Errorf("expected infected download to be marked as failed")
+	}
+	if result.Scan == nil || result.Scan.Verdict != domain.ScanInfected || result.Scan.Signature != "Fake-Signature" {
+		t.Errorf("expected infected scan verdict, got %+v", result.Scan)
+	}
+	if fs.FileExists(result.FileName) {
+		t.Errorf("expected infected file to be removed from download dir")
+	}
+	if _, err := os.Stat(filepath.Join(quarantineDir, result.FileName)); err != nil {
+		t.Errorf("expected infected file in quarantine: %v", err)
+	}
+}
+
+func TestDownloadWorker_DownloadURL_Credentials(t *testing.T) {
+	dir := makeTempDir(t)
+	fs := storage.NewFileStorage(dir)
+	logger := newTestLogger()
+	worker := NewDownloadWorker(fs, logger)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if r.Header.Get("Authorization") != "Bearer token-123" ||
+			r.Header.Get("X-Api-Key") != "key" ||
+			err != nil || cookie.Value != "abc" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, "secret data")
+	}))
+	defer server.Close()
+
+	opts := domain.TaskOptions{
+		Credentials: &domain.RequestCredentials{
+			Headers: map[string]string{"X-Api-Key": "key"},
+			Cookies: map[string]string{"session": "abc"},
+		},
+		URLCredentials: map[string]domain.RequestCredentials{
+			server.URL: {BearerToken: "token-123"},
+		},
+	}
+
+	result, err := worker.DownloadURL(context.Background(), server.URL, "creds", opts)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if !result.Success {
+		t.Errorf("expected authenticated download to succeed")
+	}
+
+	if _, err := worker.DownloadURL(context.Background(), server.URL, "nocreds", domain.TaskOptions{}); err == nil {
+		t.Errorf("expected unauthenticated download to fail")
+	}
+}
+
+func TestDownloadWorker_DownloadURL_CredentialsNotForwardedAcrossHosts(t *testing.T) {
+	worker := NewDownloadWorker(storage.NewFileStorage(makeTempDir(t)), newTestLogger())
+
+	var leaked []string
+	var mu sync.Mutex
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		for _, name := range []string{"X-Api-Key", "Authorization", "Cookie"} {
+			if r.Header.Get(name) != "" {
+				leaked = append(leaked, name)
+			}
+		}
+		mu.Unlock()
+		_, _ = io.WriteString(w, "data")
+	}))
+	defer other.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/file", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, other.URL+"/file", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	opts := domain.TaskOptions{
+		Credentials: &domain.RequestCredentials{
+			Headers:     map[string]string{"X-Api-Key": "key"},
+			Cookies:     map[string]string{"session": "abc"},
+			BearerToken: "token-123",
+		},
+	}
+
+	// A redirect on the same host keeps the credentials; the one to another host drops them.
+	result, err := worker.DownloadURL(context.Background(), origin.URL+"/moved", "creds", opts)
+	if err != nil || !result.Success {
+		t.Fatalf("expected download to succeed, got %+v, %v", result, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(leaked) != 0 {
+		t.Fatalf("credentials forwarded to another host: %v", leaked)
+	}
+}
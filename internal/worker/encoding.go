@@ -0,0 +1,191 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/metrics"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// ErrUnsupportedEncoding is returned when a response uses a Content-Encoding that
+// cannot be decoded and the task asked for decoded storage.
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// acceptEncoding lists the codings offered to servers; each of them can be decoded.
+const acceptEncoding = "gzip, deflate, zstd"
+
+// parseContentEncoding normalizes a Content-Encoding header into a comma separated
+// list of codings in the order they were applied, dropping identity. The normalized
+// value is returned even when a coding cannot be decoded.
+func parseContentEncoding(header string) (string, error) {
+	var codings []string
+	var err error
+	for _, coding := range strings.Split(header, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		switch coding {
+		case "", "identity":
+			continue
+		case "x-gzip":
+			coding = "gzip"
+		case "gzip", "deflate", "zstd":
+		default:
+			err = fmt.Errorf("%w: %q", ErrUnsupportedEncoding, coding)
+		}
+		codings = append(codings, coding)
+	}
+	return strings.Join(codings, ", "), err
+}
+
+// newDecoder wraps body so that reading it removes the codings in encoding, last applied first.
+// Closing the returned reader closes body.
+func newDecoder(encoding string, body io.ReadCloser) (io.ReadCloser, error) {
+	codings := strings.Split(encoding, ", ")
+
+	var r io.Reader = body
+	closers := []io.Closer{body}
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		var dec io.ReadCloser
+		switch codings[i] {
+		case "gzip":
+			dec, err = gzip.NewReader(r)
+		case "deflate":
+			dec, err = newDeflateReader(r)
+		case "zstd":
+			var zr *zstd.Decoder
+			zr, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err == nil {
+				dec = zr.IOReadCloser()
+			}
+		default:
+			err = fmt.Errorf("%w: %q", ErrUnsupportedEncoding, codings[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode %s body: %w", codings[i], err)
+		}
+		r = dec
+		closers = append(closers, dec)
+	}
+
+	return &decodedBody{Reader: r, closers: closers}, nil
+}
+
+// newDeflateReader accepts both zlib-wrapped data, which is what the HTTP deflate
+// coding means, and raw deflate streams that some servers send instead.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	var errs []error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		errs = append(errs, b.closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+// decodeHead decodes as much of an encoded prefix as possible so that content checks
+// see the real payload. The encoded bytes are returned when nothing can be decoded.
+func decodeHead(encoding string, head []byte) []byte {
+	dec, err := newDecoder(encoding, io.NopCloser(bytes.NewReader(head)))
+	if err != nil {
+		return head
+	}
+	defer dec.Close()
+
+	decoded, _ := io.ReadAll(io.LimitReader(dec, sniffLength))
+	if len(decoded) == 0 {
+		return head
+	}
+	return decoded
+}
+
+// fetchStored opens the source of a plain download. Unlike fetch it honours the task's
+// Encoding, and when resuming an encoded file it makes sure the server still uses the
+// coding the partial file was written with, restarting the download otherwise. A cached
+// entry turns the request into a revalidation of the stored copy.
+func (w *DownloadWorker) fetchStored(ctx context.Context, rawURL, filename string, offset int64, opts domain.TaskOptions, cached *storage.CacheEntry) (*FetchResponse, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse URL: %w", err)
+	}
+
+	req := FetchRequest{
+		URL:          u,
+		Offset:       offset,
+		Credentials:  opts.CredentialsFor(rawURL),
+		KeepEncoding: opts.Encoding == domain.EncodingEncoded,
+	}
+	if cached != nil {
+		req.IfNoneMatch = cached.ETag
+		req.IfModifiedSince = cached.LastModified
+	}
+
+	resp, err := w.openRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.KeepEncoding && resp.Offset > 0 && !w.partialMatchesEncoding(filename, resp.ContentEncoding) {
+		w.logger.Warn("content encoding changed since partial download, restarting",
+			"url", rawURL,
+			"content_encoding", resp.ContentEncoding,
+		)
+		resp.Body.Close()
+		w.recordRetry(ctx, metrics.RetryRestart)
+		req.Offset = 0
+		return w.openRequest(ctx, req)
+	}
+
+	return resp, nil
+}
+
+// partialMatchesEncoding reports whether the partial file starts like a stream in the
+// outermost coding of encoding. Codings without a recognizable header always match.
+func (w *DownloadWorker) partialMatchesEncoding(filename, encoding string) bool {
+	file, err := w.fileStorage.OpenFile(filename, os.O_RDONLY)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(file, head)
+	head = head[:n]
+
+	codings := strings.Split(encoding, ", ")
+	switch codings[len(codings)-1] {
+	case "gzip":
+		return bytes.HasPrefix(head, []byte{0x1f, 0x8b})
+	case "zstd":
+		return bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd})
+	default:
+		return true
+	}
+}
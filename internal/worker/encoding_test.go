@@ -0,0 +1,262 @@
+package worker
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// encodingServer serves payload gzip-encoded to clients that accept gzip and honours
+// Range requests against whichever representation it sends.
+func encodingServer(t *testing.T, payload []byte, gzipRanges bool) (*httptest.Server, *[]string) {
+	t.Helper()
+	encoded := gzipBytes(t, "", payload)
+	var seen []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Range")+"|"+r.Header.Get("Accept-Encoding"))
+
+		body := payload
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || gzipRanges {
+			body = encoded
+			rw.Header().Set("Content-Encoding", "gzip")
+		}
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.Header().Set("Accept-Ranges", "bytes")
+
+		var start int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start); err == nil && start > 0 {
+			rw.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(body)-1, len(body)))
+			rw.WriteHeader(http.StatusPartialContent)
+			rw.Write(body[start:])
+			return
+		}
+		rw.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &seen
+}
+
+// sampleText returns text that compresses, but not below the offsets used by resume tests.
+func sampleText() []byte {
+	var b strings.Builder
+	for i := range 500 {
+		fmt.Fprintf(&b, "%d,", i*i*7919%100003)
+	}
+	return []byte(b.String())
+}
+
+func TestDownloadURL_ContentEncoding(t *testing.T) {
+	payload := []byte(strings.Repeat("plain text line\n", 200))
+
+	tests := []struct {
+		name     string
+		mode     domain.EncodingMode
+		want     []byte
+		wantMode domain.EncodingMode
+	}{
+		{"decoded by default", "", payload, domain.EncodingDecoded},
+		{"encoded", domain.EncodingEncoded, gzipBytes(t, "", payload), domain.EncodingEncoded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, seen := encodingServer(t, payload, false)
+			dir := makeTempDir(t)
+			w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+
+			result, err := w.DownloadURL(context.Background(), server.URL+"/file.txt", "task1", domain.TaskOptions{Encoding: tt.mode})
+			if err != nil {
+				t.Fatalf("DownloadURL error: %v", err)
+			}
+			if result.ContentEncoding != "gzip" || result.Encoding != tt.wantMode {
+				t.Fatalf("unexpected encoding on result %+v", result)
+			}
+			if result.MIMEType != "text/plain" {
+				t.Fatalf("expected content check to see decoded text, got %q", result.MIMEType)
+			}
+			if (*seen)[0] != "|"+acceptEncoding {
+				t.Fatalf("unexpected request headers %q", (*seen)[0])
+			}
+
+			data, _ := os.ReadFile(filepath.Join(dir, result.FileName))
+			if !bytes.Equal(data, tt.want) {
+				t.Fatalf("stored %d bytes, want %d", len(data), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestDownloadURL_ResumeDecoded(t *testing.T) {
+	payload := sampleText()
+
+	for _, gzipRanges := range []bool{false, true} {
+		t.Run(fmt.Sprintf("server ignores identity=%v", gzipRanges), func(t *testing.T) {
+			server, seen := encodingServer(t, payload, gzipRanges)
+			dir := makeTempDir(t)
+			fs := storage.NewFileStorage(dir)
+			w := NewDownloadWorker(fs, newTestLogger())
+
+			rawURL := server.URL + "/file.txt"
+			partial := filepath.Join(dir, w.generateFilename(rawURL, "task1"))
+			os.MkdirAll(filepath.Dir(partial), 0755)
+			os.WriteFile(partial, payload[:300], 0644)
+
+			result, err := w.DownloadURL(context.Background(), rawURL, "task1", domain.TaskOptions{})
+			if err != nil {
+				t.Fatalf("DownloadURL error: %v", err)
+			}
+			if (*seen)[0] != "bytes=300-|identity" {
+				t.Fatalf("expected identity range request, got %q", (*seen)[0])
+			}
+
+			data, _ := os.ReadFile(partial)
+			if !bytes.Equal(data, payload) {
+				t.Fatalf("resumed file is corrupt: %d bytes", len(data))
+			}
+			if gzipRanges && (len(*seen) != 2 || result.Encoding != domain.EncodingDecoded) {
+				t.Fatalf("expected restart from zero, requests %q, result %+v", *seen, result)
+			}
+		})
+	}
+}
+
+func TestDownloadURL_ResumeEncoded(t *testing.T) {
+	payload := sampleText()
+	encoded := gzipBytes(t, "", payload)
+	server, seen := encodingServer(t, payload, false)
+
+	dir := makeTempDir(t)
+	w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+
+	rawURL := server.URL + "/file.txt"
+	partial := filepath.Join(dir, w.generateFilename(rawURL, "task1"))
+	os.MkdirAll(filepath.Dir(partial), 0755)
+	os.WriteFile(partial, encoded[:20], 0644)
+
+	_, err := w.DownloadURL(context.Background(), rawURL, "task1", domain.TaskOptions{Encoding: domain.EncodingEncoded})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if len(*seen) != 1 || (*seen)[0] != "bytes=20-|"+acceptEncoding {
+		t.Fatalf("unexpected requests %q", *seen)
+	}
+
+	data, _ := os.ReadFile(partial)
+	if !bytes.Equal(data, encoded) {
+		t.Fatalf("resumed encoded file differs")
+	}
+}
+
+func TestDownloadURL_ResumeEncodedRestartsOnMismatch(t *testing.T) {
+	payload := sampleText()
+	server, seen := encodingServer(t, payload, false)
+
+	dir := makeTempDir(t)
+	w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+
+	rawURL := server.URL + "/file.txt"
+	partial := filepath.Join(dir, w.generateFilename(rawURL, "task1"))
+	os.MkdirAll(filepath.Dir(partial), 0755)
+	os.WriteFile(partial, payload[:20], 0644)
+
+	if _, err := w.DownloadURL(context.Background(), rawURL, "task1", domain.TaskOptions{Encoding: domain.EncodingEncoded}); err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if len(*seen) != 2 {
+		t.Fatalf("expected a restart, got requests %q", *seen)
+	}
+
+	data, _ := os.ReadFile(partial)
+	if !bytes.Equal(data, gzipBytes(t, "", payload)) {
+		t.Fatalf("expected the whole encoded body after restart")
+	}
+}
+
+func TestDownloadURL_UnsupportedEncoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Encoding", "br")
+		rw.Write([]byte("not really brotli"))
+	}))
+	defer server.Close()
+
+	dir := makeTempDir(t)
+	w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+
+	_, err := w.DownloadURL(context.Background(), server.URL+"/file", "task1", domain.TaskOptions{})
+	if !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Fatalf("expected ErrUnsupportedEncoding, got %v", err)
+	}
+
+	result, err := w.DownloadURL(context.Background(), server.URL+"/file", "task2", domain.TaskOptions{Encoding: domain.EncodingEncoded})
+	if err != nil {
+		t.Fatalf("encoded storage should keep unknown codings: %v", err)
+	}
+	if result.ContentEncoding != "br" || result.Encoding != domain.EncodingEncoded {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestNewDecoder(t *testing.T) {
+	payload := []byte(strings.Repeat("decode me ", 50))
+
+	var zlibBuf, flateBuf bytes.Buffer
+	zw := zlib.NewWriter(&zlibBuf)
+	zw.Write(payload)
+	zw.Close()
+	fw, _ := flate.NewWriter(&flateBuf, flate.DefaultCompression)
+	fw.Write(payload)
+	fw.Close()
+
+	var stacked bytes.Buffer
+	gw := gzip.NewWriter(&stacked)
+	gw.Write(zlibBuf.Bytes())
+	gw.Close()
+
+	tests := []struct {
+		name     string
+		header   string
+		encoded  []byte
+		wantCode string
+	}{
+		{"gzip", "x-gzip", gzipBytes(t, "", payload), "gzip"},
+		{"zlib deflate", "deflate", zlibBuf.Bytes(), "deflate"},
+		{"raw deflate", "Deflate", flateBuf.Bytes(), "deflate"},
+		{"zstd", "zstd", zstdBytes(t, payload), "zstd"},
+		{"stacked", "deflate, identity, gzip", stacked.Bytes(), "deflate, gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, err := parseContentEncoding(tt.header)
+			if err != nil || encoding != tt.wantCode {
+				t.Fatalf("parseContentEncoding(%q) = %q, %v", tt.header, encoding, err)
+			}
+
+			dec, err := newDecoder(encoding, io.NopCloser(bytes.NewReader(tt.encoded)))
+			if err != nil {
+				t.Fatalf("newDecoder error: %v", err)
+			}
+			defer dec.Close()
+
+			got, err := io.ReadAll(dec)
+			if err != nil || !bytes.Equal(got, payload) {
+				t.Fatalf("decoded %q, %v", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,536 @@
+package worker
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+// ErrArchiveLimit is returned when an archive exceeds the configured extraction limits.
+var ErrArchiveLimit = errors.New("archive exceeds extraction limits")
+
+// ErrUnsafeArchivePath is returned for archive entries that would be written outside
+// the extraction directory (zip-slip).
+var ErrUnsafeArchivePath = errors.New("unsafe path in archive")
+
+// ExtractLimits protects against decompression bombs.
+// A zero value disables the corresponding limit.
+type ExtractLimits struct {
+	// MaxRatio is the maximum total extracted size relative to the archive size.
+	MaxRatio float64
+	// MaxTotalSize is the maximum total number of bytes extracted from one archive.
+	MaxTotalSize int64
+	// MaxFiles is the maximum number of entries extracted from one archive.
+	MaxFiles int
+}
+
+// DefaultExtractLimits returns the limits used unless WithExtractLimits is given.
+func DefaultExtractLimits() ExtractLimits {
+	return ExtractLimits{
+		MaxRatio:     1000,
+		MaxTotalSize: 10 << 30,
+		MaxFiles:     100000,
+	}
+}
+
+// WithExtractLimits sets the decompression bomb limits applied when tasks request extraction.
+func WithExtractLimits(limits ExtractLimits) Option {
+	return func(w *DownloadWorker) {
+		w.extractLimits = limits
+	}
+}
+
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+	archiveTarZs = "tar.zst"
+	archiveGzip  = "gzip"
+)
+
+// archiveExtensions are stripped from the URL to name the extraction directory.
+var archiveExtensions = []string{".tar.gz", ".tgz", ".tar.zst", ".tzst", ".tar", ".zip", ".gz"}
+
+// extractResult unpacks the downloaded file into <task>/<archive name>/ when it is a
+// supported archive, recording the manifest on the result. Other files are left as they are.
+func (w *DownloadWorker) extractResult(result *domain.DownloadResult, rawURL, taskID string) error {
+	archivePath := w.fileStorage.Path(result.FileName)
+
+	info, err := os.Stat(archivePath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	defer file.Close()
+
+	kind, err := detectArchive(file)
+	if err != nil {
+		return fmt.Errorf("detect archive: %w", err)
+	}
+	if kind == "" {
+		return nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind archive: %w", err)
+	}
+
+	stem := archiveStem(rawURL)
+	dir := filepath.Join(taskID, stem)
+	// Extraction and its cleanup must never reach beyond the task directory.
+	if rel, err := filepath.Rel(taskID, dir); err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return fmt.Errorf("%w: extraction dir %q", ErrUnsafeArchivePath, dir)
+	}
+	if err := os.MkdirAll(w.fileStorage.Path(dir), 0755); err != nil {
+		return fmt.Errorf("create extraction dir: %w", err)
+	}
+
+	root, err := os.OpenRoot(w.fileStorage.Path(dir))
+	if err != nil {
+		return fmt.Errorf("open extraction dir: %w", err)
+	}
+	defer root.Close()
+
+	e := &extractor{
+		root:   root,
+		dir:    dir,
+		limits: w.extractLimits,
+		budget: extractBudget(w.extractLimits, info.Size()),
+	}
+
+	switch kind {
+	case archiveZip:
+		err = e.extractZip(file, info.Size())
+	case archiveTar:
+		err = e.extractTar(file)
+	case archiveTarGz:
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(file); err == nil {
+			err = e.extractTar(gz)
+			gz.Close()
+		}
+	case archiveTarZs:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(file); err == nil {
+			err = e.extractTar(zr)
+			zr.Close()
+		}
+	case archiveGzip:
+		err = e.extractGzip(file, stem)
+	}
+
+	result.ArchiveType = kind
+	result.Extracted = e.manifest
+
+	if err != nil {
+		if removeErr := os.RemoveAll(w.fileStorage.Path(dir)); removeErr != nil {
+			w.logger.Warn("failed to remove partial extraction",
+				"dir", dir,
+				"error", removeErr,
+			)
+		}
+		result.Extracted = nil
+		return err
+	}
+
+	w.logger.Info("archive extracted",
+		"url", rawURL,
+		"archive_type", kind,
+		"dir", dir,
+		"entries", len(e.manifest),
+		"bytes", e.written,
+	)
+	return nil
+}
+
+// detectArchive identifies the archive format from its magic bytes. Compressed
+// streams are peeked into to tell a compressed tar from a single compressed file.
+func detectArchive(file *os.File) (string, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return archiveZip, nil
+	case isTarHeader(header):
+		return archiveTar, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return "", nil
+		}
+		if isTarHeader(peek(gz)) {
+			return archiveTarGz, nil
+		}
+		return archiveGzip, nil
+
+	case bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return "", nil
+		}
+		defer zr.Close()
+		if isTarHeader(peek(zr)) {
+			return archiveTarZs, nil
+		}
+	}
+
+	return "", nil
+}
+
+func peek(r io.Reader) []byte {
+	head, _ := bufio.NewReaderSize(r, 512).Peek(512)
+	return head
+}
+
+func isTarHeader(header []byte) bool {
+	return len(header) >= 262 && string(header[257:262]) == "ustar"
+}
+
+// archiveStem names the extraction directory after the URL's file name without archive extensions.
+// Names that do not stay inside the task directory, such as "..", fall back to "archive".
+func archiveStem(rawURL string) string {
+	name := "archive"
+	if u, err := url.Parse(rawURL); err == nil {
+		if base := path.Base(u.Path); base != "/" && base != "." {
+			name = base
+		}
+	}
+
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			name = name[:len(name)-len(ext)]
+			break
+		}
+	}
+	if name == "." || !filepath.IsLocal(name) || filepath.Base(name) != name {
+		return "archive"
+	}
+	return name
+}
+
+func extractBudget(limits ExtractLimits, archiveSize int64) int64 {
+	budget := int64(-1)
+	if limits.MaxTotalSize > 0 {
+		budget = limits.MaxTotalSize
+	}
+	if limits.MaxRatio > 0 {
+		byRatio := int64(limits.MaxRatio * float64(archiveSize))
+		if budget < 0 || byRatio < budget {
+			budget = byRatio
+		}
+	}
+	return budget
+}
+
+// extractor writes archive entries below root, enforcing path safety and size limits.
+type extractor struct {
+	root     *os.Root
+	dir      string
+	limits   ExtractLimits
+	budget   int64
+	written  int64
+	files    int
+	manifest []domain.ExtractedFile
+}
+
+func (e *extractor) extractZip(file *os.File, size int64) error {
+	zr, err := zip.NewReader(file, size)
+	if err != nil {
+		return fmt.Errorf("open zip: %w", err)
+	}
+
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := e.mkdir(f.Name); err != nil {
+				return err
+			}
+
+		case mode&fs.ModeSymlink != 0:
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("open %s: %w", f.Name, err)
+			}
+			target, err := io.ReadAll(io.LimitReader(rc, 4096))
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("read %s: %w", f.Name, err)
+			}
+			if err := e.symlink(f.Name, string(target)); err != nil {
+				return err
+			}
+
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("open %s: %w", f.Name, err)
+			}
+			err = e.writeFile(f.Name, rc, mode)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+
+		default:
+			if err := e.skip(f.Name, "unsupported entry type"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = e.mkdir(hdr.Name)
+		case tar.TypeReg:
+			err = e.writeFile(hdr.Name, tr, hdr.FileInfo().Mode())
+		case tar.TypeSymlink:
+			err = e.symlink(hdr.Name, hdr.Linkname)
+		case tar.TypeLink:
+			err = e.hardlink(hdr.Name, hdr.Linkname)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			err = e.skip(hdr.Name, "unsupported entry type")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *extractor) extractGzip(file *os.File, stem string) error {
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("open gzip: %w", err)
+	}
+	defer gz.Close()
+
+	name := stem
+	if header := path.Base(filepath.ToSlash(gz.Name)); gz.Name != "" && safeEntryName(header) {
+		name = header
+	}
+	return e.writeFile(name, gz, 0644)
+}
+
+// safeEntryName reports whether an archive entry name stays inside the extraction directory.
+func safeEntryName(name string) bool {
+	name = strings.TrimSuffix(filepath.FromSlash(name), string(filepath.Separator))
+	return name != "" && filepath.IsLocal(name)
+}
+
+func cleanEntryName(name string) (string, error) {
+	if !safeEntryName(name) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafeArchivePath, name)
+	}
+	return filepath.Clean(filepath.FromSlash(name)), nil
+}
+
+func (e *extractor) countEntry() error {
+	e.files++
+	if e.limits.MaxFiles > 0 && e.files > e.limits.MaxFiles {
+		return fmt.Errorf("%w: more than %d entries", ErrArchiveLimit, e.limits.MaxFiles)
+	}
+	return nil
+}
+
+func (e *extractor) mkdir(name string) error {
+	clean, err := cleanEntryName(name)
+	if err != nil {
+		return err
+	}
+	return e.mkdirAll(clean)
+}
+
+// mkdirAll creates every component of dir inside the root.
+func (e *extractor) mkdirAll(dir string) error {
+	if dir == "." {
+		return nil
+	}
+	var prefix string
+	for _, part := range strings.Split(dir, string(filepath.Separator)) {
+		prefix = filepath.Join(prefix, part)
+		if err := e.root.Mkdir(prefix, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("create dir %s: %w", prefix, err)
+		}
+	}
+	return nil
+}
+
+func (e *extractor) writeFile(name string, r io.Reader, mode fs.FileMode) error {
+	clean, err := cleanEntryName(name)
+	if err != nil {
+		return err
+	}
+	if err := e.countEntry(); err != nil {
+		return err
+	}
+	if err := e.mkdirAll(filepath.Dir(clean)); err != nil {
+		return err
+	}
+
+	perm := fs.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+	f, err := e.root.OpenFile(clean, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", clean, err)
+	}
+	defer f.Close()
+
+	// Sizes declared in headers can lie, so the limit is enforced on the bytes actually written.
+	src := r
+	if e.budget >= 0 {
+		src = io.LimitReader(r, e.budget-e.written+1)
+	}
+	n, err := io.Copy(f, src)
+	e.written += n
+	if err != nil {
+		return fmt.Errorf("write %s: %w", clean, err)
+	}
+	if e.budget >= 0 && e.written > e.budget {
+		return fmt.Errorf("%w: more than %d bytes", ErrArchiveLimit, e.budget)
+	}
+
+	e.manifest = append(e.manifest, domain.ExtractedFile{
+		Path: filepath.Join(e.dir, clean),
+		Size: n,
+		Type: "file",
+	})
+	return nil
+}
+
+// symlink creates a symbolic link only when its target stays inside the extraction
+// directory and none of its parent directories is itself a symlink; others are skipped.
+func (e *extractor) symlink(name, target string) error {
+	clean, err := cleanEntryName(name)
+	if err != nil {
+		return err
+	}
+	if err := e.countEntry(); err != nil {
+		return err
+	}
+
+	entry := domain.ExtractedFile{
+		Path:       filepath.Join(e.dir, clean),
+		Type:       "symlink",
+		LinkTarget: target,
+	}
+
+	resolved := filepath.Join(filepath.Dir(clean), filepath.FromSlash(target))
+	switch {
+	case filepath.IsAbs(target) || !filepath.IsLocal(resolved):
+		entry.Skipped = "symlink points outside the archive"
+	case e.hasSymlinkParent(clean):
+		entry.Skipped = "symlink parent is a symlink"
+	}
+	if entry.Skipped != "" {
+		e.manifest = append(e.manifest, entry)
+		return nil
+	}
+
+	if err := e.mkdirAll(filepath.Dir(clean)); err != nil {
+		return err
+	}
+	linkPath := filepath.Join(e.root.Name(), clean)
+	if err := os.Remove(linkPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("replace %s: %w", clean, err)
+	}
+	if err := os.Symlink(filepath.FromSlash(target), linkPath); err != nil {
+		return fmt.Errorf("create symlink %s: %w", clean, err)
+	}
+
+	e.manifest = append(e.manifest, entry)
+	return nil
+}
+
+func (e *extractor) hasSymlinkParent(name string) bool {
+	var prefix string
+	for _, part := range strings.Split(filepath.Dir(name), string(filepath.Separator)) {
+		if part == "." {
+			break
+		}
+		prefix = filepath.Join(prefix, part)
+		info, err := e.root.Lstat(prefix)
+		if err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hardlink copies an already extracted file, so the copy counts against the size limits.
+func (e *extractor) hardlink(name, target string) error {
+	cleanTarget, err := cleanEntryName(target)
+	if err != nil {
+		return err
+	}
+
+	src, err := e.root.Open(cleanTarget)
+	if err != nil {
+		return fmt.Errorf("open link target %s: %w", cleanTarget, err)
+	}
+	defer src.Close()
+
+	return e.writeFile(name, src, 0644)
+}
+
+func (e *extractor) skip(name, reason string) error {
+	clean, err := cleanEntryName(name)
+	if err != nil {
+		return err
+	}
+	if err := e.countEntry(); err != nil {
+		return err
+	}
+	e.manifest = append(e.manifest, domain.ExtractedFile{
+		Path:    filepath.Join(e.dir, clean),
+		Type:    "other",
+		Skipped: reason,
+	})
+	return nil
+}
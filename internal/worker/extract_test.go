@@ -0,0 +1,304 @@
+package worker
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+type tarEntry struct {
+	name     string
+	body     string
+	typeflag byte
+	linkname string
+}
+
+func buildTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: e.typeflag, Linkname: e.linkname}
+		if e.typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("write tar header: %v", err)
+		}
+		if e.typeflag == tar.TypeReg {
+			tw.Write([]byte(e.body))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Name = name
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("create zstd writer: %v", err)
+	}
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zstd: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func buildZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create zip entry: %v", err)
+		}
+		f.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// downloadArchive serves data at /<name> and downloads it with extraction enabled.
+func downloadArchive(t *testing.T, w *DownloadWorker, name string, data []byte) (domain.DownloadResult, error) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/octet-stream")
+		rw.Write(data)
+	}))
+	defer server.Close()
+
+	return w.DownloadURL(context.Background(), server.URL+"/"+name, "task1", domain.TaskOptions{Extract: true})
+}
+
+func readExtracted(t *testing.T, dir string, parts ...string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(append([]string{dir}, parts...)...))
+	if err != nil {
+		t.Fatalf("read extracted file: %v", err)
+	}
+	return string(data)
+}
+
+func TestExtract_Formats(t *testing.T) {
+	tarData := buildTar(t, []tarEntry{
+		{name: "docs/", typeflag: tar.TypeDir},
+		{name: "docs/readme.txt", body: "hello", typeflag: tar.TypeReg},
+	})
+
+	tests := []struct {
+		name     string
+		file     string
+		data     []byte
+		kind     string
+		wantPath []string
+		want     string
+	}{
+		{"zip", "bundle.zip", buildZip(t, map[string]string{"docs/readme.txt": "hello"}), archiveZip, []string{"bundle", "docs", "readme.txt"}, "hello"},
+		{"tar", "bundle.tar", tarData, archiveTar, []string{"bundle", "docs", "readme.txt"}, "hello"},
+		{"tar.gz", "bundle.tar.gz", gzipBytes(t, "", tarData), archiveTarGz, []string{"bundle", "docs", "readme.txt"}, "hello"},
+		{"tar.zst", "bundle.tar.zst", zstdBytes(t, tarData), archiveTarZs, []string{"bundle", "docs", "readme.txt"}, "hello"},
+		{"gzip", "data.csv.gz", gzipBytes(t, "", []byte("a,b\n1,2\n")), archiveGzip, []string{"data.csv", "data.csv"}, "a,b\n1,2\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := makeTempDir(t)
+			w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+
+			result, err := downloadArchive(t, w, tt.file, tt.data)
+			if err != nil {
+				t.Fatalf("DownloadURL error: %v", err)
+			}
+			if !result.Success || result.ArchiveType != tt.kind {
+				t.Fatalf("unexpected result %+v", result)
+			}
+
+			if got := readExtracted(t, filepath.Join(dir, "task1"), tt.wantPath...); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+
+			wantManifest := filepath.Join(append([]string{"task1"}, tt.wantPath...)...)
+			if len(result.Extracted) != 1 || result.Extracted[0].Path != wantManifest || result.Extracted[0].Size != int64(len(tt.want)) {
+				t.Fatalf("unexpected manifest %+v", result.Extracted)
+			}
+		})
+	}
+}
+
+func TestExtract_Links(t *testing.T) {
+	data := buildTar(t, []tarEntry{
+		{name: "a.txt", body: "content", typeflag: tar.TypeReg},
+		{name: "inside", typeflag: tar.TypeSymlink, linkname: "a.txt"},
+		{name: "outside", typeflag: tar.TypeSymlink, linkname: "../../etc/passwd"},
+		{name: "absolute", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+		{name: "p", typeflag: tar.TypeSymlink, linkname: "."},
+		{name: "p/escape", typeflag: tar.TypeSymlink, linkname: ".."},
+		{name: "copy.txt", typeflag: tar.TypeLink, linkname: "a.txt"},
+	})
+
+	dir := makeTempDir(t)
+	w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+
+	result, err := downloadArchive(t, w, "links.tar", data)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+
+	skipped := map[string]bool{}
+	for _, entry := range result.Extracted {
+		if entry.Skipped != "" {
+			skipped[filepath.Base(entry.Path)] = true
+		}
+	}
+	for _, name := range []string{"outside", "absolute", "escape"} {
+		if !skipped[name] {
+			t.Fatalf("expected %s to be skipped, manifest %+v", name, result.Extracted)
+		}
+	}
+
+	base := filepath.Join(dir, "task1", "links")
+	if got := readExtracted(t, base, "inside"); got != "content" {
+		t.Fatalf("expected symlink to resolve to a.txt, got %q", got)
+	}
+	if got := readExtracted(t, base, "copy.txt"); got != "content" {
+		t.Fatalf("expected hard link to be copied, got %q", got)
+	}
+	for _, name := range []string{"outside", "absolute", "escape"} {
+		if _, err := os.Lstat(filepath.Join(base, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s not to be created, got %v", name, err)
+		}
+	}
+}
+
+func TestExtract_ZipSlip(t *testing.T) {
+	dir := makeTempDir(t)
+	w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+
+	data := buildZip(t, map[string]string{"../../evil.txt": "pwned"})
+	result, err := downloadArchive(t, w, "evil.zip", data)
+	if !errors.Is(err, ErrUnsafeArchivePath) {
+		t.Fatalf("expected ErrUnsafeArchivePath, got %v", err)
+	}
+	if result.Success || result.Extracted != nil {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+		t.Fatalf("zip-slip entry was written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "task1", "evil")); !os.IsNotExist(err) {
+		t.Fatalf("expected partial extraction to be removed: %v", err)
+	}
+}
+
+func TestExtract_BombLimits(t *testing.T) {
+	zeros := make([]byte, 4<<20)
+
+	tests := []struct {
+		name   string
+		limits ExtractLimits
+		data   []byte
+	}{
+		{"ratio", ExtractLimits{MaxRatio: 10}, gzipBytes(t, "zeros", zeros)},
+		{"total size", ExtractLimits{MaxTotalSize: 1 << 20}, gzipBytes(t, "zeros", zeros)},
+		{"file count", ExtractLimits{MaxFiles: 2}, buildZip(t, map[string]string{"a": "1", "b": "2", "c": "3"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := makeTempDir(t)
+			w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger(), WithExtractLimits(tt.limits))
+
+			result, err := downloadArchive(t, w, "bomb.gz", tt.data)
+			if !errors.Is(err, ErrArchiveLimit) {
+				t.Fatalf("expected ErrArchiveLimit, got %v", err)
+			}
+			if result.Success {
+				t.Fatalf("expected failed result")
+			}
+		})
+	}
+}
+
+func TestExtract_NonArchiveIsKept(t *testing.T) {
+	dir := makeTempDir(t)
+	w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+
+	result, err := downloadArchive(t, w, "notes.txt", []byte("plain text"))
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if !result.Success || result.ArchiveType != "" || result.Extracted != nil {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestArchiveStem(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/files/data.tar.gz", "data"},
+		{"https://example.com/files/data.zip?x=1", "data"},
+		{"https://example.com/", "archive"},
+		{"https://example.com/a/..", "archive"},
+		{"https://example.com/a/%2e%2e", "archive"},
+		{"https://example.com/a/..tgz", "archive"},
+		{"https://example.com/a/.zip", ".zip"},
+	}
+	for _, tt := range tests {
+		if got := archiveStem(tt.url); got != tt.want {
+			t.Errorf("archiveStem(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestExtract_DotDotURLStaysInTaskDir(t *testing.T) {
+	dir := makeTempDir(t)
+	if err := os.WriteFile(filepath.Join(dir, "other.txt"), []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+
+	data := buildZip(t, map[string]string{"../x": "pwned"})
+	if _, err := downloadArchive(t, w, "a/%2e%2e", data); !errors.Is(err, ErrUnsafeArchivePath) {
+		t.Fatalf("expected ErrUnsafeArchivePath, got %v", err)
+	}
+	if got := readExtracted(t, dir, "other.txt"); got != "keep" {
+		t.Fatalf("expected files outside the task to be kept, got %q", got)
+	}
+}
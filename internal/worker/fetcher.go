@@ -0,0 +1,208 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"sync"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/metrics"
+)
+
+// ErrUnsupportedScheme is returned when no fetcher is registered for a URL scheme.
+var ErrUnsupportedScheme = errors.New("unsupported URL scheme")
+
+// Fetcher opens a byte stream for URLs of one scheme. Implementations should resume
+// at FetchRequest.Offset when the source supports it and report the offset actually used.
+type Fetcher interface {
+	Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error)
+}
+
+// HostlessFetcher is optionally implemented by fetchers whose URLs carry no host
+// (for example data: URLs), so that host-based safety checks are skipped.
+type HostlessFetcher interface {
+	Hostless() bool
+}
+
+// FetchRequest describes a single fetch.
+type FetchRequest struct {
+	URL         *url.URL
+	Offset      int64
+	Credentials domain.RequestCredentials
+	// KeepEncoding asks for the body exactly as the server encoded it. Otherwise
+	// any Content-Encoding is removed before the body is returned.
+	KeepEncoding bool
+	// IfNoneMatch and IfModifiedSince make the request conditional on the resource
+	// having changed. Fetchers that cannot revalidate ignore them.
+	IfNoneMatch     string
+	IfModifiedSince string
+}
+
+// FetchResponse is an open source body with the metadata the source reported.
+type FetchResponse struct {
+	Body io.ReadCloser
+	// Offset is the position Body starts at; zero when the source restarted from the beginning.
+	Offset int64
+	// Size is the total size of the resource, or -1 when unknown.
+	Size          int64
+	ContentType   string
+	ETag          string
+	LastModified  string
+	SupportsRange bool
+	// Proxy is the proxy used for the request, without credentials.
+	Proxy string
+	// ContentEncoding is the coding the server applied to the body, if any.
+	// Decoded reports whether it has already been removed from Body.
+	ContentEncoding string
+	Decoded         bool
+	// NotModified reports that a conditional request found the resource unchanged;
+	// Body is then empty.
+	NotModified bool
+}
+
+// Registry maps URL schemes to fetchers. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	fetchers map[string]Fetcher
+}
+
+// NewRegistry creates an empty fetcher registry.
+func NewRegistry() *Registry {
+	return &Registry{fetchers: make(map[string]Fetcher)}
+}
+
+// Register associates a fetcher with a URL scheme, replacing any previous one.
+func (r *Registry) Register(scheme string, f Fetcher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fetchers[scheme] = f
+}
+
+// Lookup returns the fetcher registered for a scheme.
+func (r *Registry) Lookup(scheme string) (Fetcher, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	f, ok := r.fetchers[scheme]
+	return f, ok
+}
+
+// Supports reports whether a fetcher is registered for the scheme.
+func (r *Registry) Supports(scheme string) bool {
+	_, ok := r.Lookup(scheme)
+	return ok
+}
+
+// RequiresHost reports whether URLs of the scheme must name a host.
+func (r *Registry) RequiresHost(scheme string) bool {
+	f, ok := r.Lookup(scheme)
+	if !ok {
+		return true
+	}
+	hostless, ok := f.(HostlessFetcher)
+	return !ok || !hostless.Hostless()
+}
+
+// Schemes returns the registered schemes in sorted order.
+func (r *Registry) Schemes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	schemes := make([]string, 0, len(r.fetchers))
+	for scheme := range r.fetchers {
+		schemes = append(schemes, scheme)
+	}
+	slices.Sort(schemes)
+	return schemes
+}
+
+// WithFetcher registers a fetcher for a URL scheme, overriding the built-in one if any.
+func WithFetcher(scheme string, f Fetcher) Option {
+	return func(w *DownloadWorker) {
+		w.customFetchers[scheme] = f
+	}
+}
+
+// Fetchers returns the worker's fetcher registry.
+func (w *DownloadWorker) Fetchers() *Registry {
+	return w.fetchers
+}
+
+// registerBuiltinFetchers installs the http, https, ftp and sftp fetchers followed by
+// any fetchers supplied with WithFetcher.
+func (w *DownloadWorker) registerBuiltinFetchers() {
+	httpFetcher := &httpFetcher{client: w.httpClient, proxy: w.resolveProxy}
+	w.fetchers.Register("http", httpFetcher)
+	w.fetchers.Register("https", httpFetcher)
+	w.fetchers.Register("ftp", &ftpFetcher{dialTimeout: w.transportConfig.DialTimeout})
+	w.fetchers.Register("sftp", &sftpFetcher{dialTimeout: w.transportConfig.DialTimeout, hostKeys: w.sshHostKeys})
+	if w.torrents != nil {
+		w.fetchers.Register("magnet", magnetScheme{})
+	}
+
+	for scheme, f := range w.customFetchers {
+		w.fetchers.Register(scheme, f)
+	}
+}
+
+// fetch picks the fetcher for the URL scheme and opens the source.
+func (w *DownloadWorker) fetch(ctx context.Context, rawURL string, offset int64, opts domain.TaskOptions) (*FetchResponse, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse URL: %w", err)
+	}
+	return w.open(ctx, u, offset, opts.CredentialsFor(rawURL))
+}
+
+// open fetches an already parsed URL with the given credentials. It is used for
+// resources discovered while downloading, such as stream segments.
+func (w *DownloadWorker) open(ctx context.Context, u *url.URL, offset int64, creds domain.RequestCredentials) (*FetchResponse, error) {
+	return w.openRequest(ctx, FetchRequest{
+		URL:         u,
+		Offset:      offset,
+		Credentials: creds,
+	})
+}
+
+func (w *DownloadWorker) openRequest(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	f, ok := w.fetchers.Lookup(req.URL.Scheme)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedScheme, req.URL.Scheme)
+	}
+
+	resp, err := f.Fetch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if w.metrics != nil {
+		resp.Body = &countingBody{ReadCloser: resp.Body, metrics: w.metrics}
+	}
+	if !resp.SupportsRange {
+		resp.Offset = 0
+	}
+	if resp.ContentEncoding == "" || req.KeepEncoding {
+		return resp, nil
+	}
+
+	// Range offsets of an encoded response count encoded bytes, which cannot be
+	// appended to a decoded partial file, so start over from the beginning.
+	if resp.Offset > 0 {
+		resp.Body.Close()
+		w.recordRetry(ctx, metrics.RetryRestart)
+		req.Offset = 0
+		return w.openRequest(ctx, req)
+	}
+
+	body, err := newDecoder(resp.ContentEncoding, resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	resp.Body = body
+	resp.Size = -1
+	resp.Decoded = true
+	return resp, nil
+}
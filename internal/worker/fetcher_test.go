@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// memFetcher serves in-memory objects for mem://bucket/key URLs.
+type memFetcher struct {
+	objects map[string]string
+}
+
+func (f *memFetcher) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	content, ok := f.objects[req.URL.Host+req.URL.Path]
+	if !ok {
+		return nil, errors.New("object not found")
+	}
+	return &FetchResponse{
+		Body:          io.NopCloser(strings.NewReader(content[req.Offset:])),
+		Offset:        req.Offset,
+		Size:          int64(len(content)),
+		ETag:          `"v1"`,
+		SupportsRange: true,
+	}, nil
+}
+
+type dataFetcher struct{}
+
+func (dataFetcher) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	_, payload, _ := strings.Cut(req.URL.Opaque, ",")
+	return &FetchResponse{Body: io.NopCloser(strings.NewReader(payload)), Size: -1}, nil
+}
+
+func (dataFetcher) Hostless() bool { return true }
+
+func TestDownloadWorker_CustomFetcher(t *testing.T) {
+	fs := storage.NewFileStorage(makeTempDir(t))
+	worker := NewDownloadWorker(fs, newTestLogger(),
+		WithFetcher("mem", &memFetcher{objects: map[string]string{"bucket/data.txt": "in-memory content"}}),
+	)
+
+	result, err := worker.DownloadURL(context.Background(), "mem://bucket/data.txt", "custom", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if result.BytesRead != int64(len("in-memory content")) || result.ETag != `"v1"` {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	_, err = worker.DownloadURL(context.Background(), "gopher://example.com/file", "custom", domain.TaskOptions{})
+	if !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("expected ErrUnsupportedScheme, got %v", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	fs := storage.NewFileStorage(makeTempDir(t))
+	worker := NewDownloadWorker(fs, newTestLogger(), WithFetcher("data", dataFetcher{}))
+	registry := worker.Fetchers()
+
+	want := []string{"data", "ftp", "http", "https", "sftp"}
+	if got := registry.Schemes(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected schemes %v, got %v", want, got)
+	}
+	if registry.RequiresHost("data") {
+		t.Errorf("expected data scheme to be hostless")
+	}
+	if !registry.RequiresHost("https") {
+		t.Errorf("expected https scheme to require a host")
+	}
+	if registry.Supports("s3") {
+		t.Errorf("expected s3 to be unsupported")
+	}
+}
+
+func TestDownloadWorker_IncompleteDownload(t *testing.T) {
+	fs := storage.NewFileStorage(makeTempDir(t))
+	short := &memFetcher{objects: map[string]string{"bucket/file": "short"}}
+	worker := NewDownloadWorker(fs, newTestLogger(), WithFetcher("mem", fetcherFunc(func(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+		resp, err := short.Fetch(ctx, req)
+		if err == nil {
+			resp.Size = 100
+		}
+		return resp, err
+	})))
+
+	result, err := worker.DownloadURL(context.Background(), "mem://bucket/file", "short", domain.TaskOptions{})
+	if err == nil || result.Success {
+		t.Errorf("expected truncated download to fail, got %+v", result)
+	}
+}
+
+type fetcherFunc func(ctx context.Context, req FetchRequest) (*FetchResponse, error)
+
+func (f fetcherFunc) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	return f(ctx, req)
+}
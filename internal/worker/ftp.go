@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+// ftpFetcher fetches ftp URLs in passive mode, resuming with REST when the server supports it.
+type ftpFetcher struct {
+	dialTimeout time.Duration
+}
+
+func (f *ftpFetcher) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	u, offset := req.URL, req.Offset
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "21")
+	}
+
+	conn, err := ftp.Dial(addr,
+		ftp.DialWithContext(ctx),
+		ftp.DialWithTimeout(f.dialTimeout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("connect to ftp server: %w", err)
+	}
+
+	user, password := loginFor(u, req.Credentials)
+	if user == "" {
+		user, password = "anonymous", "anonymous"
+	}
+
+	if err := conn.Login(user, password); err != nil {
+		_ = conn.Quit()
+		return nil, fmt.Errorf("ftp login: %w", err)
+	}
+
+	size, err := conn.FileSize(u.Path)
+	if err != nil {
+		size = -1
+	}
+
+	supportsRange := true
+	resp, err := conn.RetrFrom(u.Path, uint64(offset))
+	if err != nil && offset > 0 {
+		// The server rejected REST; fall back to a full transfer.
+		offset, supportsRange = 0, false
+		resp, err = conn.Retr(u.Path)
+	}
+	if err != nil {
+		_ = conn.Quit()
+		return nil, fmt.Errorf("ftp retrieve: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Quit()
+		_ = resp.Close()
+	})
+
+	return &FetchResponse{
+		Body:          &ftpBody{Response: resp, conn: conn, stop: stop},
+		Offset:        offset,
+		Size:          size,
+		SupportsRange: supportsRange,
+	}, nil
+}
+
+// ftpBody closes the data connection and logs out of the control connection.
+type ftpBody struct {
+	*ftp.Response
+	conn *ftp.ServerConn
+	stop func() bool
+}
+
+func (b *ftpBody) Close() error {
+	b.stop()
+	err := b.Response.Close()
+	_ = b.conn.Quit()
+	return err
+}
+
+// loginFor returns the username and password for FTP and SFTP sources. The task's basic auth
+// credentials take precedence over any user info embedded in the URL.
+func loginFor(u *url.URL, creds domain.RequestCredentials) (string, string) {
+	if creds.BasicAuth != nil {
+		return creds.BasicAuth.Username, creds.BasicAuth.Password
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		return u.User.Username(), password
+	}
+	return "", ""
+}
@@ -0,0 +1,200 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// fakeFTPServer is a minimal in-process FTP server supporting passive (EPSV) RETR with optional REST.
+type fakeFTPServer struct {
+	user, password string
+	files          map[string]string
+	supportsREST   bool
+	ln             net.Listener
+}
+
+func startFakeFTPServer(t *testing.T, srv *fakeFTPServer) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv.ln = ln
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func (s *fakeFTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var data net.Listener
+	var rest int64
+	var user string
+
+	reply("220 fake ftp ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+
+		switch strings.ToUpper(cmd) {
+		case "USER":
+			user = arg
+			reply("331 password required")
+		case "PASS":
+			if user == s.user && arg == s.password {
+				reply("230 logged in")
+			} else {
+				reply("530 login incorrect")
+			}
+		case "FEAT":
+			reply("211-Features:\r\n REST STREAM\r\n211 End")
+		case "TYPE":
+			reply("200 type set")
+		case "EPSV":
+			data, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				reply("425 cannot open data connection")
+				continue
+			}
+			reply("229 Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port)
+		case "REST":
+			if !s.supportsREST {
+				reply("502 REST not implemented")
+				continue
+			}
+			rest, _ = strconv.ParseInt(arg, 10, 64)
+			reply("350 restarting at %d", rest)
+		case "RETR":
+			content, ok := s.files[arg]
+			if !ok || data == nil {
+				reply("550 file not found")
+				continue
+			}
+			reply("150 opening data connection")
+			dc, err := data.Accept()
+			if err == nil {
+				_, _ = dc.Write([]byte(content[rest:]))
+				dc.Close()
+			}
+			data.Close()
+			data, rest = nil, 0
+			reply("226 transfer complete")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+func TestDownloadWorker_FTP(t *testing.T) {
+	addr := startFakeFTPServer(t, &fakeFTPServer{
+		user:         "vendor",
+		password:     "secret",
+		files:        map[string]string{"/pub/data.csv": "id,name\n1,alpha\n2,beta\n"},
+		supportsREST: true,
+	})
+
+	dir := makeTempDir(t)
+	fs := storage.NewFileStorage(dir)
+	worker := NewDownloadWorker(fs, newTestLogger())
+
+	fileURL := "ftp://" + addr + "/pub/data.csv"
+	opts := domain.TaskOptions{
+		Credentials: &domain.RequestCredentials{BasicAuth: &domain.BasicAuth{Username: "vendor", Password: "secret"}},
+	}
+
+	result, err := worker.DownloadURL(context.Background(), fileURL, "ftp", opts)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if result.BytesRead != int64(len("id,name\n1,alpha\n2,beta\n")) {
+		t.Errorf("unexpected bytes read: %d", result.BytesRead)
+	}
+
+	// Truncate the file and download again to exercise REST-based resume.
+	filePath := filepath.Join(dir, result.FileName)
+	if err := os.WriteFile(filePath, []byte("id,name\n1,"), 0644); err != nil {
+		t.Fatalf("failed to truncate file: %v", err)
+	}
+
+	if _, err := worker.DownloadURL(context.Background(), fileURL, "ftp", opts); err != nil {
+		t.Fatalf("DownloadURL resume error: %v", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "id,name\n1,alpha\n2,beta\n" {
+		t.Errorf("unexpected resumed content %q", data)
+	}
+
+	badOpts := domain.TaskOptions{
+		Credentials: &domain.RequestCredentials{BasicAuth: &domain.BasicAuth{Username: "vendor", Password: "wrong"}},
+	}
+	if _, err := worker.DownloadURL(context.Background(), fileURL, "ftp-bad", badOpts); err == nil {
+		t.Errorf("expected login failure with wrong password")
+	}
+}
+
+func TestDownloadWorker_FTP_NoRESTFallback(t *testing.T) {
+	addr := startFakeFTPServer(t, &fakeFTPServer{
+		user:     "anonymous",
+		password: "anonymous",
+		files:    map[string]string{"/file.txt": "hello world"},
+	})
+
+	dir := makeTempDir(t)
+	fs := storage.NewFileStorage(dir)
+	worker := NewDownloadWorker(fs, newTestLogger())
+
+	fileURL := "ftp://" + addr + "/file.txt"
+	filePath := filepath.Join(dir, worker.generateFilename(fileURL, "norest"))
+	if err := os.WriteFile(filePath, []byte("hel"), 0644); err != nil {
+		t.Fatalf("failed to create partial file: %v", err)
+	}
+
+	if _, err := worker.DownloadURL(context.Background(), fileURL, "norest", domain.TaskOptions{}); err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("expected full re-download without REST, got %q", data)
+	}
+}
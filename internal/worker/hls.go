@@ -0,0 +1,239 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// hlsPlaylist is a parsed HLS playlist. A master playlist only has variants,
+// a media playlist only has segments.
+type hlsPlaylist struct {
+	variants []streamVariant
+	segments []streamSegment
+}
+
+// parseHLS parses an HLS master or media playlist. Relative URIs are resolved against base.
+// Live playlists (without EXT-X-ENDLIST) and SAMPLE-AES encryption are rejected.
+func parseHLS(base *url.URL, data []byte) (*hlsPlaylist, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "#EXTM3U" {
+		return nil, errors.New("not an HLS playlist: missing #EXTM3U header")
+	}
+
+	var (
+		playlist    hlsPlaylist
+		sequence    int64
+		key         *segmentKey
+		initSegment *streamSegment
+		lastInit    *streamSegment
+		pendingVar  *streamVariant
+		byteRange   *hlsByteRange
+		nextOffset  = map[string]int64{}
+		inSegment   bool
+		endList     bool
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		switch {
+		case tag == "#EXT-X-STREAM-INF":
+			attrs := parseHLSAttributes(value)
+			bandwidth, _ := strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+			pendingVar = &streamVariant{bandwidth: bandwidth}
+
+		case tag == "#EXT-X-MEDIA-SEQUENCE":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EXT-X-MEDIA-SEQUENCE %q", value)
+			}
+			sequence = n
+
+		case tag == "#EXT-X-KEY":
+			attrs := parseHLSAttributes(value)
+			k, err := parseHLSKey(base, attrs)
+			if err != nil {
+				return nil, err
+			}
+			key = k
+
+		case tag == "#EXT-X-MAP":
+			attrs := parseHLSAttributes(value)
+			u, err := base.Parse(attrs["URI"])
+			if err != nil || attrs["URI"] == "" {
+				return nil, fmt.Errorf("invalid EXT-X-MAP URI %q", attrs["URI"])
+			}
+			initSegment = &streamSegment{url: u, length: -1, key: key, sequence: sequence}
+			if r, ok := attrs["BYTERANGE"]; ok {
+				br, err := parseHLSByteRange(r)
+				if err != nil {
+					return nil, err
+				}
+				initSegment.offset, initSegment.length = br.offset, br.length
+			}
+
+		case tag == "#EXTINF":
+			inSegment = true
+
+		case tag == "#EXT-X-BYTERANGE":
+			br, err := parseHLSByteRange(value)
+			if err != nil {
+				return nil, err
+			}
+			byteRange = &br
+
+		case tag == "#EXT-X-ENDLIST":
+			endList = true
+
+		case strings.HasPrefix(line, "#"):
+			// Other tags do not affect which bytes make up the stream.
+
+		case pendingVar != nil:
+			u, err := base.Parse(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid variant URI %q: %w", line, err)
+			}
+			pendingVar.url = u
+			playlist.variants = append(playlist.variants, *pendingVar)
+			pendingVar = nil
+
+		case inSegment:
+			u, err := base.Parse(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid segment URI %q: %w", line, err)
+			}
+
+			if initSegment != nil && initSegment != lastInit {
+				playlist.segments = append(playlist.segments, *initSegment)
+				lastInit = initSegment
+			}
+
+			seg := streamSegment{url: u, length: -1, key: key, sequence: sequence}
+			if byteRange != nil {
+				seg.length = byteRange.length
+				seg.offset = nextOffset[u.String()]
+				if byteRange.hasOffset {
+					seg.offset = byteRange.offset
+				}
+				nextOffset[u.String()] = seg.offset + seg.length
+			}
+			playlist.segments = append(playlist.segments, seg)
+			if len(playlist.segments) > maxSegments {
+				return nil, ErrTooManySegments
+			}
+
+			sequence++
+			inSegment = false
+			byteRange = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read playlist: %w", err)
+	}
+
+	if len(playlist.variants) > 0 {
+		return &playlist, nil
+	}
+	if !endList {
+		return nil, errors.New("live HLS playlists are not supported")
+	}
+	if len(playlist.segments) == 0 {
+		return nil, errors.New("HLS playlist has no segments")
+	}
+	return &playlist, nil
+}
+
+func parseHLSKey(base *url.URL, attrs map[string]string) (*segmentKey, error) {
+	switch attrs["METHOD"] {
+	case "NONE":
+		return nil, nil
+	case "AES-128":
+	default:
+		return nil, fmt.Errorf("unsupported HLS encryption method %q", attrs["METHOD"])
+	}
+
+	u, err := base.Parse(attrs["URI"])
+	if err != nil || attrs["URI"] == "" {
+		return nil, fmt.Errorf("invalid EXT-X-KEY URI %q", attrs["URI"])
+	}
+
+	k := &segmentKey{url: u}
+	if iv, ok := attrs["IV"]; ok {
+		raw := strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+		k.iv, err = hex.DecodeString(raw)
+		if err != nil || len(k.iv) != 16 {
+			return nil, fmt.Errorf("invalid EXT-X-KEY IV %q", iv)
+		}
+	}
+	return k, nil
+}
+
+type hlsByteRange struct {
+	length    int64
+	offset    int64
+	hasOffset bool
+}
+
+// parseHLSByteRange parses "<length>[@<offset>]".
+func parseHLSByteRange(value string) (hlsByteRange, error) {
+	var br hlsByteRange
+	lengthStr, offsetStr, hasOffset := strings.Cut(value, "@")
+
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil || length < 0 {
+		return br, fmt.Errorf("invalid byte range %q", value)
+	}
+	br.length = length
+
+	if hasOffset {
+		offset, err := strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil || offset < 0 {
+			return br, fmt.Errorf("invalid byte range %q", value)
+		}
+		br.offset, br.hasOffset = offset, true
+	}
+	return br, nil
+}
+
+// parseHLSAttributes parses an attribute list such as
+// BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=1280x720.
+func parseHLSAttributes(list string) map[string]string {
+	attrs := make(map[string]string)
+
+	for list != "" {
+		name, rest, ok := strings.Cut(list, "=")
+		if !ok {
+			break
+		}
+		name = strings.TrimSpace(name)
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, list = rest[1:], ""
+			} else {
+				value = rest[1 : end+1]
+				list = strings.TrimPrefix(rest[end+2:], ",")
+			}
+		} else {
+			value, list, _ = strings.Cut(rest, ",")
+		}
+
+		attrs[name] = value
+	}
+
+	return attrs
+}
@@ -0,0 +1,330 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+func encryptSegment(t *testing.T, plain, key, iv []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("create cipher: %v", err)
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+	return out
+}
+
+// newStreamWorker returns a worker that accepts the loopback addresses of test servers.
+func newStreamWorker(dir string) *DownloadWorker {
+	w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+	w.validateURLs = func([]string) error { return nil }
+	return w
+}
+
+func TestParseHLS_MasterPlaylist(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/video/master.m3u8")
+	data := []byte(`#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2"
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720
+high/index.m3u8
+`)
+
+	playlist, err := parseHLS(base, data)
+	if err != nil {
+		t.Fatalf("parseHLS error: %v", err)
+	}
+	if len(playlist.variants) != 2 {
+		t.Fatalf("expected 2 variants, got %d", len(playlist.variants))
+	}
+	if got := playlist.variants[1].url.String(); got != "https://cdn.example.com/video/high/index.m3u8" {
+		t.Fatalf("unexpected variant URL %s", got)
+	}
+	if playlist.variants[0].bandwidth != 800000 {
+		t.Fatalf("unexpected bandwidth %d", playlist.variants[0].bandwidth)
+	}
+}
+
+func TestParseHLS_MediaPlaylist(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/video/index.m3u8")
+	data := []byte(`#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:7
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-KEY:METHOD=AES-128,URI="https://keys.example.com/k1"
+#EXTINF:4.0,
+seg0.m4s
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:4.0,
+#EXT-X-BYTERANGE:100@0
+all.m4s
+#EXTINF:4.0,
+#EXT-X-BYTERANGE:50
+all.m4s
+#EXT-X-ENDLIST
+`)
+
+	playlist, err := parseHLS(base, data)
+	if err != nil {
+		t.Fatalf("parseHLS error: %v", err)
+	}
+
+	segs := playlist.segments
+	if len(segs) != 4 {
+		t.Fatalf("expected init + 3 segments, got %d", len(segs))
+	}
+	if segs[0].url.Path != "/video/init.mp4" {
+		t.Fatalf("expected init segment first, got %s", segs[0].url)
+	}
+	if segs[1].key == nil || segs[1].key.url.Host != "keys.example.com" || segs[1].sequence != 7 {
+		t.Fatalf("unexpected encrypted segment %+v", segs[1])
+	}
+	if segs[2].key != nil || segs[2].offset != 0 || segs[2].length != 100 {
+		t.Fatalf("unexpected byte range segment %+v", segs[2])
+	}
+	if segs[3].offset != 100 || segs[3].length != 50 {
+		t.Fatalf("expected byte range to continue at 100, got %+v", segs[3])
+	}
+}
+
+func TestParseHLS_Rejects(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/index.m3u8")
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not a playlist", "hello"},
+		{"live playlist", "#EXTM3U\n#EXTINF:4,\nseg0.ts\n"},
+		{"sample aes", "#EXTM3U\n#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"k\"\n#EXTINF:4,\nseg0.ts\n#EXT-X-ENDLIST\n"},
+		{"bad iv", "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"k\",IV=0x01\n#EXTINF:4,\nseg0.ts\n#EXT-X-ENDLIST\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseHLS(base, []byte(tt.data)); err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
+
+func TestParseHLS_TooManySegments(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/index.m3u8")
+	data := "#EXTM3U\n" + strings.Repeat("#EXTINF:1,\ns.ts\n", maxSegments+1) + "#EXT-X-ENDLIST\n"
+
+	if _, err := parseHLS(base, []byte(data)); !errors.Is(err, ErrTooManySegments) {
+		t.Fatalf("expected ErrTooManySegments, got %v", err)
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	variants := []streamVariant{{bandwidth: 2000}, {bandwidth: 500}, {bandwidth: 1000}}
+
+	tests := []struct {
+		name string
+		opts *domain.StreamOptions
+		want int64
+	}{
+		{"default best", nil, 2000},
+		{"best", &domain.StreamOptions{Variant: domain.VariantBest}, 2000},
+		{"worst", &domain.StreamOptions{Variant: domain.VariantWorst}, 500},
+		{"max bandwidth", &domain.StreamOptions{MaxBandwidth: 1500}, 1000},
+		{"max bandwidth below all", &domain.StreamOptions{MaxBandwidth: 100}, 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := variants[selectVariant(variants, tt.opts)].bandwidth; got != tt.want {
+				t.Fatalf("expected bandwidth %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDownloadWorker_HLS_EncryptedWithRetry(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	segments := [][]byte{
+		[]byte(strings.Repeat("first segment ", 100)),
+		[]byte(strings.Repeat("second segment ", 100)),
+		[]byte(strings.Repeat("third segment ", 100)),
+	}
+
+	var flaky atomic.Int32
+	var keyRequests atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/master.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=100000\nlow.m3u8\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=900000\nhigh.m3u8\n"))
+	})
+	mux.HandleFunc("/low.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "low variant must not be selected", http.StatusTeapot)
+	})
+	mux.HandleFunc("/high.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:3\n" +
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n" +
+			"#EXTINF:2,\nseg/0.ts\n#EXTINF:2,\nseg/1.ts\n#EXTINF:2,\nseg/2.ts\n#EXT-X-ENDLIST\n"))
+	})
+	mux.HandleFunc("/key.bin", func(w http.ResponseWriter, r *http.Request) {
+		keyRequests.Add(1)
+		w.Write(key)
+	})
+	mux.HandleFunc("/seg/", func(w http.ResponseWriter, r *http.Request) {
+		var i int
+		switch r.URL.Path {
+		case "/seg/0.ts":
+			i = 0
+		case "/seg/1.ts":
+			i = 1
+			if flaky.Add(1) == 1 {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+		case "/seg/2.ts":
+			i = 2
+		}
+		iv := make([]byte, aes.BlockSize)
+		iv[15] = byte(3 + i)
+		w.Write(encryptSegment(t, segments[i], key, iv))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := makeTempDir(t)
+	w := newStreamWorker(dir)
+
+	result, err := w.DownloadURL(context.Background(), server.URL+"/master.m3u8", "task1", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if !result.Success || result.Segments != 3 || result.Bandwidth != 900000 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	want := bytes.Join(segments, nil)
+	if result.BytesRead != int64(len(want)) {
+		t.Fatalf("expected %d bytes, got %d", len(want), result.BytesRead)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, result.FileName))
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("output does not match decrypted segments in order")
+	}
+	if flaky.Load() != 2 {
+		t.Fatalf("expected flaky segment to be retried once, got %d requests", flaky.Load())
+	}
+	if keyRequests.Load() != 1 {
+		t.Fatalf("expected key to be fetched once, got %d", keyRequests.Load())
+	}
+}
+
+func TestDownloadWorker_HLS_SegmentFailureRemovesOutput(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXTINF:2,\nok.ts\n#EXTINF:2,\nmissing.ts\n#EXT-X-ENDLIST\n"))
+	})
+	mux.HandleFunc("/ok.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("segment"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := makeTempDir(t)
+	w := newStreamWorker(dir)
+
+	result, err := w.DownloadURL(context.Background(), server.URL+"/index.m3u8", "task1", domain.TaskOptions{})
+	if err == nil {
+		t.Fatalf("expected error for missing segment")
+	}
+	if result.Success || !strings.Contains(result.Error, "segment 1") {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(dir, result.FileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected partial output to be removed, got %v", err)
+	}
+}
+
+func TestDownloadWorker_HLS_ForeignSegments(t *testing.T) {
+	var foreignAuth atomic.Value
+	foreignAuth.Store("")
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		foreignAuth.Store(r.Header.Get("Authorization"))
+		w.Write([]byte("[foreign]"))
+	}))
+	defer foreign.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("#EXTM3U\n#EXTINF:2,\nlocal.ts\n#EXTINF:2,\n" + foreign.URL + "/remote.ts\n#EXT-X-ENDLIST\n"))
+	})
+	mux.HandleFunc("/local.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[local]"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	opts := domain.TaskOptions{Credentials: &domain.RequestCredentials{BearerToken: "secret"}}
+
+	t.Run("credentials stay with the manifest origin", func(t *testing.T) {
+		dir := makeTempDir(t)
+		result, err := newStreamWorker(dir).DownloadURL(context.Background(), server.URL+"/index.m3u8", "task1", opts)
+		if err != nil {
+			t.Fatalf("DownloadURL error: %v", err)
+		}
+		got, _ := os.ReadFile(filepath.Join(dir, result.FileName))
+		if string(got) != "[local][foreign]" {
+			t.Fatalf("unexpected output %q", got)
+		}
+		if auth := foreignAuth.Load().(string); auth != "" {
+			t.Errorf("expected no credentials to be sent to another host, got %q", auth)
+		}
+	})
+
+	t.Run("unsafe segment URLs are rejected", func(t *testing.T) {
+		foreignAuth.Store("unset")
+		w := newStreamWorker(makeTempDir(t))
+		w.validateURLs = func(urls []string) error {
+			for _, u := range urls {
+				if strings.HasPrefix(u, foreign.URL) {
+					return fmt.Errorf("invalid URL %q", u)
+				}
+			}
+			return nil
+		}
+
+		result, err := w.DownloadURL(context.Background(), server.URL+"/index.m3u8", "task1", opts)
+		if err == nil || !strings.Contains(result.Error, "invalid URL") {
+			t.Fatalf("expected the foreign segment to be rejected, got %+v", result)
+		}
+		if foreignAuth.Load().(string) != "unset" {
+			t.Error("expected the rejected segment not to be requested")
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// httpFetcher fetches http and https URLs using Range requests for resume.
+type httpFetcher struct {
+	client *http.Client
+	proxy  func(*http.Request) (*url.URL, error)
+}
+
+func (f *httpFetcher) Fetch(ctx context.Context, fr FetchRequest) (*FetchResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fr.URL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	req = applyCredentials(req, fr.Credentials)
+
+	var proxy string
+	if proxyURL, err := f.proxy(req); err == nil && proxyURL != nil {
+		proxy = redactProxy(proxyURL)
+	}
+
+	if fr.Offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", fr.Offset))
+	}
+	if fr.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", fr.IfNoneMatch)
+	}
+	if fr.IfModifiedSince != "" {
+		req.Header.Set("If-Modified-Since", fr.IfModifiedSince)
+	}
+
+	// Setting Accept-Encoding explicitly also stops net/http from decoding
+	// gzip behind our back, so Content-Length and ranges stay meaningful.
+	if req.Header.Get("Accept-Encoding") == "" {
+		if fr.Offset > 0 && !fr.KeepEncoding {
+			req.Header.Set("Accept-Encoding", "identity")
+		} else {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && (fr.IfNoneMatch != "" || fr.IfModifiedSince != "") {
+		resp.Body.Close()
+		return &FetchResponse{
+			Body:         http.NoBody,
+			Size:         -1,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			NotModified:  true,
+		}, nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	result := &FetchResponse{
+		Body:          resp.Body,
+		Size:          -1,
+		ContentType:   resp.Header.Get("Content-Type"),
+		ETag:          resp.Header.Get("ETag"),
+		LastModified:  resp.Header.Get("Last-Modified"),
+		SupportsRange: resp.StatusCode == http.StatusPartialContent || resp.Header.Get("Accept-Ranges") == "bytes",
+		Proxy:         proxy,
+	}
+
+	result.ContentEncoding, err = parseContentEncoding(resp.Header.Get("Content-Encoding"))
+	if err != nil && !fr.KeepEncoding {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusPartialContent {
+		result.Offset = fr.Offset
+		result.Size = contentRangeSize(resp.Header.Get("Content-Range"))
+	} else if resp.ContentLength >= 0 {
+		result.Size = resp.ContentLength
+	}
+
+	return result, nil
+}
+
+// contentRangeSize extracts the complete length from a "bytes start-end/size" header.
+func contentRangeSize(header string) int64 {
+	_, total, ok := strings.Cut(header, "/")
+	if !ok || total == "*" {
+		return -1
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// metalinkDoc mirrors the parts of a Metalink v4 (RFC 5854) document used for downloads.
+type metalinkDoc struct {
+	XMLName xml.Name `xml:"metalink"`
+	Files   []struct {
+		Name   string `xml:"name,attr"`
+		Size   *int64 `xml:"size"`
+		Hashes []struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"hash"`
+		URLs []struct {
+			Priority int    `xml:"priority,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"url"`
+	} `xml:"file"`
+}
+
+// metalinkFile is a file described by a Metalink document. Size is -1 when not declared.
+type metalinkFile struct {
+	name   string
+	size   int64
+	hashes map[string]string
+	urls   []string
+}
+
+// isMetalinkURL reports whether the URL points to a Metalink document.
+func isMetalinkURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".meta4", ".metalink":
+		return true
+	}
+	return false
+}
+
+// parseMetalink parses a Metalink v4 document describing a single file.
+// Mirror URLs are returned in priority order; relative URLs are resolved against base.
+func parseMetalink(base *url.URL, data []byte) (*metalinkFile, error) {
+	var doc metalinkDoc
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse metalink: %w", err)
+	}
+	if len(doc.Files) != 1 {
+		return nil, fmt.Errorf("metalink must describe exactly one file, got %d", len(doc.Files))
+	}
+
+	f := doc.Files[0]
+	file := &metalinkFile{
+		name:   f.Name,
+		size:   -1,
+		hashes: make(map[string]string),
+	}
+	if f.Size != nil {
+		file.size = *f.Size
+	}
+	for _, h := range f.Hashes {
+		file.hashes[strings.ToLower(h.Type)] = strings.ToLower(strings.TrimSpace(h.Value))
+	}
+
+	// Priority 1 is the most preferred; URLs without a priority come last.
+	urls := f.URLs
+	sort.SliceStable(urls, func(i, j int) bool {
+		pi, pj := urls[i].Priority, urls[j].Priority
+		if pi == 0 {
+			pi = 1 << 30
+		}
+		if pj == 0 {
+			pj = 1 << 30
+		}
+		return pi < pj
+	})
+	for _, u := range urls {
+		resolved, err := base.Parse(strings.TrimSpace(u.Value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid metalink URL %q: %w", u.Value, err)
+		}
+		file.urls = append(file.urls, resolved.String())
+	}
+	if len(file.urls) == 0 {
+		return nil, errors.New("metalink file has no URLs")
+	}
+
+	return file, nil
+}
+
+// hashPreference lists supported Metalink hash types, strongest first.
+var hashPreference = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha-512", sha512.New},
+	{"sha-384", sha512.New384},
+	{"sha-256", sha256.New},
+	{"sha-1", sha1.New},
+	{"md5", md5.New},
+}
+
+// ErrHashMismatch is returned when a downloaded file does not match its declared hash.
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// verifyHash checks the file against the strongest supported declared hash and returns
+// it as "<type>:<hex>". It returns an empty string when no supported hash is declared.
+func verifyHash(filePath string, hashes map[string]string) (string, error) {
+	for _, candidate := range hashPreference {
+		want, ok := hashes[candidate.name]
+		if !ok {
+			continue
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return "", fmt.Errorf("open file for hashing: %w", err)
+		}
+
+		h := candidate.new()
+		_, err = io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			return "", fmt.Errorf("hash file: %w", err)
+		}
+
+		got := hex.EncodeToString(h.Sum(nil))
+		if got != want {
+			return "", fmt.Errorf("%w: %s expected %s, got %s", ErrHashMismatch, candidate.name, want, got)
+		}
+		return candidate.name + ":" + got, nil
+	}
+	return "", nil
+}
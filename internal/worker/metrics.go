@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"io"
+	"net/url"
+
+	"github.com/veranemoloko/url-downloader/internal/metrics"
+)
+
+// WithMetrics reports downloads, received bytes and retries to m.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(w *DownloadWorker) {
+		w.metrics = m
+	}
+}
+
+// countingBody adds every byte read from a source to the downloaded bytes metric.
+type countingBody struct {
+	io.ReadCloser
+	metrics *metrics.Metrics
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.metrics.AddBytes(int64(n))
+	return n, err
+}
+
+// metricsHost returns the label used for per-host error counts: the host name,
+// or the scheme for hostless URLs such as magnet links.
+func metricsHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid"
+	}
+	if host := u.Hostname(); host != "" {
+		return host
+	}
+	return u.Scheme
+}
@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/metrics"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+func TestDownloadWorker_Metrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(rw, r)
+			return
+		}
+		rw.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	defer server.Close()
+
+	m := metrics.New()
+	w := NewDownloadWorker(storage.NewFileStorage(makeTempDir(t)), newTestLogger(), WithMetrics(m))
+
+	if _, err := w.DownloadURL(context.Background(), server.URL+"/file", "task1", domain.TaskOptions{}); err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if _, err := w.DownloadURL(context.Background(), server.URL+"/missing", "task1", domain.TaskOptions{}); err == nil {
+		t.Fatalf("expected an error for the missing file")
+	}
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		`downloader_downloaded_bytes_total 1000`,
+		`downloader_active_downloads 0`,
+		`downloader_download_errors_total{host="127.0.0.1"} 1`,
+		`downloader_download_duration_seconds_count{outcome="success"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
+
+func TestMetricsHost(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com:8443/a": "example.com",
+		"magnet:?xt=urn:btih:abc":    "magnet",
+		"sftp://user@host/file":      "host",
+	}
+	for rawURL, want := range tests {
+		if got := metricsHost(rawURL); got != want {
+			t.Errorf("metricsHost(%q) = %q, want %q", rawURL, got, want)
+		}
+	}
+}
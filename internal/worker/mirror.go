@@ -0,0 +1,407 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// mirrorProbeSize is how much is read from each mirror to estimate its speed.
+	mirrorProbeSize = 64 << 10
+	// mirrorProbeTimeout bounds a single mirror probe.
+	mirrorProbeTimeout = 10 * time.Second
+	// mirrorChunkSize is the range size fetched per request when splitting across mirrors.
+	mirrorChunkSize = 4 << 20
+)
+
+// mirror is a candidate source ranked by its probe.
+type mirror struct {
+	url           string
+	creds         domain.RequestCredentials
+	elapsed       time.Duration
+	size          int64
+	supportsRange bool
+	err           error
+}
+
+// downloadMirrored downloads a file available from several mirrors, either listed in
+// TaskOptions.Mirrors or described by a Metalink document. Mirrors are tried fastest first,
+// failing over (and resuming where possible) when one breaks. Declared hashes are verified.
+// Metalink mirrors come from a remote document: unsafe ones are skipped, and the task
+// credentials are only sent to hosts the task itself names.
+func (w *DownloadWorker) downloadMirrored(ctx context.Context, rawURL string, taskID string, opts domain.TaskOptions) (domain.DownloadResult, error) {
+	result := domain.DownloadResult{URL: rawURL}
+
+	fail := func(err error) (domain.DownloadResult, error) {
+		result.Error = err.Error()
+		w.logger.Error("mirrored download failed",
+			"url", rawURL,
+			"error", err,
+		)
+		return result, err
+	}
+
+	filename := w.generateFilename(rawURL, taskID)
+	result.FileName = filename
+
+	urls := []string{rawURL}
+	size := int64(-1)
+	var hashes map[string]string
+
+	if isMetalinkURL(rawURL) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return fail(fmt.Errorf("parse URL: %w", err))
+		}
+		data, err := w.readManifest(ctx, u, opts.CredentialsFor(rawURL))
+		if err != nil {
+			return fail(err)
+		}
+		file, err := parseMetalink(u, data)
+		if err != nil {
+			return fail(err)
+		}
+		urls, size, hashes = w.safeMirrors(file.urls), file.size, file.hashes
+	}
+
+	for _, m := range opts.Mirrors[rawURL] {
+		if !slices.Contains(urls, m) {
+			urls = append(urls, m)
+		}
+	}
+	if len(urls) == 0 {
+		return fail(errors.New("metalink lists no safe URLs"))
+	}
+
+	mirrors := w.rankMirrors(ctx, mirrorCandidates(rawURL, urls, opts))
+	if size < 0 {
+		for _, m := range mirrors {
+			if m.err == nil && m.size >= 0 {
+				size = m.size
+				break
+			}
+		}
+	}
+
+	policies := []ContentPolicy{w.contentPolicy, {Allowed: opts.AllowedMIMETypes}}
+
+	var err error
+	if split := rangeMirrors(mirrors); opts.SplitMirrors && size > mirrorChunkSize && len(split) > 1 {
+		err = w.downloadSplit(ctx, split, size, filename, policies, &result)
+	} else {
+		err = w.downloadFailover(ctx, mirrors, size, filename, policies, &result)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	result.Hash, err = verifyHash(w.fileStorage.Path(filename), hashes)
+	if err != nil {
+		w.removePartial(filename)
+		return fail(err)
+	}
+
+	if w.fileScanner != nil {
+		result.Scan, err = w.scanFile(ctx, filename)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	result.Success = true
+
+	return result, nil
+}
+
+// safeMirrors drops the Metalink URLs that fail validation, as task URLs would.
+func (w *DownloadWorker) safeMirrors(urls []string) []string {
+	var safe []string
+	for _, u := range urls {
+		if err := w.validateURLs([]string{u}); err != nil {
+			w.logger.Warn("skipping unsafe metalink mirror",
+				"url", u,
+				"error", err,
+			)
+			continue
+		}
+		safe = append(safe, u)
+	}
+	return safe
+}
+
+// mirrorCandidates pairs each mirror URL with the credentials sent to it. Only hosts
+// named by the task, the URL itself or its configured mirrors, receive credentials.
+func mirrorCandidates(rawURL string, urls []string, opts domain.TaskOptions) []mirror {
+	trusted := make(map[string]bool)
+	for _, u := range append([]string{rawURL}, opts.Mirrors[rawURL]...) {
+		if parsed, err := url.Parse(u); err == nil {
+			trusted[parsed.Host] = true
+		}
+	}
+
+	candidates := make([]mirror, len(urls))
+	for i, u := range urls {
+		candidates[i] = mirror{url: u}
+		if parsed, err := url.Parse(u); err == nil && trusted[parsed.Host] {
+			candidates[i].creds = opts.CredentialsFor(u)
+		}
+	}
+	return candidates
+}
+
+// rankMirrors probes every mirror concurrently by reading its first bytes and orders
+// them fastest first. Mirrors that failed the probe are kept at the end for failover.
+func (w *DownloadWorker) rankMirrors(ctx context.Context, candidates []mirror) []mirror {
+	mirrors := make([]mirror, len(candidates))
+
+	var wg sync.WaitGroup
+	for i, m := range candidates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mirrors[i] = w.probeMirror(ctx, m)
+		}()
+	}
+	wg.Wait()
+
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		if (mirrors[i].err == nil) != (mirrors[j].err == nil) {
+			return mirrors[i].err == nil
+		}
+		return mirrors[i].elapsed < mirrors[j].elapsed
+	})
+
+	return mirrors
+}
+
+func (w *DownloadWorker) probeMirror(ctx context.Context, m mirror) mirror {
+	m.size = -1
+
+	ctx, cancel := context.WithTimeout(ctx, mirrorProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := w.fetchMirror(ctx, m, 0)
+	if err != nil {
+		m.err = err
+		return m
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.CopyN(io.Discard, resp.Body, mirrorProbeSize); err != nil && err != io.EOF {
+		m.err = err
+		return m
+	}
+
+	m.elapsed = time.Since(start)
+	m.size = resp.Size
+	m.supportsRange = resp.SupportsRange
+	return m
+}
+
+// rangeMirrors returns the healthy mirrors that can serve byte ranges.
+func rangeMirrors(mirrors []mirror) []mirror {
+	var out []mirror
+	for _, m := range mirrors {
+		if m.err == nil && m.supportsRange {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// downloadFailover downloads from one mirror at a time. When a mirror fails, the next one
+// resumes from what is already on disk. Content policy violations stop the failover.
+func (w *DownloadWorker) downloadFailover(ctx context.Context, mirrors []mirror, size int64, filename string, policies []ContentPolicy, result *domain.DownloadResult) error {
+	var lastErr error
+
+	for _, m := range mirrors {
+		var existingSize int64
+		if w.fileStorage.FileExists(filename) {
+			if s, err := w.fileStorage.GetFileSize(filename); err == nil {
+				existingSize = s
+			}
+		}
+
+		err := w.receiveFromMirror(ctx, m, existingSize, size, filename, policies, result)
+		if result.BytesRead > existingSize {
+			result.Mirrors = append(result.Mirrors, m.url)
+		}
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrContentTypeNotAllowed) || ctx.Err() != nil {
+			return err
+		}
+
+		lastErr = err
+		w.recordRetry(ctx, metrics.RetryMirror, attribute.String("mirror", spanURL(m.url)))
+		w.logger.Warn("mirror failed, trying next",
+			"mirror", m.url,
+			"error", err,
+		)
+	}
+
+	return fmt.Errorf("all mirrors failed: %w", lastErr)
+}
+
+func (w *DownloadWorker) receiveFromMirror(ctx context.Context, m mirror, existingSize, size int64, filename string, policies []ContentPolicy, result *domain.DownloadResult) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	src, err := w.fetchMirror(ctx, m, existingSize)
+	if err != nil {
+		return err
+	}
+	defer src.Body.Close()
+
+	if size >= 0 && src.Size >= 0 && src.Size != size {
+		return fmt.Errorf("mirror size %d does not match expected %d", src.Size, size)
+	}
+
+	result.Proxy = src.Proxy
+	result.ETag = src.ETag
+	result.LastModified = src.LastModified
+
+	return w.receive(ctx, cancel, src, filename, policies, result)
+}
+
+// downloadSplit fetches fixed-size ranges from several mirrors in parallel, one worker per
+// mirror. A failing mirror hands its range back to the others and stops taking new ones.
+func (w *DownloadWorker) downloadSplit(ctx context.Context, mirrors []mirror, size int64, filename string, policies []ContentPolicy, result *domain.DownloadResult) error {
+	file, err := w.fileStorage.CreateFile(filename)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("allocate file: %w", err)
+	}
+
+	type chunk struct{ offset, length int64 }
+	count := int((size + mirrorChunkSize - 1) / mirrorChunkSize)
+	queue := make(chan chunk, count)
+	for offset := int64(0); offset < size; offset += mirrorChunkSize {
+		queue <- chunk{offset: offset, length: min(mirrorChunkSize, size-offset)}
+	}
+
+	var (
+		mu        sync.Mutex
+		remaining = count
+		used      = make(map[string]bool)
+		lastErr   error
+		done      = make(chan struct{})
+		wg        sync.WaitGroup
+	)
+
+	for _, m := range mirrors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var c chunk
+				select {
+				case c = <-queue:
+				case <-done:
+					return
+				case <-ctx.Done():
+					return
+				}
+
+				if err := w.fetchRange(ctx, m, c.offset, c.length, file); err != nil {
+					queue <- c
+					mu.Lock()
+					lastErr = err
+					mu.Unlock()
+					w.recordRetry(ctx, metrics.RetryMirror, attribute.String("mirror", spanURL(m.url)))
+					w.logger.Warn("mirror failed, handing range to other mirrors",
+						"mirror", m.url,
+						"offset", c.offset,
+						"error", err,
+					)
+					return
+				}
+
+				mu.Lock()
+				used[m.url] = true
+				remaining--
+				if remaining == 0 {
+					close(done)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return fmt.Errorf("all mirrors failed with %d of %d ranges left: %w", remaining, count, lastErr)
+	}
+
+	for _, m := range mirrors {
+		if used[m.url] {
+			result.Mirrors = append(result.Mirrors, m.url)
+		}
+	}
+	result.BytesRead = size
+
+	head := make([]byte, min(size, sniffLength))
+	if _, err := file.ReadAt(head, 0); err != nil {
+		return fmt.Errorf("read content: %w", err)
+	}
+	result.MIMEType, err = checkContent(policies, "", head)
+	if err != nil {
+		file.Close()
+		w.removePartial(filename)
+		return err
+	}
+
+	return nil
+}
+
+// fetchMirror fetches the mirror with the credentials chosen for it by mirrorCandidates.
+func (w *DownloadWorker) fetchMirror(ctx context.Context, m mirror, offset int64) (*FetchResponse, error) {
+	u, err := url.Parse(m.url)
+	if err != nil {
+		return nil, fmt.Errorf("parse URL: %w", err)
+	}
+	return w.open(ctx, u, offset, m.creds)
+}
+
+// fetchRange writes length bytes starting at offset from the mirror into file.
+func (w *DownloadWorker) fetchRange(ctx context.Context, m mirror, offset, length int64, file *os.File) error {
+	src, err := w.fetchMirror(ctx, m, offset)
+	if err != nil {
+		return err
+	}
+	defer src.Body.Close()
+
+	if src.Offset != offset {
+		return errors.New("mirror ignored the range request")
+	}
+
+	n, err := io.Copy(io.NewOffsetWriter(file, offset), io.LimitReader(src.Body, length))
+	if err != nil {
+		return fmt.Errorf("copy range: %w", err)
+	}
+	if n != length {
+		return fmt.Errorf("short range: got %d of %d bytes", n, length)
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// newMirrorServer serves content with range support. Requests are counted, and the
+// server can be made to fail or to answer slowly.
+func newMirrorServer(t *testing.T, content []byte, delay time.Duration, fail bool) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if fail {
+			http.Error(w, "mirror down", http.StatusBadGateway)
+			return
+		}
+		time.Sleep(delay)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func randomContent(t *testing.T, size int) []byte {
+	t.Helper()
+	content := make([]byte, size)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("generate content: %v", err)
+	}
+	return content
+}
+
+func TestDownloadWorker_MirrorFailover(t *testing.T) {
+	content := randomContent(t, 100<<10)
+	down, _ := newMirrorServer(t, content, 0, true)
+	up, _ := newMirrorServer(t, content, 0, false)
+
+	dir := makeTempDir(t)
+	w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+
+	primary := down.URL + "/file.bin"
+	opts := domain.TaskOptions{Mirrors: map[string][]string{primary: {up.URL + "/file.bin"}}}
+
+	result, err := w.DownloadURL(context.Background(), primary, "task1", opts)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if !result.Success || len(result.Mirrors) != 1 || result.Mirrors[0] != up.URL+"/file.bin" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, result.FileName))
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content does not match")
+	}
+}
+
+func TestDownloadWorker_MirrorPicksFastest(t *testing.T) {
+	content := randomContent(t, 10<<10)
+	slow, _ := newMirrorServer(t, content, 300*time.Millisecond, false)
+	fast, _ := newMirrorServer(t, content, 0, false)
+
+	w := NewDownloadWorker(storage.NewFileStorage(makeTempDir(t)), newTestLogger())
+
+	primary := slow.URL + "/file.bin"
+	opts := domain.TaskOptions{Mirrors: map[string][]string{primary: {fast.URL + "/file.bin"}}}
+
+	result, err := w.DownloadURL(context.Background(), primary, "task1", opts)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if len(result.Mirrors) != 1 || result.Mirrors[0] != fast.URL+"/file.bin" {
+		t.Fatalf("expected fast mirror to be used, got %v", result.Mirrors)
+	}
+}
+
+func TestDownloadWorker_MirrorSplitRanges(t *testing.T) {
+	content := randomContent(t, 3*mirrorChunkSize+1234)
+	a, aRequests := newMirrorServer(t, content, 0, false)
+	b, bRequests := newMirrorServer(t, content, 0, false)
+
+	dir := makeTempDir(t)
+	w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+
+	primary := a.URL + "/file.bin"
+	opts := domain.TaskOptions{
+		Mirrors:      map[string][]string{primary: {b.URL + "/file.bin"}},
+		SplitMirrors: true,
+	}
+
+	result, err := w.DownloadURL(context.Background(), primary, "task1", opts)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if result.BytesRead != int64(len(content)) {
+		t.Fatalf("expected %d bytes, got %d", len(content), result.BytesRead)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, result.FileName))
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content does not match")
+	}
+
+	// One probe each plus four ranges shared between the two mirrors.
+	if total := aRequests.Load() + bRequests.Load(); total != 6 {
+		t.Fatalf("expected 6 requests in total, got %d", total)
+	}
+}
+
+func metalinkDocument(hash string, urls ...string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="dataset.bin">
+    <hash type="sha-256">` + hash + `</hash>
+`)
+	for i := len(urls) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "    <url priority=\"%d\">%s</url>\n", i+1, urls[i])
+	}
+	b.WriteString("  </file>\n</metalink>\n")
+	return b.String()
+}
+
+func TestDownloadWorker_Metalink(t *testing.T) {
+	content := randomContent(t, 50<<10)
+	sum := sha256.Sum256(content)
+	good := hex.EncodeToString(sum[:])
+
+	mirror, _ := newMirrorServer(t, content, 0, false)
+
+	tests := []struct {
+		name    string
+		hash    string
+		wantErr error
+	}{
+		{"hash matches", good, nil},
+		{"hash mismatch", strings.Repeat("0", 64), ErrHashMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := metalinkDocument(tt.hash, mirror.URL+"/file.bin")
+			meta := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/metalink4+xml")
+				w.Write([]byte(doc))
+			}))
+			defer meta.Close()
+
+			dir := makeTempDir(t)
+			w := NewDownloadWorker(storage.NewFileStorage(dir), newTestLogger())
+			w.validateURLs = func([]string) error { return nil }
+
+			result, err := w.DownloadURL(context.Background(), meta.URL+"/dataset.meta4", "task1", domain.TaskOptions{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			_, statErr := os.Stat(filepath.Join(dir, result.FileName))
+			if tt.wantErr != nil {
+				if result.Success || !os.IsNotExist(statErr) {
+					t.Fatalf("expected failed result without output, got %+v (%v)", result, statErr)
+				}
+				return
+			}
+			if !result.Success || result.Hash != "sha-256:"+good {
+				t.Fatalf("unexpected result %+v", result)
+			}
+		})
+	}
+}
+
+func TestDownloadWorker_MetalinkForeignMirrors(t *testing.T) {
+	content := randomContent(t, 10<<10)
+
+	var foreignAuth atomic.Value
+	foreignAuth.Store("")
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		foreignAuth.Store(r.Header.Get("Authorization"))
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer foreign.Close()
+	unsafe, unsafeRequests := newMirrorServer(t, content, 0, false)
+
+	sum := sha256.Sum256(content)
+	doc := metalinkDocument(hex.EncodeToString(sum[:]), foreign.URL+"/file.bin", unsafe.URL+"/file.bin")
+	meta := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(doc))
+	}))
+	defer meta.Close()
+
+	w := NewDownloadWorker(storage.NewFileStorage(makeTempDir(t)), newTestLogger())
+	w.validateURLs = func(urls []string) error {
+		for _, u := range urls {
+			if strings.HasPrefix(u, unsafe.URL) {
+				return fmt.Errorf("invalid URL %q", u)
+			}
+		}
+		return nil
+	}
+
+	opts := domain.TaskOptions{Credentials: &domain.RequestCredentials{BearerToken: "secret"}}
+	result, err := w.DownloadURL(context.Background(), meta.URL+"/dataset.meta4", "task1", opts)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if !result.Success || len(result.Mirrors) != 1 || !strings.HasPrefix(result.Mirrors[0], foreign.URL) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if auth := foreignAuth.Load().(string); auth != "" {
+		t.Errorf("expected no credentials to be sent to a metalink mirror, got %q", auth)
+	}
+	if n := unsafeRequests.Load(); n != 0 {
+		t.Errorf("expected the unsafe mirror not to be requested, got %d requests", n)
+	}
+}
+
+func TestParseMetalink(t *testing.T) {
+	base, _ := url.Parse("https://example.com/data/file.meta4")
+	data := []byte(`<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="file.bin">
+    <size>42</size>
+    <hash type="SHA-256">ABCD</hash>
+    <url>https://c.example.com/file.bin</url>
+    <url priority="2">https://b.example.com/file.bin</url>
+    <url priority="1">mirror/file.bin</url>
+  </file>
+</metalink>`)
+
+	file, err := parseMetalink(base, data)
+	if err != nil {
+		t.Fatalf("parseMetalink error: %v", err)
+	}
+	if file.size != 42 || file.hashes["sha-256"] != "abcd" {
+		t.Fatalf("unexpected file %+v", file)
+	}
+	want := []string{
+		"https://example.com/data/mirror/file.bin",
+		"https://b.example.com/file.bin",
+		"https://c.example.com/file.bin",
+	}
+	for i := range want {
+		if file.urls[i] != want[i] {
+			t.Fatalf("expected urls %v, got %v", want, file.urls)
+		}
+	}
+
+	if _, err := parseMetalink(base, []byte(`<metalink><file name="a"/><file name="b"/></metalink>`)); err == nil {
+		t.Fatalf("expected error for multiple files")
+	}
+}
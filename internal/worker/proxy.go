@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyConfig describes an outbound proxy and the hosts that bypass it.
+// URL may use the http, https, socks5 or socks5h scheme and may carry user:password.
+// NoProxy is a comma-separated list in the NO_PROXY format (hosts, domains, CIDRs).
+type ProxyConfig struct {
+	URL     string
+	NoProxy string
+}
+
+type proxyFunc func(*url.URL) (*url.URL, error)
+
+type proxyContextKey struct{}
+
+// Validate checks that the proxy URL is well-formed and uses a supported scheme.
+func (c ProxyConfig) Validate() error {
+	_, err := c.proxyFunc()
+	return err
+}
+
+// WithProxy routes downloads through the given proxy unless a task selects its own.
+// An invalid configuration makes every request fail; call ProxyConfig.Validate first.
+func WithProxy(cfg ProxyConfig) Option {
+	return func(w *DownloadWorker) {
+		fn, err := cfg.proxyFunc()
+		if err != nil {
+			fn = func(*url.URL) (*url.URL, error) { return nil, err }
+		}
+		w.proxy = fn
+	}
+}
+
+// proxyFunc validates the configuration and returns a resolver for request URLs.
+func (c ProxyConfig) proxyFunc() (proxyFunc, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse proxy URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", c.URL)
+	}
+
+	cfg := httpproxy.Config{
+		HTTPProxy:  c.URL,
+		HTTPSProxy: c.URL,
+		NoProxy:    c.NoProxy,
+	}
+	return cfg.ProxyFunc(), nil
+}
+
+// taskProxyConfig builds the proxy configuration for a task, adding sealed credentials to the URL.
+func taskProxyConfig(opts *domain.ProxyOptions) (ProxyConfig, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return ProxyConfig{}, fmt.Errorf("parse proxy URL: %w", err)
+	}
+	if opts.Username != "" {
+		u.User = url.UserPassword(opts.Username, opts.Password)
+	}
+	return ProxyConfig{
+		URL:     u.String(),
+		NoProxy: strings.Join(opts.NoProxy, ","),
+	}, nil
+}
+
+// resolveProxy is used as http.Transport.Proxy. A per-task proxy stored in the request
+// context takes precedence over the worker-wide proxy.
+func (w *DownloadWorker) resolveProxy(req *http.Request) (*url.URL, error) {
+	if fn, ok := req.Context().Value(proxyContextKey{}).(proxyFunc); ok {
+		return fn(req.URL)
+	}
+	if w.proxy != nil {
+		return w.proxy(req.URL)
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+func withTaskProxy(ctx context.Context, fn proxyFunc) context.Context {
+	return context.WithValue(ctx, proxyContextKey{}, fn)
+}
+
+// withTaskOptionsProxy installs the proxy selected by the task options, if any, in ctx.
+func withTaskOptionsProxy(ctx context.Context, opts domain.TaskOptions) (context.Context, error) {
+	if opts.Proxy == nil {
+		return ctx, nil
+	}
+	proxyCfg, err := taskProxyConfig(opts.Proxy)
+	if err != nil {
+		return ctx, err
+	}
+	fn, err := proxyCfg.proxyFunc()
+	if err != nil {
+		return ctx, err
+	}
+	return withTaskProxy(ctx, fn), nil
+}
+
+// redactProxy returns the proxy address without credentials for recording on results.
+func redactProxy(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	return redacted.String()
+}
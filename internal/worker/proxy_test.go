@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// newTestProxy starts an HTTP forward proxy that answers every request itself
+// and requires the given basic credentials when user is non-empty.
+func newTestProxy(t *testing.T, user, password, body string) *httptest.Server {
+	t.Helper()
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user != "" {
+			want := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+			if r.Header.Get("Proxy-Authorization") != want {
+				w.WriteHeader(http.StatusProxyAuthRequired)
+				return
+			}
+		}
+		if !r.URL.IsAbs() {
+			http.Error(w, "expected absolute-form request", http.StatusBadRequest)
+			return
+		}
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(proxy.Close)
+
+	return proxy
+}
+
+func TestDownloadWorker_GlobalProxy(t *testing.T) {
+	proxy := newTestProxy(t, "", "", "via global proxy")
+
+	fs := storage.NewFileStorage(makeTempDir(t))
+	worker := NewDownloadWorker(fs, newTestLogger(), WithProxy(ProxyConfig{URL: proxy.URL}))
+
+	result, err := worker.DownloadURL(context.Background(), "http://files.example.test/data.csv", "proxy", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if result.Proxy != proxy.URL {
+		t.Errorf("expected proxy %q recorded, got %q", proxy.URL, result.Proxy)
+	}
+	if result.BytesRead != int64(len("via global proxy")) {
+		t.Errorf("unexpected bytes read: %d", result.BytesRead)
+	}
+}
+
+func TestDownloadWorker_TaskProxyWithAuth(t *testing.T) {
+	global := newTestProxy(t, "", "", "via global proxy")
+	taskProxy := newTestProxy(t, "alice", "s3cret", "via task proxy")
+
+	fs := storage.NewFileStorage(makeTempDir(t))
+	worker := NewDownloadWorker(fs, newTestLogger(), WithProxy(ProxyConfig{URL: global.URL}))
+
+	opts := domain.TaskOptions{
+		Proxy: &domain.ProxyOptions{URL: taskProxy.URL, Username: "alice", Password: "s3cret"},
+	}
+
+	result, err := worker.DownloadURL(context.Background(), "http://files.example.test/data.csv", "proxy", opts)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if result.Proxy != taskProxy.URL {
+		t.Errorf("expected task proxy %q recorded, got %q", taskProxy.URL, result.Proxy)
+	}
+	if strings.Contains(result.Proxy, "s3cret") {
+		t.Errorf("proxy credentials leaked into result: %q", result.Proxy)
+	}
+
+	opts.Proxy.Password = "wrong"
+	if _, err := worker.DownloadURL(context.Background(), "http://files.example.test/other.csv", "proxy", opts); err == nil {
+		t.Errorf("expected proxy authentication failure")
+	}
+}
+
+func TestProxyConfig_NoProxy(t *testing.T) {
+	fn, err := ProxyConfig{URL: "socks5://proxy.corp:1080", NoProxy: "internal.corp,.mirror.org"}.proxyFunc()
+	if err != nil {
+		t.Fatalf("proxyFunc error: %v", err)
+	}
+
+	tests := []struct {
+		target  string
+		proxied bool
+	}{
+		{"https://example.com/file", true},
+		{"https://internal.corp/file", false},
+		{"https://eu.mirror.org/file", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		u, err := fn(req.URL)
+		if err != nil {
+			t.Fatalf("proxy func error for %s: %v", tt.target, err)
+		}
+		if (u != nil) != tt.proxied {
+			t.Errorf("%s: expected proxied=%v, got %v", tt.target, tt.proxied, u)
+		}
+	}
+
+	if err := (ProxyConfig{URL: "ftp://proxy.corp"}).Validate(); err == nil {
+		t.Errorf("expected unsupported scheme to fail validation")
+	}
+}
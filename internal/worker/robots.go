@@ -0,0 +1,150 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+// maxRobotsSize limits how much of a robots.txt file is read, as recommended by RFC 9309.
+const maxRobotsSize = 500 << 10
+
+// robotsRules holds the Allow and Disallow rules of the "*" group of a robots.txt file.
+type robotsRules struct {
+	rules []robotsRule
+}
+
+type robotsRule struct {
+	allow   bool
+	length  int
+	pattern *regexp.Regexp
+}
+
+// parseRobots parses the rules that apply to every user agent. Groups naming other
+// agents are ignored because the worker does not identify itself with a product token.
+func parseRobots(data []byte) *robotsRules {
+	rules := &robotsRules{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	var (
+		applies   bool
+		inAgents  bool
+		sawAgents bool
+	)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		field = strings.ToLower(strings.TrimSpace(field))
+		value = strings.TrimSpace(value)
+
+		switch field {
+		case "user-agent":
+			if !inAgents {
+				applies = false
+			}
+			inAgents, sawAgents = true, true
+			if value == "*" {
+				applies = true
+			}
+		case "allow", "disallow":
+			inAgents = false
+			if !applies || !sawAgents || value == "" {
+				continue
+			}
+			rules.rules = append(rules.rules, robotsRule{
+				allow:   field == "allow",
+				length:  len(value),
+				pattern: robotsPattern(value),
+			})
+		default:
+			inAgents = false
+		}
+	}
+
+	return rules
+}
+
+// robotsPattern converts a robots.txt path pattern with * and $ into an anchored regexp.
+func robotsPattern(value string) *regexp.Regexp {
+	anchored := strings.HasSuffix(value, "$")
+	value = strings.TrimSuffix(value, "$")
+
+	parts := strings.Split(value, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// allowed reports whether u may be fetched. The longest matching rule wins and
+// Allow wins a tie, as described in RFC 9309.
+func (r *robotsRules) allowed(u *url.URL) bool {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	allow, best := true, -1
+	for _, rule := range r.rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > best || (rule.length == best && rule.allow) {
+			allow, best = rule.allow, rule.length
+		}
+	}
+	return allow
+}
+
+// robotsCache fetches robots.txt once per origin during a crawl. Origins whose
+// robots.txt cannot be fetched are treated as allowing everything.
+type robotsCache struct {
+	mu      sync.Mutex
+	origins map[string]*robotsRules
+}
+
+func (c *robotsCache) allowed(ctx context.Context, w *DownloadWorker, u *url.URL, creds domain.RequestCredentials) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return true
+	}
+	origin := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rules, ok := c.origins[origin]
+	if !ok {
+		rules = &robotsRules{}
+		robotsURL := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+		if resp, err := w.open(ctx, robotsURL, 0, creds); err == nil {
+			data, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+			resp.Body.Close()
+			if err == nil {
+				rules = parseRobots(data)
+			}
+		}
+		if c.origins == nil {
+			c.origins = make(map[string]*robotsRules)
+		}
+		c.origins[origin] = rules
+	}
+
+	return rules.allowed(u)
+}
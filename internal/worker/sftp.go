@@ -0,0 +1,158 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNoHostKey is returned when an SFTP server cannot be verified because neither
+// a known_hosts file nor a per-task host key is configured.
+var ErrNoHostKey = errors.New("no ssh host key configured")
+
+// WithSSHHostKeys sets the host key verification used for SFTP sources,
+// typically built with knownhosts.New.
+func WithSSHHostKeys(callback ssh.HostKeyCallback) Option {
+	return func(w *DownloadWorker) {
+		w.sshHostKeys = callback
+	}
+}
+
+// sftpFetcher fetches sftp URLs with password or private key authentication.
+type sftpFetcher struct {
+	dialTimeout time.Duration
+	hostKeys    ssh.HostKeyCallback
+}
+
+func (f *sftpFetcher) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	u, offset := req.URL, req.Offset
+
+	config, err := f.clientConfig(u, req.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	dialer := net.Dialer{Timeout: f.dialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect to sftp server: %w", err)
+	}
+
+	stopHandshake := context.AfterFunc(ctx, func() { _ = netConn.Close() })
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	stopHandshake()
+	if err != nil {
+		_ = netConn.Close()
+		return nil, fmt.Errorf("ssh handshake: %w", err)
+	}
+
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return nil, fmt.Errorf("start sftp session: %w", err)
+	}
+
+	file, err := sftpClient.Open(u.Path)
+	var size int64 = -1
+	if err == nil {
+		if info, statErr := file.Stat(); statErr == nil {
+			size = info.Size()
+		}
+	}
+	if err == nil && offset > 0 {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		_ = sftpClient.Close()
+		_ = sshClient.Close()
+		return nil, fmt.Errorf("sftp open: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = sshClient.Close() })
+
+	return &FetchResponse{
+		Body: &sftpBody{
+			File:   file,
+			client: sftpClient,
+			conn:   sshClient,
+			stop:   stop,
+		},
+		Offset:        offset,
+		Size:          size,
+		SupportsRange: true,
+	}, nil
+}
+
+func (f *sftpFetcher) clientConfig(u *url.URL, creds domain.RequestCredentials) (*ssh.ClientConfig, error) {
+	hostKeyCallback := f.hostKeys
+	if creds.SSHHostKey != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(creds.SSHHostKey))
+		if err != nil {
+			return nil, fmt.Errorf("parse ssh host key: %w", err)
+		}
+		hostKeyCallback = ssh.FixedHostKey(key)
+	}
+	if hostKeyCallback == nil {
+		return nil, ErrNoHostKey
+	}
+
+	user, password := loginFor(u, creds)
+	if user == "" {
+		return nil, fmt.Errorf("sftp requires a username")
+	}
+
+	var auth []ssh.AuthMethod
+	if creds.SSHPrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if creds.SSHPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(creds.SSHPrivateKey), []byte(creds.SSHPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(creds.SSHPrivateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse ssh private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         f.dialTimeout,
+	}, nil
+}
+
+// sftpBody closes the remote file together with the SFTP session and SSH connection.
+type sftpBody struct {
+	*sftp.File
+	client *sftp.Client
+	conn   *ssh.Client
+	stop   func() bool
+}
+
+func (b *sftpBody) Close() error {
+	b.stop()
+	err := b.File.Close()
+	_ = b.client.Close()
+	_ = b.conn.Close()
+	return err
+}
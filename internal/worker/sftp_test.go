@@ -0,0 +1,194 @@
+package worker
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSFTPServer serves the local filesystem read-only over SFTP and accepts the given password
+// or client public key. It returns the listen address and the server host key in authorized_keys format.
+func startSFTPServer(t *testing.T, password string, clientKey ssh.PublicKey) (string, string) {
+	t.Helper()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("failed to create host signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == password {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if clientKey != nil && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	return ln.Addr().String(), string(ssh.MarshalAuthorizedKey(hostSigner.PublicKey()))
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+			}
+		}()
+
+		server, err := sftp.NewServer(channel, sftp.ReadOnly())
+		if err != nil {
+			return
+		}
+		go func() {
+			_ = server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+func TestDownloadWorker_SFTP_Password(t *testing.T) {
+	root := makeTempDir(t)
+	if err := os.WriteFile(filepath.Join(root, "feed.csv"), []byte("a,b,c\n1,2,3\n"), 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+	addr, hostKey := startSFTPServer(t, "s3cret", nil)
+
+	dir := makeTempDir(t)
+	fs := storage.NewFileStorage(dir)
+	worker := NewDownloadWorker(fs, newTestLogger())
+
+	fileURL := "sftp://vendor@" + addr + root + "/feed.csv"
+	opts := domain.TaskOptions{
+		Credentials: &domain.RequestCredentials{
+			BasicAuth:  &domain.BasicAuth{Username: "vendor", Password: "s3cret"},
+			SSHHostKey: hostKey,
+		},
+	}
+
+	partial := filepath.Join(dir, worker.generateFilename(fileURL, "sftp"))
+	if err := os.WriteFile(partial, []byte("a,b,"), 0644); err != nil {
+		t.Fatalf("failed to create partial file: %v", err)
+	}
+
+	result, err := worker.DownloadURL(context.Background(), fileURL, "sftp", opts)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if result.BytesRead != int64(len("a,b,c\n1,2,3\n")) {
+		t.Errorf("unexpected bytes read: %d", result.BytesRead)
+	}
+
+	data, err := os.ReadFile(partial)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(data) != "a,b,c\n1,2,3\n" {
+		t.Errorf("unexpected resumed content %q", data)
+	}
+}
+
+func TestDownloadWorker_SFTP_PrivateKey(t *testing.T) {
+	root := makeTempDir(t)
+	if err := os.WriteFile(filepath.Join(root, "report.txt"), []byte("key auth works"), 0644); err != nil {
+		t.Fatalf("failed to write source file: %v", err)
+	}
+
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatalf("failed to convert client key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatalf("failed to marshal client key: %v", err)
+	}
+
+	addr, hostKey := startSFTPServer(t, "", sshPub)
+
+	fs := storage.NewFileStorage(makeTempDir(t))
+
+	hostPub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		t.Fatalf("failed to parse host key: %v", err)
+	}
+	worker := NewDownloadWorker(fs, newTestLogger(), WithSSHHostKeys(ssh.FixedHostKey(hostPub)))
+
+	opts := domain.TaskOptions{
+		Credentials: &domain.RequestCredentials{SSHPrivateKey: string(pem.EncodeToMemory(block))},
+	}
+
+	result, err := worker.DownloadURL(context.Background(), "sftp://vendor@"+addr+root+"/report.txt", "sftp-key", opts)
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if !result.Success {
+		t.Errorf("expected key-authenticated download to succeed")
+	}
+}
+
+func TestDownloadWorker_SFTP_RequiresHostKey(t *testing.T) {
+	fs := storage.NewFileStorage(makeTempDir(t))
+	worker := NewDownloadWorker(fs, newTestLogger())
+
+	_, err := worker.DownloadURL(context.Background(), "sftp://vendor@127.0.0.1:1/file", "sftp-nokey", domain.TaskOptions{})
+	if !errors.Is(err, ErrNoHostKey) {
+		t.Errorf("expected ErrNoHostKey, got %v", err)
+	}
+}
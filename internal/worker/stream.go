@@ -0,0 +1,553 @@
+package worker
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrTooManySegments is returned for manifests that describe more than maxSegments segments.
+var ErrTooManySegments = fmt.Errorf("stream has more than %d segments", maxSegments)
+
+const (
+	// maxManifestSize limits how much of an HLS playlist or DASH manifest is read.
+	maxManifestSize = 10 << 20
+	// streamConcurrency is the number of segments fetched (and spooled) at once.
+	streamConcurrency = 4
+	// maxSegments limits how many segments a stream may have, so that a manifest cannot
+	// make the worker enumerate an unbounded number of them.
+	maxSegments = 100_000
+	// maxEncryptedSegmentSize limits the encrypted segments, which are decrypted in memory.
+	maxEncryptedSegmentSize = 64 << 20
+	// segmentAttempts is how many times a failing segment is fetched before giving up.
+	segmentAttempts = 3
+	// segmentRetryDelay is multiplied by the attempt number between retries.
+	segmentRetryDelay = 500 * time.Millisecond
+)
+
+// streamVariant is one selectable rendition of an adaptive stream.
+type streamVariant struct {
+	url       *url.URL
+	bandwidth int64
+}
+
+// streamSegment is a piece of the output file. A length of -1 means the whole resource.
+type streamSegment struct {
+	url      *url.URL
+	offset   int64
+	length   int64
+	key      *segmentKey
+	sequence int64
+}
+
+// segmentKey describes AES-128 encryption of HLS segments. Without an explicit IV
+// the segment's media sequence number is used, as the HLS spec requires.
+type segmentKey struct {
+	url *url.URL
+	iv  []byte
+}
+
+// streamSource opens the playlists, segments and keys named by a stream manifest. Their
+// URLs come from a remote document, so each one is validated like a task URL before it
+// is requested, and the task credentials are only sent to the origin of the manifest.
+type streamSource struct {
+	w      *DownloadWorker
+	origin *url.URL
+	creds  domain.RequestCredentials
+}
+
+func (s *streamSource) credentialsFor(u *url.URL) domain.RequestCredentials {
+	if u.Scheme != s.origin.Scheme || u.Host != s.origin.Host {
+		return domain.RequestCredentials{}
+	}
+	return s.creds
+}
+
+func (s *streamSource) open(ctx context.Context, u *url.URL, offset int64) (*FetchResponse, error) {
+	if err := s.w.validateURLs([]string{u.String()}); err != nil {
+		return nil, err
+	}
+	return s.w.open(ctx, u, offset, s.credentialsFor(u))
+}
+
+func (s *streamSource) readManifest(ctx context.Context, u *url.URL) ([]byte, error) {
+	if err := s.w.validateURLs([]string{u.String()}); err != nil {
+		return nil, fmt.Errorf("fetch manifest: %w", err)
+	}
+	return s.w.readManifest(ctx, u, s.credentialsFor(u))
+}
+
+// streamKind reports whether the URL points to an HLS playlist or DASH manifest.
+func streamKind(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".m3u8":
+		return "hls"
+	case ".mpd":
+		return "dash"
+	}
+	return ""
+}
+
+// downloadStream fetches an HLS or DASH manifest, selects a variant, downloads its
+// segments concurrently and concatenates them, decrypted, into a single file.
+// Segments are spooled to temporary files, so they are never held in memory whole.
+func (w *DownloadWorker) downloadStream(ctx context.Context, rawURL string, taskID string, kind string, opts domain.TaskOptions) (domain.DownloadResult, error) {
+	result := domain.DownloadResult{URL: rawURL}
+
+	fail := func(err error) (domain.DownloadResult, error) {
+		result.Error = err.Error()
+		w.logger.Error("stream download failed",
+			"url", rawURL,
+			"error", err,
+		)
+		return result, err
+	}
+
+	manifestURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fail(fmt.Errorf("parse URL: %w", err))
+	}
+	src := &streamSource{w: w, origin: manifestURL, creds: opts.CredentialsFor(rawURL)}
+
+	var segments []streamSegment
+	switch kind {
+	case "hls":
+		segments, result.Bandwidth, err = src.hlsSegments(ctx, opts.Stream)
+	case "dash":
+		segments, result.Bandwidth, err = src.dashSegments(ctx, opts.Stream)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	result.Segments = len(segments)
+
+	filename := w.generateFilename(rawURL, taskID)
+	result.FileName = filename
+
+	file, err := w.fileStorage.CreateFile(filename)
+	if err != nil {
+		return fail(fmt.Errorf("create file: %w", err))
+	}
+	defer file.Close()
+
+	policies := []ContentPolicy{w.contentPolicy, {Allowed: opts.AllowedMIMETypes}}
+	keys := &keyCache{keys: make(map[string][]byte)}
+
+	checked := false
+	result.BytesRead, err = src.downloadSegments(ctx, segments, keys, func(segment *os.File) (int64, error) {
+		if !checked {
+			// Segments carry no useful Content-Type, so the policy is checked against the first bytes only.
+			head := make([]byte, sniffLength)
+			n, err := io.ReadFull(segment, head)
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, fmt.Errorf("read segment: %w", err)
+			}
+			mimeType, err := checkContent(policies, "", head[:n])
+			result.MIMEType = mimeType
+			if err != nil {
+				return 0, err
+			}
+			checked = true
+			if _, err := segment.Seek(0, io.SeekStart); err != nil {
+				return 0, fmt.Errorf("read segment: %w", err)
+			}
+		}
+
+		n, err := io.Copy(file, segment)
+		if err != nil {
+			return n, fmt.Errorf("write segment: %w", err)
+		}
+		return n, nil
+	})
+	if err != nil {
+		file.Close()
+		w.removePartial(filename)
+		return fail(err)
+	}
+
+	if w.fileScanner != nil {
+		result.Scan, err = w.scanFile(ctx, filename)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	result.Success = true
+
+	return result, nil
+}
+
+func (s *streamSource) hlsSegments(ctx context.Context, opts *domain.StreamOptions) ([]streamSegment, int64, error) {
+	data, err := s.readManifest(ctx, s.origin)
+	if err != nil {
+		return nil, 0, err
+	}
+	playlist, err := parseHLS(s.origin, data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var bandwidth int64
+	if len(playlist.variants) > 0 {
+		variant := playlist.variants[selectVariant(playlist.variants, opts)]
+		bandwidth = variant.bandwidth
+
+		data, err = s.readManifest(ctx, variant.url)
+		if err != nil {
+			return nil, 0, err
+		}
+		playlist, err = parseHLS(variant.url, data)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(playlist.variants) > 0 {
+			return nil, 0, errors.New("HLS variant is itself a master playlist")
+		}
+	}
+
+	return playlist.segments, bandwidth, nil
+}
+
+func (s *streamSource) dashSegments(ctx context.Context, opts *domain.StreamOptions) ([]streamSegment, int64, error) {
+	data, err := s.readManifest(ctx, s.origin)
+	if err != nil {
+		return nil, 0, err
+	}
+	periods, err := parseDASH(s.origin, data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var segments []streamSegment
+	var bandwidth int64
+	for _, period := range periods {
+		i := selectVariant(period.variants, opts)
+		segments = append(segments, period.segments[i]...)
+		bandwidth = max(bandwidth, period.variants[i].bandwidth)
+	}
+
+	return segments, bandwidth, nil
+}
+
+func (w *DownloadWorker) readManifest(ctx context.Context, u *url.URL, creds domain.RequestCredentials) ([]byte, error) {
+	resp, err := w.open(ctx, u, 0, creds)
+	if err != nil {
+		return nil, fmt.Errorf("fetch manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	return data, nil
+}
+
+// selectVariant returns the index of the highest bandwidth variant by default, the lowest
+// for VariantWorst, or the highest not exceeding MaxBandwidth when it is set.
+func selectVariant(variants []streamVariant, opts *domain.StreamOptions) int {
+	order := make([]int, len(variants))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return variants[order[a]].bandwidth < variants[order[b]].bandwidth
+	})
+
+	lowest, highest := order[0], order[len(order)-1]
+
+	switch {
+	case opts == nil:
+		return highest
+	case opts.MaxBandwidth > 0:
+		chosen := lowest
+		for _, i := range order {
+			if variants[i].bandwidth <= opts.MaxBandwidth {
+				chosen = i
+			}
+		}
+		return chosen
+	case opts.Variant == domain.VariantWorst:
+		return lowest
+	default:
+		return highest
+	}
+}
+
+// downloadSegments fetches segments concurrently and passes them to write in playlist order,
+// each spooled to a temporary file that is removed once written. At most streamConcurrency
+// segments are in flight or waiting to be written at any time.
+func (s *streamSource) downloadSegments(ctx context.Context, segments []streamSegment, keys *keyCache, write func(*os.File) (int64, error)) (int64, error) {
+	g, ctx := errgroup.WithContext(ctx)
+
+	slots := make([]chan *os.File, len(segments))
+	for i := range slots {
+		slots[i] = make(chan *os.File, 1)
+	}
+	sem := make(chan struct{}, streamConcurrency)
+
+	g.Go(func() error {
+		for i, seg := range segments {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			i, seg := i, seg
+			g.Go(func() error {
+				spool, err := s.fetchSegment(ctx, seg, keys)
+				if err != nil {
+					return fmt.Errorf("segment %d: %w", i, err)
+				}
+				slots[i] <- spool
+				return nil
+			})
+		}
+		return nil
+	})
+
+	var total int64
+	g.Go(func() error {
+		for i := range segments {
+			select {
+			case spool := <-slots[i]:
+				n, err := write(spool)
+				removeSpool(spool)
+				if err != nil {
+					return err
+				}
+				total += n
+				<-sem
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	err := g.Wait()
+	// Segments fetched after a failure are never written.
+	for _, slot := range slots {
+		select {
+		case spool := <-slot:
+			removeSpool(spool)
+		default:
+		}
+	}
+	return total, err
+}
+
+// fetchSegment downloads and decrypts one segment into a temporary file, retrying
+// transient failures. The file is positioned at its start.
+func (s *streamSource) fetchSegment(ctx context.Context, seg streamSegment, keys *keyCache) (*os.File, error) {
+	spool, err := os.CreateTemp("", "segment-*")
+	if err != nil {
+		return nil, fmt.Errorf("create segment spool: %w", err)
+	}
+
+	for attempt := 1; attempt <= segmentAttempts; attempt++ {
+		err = s.readSegment(ctx, seg, spool)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+
+		if attempt < segmentAttempts {
+			s.w.recordRetry(ctx, metrics.RetrySegment, attribute.Int("attempt", attempt))
+			s.w.logger.Warn("segment fetch failed, retrying",
+				"url", seg.url.Redacted(),
+				"attempt", attempt,
+				"error", err,
+			)
+			select {
+			case <-time.After(time.Duration(attempt) * segmentRetryDelay):
+			case <-ctx.Done():
+				removeSpool(spool)
+				return nil, ctx.Err()
+			}
+		}
+	}
+	if err == nil && seg.key != nil {
+		err = s.decrypt(ctx, seg, keys, spool)
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeSpool(spool)
+		return nil, err
+	}
+	return spool, nil
+}
+
+// readSegment writes one segment to spool, replacing what an earlier attempt left there.
+func (s *streamSource) readSegment(ctx context.Context, seg streamSegment, spool *os.File) error {
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("reset segment spool: %w", err)
+	}
+	if err := spool.Truncate(0); err != nil {
+		return fmt.Errorf("reset segment spool: %w", err)
+	}
+
+	resp, err := s.open(ctx, seg.url, seg.offset)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	if skip := seg.offset - resp.Offset; skip > 0 {
+		if _, err := io.CopyN(io.Discard, body, skip); err != nil {
+			return fmt.Errorf("skip to byte range: %w", err)
+		}
+	}
+
+	if seg.length < 0 {
+		if _, err := io.Copy(spool, body); err != nil {
+			return fmt.Errorf("read segment: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := io.CopyN(spool, body, seg.length); err != nil {
+		return fmt.Errorf("read byte range: %w", err)
+	}
+	return nil
+}
+
+// decrypt replaces the encrypted segment in spool with its plaintext.
+func (s *streamSource) decrypt(ctx context.Context, seg streamSegment, keys *keyCache, spool *os.File) error {
+	key, err := keys.get(ctx, s, seg.key.url)
+	if err != nil {
+		return err
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("read segment: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(spool, maxEncryptedSegmentSize+1))
+	if err != nil {
+		return fmt.Errorf("read segment: %w", err)
+	}
+	if len(data) > maxEncryptedSegmentSize {
+		return fmt.Errorf("encrypted segment exceeds %d bytes", maxEncryptedSegmentSize)
+	}
+
+	plain, err := decryptSegment(data, key, seg.key.ivFor(seg.sequence))
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("write segment: %w", err)
+	}
+	if err := spool.Truncate(0); err != nil {
+		return fmt.Errorf("write segment: %w", err)
+	}
+	if _, err := spool.Write(plain); err != nil {
+		return fmt.Errorf("write segment: %w", err)
+	}
+	return nil
+}
+
+func removeSpool(spool *os.File) {
+	spool.Close()
+	os.Remove(spool.Name())
+}
+
+func (k *segmentKey) ivFor(sequence int64) []byte {
+	if k.iv != nil {
+		return k.iv
+	}
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	return iv
+}
+
+// decryptSegment decrypts AES-128-CBC data and strips its PKCS#7 padding.
+func decryptSegment(data, key, iv []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted segment size %d is not a multiple of the block size", len(data))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errors.New("invalid segment padding")
+	}
+	for _, b := range plain[len(plain)-pad:] {
+		if int(b) != pad {
+			return nil, errors.New("invalid segment padding")
+		}
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+// keyCache fetches each AES key once per download.
+type keyCache struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (c *keyCache) get(ctx context.Context, src *streamSource, u *url.URL) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[u.String()]; ok {
+		return key, nil
+	}
+
+	resp, err := src.open(ctx, u, 0)
+	if err != nil {
+		return nil, fmt.Errorf("fetch key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	key, err := io.ReadAll(io.LimitReader(resp.Body, aes.BlockSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	if len(key) != aes.BlockSize {
+		return nil, fmt.Errorf("AES-128 key must be %d bytes, got %d", aes.BlockSize, len(key))
+	}
+
+	c.keys[u.String()] = key
+	return key, nil
+}
+
+// removePartial deletes the output of a failed download that cannot be resumed,
+// such as a stream assembled from segments or a file that failed verification.
+func (w *DownloadWorker) removePartial(filename string) {
+	if err := os.Remove(w.fileStorage.Path(filename)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		w.logger.Warn("failed to remove partial download",
+			"file_name", filename,
+			"error", err,
+		)
+	}
+}
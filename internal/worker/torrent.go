@@ -0,0 +1,462 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	torrentstorage "github.com/anacrolix/torrent/storage"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+)
+
+// maxTorrentFileSize limits how much is read when fetching a .torrent file.
+const maxTorrentFileSize = 10 << 20
+
+// torrentProgressInterval is how often torrent progress is reported.
+const torrentProgressInterval = time.Second
+
+const (
+	// DefaultTorrentMetadataTimeout is used when TorrentConfig.MetadataTimeout is zero.
+	DefaultTorrentMetadataTimeout = 5 * time.Minute
+	// DefaultTorrentStallTimeout is used when TorrentConfig.StallTimeout is zero.
+	DefaultTorrentStallTimeout = 10 * time.Minute
+)
+
+// TorrentConfig configures the BitTorrent client.
+type TorrentConfig struct {
+	// DataDir is the default storage root; downloads themselves go to the task directory.
+	DataDir         string
+	ListenPort      int
+	NoDHT           bool
+	DisableTrackers bool
+	// SeedRatio is the default upload/download ratio to seed to after completion; zero disables seeding.
+	SeedRatio float64
+	// MaxSeedTime stops seeding after this long even if the ratio is not reached; zero means no limit.
+	MaxSeedTime time.Duration
+	// MetadataTimeout fails a magnet download whose metadata has not arrived by then.
+	MetadataTimeout time.Duration
+	// StallTimeout fails a download that has not received any data for this long.
+	StallTimeout time.Duration
+}
+
+// ErrTorrentInUse is returned when a torrent is added while another task is downloading it.
+// The client holds a single copy of each torrent, stored in the directory of one task.
+var ErrTorrentInUse = errors.New("torrent is already being downloaded")
+
+// ErrTorrentStalled is returned when a torrent yields no metadata or no data in time,
+// typically because it has no reachable peers.
+var ErrTorrentStalled = errors.New("torrent download stalled")
+
+// TorrentClient downloads magnet links and .torrent payloads and seeds them afterwards.
+type TorrentClient struct {
+	client   *torrent.Client
+	cfg      TorrentConfig
+	seeders  sync.WaitGroup
+	closing  chan struct{}
+	closeOne sync.Once
+
+	mu     sync.Mutex
+	active map[metainfo.Hash]*activeTorrent
+}
+
+// activeTorrent is a torrent owned by the task that added it, from claim until it is
+// dropped. A torrent that is only seeding is given up when another task claims it.
+type activeTorrent struct {
+	hash     metainfo.Hash
+	taskID   string
+	t        *torrent.Torrent
+	seeding  bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	released chan struct{}
+}
+
+// NewTorrentClient starts a BitTorrent client listening on cfg.ListenPort.
+func NewTorrentClient(cfg TorrentConfig) (*TorrentClient, error) {
+	if cfg.MetadataTimeout <= 0 {
+		cfg.MetadataTimeout = DefaultTorrentMetadataTimeout
+	}
+	if cfg.StallTimeout <= 0 {
+		cfg.StallTimeout = DefaultTorrentStallTimeout
+	}
+
+	clientCfg := torrent.NewDefaultClientConfig()
+	clientCfg.DataDir = cfg.DataDir
+	clientCfg.DefaultStorage = torrentstorage.NewFileOpts(torrentstorage.NewFileClientOpts{
+		ClientBaseDir:   cfg.DataDir,
+		PieceCompletion: torrentstorage.NewMapPieceCompletion(),
+	})
+	clientCfg.ListenPort = cfg.ListenPort
+	clientCfg.NoDHT = cfg.NoDHT
+	clientCfg.DisableTrackers = cfg.DisableTrackers
+	clientCfg.Seed = true
+	clientCfg.DisableWebtorrent = true
+
+	client, err := torrent.NewClient(clientCfg)
+	if err != nil {
+		return nil, fmt.Errorf("start torrent client: %w", err)
+	}
+
+	return &TorrentClient{
+		client:  client,
+		cfg:     cfg,
+		closing: make(chan struct{}),
+		active:  make(map[metainfo.Hash]*activeTorrent),
+	}, nil
+}
+
+// claim reserves the torrent with hash for taskID. It fails with ErrTorrentInUse while
+// another download of the torrent is running; a torrent that is only seeding is dropped
+// first, so that this download gets its own copy in its task directory.
+func (c *TorrentClient) claim(ctx context.Context, hash metainfo.Hash, taskID string) (*activeTorrent, error) {
+	for {
+		c.mu.Lock()
+		current, ok := c.active[hash]
+		if !ok {
+			owner := &activeTorrent{
+				hash:     hash,
+				taskID:   taskID,
+				stop:     make(chan struct{}),
+				released: make(chan struct{}),
+			}
+			c.active[hash] = owner
+			c.mu.Unlock()
+			return owner, nil
+		}
+		seeding := current.seeding
+		c.mu.Unlock()
+
+		if !seeding {
+			return nil, fmt.Errorf("%w by task %s", ErrTorrentInUse, current.taskID)
+		}
+		current.stopOnce.Do(func() { close(current.stop) })
+		select {
+		case <-current.released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// drop removes the torrent of owner from the client and releases its claim.
+func (c *TorrentClient) drop(owner *activeTorrent) {
+	if owner.t != nil {
+		owner.t.Drop()
+	}
+	c.mu.Lock()
+	delete(c.active, owner.hash)
+	c.mu.Unlock()
+	close(owner.released)
+}
+
+// Close stops seeding and shuts down the client.
+func (c *TorrentClient) Close() {
+	c.closeOne.Do(func() {
+		close(c.closing)
+		c.seeders.Wait()
+		c.client.Close()
+	})
+}
+
+// WithTorrents enables magnet: and .torrent URLs using the given client.
+func WithTorrents(c *TorrentClient) Option {
+	return func(w *DownloadWorker) {
+		w.torrents = c
+	}
+}
+
+// magnetScheme is registered so that validation accepts magnet URIs. DownloadURL
+// hands torrent URLs to the torrent client before any fetcher is consulted.
+type magnetScheme struct{}
+
+func (magnetScheme) Fetch(ctx context.Context, req FetchRequest) (*FetchResponse, error) {
+	return nil, fmt.Errorf("%w: magnet URIs are handled by the torrent client", ErrUnsupportedScheme)
+}
+
+func (magnetScheme) Hostless() bool { return true }
+
+// isTorrentURL reports whether the URL is a magnet link or points to a .torrent file.
+func isTorrentURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "magnet" || strings.HasSuffix(strings.ToLower(u.Path), ".torrent")
+}
+
+// downloadTorrent downloads a torrent payload into the task's directory, reporting progress
+// while pieces arrive. Every file of the payload is checked against the content policies
+// and scanned like any other download; only then is the torrent seeded in the background
+// when a seed ratio is configured.
+func (w *DownloadWorker) downloadTorrent(ctx context.Context, rawURL string, taskID string, opts domain.TaskOptions) (domain.DownloadResult, error) {
+	result := domain.DownloadResult{URL: rawURL}
+
+	fail := func(err error) (domain.DownloadResult, error) {
+		result.Error = err.Error()
+		w.logger.Error("torrent download failed",
+			"url", rawURL,
+			"error", err,
+		)
+		return result, err
+	}
+
+	spec, err := w.torrentSpec(ctx, rawURL, opts)
+	if err != nil {
+		return fail(err)
+	}
+	w.dropUnsafeSources(spec)
+
+	owner, err := w.torrents.claim(ctx, spec.InfoHash, taskID)
+	if err != nil {
+		return fail(err)
+	}
+
+	pieceCompletion := torrentstorage.NewMapPieceCompletion()
+	spec.Storage = torrentstorage.NewFileOpts(torrentstorage.NewFileClientOpts{
+		ClientBaseDir:   w.fileStorage.Path(taskID),
+		PieceCompletion: pieceCompletion,
+	})
+
+	t, _, err := w.torrents.client.AddTorrentSpec(spec)
+	if err != nil {
+		w.torrents.drop(owner)
+		return fail(fmt.Errorf("add torrent: %w", err))
+	}
+	owner.t = t
+	result.InfoHash = t.InfoHash().HexString()
+
+	metadataTimer := time.NewTimer(w.torrents.cfg.MetadataTimeout)
+	select {
+	case <-t.GotInfo():
+		metadataTimer.Stop()
+	case <-metadataTimer.C:
+		w.torrents.drop(owner)
+		return fail(fmt.Errorf("%w: no metadata after %s", ErrTorrentStalled, w.torrents.cfg.MetadataTimeout))
+	case <-ctx.Done():
+		metadataTimer.Stop()
+		w.torrents.drop(owner)
+		return fail(fmt.Errorf("wait for torrent metadata: %w", ctx.Err()))
+	}
+
+	result.FileName = filepath.Join(taskID, t.Name())
+	result.TotalBytes = t.Length()
+
+	// Recheck data already on disk so that restarted tasks resume instead of starting over.
+	t.VerifyData()
+	t.DownloadAll()
+	// Pieces already on disk are not counted as downloaded bytes.
+	verified := t.BytesCompleted()
+	defer func() { w.metrics.AddBytes(result.BytesRead - verified) }()
+
+	ticker := time.NewTicker(torrentProgressInterval)
+	defer ticker.Stop()
+
+	lastProgress := time.Now()
+	for done := false; !done; {
+		select {
+		case <-t.Complete().On():
+			done = true
+		case now := <-ticker.C:
+			if completed := t.BytesCompleted(); completed > result.BytesRead {
+				result.BytesRead = completed
+				lastProgress = now
+			} else if stalled := now.Sub(lastProgress); stalled >= w.torrents.cfg.StallTimeout {
+				w.torrents.drop(owner)
+				return fail(fmt.Errorf("%w: no data for %s", ErrTorrentStalled, stalled.Round(time.Second)))
+			}
+			w.reportProgress(taskID, result)
+		case <-ctx.Done():
+			result.BytesRead = t.BytesCompleted()
+			w.torrents.drop(owner)
+			return fail(ctx.Err())
+		}
+	}
+
+	result.BytesRead = t.Length()
+
+	policies := []ContentPolicy{w.contentPolicy, {Allowed: opts.AllowedMIMETypes}}
+	if err := w.checkTorrentFiles(ctx, &result, policies); err != nil {
+		w.torrents.drop(owner)
+		if errors.Is(err, ErrContentTypeNotAllowed) {
+			if removeErr := os.RemoveAll(w.fileStorage.Path(result.FileName)); removeErr != nil {
+				w.logger.Warn("failed to remove torrent payload",
+					"file_name", result.FileName,
+					"error", removeErr,
+				)
+			}
+		}
+		return fail(err)
+	}
+
+	result.Success = true
+
+	ratio := w.torrents.cfg.SeedRatio
+	if opts.SeedRatio != nil {
+		ratio = *opts.SeedRatio
+	}
+	w.torrents.seed(owner, ratio)
+
+	return result, nil
+}
+
+// checkTorrentFiles checks every file of a completed torrent payload against the content
+// policies and passes it to the scanner when one is configured. The MIME type and scan
+// of the last file checked are recorded on result; the first failure stops the check.
+func (w *DownloadWorker) checkTorrentFiles(ctx context.Context, result *domain.DownloadResult, policies []ContentPolicy) error {
+	root := w.fileStorage.Path(result.FileName)
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(result.FileName, rel)
+
+		file, err := w.fileStorage.OpenFile(filename, os.O_RDONLY)
+		if err != nil {
+			return fmt.Errorf("open torrent file: %w", err)
+		}
+		head := make([]byte, sniffLength)
+		n, err := io.ReadFull(file, head)
+		file.Close()
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("read torrent file: %w", err)
+		}
+
+		result.MIMEType, err = checkContent(policies, "", head[:n])
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+
+		if w.fileScanner != nil {
+			result.Scan, err = w.scanFile(ctx, filename)
+			if err != nil {
+				return fmt.Errorf("%s: %w", rel, err)
+			}
+		}
+		return nil
+	})
+}
+
+// torrentSpec builds a torrent spec from a magnet URI or by fetching a .torrent file.
+func (w *DownloadWorker) torrentSpec(ctx context.Context, rawURL string, opts domain.TaskOptions) (*torrent.TorrentSpec, error) {
+	if strings.HasPrefix(rawURL, "magnet:") {
+		spec, err := torrent.TorrentSpecFromMagnetUri(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse magnet URI: %w", err)
+		}
+		return spec, nil
+	}
+
+	resp, err := w.fetch(ctx, rawURL, 0, opts)
+	if err != nil {
+		return nil, fmt.Errorf("fetch torrent file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	mi, err := metainfo.Load(io.LimitReader(resp.Body, maxTorrentFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("parse torrent file: %w", err)
+	}
+
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		return nil, fmt.Errorf("parse torrent file: %w", err)
+	}
+	return spec, nil
+}
+
+// dropUnsafeSources removes the trackers, web seeds and metainfo sources of spec that
+// fail URL validation. They come from the magnet URI or the .torrent file, so without
+// this a task could make the client contact loopback or private addresses.
+func (w *DownloadWorker) dropUnsafeSources(spec *torrent.TorrentSpec) {
+	safe := func(raw string) bool {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return false
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			// UDP and WebSocket trackers are not download schemes; only their host is checked.
+			if u.Host == "" {
+				return false
+			}
+			raw = "http://" + u.Host + "/"
+		}
+		if err := w.validateURLs([]string{raw}); err != nil {
+			w.logger.Warn("ignoring unsafe torrent source", "source", u.Redacted(), "error", err)
+			return false
+		}
+		return true
+	}
+
+	var trackers [][]string
+	for _, tier := range spec.Trackers {
+		if tier = slices.DeleteFunc(slices.Clone(tier), func(raw string) bool { return !safe(raw) }); len(tier) > 0 {
+			trackers = append(trackers, tier)
+		}
+	}
+	spec.Trackers = trackers
+	spec.Webseeds = slices.DeleteFunc(slices.Clone(spec.Webseeds), func(raw string) bool { return !safe(raw) })
+	spec.Sources = slices.DeleteFunc(slices.Clone(spec.Sources), func(raw string) bool { return !safe(raw) })
+}
+
+// seed keeps uploading a completed torrent until the ratio or MaxSeedTime is reached, or
+// until another task claims the torrent, then drops it. A zero ratio drops it immediately.
+func (c *TorrentClient) seed(owner *activeTorrent, ratio float64) {
+	if ratio <= 0 {
+		c.drop(owner)
+		return
+	}
+
+	t := owner.t
+	target := int64(ratio * float64(t.Length()))
+
+	c.mu.Lock()
+	owner.seeding = true
+	c.mu.Unlock()
+
+	c.seeders.Add(1)
+	go func() {
+		defer c.seeders.Done()
+		defer c.drop(owner)
+
+		var deadline <-chan time.Time
+		if c.cfg.MaxSeedTime > 0 {
+			timer := time.NewTimer(c.cfg.MaxSeedTime)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
+		ticker := time.NewTicker(torrentProgressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				stats := t.Stats()
+				if stats.BytesWrittenData.Int64() >= target {
+					return
+				}
+			case <-deadline:
+				return
+			case <-owner.stop:
+				return
+			case <-c.closing:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,410 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	torrentstorage "github.com/anacrolix/torrent/storage"
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+// startSeeder creates a payload file, builds its metainfo and seeds it from a local client.
+// It returns the metainfo, the payload and the seeder's listen address.
+func startSeeder(t *testing.T, size int) (*metainfo.MetaInfo, []byte, string) {
+	t.Helper()
+
+	payload := make([]byte, size)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatalf("generate payload: %v", err)
+	}
+
+	seedDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(seedDir, "dataset.bin"), payload, 0644); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+
+	info := metainfo.Info{PieceLength: 16 << 10}
+	if err := info.BuildFromFilePath(filepath.Join(seedDir, "dataset.bin")); err != nil {
+		t.Fatalf("build info: %v", err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatalf("marshal info: %v", err)
+	}
+	mi := &metainfo.MetaInfo{InfoBytes: infoBytes}
+
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = seedDir
+	cfg.DefaultStorage = torrentstorage.NewFileOpts(torrentstorage.NewFileClientOpts{
+		ClientBaseDir:   seedDir,
+		PieceCompletion: torrentstorage.NewMapPieceCompletion(),
+	})
+	cfg.ListenPort = 0
+	cfg.NoDHT = true
+	cfg.DisableTrackers = true
+	cfg.NoDefaultPortForwarding = true
+	cfg.Seed = true
+
+	seeder, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatalf("start seeder: %v", err)
+	}
+	t.Cleanup(func() { seeder.Close() })
+
+	tor, err := seeder.AddTorrent(mi)
+	if err != nil {
+		t.Fatalf("add torrent to seeder: %v", err)
+	}
+	tor.VerifyData()
+	if !tor.Complete().Bool() {
+		t.Fatalf("seeder does not have the complete payload")
+	}
+
+	port := seeder.LocalPort()
+	return mi, payload, net.JoinHostPort("127.0.0.1", fmt.Sprint(port))
+}
+
+func newTorrentWorker(t *testing.T, downloadDir string) *DownloadWorker {
+	t.Helper()
+
+	client, err := NewTorrentClient(TorrentConfig{
+		DataDir:         t.TempDir(),
+		NoDHT:           true,
+		DisableTrackers: true,
+	})
+	if err != nil {
+		t.Fatalf("NewTorrentClient error: %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	return NewDownloadWorker(storage.NewFileStorage(downloadDir), newTestLogger(), WithTorrents(client))
+}
+
+func TestDownloadURL_MagnetFromLocalSwarm(t *testing.T) {
+	mi, payload, seederAddr := startSeeder(t, 256<<10)
+
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		t.Fatalf("unmarshal info: %v", err)
+	}
+	magnet := mi.Magnet(nil, &info)
+	magnet.Params.Set("x.pe", seederAddr)
+
+	downloadDir := t.TempDir()
+	w := newTorrentWorker(t, downloadDir)
+
+	var mu sync.Mutex
+	var progress []domain.DownloadResult
+	w.SetProgressHandler(func(taskID string, result domain.DownloadResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress = append(progress, result)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := w.DownloadURL(ctx, magnet.String(), "task1", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("DownloadURL error: %v", err)
+	}
+	if !result.Success {
+		t.Fatalf("expected success, got %+v", result)
+	}
+	if result.InfoHash != mi.HashInfoBytes().HexString() {
+		t.Fatalf("expected info hash %s, got %s", mi.HashInfoBytes().HexString(), result.InfoHash)
+	}
+	if result.BytesRead != int64(len(payload)) || result.TotalBytes != int64(len(payload)) {
+		t.Fatalf("expected %d bytes, got read=%d total=%d", len(payload), result.BytesRead, result.TotalBytes)
+	}
+	if result.FileName != filepath.Join("task1", "dataset.bin") {
+		t.Fatalf("unexpected file name %q", result.FileName)
+	}
+
+	got, err := os.ReadFile(filepath.Join(downloadDir, result.FileName))
+	if err != nil {
+		t.Fatalf("read downloaded payload: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("downloaded payload does not match")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range progress {
+		if p.URL != result.URL || p.TotalBytes != int64(len(payload)) {
+			t.Fatalf("unexpected progress update %+v", p)
+		}
+	}
+}
+
+func TestDownloadURL_MagnetWithoutPeersCancels(t *testing.T) {
+	mi, _, _ := startSeeder(t, 16<<10)
+
+	w := newTorrentWorker(t, t.TempDir())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	result, err := w.DownloadURL(ctx, mi.Magnet(nil, nil).String(), "task1", domain.TaskOptions{})
+	if err == nil {
+		t.Fatalf("expected error without peers")
+	}
+	if result.Success || result.Error == "" {
+		t.Fatalf("expected failed result, got %+v", result)
+	}
+}
+
+// seederMagnet returns a magnet link for the torrent served by startSeeder at addr.
+func seederMagnet(t *testing.T, mi *metainfo.MetaInfo, addr string) string {
+	t.Helper()
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		t.Fatalf("unmarshal info: %v", err)
+	}
+	magnet := mi.Magnet(nil, &info)
+	magnet.Params.Set("x.pe", addr)
+	return magnet.String()
+}
+
+func TestDownloadURL_TorrentContentPolicy(t *testing.T) {
+	mi, _, seederAddr := startSeeder(t, 64<<10)
+
+	downloadDir := t.TempDir()
+	w := newTorrentWorker(t, downloadDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	opts := domain.TaskOptions{AllowedMIMETypes: []string{"text/plain"}}
+	result, err := w.DownloadURL(ctx, seederMagnet(t, mi, seederAddr), "task1", opts)
+	if !errors.Is(err, ErrContentTypeNotAllowed) {
+		t.Fatalf("expected ErrContentTypeNotAllowed, got %v", err)
+	}
+	if result.Success || result.MIMEType != "application/octet-stream" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(downloadDir, result.FileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected the rejected payload to be removed, got %v", err)
+	}
+	if len(w.torrents.active) != 0 {
+		t.Fatalf("expected the rejected torrent not to be seeded")
+	}
+}
+
+func TestDownloadURL_TorrentSharedByTasks(t *testing.T) {
+	mi, payload, seederAddr := startSeeder(t, 64<<10)
+	magnet := seederMagnet(t, mi, seederAddr)
+
+	downloadDir := t.TempDir()
+	w := newTorrentWorker(t, downloadDir)
+
+	t.Run("running download is not shared", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Without peers the first download waits for metadata until it is cancelled.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			w.DownloadURL(ctx, mi.Magnet(nil, nil).String(), "waiting", domain.TaskOptions{})
+		}()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			w.torrents.mu.Lock()
+			claimed := len(w.torrents.active) > 0
+			w.torrents.mu.Unlock()
+			if claimed {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("first download did not claim the torrent")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		_, err := w.DownloadURL(context.Background(), magnet, "second", domain.TaskOptions{})
+		if !errors.Is(err, ErrTorrentInUse) {
+			t.Fatalf("expected ErrTorrentInUse, got %v", err)
+		}
+		cancel()
+		<-done
+	})
+
+	t.Run("seeding torrent is handed over", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		ratio := 100.0
+		for _, taskID := range []string{"task1", "task2"} {
+			_, err := w.DownloadURL(ctx, magnet, taskID, domain.TaskOptions{SeedRatio: &ratio})
+			if err != nil {
+				t.Fatalf("DownloadURL %s error: %v", taskID, err)
+			}
+			got, err := os.ReadFile(filepath.Join(downloadDir, taskID, "dataset.bin"))
+			if err != nil || !bytes.Equal(got, payload) {
+				t.Fatalf("expected the payload of %s in its own directory, got %v", taskID, err)
+			}
+		}
+	})
+}
+
+func TestDownloadURL_MagnetMetadataTimeout(t *testing.T) {
+	mi, _, _ := startSeeder(t, 16<<10)
+
+	client, err := NewTorrentClient(TorrentConfig{
+		DataDir:         t.TempDir(),
+		NoDHT:           true,
+		DisableTrackers: true,
+		MetadataTimeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewTorrentClient error: %v", err)
+	}
+	t.Cleanup(client.Close)
+	w := NewDownloadWorker(storage.NewFileStorage(t.TempDir()), newTestLogger(), WithTorrents(client))
+
+	result, err := w.DownloadURL(context.Background(), mi.Magnet(nil, nil).String(), "task1", domain.TaskOptions{})
+	if !errors.Is(err, ErrTorrentStalled) {
+		t.Fatalf("expected ErrTorrentStalled, got %v", err)
+	}
+	if result.Success || len(client.active) != 0 {
+		t.Fatalf("expected a failed and released download, got %+v", result)
+	}
+}
+
+func TestDownloadURL_TorrentStallTimeout(t *testing.T) {
+	mi, _, _ := startSeeder(t, 64<<10)
+
+	// The .torrent file carries the metadata, but nobody serves the data.
+	var buf bytes.Buffer
+	if err := mi.Write(&buf); err != nil {
+		t.Fatalf("write metainfo: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	client, err := NewTorrentClient(TorrentConfig{
+		DataDir:         t.TempDir(),
+		NoDHT:           true,
+		DisableTrackers: true,
+		StallTimeout:    1500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewTorrentClient error: %v", err)
+	}
+	t.Cleanup(client.Close)
+	w := NewDownloadWorker(storage.NewFileStorage(t.TempDir()), newTestLogger(), WithTorrents(client))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := w.DownloadURL(ctx, server.URL+"/dataset.torrent", "task1", domain.TaskOptions{})
+	if !errors.Is(err, ErrTorrentStalled) {
+		t.Fatalf("expected ErrTorrentStalled, got %v", err)
+	}
+	if result.Success || len(client.active) != 0 {
+		t.Fatalf("expected a failed and released download, got %+v", result)
+	}
+}
+
+func TestDropUnsafeSources(t *testing.T) {
+	w := newTorrentWorker(t, t.TempDir())
+	spec := &torrent.TorrentSpec{
+		Trackers: [][]string{
+			{"udp://127.0.0.1:6969/announce", "udp://tracker.example.com:6969/announce"},
+			{"http://169.254.169.254/announce"},
+		},
+		Webseeds: []string{"http://10.0.0.1/data", "https://seed.example.com/data"},
+		Sources:  []string{"http://localhost/meta.torrent", "https://example.com/meta.torrent"},
+	}
+
+	w.dropUnsafeSources(spec)
+
+	if len(spec.Trackers) != 1 || len(spec.Trackers[0]) != 1 || spec.Trackers[0][0] != "udp://tracker.example.com:6969/announce" {
+		t.Errorf("unexpected trackers %v", spec.Trackers)
+	}
+	if len(spec.Webseeds) != 1 || spec.Webseeds[0] != "https://seed.example.com/data" {
+		t.Errorf("unexpected web seeds %v", spec.Webseeds)
+	}
+	if len(spec.Sources) != 1 || spec.Sources[0] != "https://example.com/meta.torrent" {
+		t.Errorf("unexpected sources %v", spec.Sources)
+	}
+}
+
+func TestTorrentSpec_FetchesTorrentFile(t *testing.T) {
+	mi, _, _ := startSeeder(t, 16<<10)
+
+	var buf bytes.Buffer
+	if err := mi.Write(&buf); err != nil {
+		t.Fatalf("write metainfo: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/x-bittorrent")
+		rw.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	w := newTorrentWorker(t, t.TempDir())
+
+	spec, err := w.torrentSpec(context.Background(), server.URL+"/dataset.torrent", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("torrentSpec error: %v", err)
+	}
+	if spec.InfoHash != mi.HashInfoBytes() {
+		t.Fatalf("expected info hash %s, got %s", mi.HashInfoBytes(), spec.InfoHash)
+	}
+}
+
+func TestIsTorrentURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a", true},
+		{"https://example.com/datasets/images.torrent", true},
+		{"https://example.com/datasets/IMAGES.TORRENT?token=1", true},
+		{"https://example.com/datasets/images.tar", false},
+		{"ftp://example.com/torrent", false},
+	}
+
+	for _, tt := range tests {
+		if got := isTorrentURL(tt.url); got != tt.want {
+			t.Errorf("isTorrentURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestTorrents_RegisterMagnetScheme(t *testing.T) {
+	w := newTorrentWorker(t, t.TempDir())
+
+	if !w.Fetchers().Supports("magnet") {
+		t.Fatalf("expected magnet scheme to be registered")
+	}
+	if w.Fetchers().RequiresHost("magnet") {
+		t.Fatalf("expected magnet scheme to be hostless")
+	}
+
+	plain := NewDownloadWorker(storage.NewFileStorage(t.TempDir()), newTestLogger())
+	if plain.Fetchers().Supports("magnet") {
+		t.Fatalf("magnet scheme must not be registered without a torrent client")
+	}
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/veranemoloko/url-downloader/internal/worker")
+
+// startDownloadSpan starts the span covering one DownloadURL call.
+func startDownloadSpan(ctx context.Context, rawURL, taskID string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "DownloadWorker.DownloadURL", trace.WithAttributes(
+		attribute.String("task.id", taskID),
+		attribute.String("url.full", spanURL(rawURL)),
+	))
+}
+
+// endDownloadSpan records the outcome of a download on its span and ends it.
+func endDownloadSpan(span trace.Span, result domain.DownloadResult, err error) {
+	span.SetAttributes(
+		attribute.Bool("download.success", result.Success),
+		attribute.Int64("download.bytes", result.BytesRead),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// recordRetry counts a retry and adds it as an event to the current span.
+func (w *DownloadWorker) recordRetry(ctx context.Context, kind string, attrs ...attribute.KeyValue) {
+	w.metrics.Retry(kind)
+	trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+		append([]attribute.KeyValue{attribute.String("retry.kind", kind)}, attrs...)...,
+	))
+}
+
+// spanURL strips credentials and the query string, which often carries tokens,
+// before a URL is attached to a span.
+func spanURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
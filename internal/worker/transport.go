@@ -0,0 +1,178 @@
+package worker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ErrStalled is returned when a download receives no data for longer than the idle read timeout.
+var ErrStalled = errors.New("download stalled")
+
+// TransportConfig controls connection setup and timeouts of the worker's HTTP client.
+// Zero durations disable the corresponding timeout.
+type TransportConfig struct {
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	// IdleReadTimeout aborts a download when the body yields no bytes for this long.
+	IdleReadTimeout time.Duration
+	// TotalTimeout bounds a whole request including the body; zero means no limit.
+	TotalTimeout time.Duration
+	TLSConfig    *tls.Config
+	DisableHTTP2 bool
+}
+
+// DefaultTransportConfig returns the timeouts used when no TransportConfig is supplied.
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		DialTimeout:           30 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		IdleReadTimeout:       2 * time.Minute,
+	}
+}
+
+// TLSFiles points to PEM files and settings used to build a tls.Config.
+type TLSFiles struct {
+	CAFile         string
+	ClientCertFile string
+	ClientKeyFile  string
+	MinVersion     string
+}
+
+// LoadTLSConfig builds a tls.Config from PEM files. Custom CAs are added to the system pool,
+// a client certificate enables mTLS and MinVersion accepts "1.0" to "1.3".
+func LoadTLSConfig(files TLSFiles) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if files.MinVersion != "" {
+		version, err := parseTLSVersion(files.MinVersion)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MinVersion = version
+	}
+
+	if files.CAFile != "" {
+		pem, err := os.ReadFile(files.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", files.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if files.ClientCertFile != "" || files.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(files.ClientCertFile, files.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q", version)
+	}
+}
+
+// WithTransport replaces the default connection timeouts and TLS settings.
+func WithTransport(cfg TransportConfig) Option {
+	return func(w *DownloadWorker) {
+		w.transportConfig = cfg
+	}
+}
+
+func (w *DownloadWorker) newHTTPClient() *http.Client {
+	cfg := w.transportConfig
+
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = w.resolveProxy
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	if cfg.TLSConfig != nil {
+		transport.TLSClientConfig = cfg.TLSConfig.Clone()
+	}
+
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetHTTP1(true)
+	transport.Protocols.SetHTTP2(!cfg.DisableHTTP2)
+
+	// Requests are traced, but trace headers are not sent to third-party download sources.
+	return &http.Client{
+		Timeout:       cfg.TotalTimeout,
+		CheckRedirect: stripCredentialsOnRedirect,
+		Transport: otelhttp.NewTransport(transport,
+			otelhttp.WithPropagators(propagation.NewCompositeTextMapPropagator()),
+		),
+	}
+}
+
+// idleTimeoutReader cancels the download when Read makes no progress within the timeout.
+type idleTimeoutReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	once    sync.Once
+}
+
+// newIdleTimeoutReader wraps r so that cancel is called with ErrStalled when no data
+// arrives for timeout. Stop must be called once reading is finished.
+func newIdleTimeoutReader(r io.Reader, timeout time.Duration, cancel context.CancelCauseFunc) *idleTimeoutReader {
+	return &idleTimeoutReader{
+		r:       r,
+		timeout: timeout,
+		timer: time.AfterFunc(timeout, func() {
+			cancel(fmt.Errorf("%w: no data for %s", ErrStalled, timeout))
+		}),
+	}
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+func (r *idleTimeoutReader) Stop() {
+	r.once.Do(func() { r.timer.Stop() })
+}
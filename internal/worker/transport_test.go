@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/veranemoloko/url-downloader/internal/domain"
+	"github.com/veranemoloko/url-downloader/internal/storage"
+)
+
+func TestDownloadWorker_IdleReadTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cfg := DefaultTransportConfig()
+	cfg.IdleReadTimeout = 100 * time.Millisecond
+
+	fs := storage.NewFileStorage(makeTempDir(t))
+	worker := NewDownloadWorker(fs, newTestLogger(), WithTransport(cfg))
+
+	start := time.Now()
+	result, err := worker.DownloadURL(context.Background(), server.URL, "stall", domain.TaskOptions{})
+	if !errors.Is(err, ErrStalled) {
+		t.Fatalf("expected ErrStalled, got %v", err)
+	}
+	if result.Success {
+		t.Errorf("expected stalled download to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("stall detection took too long: %s", elapsed)
+	}
+}
+
+func TestDownloadWorker_CustomCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "tls content")
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(makeTempDir(t), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	fs := storage.NewFileStorage(makeTempDir(t))
+
+	untrusted := NewDownloadWorker(fs, newTestLogger())
+	if _, err := untrusted.DownloadURL(context.Background(), server.URL, "ca", domain.TaskOptions{}); err == nil {
+		t.Fatalf("expected certificate verification failure without custom CA")
+	}
+
+	tlsConfig, err := LoadTLSConfig(TLSFiles{CAFile: caFile, MinVersion: "1.2"})
+	if err != nil {
+		t.Fatalf("LoadTLSConfig error: %v", err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 minimum, got %x", tlsConfig.MinVersion)
+	}
+
+	cfg := DefaultTransportConfig()
+	cfg.TLSConfig = tlsConfig
+	trusted := NewDownloadWorker(fs, newTestLogger(), WithTransport(cfg))
+
+	result, err := trusted.DownloadURL(context.Background(), server.URL, "ca", domain.TaskOptions{})
+	if err != nil {
+		t.Fatalf("DownloadURL error with custom CA: %v", err)
+	}
+	if !result.Success {
+		t.Errorf("expected download with custom CA to succeed")
+	}
+}
+
+func TestLoadTLSConfig_Errors(t *testing.T) {
+	if _, err := LoadTLSConfig(TLSFiles{MinVersion: "2.0"}); err == nil {
+		t.Errorf("expected error for unsupported TLS version")
+	}
+	if _, err := LoadTLSConfig(TLSFiles{CAFile: "/nonexistent/ca.pem"}); err == nil {
+		t.Errorf("expected error for missing CA file")
+	}
+	if _, err := LoadTLSConfig(TLSFiles{ClientCertFile: "/nonexistent/cert.pem"}); err == nil {
+		t.Errorf("expected error for missing client certificate")
+	}
+}
@@ -22,8 +22,8 @@ def make_request(method, endpoint, data=None):
 
 def test_service_health():
     print("🐱 Checking if service is alive...")
-    status, body = make_request("GET", "/tasks/nonexistent")
-    if status in [200, 404]:
+    status, body = make_request("GET", "/healthz")
+    if status == 200:
         print("🐈 Service is alive and responding!")
         return True
     print(f"❌ Service not responding: {status} - {body}")
@@ -31,7 +31,7 @@ def test_service_health():
 
 def test_invalid_urls():
     print("\n🐾 Checking URL validation...")
-    invalid_urls = ["not-a-url", "ftp://example.com/file.txt"]
+    invalid_urls = ["not-a-url", "gopher://example.com/file.txt"]
     status, body = make_request("POST", "/tasks", {"urls": invalid_urls})
     if status == 400:
         print("🐱 URL validation works!")